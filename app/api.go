@@ -1,14 +1,18 @@
 package app
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/karimra/gnmic/collector"
 	"github.com/karimra/gnmic/config"
+	"github.com/karimra/gnmic/formatters/event_trigger"
 	"github.com/karimra/gnmic/types"
 	"github.com/karimra/gnmic/utils"
 	"github.com/prometheus/client_golang/prometheus"
@@ -25,6 +29,19 @@ func (a *App) newAPIServer() (*http.Server, error) {
 		a.router.Handle("/metrics", promhttp.HandlerFor(a.reg, promhttp.HandlerOpts{}))
 		a.reg.MustRegister(prometheus.NewGoCollector())
 		a.reg.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+		a.reg.MustRegister(apiRejectedRequests)
+	}
+	if a.Config.APIServer.Auth != nil {
+		auth, err := newAPIAuthenticator(a.Config.APIServer.Auth, a.Logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init API authentication: %v", err)
+		}
+		a.router.Use(auth.middleware)
+		if a.Config.APIServer.Auth.ClientCert && tlscfg != nil {
+			// verify the client certificates presented by the API clients
+			tlscfg.ClientCAs = tlscfg.RootCAs
+			tlscfg.ClientAuth = tls.VerifyClientCertIfGiven
+		}
 	}
 	s := &http.Server{
 		Addr:         a.Config.APIServer.Address,
@@ -116,33 +133,257 @@ func (a *App) handleConfigTargetsDelete(w http.ResponseWriter, r *http.Request)
 }
 
 func (a *App) handleConfigSubscriptions(w http.ResponseWriter, r *http.Request) {
-	subsc, err := a.Config.GetSubscriptions(nil)
+	vars := mux.Vars(r)
+	id := vars["id"]
+	var subsc map[string]*types.SubscriptionConfig
+	var err error
+	if a.collector != nil {
+		// the collector holds the subscriptions changes applied through the API
+		subsc = a.collector.SubscriptionsConfig()
+	} else {
+		subsc, err = a.Config.GetSubscriptions(nil)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+			return
+		}
+	}
+	if id == "" {
+		err = json.NewEncoder(w).Encode(subsc)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		}
+		return
+	}
+	if sc, ok := subsc[id]; ok {
+		err = json.NewEncoder(w).Encode(sc)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(APIErrors{Errors: []string{fmt.Sprintf("subscription %q not found", id)}})
+}
+
+// handleConfigSubscriptionsPost creates a new subscription and subscribes the running targets it applies to.
+func (a *App) handleConfigSubscriptionsPost(w http.ResponseWriter, r *http.Request) {
+	if !a.collectorRunning(w) {
+		return
+	}
+	sc, err := readSubscriptionConfig(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		return
+	}
+	if _, ok := a.collector.SubscriptionsConfig()[sc.Name]; ok {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{fmt.Sprintf("subscription %q already exists", sc.Name)}})
+		return
+	}
+	a.applySubscriptionConfig(w, sc)
+}
+
+// handleConfigSubscriptionsPut creates or replaces the subscription {id},
+// the targets using it are re-subscribed.
+func (a *App) handleConfigSubscriptionsPut(w http.ResponseWriter, r *http.Request) {
+	if !a.collectorRunning(w) {
+		return
+	}
+	vars := mux.Vars(r)
+	id := vars["id"]
+	sc, err := readSubscriptionConfig(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		return
+	}
+	if sc.Name == "" {
+		sc.Name = id
+	}
+	if sc.Name != id {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{fmt.Sprintf("subscription name %q does not match %q", sc.Name, id)}})
+		return
+	}
+	a.applySubscriptionConfig(w, sc)
+}
+
+func (a *App) handleConfigSubscriptionsDelete(w http.ResponseWriter, r *http.Request) {
+	if !a.collectorRunning(w) {
+		return
+	}
+	vars := mux.Vars(r)
+	id := vars["id"]
+	err := a.collector.DeleteSubscription(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
 		return
 	}
-	err = json.NewEncoder(w).Encode(subsc)
+	a.m.Lock()
+	delete(a.Config.Subscriptions, id)
+	a.m.Unlock()
+}
+
+func (a *App) applySubscriptionConfig(w http.ResponseWriter, sc *types.SubscriptionConfig) {
+	subs := a.collector.SubscriptionsConfig()
+	delete(subs, sc.Name)
+	err := a.Config.SetSubscriptionConfigDefaults(sc, subs)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		return
+	}
+	err = a.collector.UpdateSubscriptionConfig(sc)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
 		return
 	}
+	a.m.Lock()
+	a.Config.Subscriptions[sc.Name] = sc
+	a.m.Unlock()
+}
+
+func readSubscriptionConfig(r *http.Request) (*types.SubscriptionConfig, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	sc := new(types.SubscriptionConfig)
+	err = json.Unmarshal(body, sc)
+	if err != nil {
+		return nil, err
+	}
+	return sc, nil
 }
 
 func (a *App) handleConfigOutputs(w http.ResponseWriter, r *http.Request) {
-	outputs, err := a.Config.GetOutputs()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	var outputs map[string]map[string]interface{}
+	var err error
+	if a.collector != nil {
+		// the collector holds the outputs changes applied through the API
+		outputs = a.collector.OutputsConfig()
+	} else {
+		a.m.Lock()
+		outputs, err = a.Config.GetOutputs()
+		a.m.Unlock()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+			return
+		}
+	}
+	if id == "" {
+		err = json.NewEncoder(w).Encode(outputs)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		}
+		return
+	}
+	if o, ok := outputs[id]; ok {
+		err = json.NewEncoder(w).Encode(o)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(APIErrors{Errors: []string{fmt.Sprintf("output %q not found", id)}})
+}
+
+// handleConfigOutputsPost creates and initializes a new output,
+// the output name is read from the body field "name".
+func (a *App) handleConfigOutputsPost(w http.ResponseWriter, r *http.Request) {
+	if !a.collectorRunning(w) {
+		return
+	}
+	name, cfg, err := readNamedConfig(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
 		return
 	}
-	err = json.NewEncoder(w).Encode(outputs)
+	if _, ok := a.collector.OutputsConfig()[name]; ok {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{fmt.Sprintf("output %q already exists", name)}})
+		return
+	}
+	a.applyOutputConfig(w, name, cfg)
+}
+
+// handleConfigOutputsPut creates or replaces the output {id},
+// an existing output is closed once its ongoing writes are done.
+func (a *App) handleConfigOutputsPut(w http.ResponseWriter, r *http.Request) {
+	if !a.collectorRunning(w) {
+		return
+	}
+	vars := mux.Vars(r)
+	id := vars["id"]
+	name, cfg, err := readNamedConfig(r)
+	if err != nil && err != errMissingName {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		return
+	}
+	if name != "" && name != id {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{fmt.Sprintf("output name %q does not match %q", name, id)}})
+		return
+	}
+	a.applyOutputConfig(w, id, cfg)
+}
+
+func (a *App) handleConfigOutputsDelete(w http.ResponseWriter, r *http.Request) {
+	if !a.collectorRunning(w) {
+		return
+	}
+	vars := mux.Vars(r)
+	id := vars["id"]
+	err := a.collector.DeleteOutput(id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		return
+	}
+	a.m.Lock()
+	delete(a.Config.Outputs, id)
+	a.m.Unlock()
+}
+
+func (a *App) applyOutputConfig(w http.ResponseWriter, name string, cfg map[string]interface{}) {
+	err := a.Config.SetOutputConfigDefaults(cfg)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		return
+	}
+	eps := a.collector.ProcessorsConfig()
+	for _, ep := range collector.OutputProcessors(cfg) {
+		if _, ok := eps[ep]; !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(APIErrors{Errors: []string{fmt.Sprintf("unknown processor %q", ep)}})
+			return
+		}
+	}
+	err = a.collector.UpdateOutput(a.ctx, name, cfg)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
 		return
 	}
+	a.m.Lock()
+	a.Config.Outputs[name] = cfg
+	a.m.Unlock()
 }
 
 func (a *App) handleConfigClustering(w http.ResponseWriter, r *http.Request) {
@@ -176,18 +417,156 @@ func (a *App) handleConfigInputs(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *App) handleConfigProcessors(w http.ResponseWriter, r *http.Request) {
-	evps, err := a.Config.GetEventProcessors()
+	vars := mux.Vars(r)
+	id := vars["id"]
+	var evps map[string]map[string]interface{}
+	var err error
+	if a.collector != nil {
+		evps = a.collector.ProcessorsConfig()
+	} else {
+		a.m.Lock()
+		evps, err = a.Config.GetEventProcessors()
+		a.m.Unlock()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+			return
+		}
+	}
+	if id == "" {
+		err = json.NewEncoder(w).Encode(evps)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		}
+		return
+	}
+	if ep, ok := evps[id]; ok {
+		err = json.NewEncoder(w).Encode(ep)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(APIErrors{Errors: []string{fmt.Sprintf("processor %q not found", id)}})
+}
+
+// handleConfigProcessorsPost creates a new event processor,
+// the processor name is read from the body field "name".
+func (a *App) handleConfigProcessorsPost(w http.ResponseWriter, r *http.Request) {
+	if !a.collectorRunning(w) {
+		return
+	}
+	name, cfg, err := readNamedConfig(r)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		return
+	}
+	if _, ok := a.collector.ProcessorsConfig()[name]; ok {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{fmt.Sprintf("processor %q already exists", name)}})
+		return
+	}
+	a.applyProcessorConfig(w, name, cfg)
+}
+
+// handleConfigProcessorsPut creates or replaces the event processor {id},
+// the outputs using it are re-initialized.
+func (a *App) handleConfigProcessorsPut(w http.ResponseWriter, r *http.Request) {
+	if !a.collectorRunning(w) {
+		return
+	}
+	vars := mux.Vars(r)
+	id := vars["id"]
+	name, cfg, err := readNamedConfig(r)
+	if err != nil && err != errMissingName {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		return
+	}
+	if name != "" && name != id {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{fmt.Sprintf("processor name %q does not match %q", name, id)}})
+		return
+	}
+	a.applyProcessorConfig(w, id, cfg)
+}
+
+func (a *App) handleConfigProcessorsDelete(w http.ResponseWriter, r *http.Request) {
+	if !a.collectorRunning(w) {
+		return
+	}
+	vars := mux.Vars(r)
+	id := vars["id"]
+	if _, ok := a.collector.ProcessorsConfig()[id]; !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{fmt.Sprintf("processor %q not found", id)}})
+		return
+	}
+	err := a.collector.DeleteProcessor(id)
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+		return
+	}
+	a.m.Lock()
+	delete(a.Config.Processors, id)
+	a.m.Unlock()
+}
+
+func (a *App) applyProcessorConfig(w http.ResponseWriter, name string, cfg map[string]interface{}) {
+	err := a.Config.SetProcessorConfigDefaults(cfg)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
 		return
 	}
-	err = json.NewEncoder(w).Encode(evps)
+	err = a.collector.UpdateProcessor(a.ctx, name, cfg)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
 		return
 	}
+	a.m.Lock()
+	a.Config.Processors[name] = cfg
+	a.m.Unlock()
+}
+
+var errMissingName = errors.New("missing name")
+
+// collectorRunning returns false and writes a 503 response if there is no running collector
+// to apply the configuration changes to.
+func (a *App) collectorRunning(w http.ResponseWriter) bool {
+	if a.collector != nil {
+		return true
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(APIErrors{Errors: []string{"configuration changes require a running collector"}})
+	return false
+}
+
+// readNamedConfig reads a json config object from the request body,
+// the "name" field is removed from the returned config.
+func readNamedConfig(r *http.Request) (string, map[string]interface{}, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Body.Close()
+	cfg := make(map[string]interface{})
+	err = json.Unmarshal(body, &cfg)
+	if err != nil {
+		return "", nil, err
+	}
+	name, _ := cfg["name"].(string)
+	delete(cfg, "name")
+	if name == "" {
+		return "", cfg, errMissingName
+	}
+	return name, cfg, nil
 }
 
 func (a *App) handleConfig(w http.ResponseWriter, r *http.Request) {
@@ -267,6 +646,16 @@ func (a *App) handleTargetsDelete(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleAlarmsGet returns the alarms raised by the event-trigger processors
+// and not cleared yet.
+func (a *App) handleAlarmsGet(w http.ResponseWriter, r *http.Request) {
+	err := json.NewEncoder(w).Encode(event_trigger.ActiveAlarms())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(APIErrors{Errors: []string{err.Error()}})
+	}
+}
+
 func headersMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
@@ -0,0 +1,215 @@
+package app
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/karimra/gnmic/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// clusterAPIUser is the identity of the cluster members authenticated with the cluster token
+const clusterAPIUser = "gnmic-cluster"
+
+var (
+	errAPINoCredentials      = errors.New("no credentials")
+	errAPIInvalidCredentials = errors.New("invalid credentials")
+)
+
+var apiRejectedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "api",
+	Name:      "rejected_requests_total",
+	Help:      "Number of API requests rejected because they are unauthorized or forbidden",
+}, []string{"reason"})
+
+// apiAuthenticator authenticates the API requests and authorizes them based on the user role
+type apiAuthenticator struct {
+	cfg *config.APIAuth
+	// token to user
+	tokens map[string]string
+	// user to password hash
+	htpasswd map[string]string
+	// user to role
+	roles  map[string]string
+	logger *log.Logger
+}
+
+func newAPIAuthenticator(cfg *config.APIAuth, logger *log.Logger) (*apiAuthenticator, error) {
+	aa := &apiAuthenticator{
+		cfg:    cfg,
+		tokens: make(map[string]string, len(cfg.Tokens)),
+		roles:  make(map[string]string),
+		logger: logger,
+	}
+	for _, t := range cfg.Tokens {
+		aa.tokens[t.Token] = t.User
+	}
+	for role, users := range cfg.Roles {
+		for _, u := range users {
+			if r, ok := aa.roles[u]; ok && r != role {
+				return nil, fmt.Errorf("user %q has more than one role", u)
+			}
+			aa.roles[u] = role
+		}
+	}
+	if cfg.HtpasswdFile != "" {
+		var err error
+		aa.htpasswd, err = readHtpasswdFile(cfg.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return aa, nil
+}
+
+// middleware rejects the requests that cannot be authenticated with 401
+// and the ones not allowed for the user role with 403.
+func (aa *apiAuthenticator) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := aa.authenticate(r)
+		if err != nil {
+			aa.logger.Printf("API: unauthorized request %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			apiRejectedRequests.WithLabelValues("unauthorized").Inc()
+			if aa.htpasswd != nil {
+				w.Header().Set("WWW-Authenticate", `Basic realm="gnmic"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="gnmic"`)
+			}
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(APIErrors{Errors: []string{"unauthorized"}})
+			return
+		}
+		role := aa.role(user)
+		if !roleAllows(role, r.Method) {
+			aa.logger.Printf("API: forbidden request %s %s from %s, user %q with role %q", r.Method, r.URL.Path, r.RemoteAddr, user, role)
+			apiRejectedRequests.WithLabelValues("forbidden").Inc()
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(APIErrors{Errors: []string{fmt.Sprintf("user %q is not allowed to %s %s", user, r.Method, r.URL.Path)}})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns the user identity of request r,
+// from a bearer token, a basic authentication header or a verified client certificate.
+func (aa *apiAuthenticator) authenticate(r *http.Request) (string, error) {
+	authz := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(authz, "Bearer "):
+		token := strings.TrimSpace(strings.TrimPrefix(authz, "Bearer "))
+		if aa.cfg.ClusterToken != "" && secureCompare(token, aa.cfg.ClusterToken) {
+			return clusterAPIUser, nil
+		}
+		for t, user := range aa.tokens {
+			if secureCompare(token, t) {
+				return user, nil
+			}
+		}
+		return "", errAPIInvalidCredentials
+	case strings.HasPrefix(authz, "Basic "):
+		user, password, ok := r.BasicAuth()
+		if !ok || aa.htpasswd == nil {
+			return "", errAPIInvalidCredentials
+		}
+		hash, ok := aa.htpasswd[user]
+		if !ok || !checkHtpasswd(hash, password) {
+			return "", errAPIInvalidCredentials
+		}
+		return user, nil
+	case authz != "":
+		return "", fmt.Errorf("unsupported authorization scheme")
+	}
+	if aa.cfg.ClientCert && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.PeerCertificates) > 0 {
+		cn := r.TLS.PeerCertificates[0].Subject.CommonName
+		if cn != "" {
+			return cn, nil
+		}
+	}
+	return "", errAPINoCredentials
+}
+
+func (aa *apiAuthenticator) role(user string) string {
+	if user == clusterAPIUser {
+		return config.APIRoleReadWrite
+	}
+	if role, ok := aa.roles[user]; ok {
+		return role
+	}
+	return aa.cfg.DefaultRole
+}
+
+// roleAllows returns true if the role is allowed to send a request with method
+func roleAllows(role, method string) bool {
+	switch role {
+	case config.APIRoleReadWrite:
+		return true
+	case config.APIRoleReadOnly:
+		switch method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return true
+		}
+	}
+	return false
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// readHtpasswdFile reads an htpasswd file with bcrypt or SHA1 hashed passwords
+func readHtpasswdFile(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("%s: malformed line %d", name, lineNum)
+		}
+		if !isBcryptHash(parts[1]) && !strings.HasPrefix(parts[1], "{SHA}") {
+			return nil, fmt.Errorf("%s: line %d: unsupported password hash for user %q, use bcrypt or SHA1", name, lineNum, parts[0])
+		}
+		users[parts[0]] = parts[1]
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$")
+}
+
+func checkHtpasswd(hash, password string) bool {
+	if isBcryptHash(hash) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	}
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(password))
+		return secureCompare(strings.TrimPrefix(hash, "{SHA}"), base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/karimra/gnmic/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAPIAuthMiddleware(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gnmic-api-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	hash, err := bcrypt.GenerateFromPassword([]byte("alicepwd"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	htpasswd := filepath.Join(dir, "htpasswd")
+	// bobpwd SHA1
+	content := "# users\nalice:" + string(hash) + "\nbob:{SHA}tjm75MZa6ep5tCaHAehlaoDJWxQ=\n"
+	err = ioutil.WriteFile(htpasswd, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aa, err := newAPIAuthenticator(&config.APIAuth{
+		Tokens:       []*config.APIToken{{User: "ci", Token: "ci-token"}, {User: "viewer", Token: "viewer-token"}},
+		HtpasswdFile: htpasswd,
+		ClientCert:   true,
+		Roles: map[string][]string{
+			config.APIRoleReadWrite: {"ci", "alice"},
+			config.APIRoleReadOnly:  {"viewer", "bob", "client1"},
+		},
+		ClusterToken: "cluster-token",
+	}, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := aa.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	clientCert := &x509.Certificate{Subject: pkix.Name{CommonName: "client1"}}
+	tests := []struct {
+		name     string
+		method   string
+		setAuth  func(r *http.Request)
+		wantCode int
+	}{
+		{name: "no_credentials", method: http.MethodGet, wantCode: http.StatusUnauthorized},
+		{name: "invalid_token", method: http.MethodGet, wantCode: http.StatusUnauthorized,
+			setAuth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }},
+		{name: "read_write_token_post", method: http.MethodPost, wantCode: http.StatusOK,
+			setAuth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer ci-token") }},
+		{name: "read_only_token_get", method: http.MethodGet, wantCode: http.StatusOK,
+			setAuth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer viewer-token") }},
+		{name: "read_only_token_delete", method: http.MethodDelete, wantCode: http.StatusForbidden,
+			setAuth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer viewer-token") }},
+		{name: "cluster_token_delete", method: http.MethodDelete, wantCode: http.StatusOK,
+			setAuth: func(r *http.Request) { r.Header.Set("Authorization", "Bearer cluster-token") }},
+		{name: "basic_bcrypt_post", method: http.MethodPost, wantCode: http.StatusOK,
+			setAuth: func(r *http.Request) { r.SetBasicAuth("alice", "alicepwd") }},
+		{name: "basic_wrong_password", method: http.MethodGet, wantCode: http.StatusUnauthorized,
+			setAuth: func(r *http.Request) { r.SetBasicAuth("alice", "bobpwd") }},
+		{name: "basic_sha1_get", method: http.MethodGet, wantCode: http.StatusOK,
+			setAuth: func(r *http.Request) { r.SetBasicAuth("bob", "bobpwd") }},
+		{name: "basic_sha1_post", method: http.MethodPost, wantCode: http.StatusForbidden,
+			setAuth: func(r *http.Request) { r.SetBasicAuth("bob", "bobpwd") }},
+		{name: "client_cert_get", method: http.MethodGet, wantCode: http.StatusOK,
+			setAuth: func(r *http.Request) {
+				r.TLS = &tls.ConnectionState{
+					PeerCertificates: []*x509.Certificate{clientCert},
+					VerifiedChains:   [][]*x509.Certificate{{clientCert}},
+				}
+			}},
+		{name: "unverified_client_cert", method: http.MethodGet, wantCode: http.StatusUnauthorized,
+			setAuth: func(r *http.Request) {
+				r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{clientCert}}
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/config/targets", nil)
+			if tt.setAuth != nil {
+				tt.setAuth(req)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("expected status %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestReadHtpasswdFileUnsupportedHash(t *testing.T) {
+	f, err := ioutil.TempFile("", "htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("alice:$apr1$salt$hash\n")
+	f.Close()
+	_, err = readHtpasswdFile(f.Name())
+	if err == nil {
+		t.Fatal("expected an error for an apr1 hash")
+	}
+}
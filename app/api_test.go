@@ -0,0 +1,278 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/collector"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/formatters/event_trigger"
+	"github.com/karimra/gnmic/types"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func newTestAPIApp() *App {
+	a := New()
+	a.collector = collector.New(&collector.Config{}, nil, collector.WithLogger(nil))
+	a.routes()
+	return a
+}
+
+func (a *App) testRequest(t *testing.T, method, url string, body interface{}) *httptest.ResponseRecorder {
+	var b []byte
+	if body != nil {
+		var err error
+		b, err = json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, url, bytes.NewBuffer(b))
+	rec := httptest.NewRecorder()
+	a.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestConfigSubscriptionsCRUD(t *testing.T) {
+	a := newTestAPIApp()
+	sub1 := &types.SubscriptionConfig{
+		Name:  "sub1",
+		Paths: []string{"/interface/statistics"},
+	}
+	rec := a.testRequest(t, http.MethodPost, "/config/subscriptions", sub1)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected POST status %d: %s", rec.Code, rec.Body.String())
+	}
+	// duplicate
+	rec = a.testRequest(t, http.MethodPost, "/config/subscriptions", sub1)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected a conflict, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// missing paths
+	rec = a.testRequest(t, http.MethodPost, "/config/subscriptions", &types.SubscriptionConfig{Name: "sub2"})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a bad request, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// poll and stream subscriptions cannot be mixed
+	rec = a.testRequest(t, http.MethodPut, "/config/subscriptions/sub2", &types.SubscriptionConfig{
+		Paths: []string{"/system"},
+		Mode:  "poll",
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a bad request, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// replace
+	rec = a.testRequest(t, http.MethodPut, "/config/subscriptions/sub1", &types.SubscriptionConfig{
+		Paths:      []string{"/system"},
+		Mode:       "stream",
+		StreamMode: "on-change",
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected PUT status %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = a.testRequest(t, http.MethodGet, "/config/subscriptions/sub1", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected GET status %d: %s", rec.Code, rec.Body.String())
+	}
+	sc := new(types.SubscriptionConfig)
+	if err := json.Unmarshal(rec.Body.Bytes(), sc); err != nil {
+		t.Fatal(err)
+	}
+	if sc.Name != "sub1" || len(sc.Paths) != 1 || sc.Paths[0] != "/system" || sc.StreamMode != "on-change" {
+		t.Fatalf("unexpected subscription config: %+v", sc)
+	}
+
+	rec = a.testRequest(t, http.MethodDelete, "/config/subscriptions/sub1", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected DELETE status %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = a.testRequest(t, http.MethodGet, "/config/subscriptions/sub1", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected subscription to be deleted, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = a.testRequest(t, http.MethodDelete, "/config/subscriptions/sub1", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected a not found, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestConfigSubscriptionsConcurrentGet(t *testing.T) {
+	a := newTestAPIApp()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			name := fmt.Sprintf("sub%d", i)
+			a.testRequest(t, http.MethodPut, "/config/subscriptions/"+name, &types.SubscriptionConfig{
+				Paths: []string{"/system"},
+			})
+			a.testRequest(t, http.MethodDelete, "/config/subscriptions/"+name, nil)
+		}
+	}()
+	req := &gnmi.GetRequest{Path: []*gnmi.Path{{Elem: []*gnmi.PathElem{{Name: "subscriptions"}}}}}
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if _, err := a.handlegNMIcInternalGet(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConfigOutputsAndProcessorsCRUD(t *testing.T) {
+	a := newTestAPIApp()
+	// unknown processor reference
+	rec := a.testRequest(t, http.MethodPost, "/config/outputs", map[string]interface{}{
+		"name":             "out1",
+		"type":             "file",
+		"file-type":        "stdout",
+		"event-processors": []string{"proc1"},
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a bad request, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// invalid processor type
+	rec = a.testRequest(t, http.MethodPut, "/config/processors/proc1", map[string]interface{}{
+		"event-unknown": map[string]interface{}{},
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a bad request, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = a.testRequest(t, http.MethodPost, "/config/processors", map[string]interface{}{
+		"name": "proc1",
+		"event-delete": map[string]interface{}{
+			"tags": []string{"^subscription-name$"},
+		},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected POST status %d: %s", rec.Code, rec.Body.String())
+	}
+	dir, err := ioutil.TempDir("", "gnmic-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "out1.txt")
+	rec = a.testRequest(t, http.MethodPost, "/config/outputs", map[string]interface{}{
+		"name":             "out1",
+		"type":             "file",
+		"filename":         fileName,
+		"event-processors": []string{"proc1"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected POST status %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = a.testRequest(t, http.MethodPost, "/config/outputs", map[string]interface{}{
+		"name": "out1",
+		"type": "file",
+	})
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected a conflict, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = a.testRequest(t, http.MethodPut, "/config/outputs/out2", map[string]interface{}{
+		"type": "unknown",
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a bad request, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = a.testRequest(t, http.MethodGet, "/config/outputs/out1", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected GET status %d: %s", rec.Code, rec.Body.String())
+	}
+	// a processor in use cannot be deleted
+	rec = a.testRequest(t, http.MethodDelete, "/config/processors/proc1", nil)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected a conflict, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// the output is initialized asynchronously, wait for its file to be created
+	for i := 0; i < 100; i++ {
+		if _, err = os.Stat(fileName); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	rec = a.testRequest(t, http.MethodDelete, "/config/outputs/out1", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected DELETE status %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = a.testRequest(t, http.MethodDelete, "/config/processors/proc1", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected DELETE status %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = a.testRequest(t, http.MethodGet, "/config/processors/proc1", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected processor to be deleted, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestConfigChangesWithoutCollector(t *testing.T) {
+	a := New()
+	a.routes()
+	reqs := []struct {
+		method string
+		url    string
+		body   interface{}
+	}{
+		{method: http.MethodPost, url: "/config/subscriptions", body: &types.SubscriptionConfig{Name: "sub1", Paths: []string{"/system"}}},
+		{method: http.MethodPut, url: "/config/subscriptions/sub1", body: &types.SubscriptionConfig{Paths: []string{"/system"}}},
+		{method: http.MethodDelete, url: "/config/subscriptions/sub1"},
+		{method: http.MethodPost, url: "/config/outputs", body: map[string]interface{}{"name": "out1", "type": "file"}},
+		{method: http.MethodPut, url: "/config/outputs/out1", body: map[string]interface{}{"type": "file"}},
+		{method: http.MethodDelete, url: "/config/outputs/out1"},
+		{method: http.MethodPost, url: "/config/processors", body: map[string]interface{}{"name": "proc1"}},
+		{method: http.MethodPut, url: "/config/processors/proc1", body: map[string]interface{}{}},
+		{method: http.MethodDelete, url: "/config/processors/proc1"},
+	}
+	for _, req := range reqs {
+		rec := a.testRequest(t, req.method, req.url, req.body)
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s %s: expected status %d, got %d: %s", req.method, req.url, http.StatusServiceUnavailable, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestAlarmsGet(t *testing.T) {
+	a := newTestAPIApp()
+	p := formatters.EventProcessors["event-trigger"]()
+	err := p.Init(map[string]interface{}{
+		"alarm-name":      "api-test",
+		"condition":       `.values["oper-state"] == "down"`,
+		"clear-condition": `.values["oper-state"] == "up"`,
+		"key-tags":        []string{"source"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Apply(&formatters.EventMsg{
+		Tags:   map[string]string{"source": "r1"},
+		Values: map[string]interface{}{"oper-state": "down"},
+	})
+	defer p.Apply(&formatters.EventMsg{
+		Tags:   map[string]string{"source": "r1"},
+		Values: map[string]interface{}{"oper-state": "up"},
+	})
+	rec := a.testRequest(t, http.MethodGet, "/alarms", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected GET status %d: %s", rec.Code, rec.Body.String())
+	}
+	alarms := make([]*event_trigger.Alarm, 0)
+	err = json.Unmarshal(rec.Body.Bytes(), &alarms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(alarms) != 1 || alarms[0].Name != "api-test" || alarms[0].Key["source"] != "r1" {
+		t.Errorf("unexpected alarms: %s", rec.Body.String())
+	}
+}
@@ -77,6 +77,8 @@ type App struct {
 	match           *match.Match
 	subscribeRPCsem *semaphore.Weighted
 	unaryRPCsem     *semaphore.Weighted
+	gnmiAuth        *gnmiAuthorizer
+	history         *collector.History
 }
 
 func New() *App {
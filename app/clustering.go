@@ -522,6 +522,7 @@ func (a *App) deleteTarget(name string) error {
 			continue
 		}
 
+		a.setClusterAuth(req)
 		rsp, err := a.httpClient.Do(req)
 		if err != nil {
 			a.Logger.Printf("failed deleting target %q: %v", name, err)
@@ -549,6 +550,7 @@ func (a *App) assignTarget(ctx context.Context, tc *types.TargetConfig, service
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	a.setClusterAuth(req)
 	resp, err := a.httpClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
@@ -562,6 +564,7 @@ func (a *App) assignTarget(ctx context.Context, tc *types.TargetConfig, service
 	if err != nil {
 		return err
 	}
+	a.setClusterAuth(req)
 	resp, err = a.httpClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
@@ -585,6 +588,7 @@ func (a *App) unassignTarget(name string, serviceID string) error {
 		if err != nil {
 			continue
 		}
+		a.setClusterAuth(req)
 		rsp, err := a.httpClient.Do(req)
 		if err != nil {
 			continue
@@ -594,3 +598,11 @@ func (a *App) unassignTarget(name string, serviceID string) error {
 	}
 	return nil
 }
+
+// setClusterAuth adds the cluster token to the API requests sent to the other cluster members
+func (a *App) setClusterAuth(req *http.Request) {
+	if a.Config.APIServer == nil || a.Config.APIServer.Auth == nil || a.Config.APIServer.Auth.ClusterToken == "" {
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+a.Config.APIServer.Auth.ClusterToken)
+}
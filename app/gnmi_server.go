@@ -73,6 +73,14 @@ func (a *App) startGnmiServer() {
 	a.subscribeRPCsem = semaphore.NewWeighted(a.Config.GnmiServer.MaxSubscriptions)
 	a.unaryRPCsem = semaphore.NewWeighted(a.Config.GnmiServer.MaxUnaryRPC)
 	a.c.SetClient(a.Update)
+	if a.Config.GnmiServer.Auth != nil {
+		var err error
+		a.gnmiAuth, err = newGNMIAuthorizer(a.Config.GnmiServer.Auth)
+		if err != nil {
+			a.Logger.Printf("failed to init gNMI server authentication: %v", err)
+			return
+		}
+	}
 	//
 	var l net.Listener
 	var err error
@@ -216,6 +224,7 @@ func (a *App) gRPCServerOpts() ([]grpc.ServerOption, error) {
 			grpc.UnaryInterceptor(grpcMetrics.UnaryServerInterceptor()),
 		)
 		a.reg.MustRegister(grpcMetrics)
+		a.reg.MustRegister(gnmiServerDeniedRPCs)
 	}
 
 	tlscfg, err := utils.NewTLSConfig(a.Config.GnmiServer.CaFile, a.Config.GnmiServer.CertFile, a.Config.GnmiServer.KeyFile, a.Config.GnmiServer.SkipVerify)
@@ -224,6 +233,8 @@ func (a *App) gRPCServerOpts() ([]grpc.ServerOption, error) {
 	}
 	if tlscfg != nil {
 		opts = append(opts, grpc.Creds(credentials.NewTLS(tlscfg)))
+	} else if a.Config.GnmiServer.Auth != nil && a.Config.GnmiServer.Auth.HtpasswdFile != "" {
+		a.Logger.Printf("gNMI server authentication is enabled without TLS, the clients credentials are sent in clear text")
 	}
 
 	return opts, nil
@@ -250,6 +261,14 @@ OUTER:
 	return targets, nil
 }
 
+func targetsNames(targets map[string]*types.TargetConfig) []string {
+	names := make([]string, 0, len(targets))
+	for n := range targets {
+		names = append(names, utils.GetHost(n))
+	}
+	return names
+}
+
 func (a *App) Update(n *ctree.Leaf) {
 	switch v := n.Value().(type) {
 	case *gnmi.Notification:
@@ -285,6 +304,10 @@ func (a *App) Get(ctx context.Context, req *gnmi.GetRequest) (*gnmi.GetResponse,
 	}
 
 	if _, ok := origins["gnmic"]; ok {
+		err := a.authorizeGNMI(ctx, gnmiRPCGet, []string{gnmicInternalTarget}, fullPaths(req.GetPrefix(), req.GetPath()...))
+		if err != nil {
+			return nil, err
+		}
 		return a.handlegNMIcInternalGet(ctx, req)
 	}
 
@@ -300,6 +323,10 @@ func (a *App) Get(ctx context.Context, req *gnmi.GetRequest) (*gnmi.GetResponse,
 	if numTargets == 0 {
 		return nil, status.Errorf(codes.NotFound, "unknown target %q", targetName)
 	}
+	err = a.authorizeGNMI(ctx, gnmiRPCGet, targetsNames(targets), fullPaths(req.GetPrefix(), req.GetPath()...))
+	if err != nil {
+		return nil, err
+	}
 	results := make(chan *gnmi.Notification)
 	errChan := make(chan error, numTargets)
 
@@ -406,6 +433,26 @@ func (a *App) Set(ctx context.Context, req *gnmi.SetRequest) (*gnmi.SetResponse,
 	if numTargets == 0 {
 		return nil, status.Errorf(codes.NotFound, "unknown target(s) %q", targetName)
 	}
+	setPaths := make([]*gnmi.Path, 0, numUpdates+numReplaces+numDeletes)
+	for _, upd := range req.GetUpdate() {
+		setPaths = append(setPaths, upd.GetPath())
+	}
+	for _, upd := range req.GetReplace() {
+		setPaths = append(setPaths, upd.GetPath())
+	}
+	setPaths = append(setPaths, req.GetDelete()...)
+	err = a.authorizeGNMI(ctx, gnmiRPCSet, targetsNames(targets), fullPaths(req.GetPrefix(), setPaths...))
+	if err != nil {
+		return nil, err
+	}
+	if a.Config.GnmiServer.TransactionalSet {
+		response, err := a.transactionalSet(ctx, req, targets)
+		if err != nil {
+			return nil, err
+		}
+		a.Logger.Printf("sending SetResponse to %q: %+v", pr.Addr, response)
+		return response, nil
+	}
 	results := make(chan *gnmi.UpdateResult)
 	errChan := make(chan error, numTargets)
 
@@ -506,6 +553,27 @@ func (a *App) Subscribe(stream gnmi.GNMI_SubscribeServer) error {
 	if !a.c.HasTarget(sc.target) {
 		return status.Errorf(codes.NotFound, "target %q not found", sc.target)
 	}
+	if a.gnmiAuth != nil {
+		subTargets := []string{sc.target}
+		if sc.target == "*" {
+			a.m.RLock()
+			subTargets = targetsNames(a.Config.Targets)
+			a.m.RUnlock()
+		}
+		subPaths := make([]*gnmi.Path, 0, len(sc.req.GetSubscribe().GetSubscription()))
+		for _, sub := range sc.req.GetSubscribe().GetSubscription() {
+			subPaths = append(subPaths, sub.GetPath())
+		}
+		err = a.authorizeGNMI(stream.Context(), gnmiRPCSubscribe, subTargets, fullPaths(sc.req.GetSubscribe().GetPrefix(), subPaths...))
+		if err != nil {
+			return err
+		}
+	}
+
+	hr, err := a.getHistoryRequest(sc.req)
+	if err != nil {
+		return err
+	}
 
 	a.Logger.Printf("received a subscribe request mode=%v from %q for target %q", sc.req.GetSubscribe().GetMode(), pr.Addr, sc.target)
 	defer a.Logger.Printf("subscription from peer %q terminated", pr.Addr)
@@ -521,12 +589,23 @@ func (a *App) Subscribe(stream gnmi.GNMI_SubscribeServer) error {
 	}
 	a.Logger.Printf("acquired subscription spot for target %q", sc.target)
 
-	switch sc.req.GetSubscribe().GetMode() {
-	case gnmi.SubscriptionList_ONCE:
+	switch {
+	case hr != nil && !hr.live:
+		go a.handleHistorySubscription(sc, hr)
+	case sc.req.GetSubscribe().GetMode() == gnmi.SubscriptionList_ONCE:
 		go a.handleONCESubscriptionRequest(sc)
-	case gnmi.SubscriptionList_POLL:
+	case sc.req.GetSubscribe().GetMode() == gnmi.SubscriptionList_POLL:
 		go a.handlePolledSubscription(sc)
-	case gnmi.SubscriptionList_STREAM:
+	case sc.req.GetSubscribe().GetMode() == gnmi.SubscriptionList_STREAM:
+		if hr != nil {
+			// the history is replayed before the current state and the live updates
+			a.Logger.Printf("replaying history to target %q from %s", sc.target, hr.start)
+			err = a.replayHistory(sc, hr)
+			if err != nil {
+				a.subscribeRPCsem.Release(1)
+				return status.Errorf(codes.Internal, "failed to replay history: %v", err)
+			}
+		}
 		go a.handleStreamSubscriptionRequest(sc)
 	default:
 		return status.Errorf(codes.InvalidArgument, "unrecognized subscription mode: %v", sc.req.GetSubscribe().GetMode())
@@ -0,0 +1,249 @@
+package app
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/karimra/gnmic/config"
+	"github.com/karimra/gnmic/utils"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// gNMI RPCs names used in the access rules
+const (
+	gnmiRPCGet       = "get"
+	gnmiRPCSet       = "set"
+	gnmiRPCSubscribe = "subscribe"
+)
+
+// gnmicInternalTarget is the target name used to authorize the Get requests with origin `gnmic`
+const gnmicInternalTarget = "gnmic"
+
+var gnmiServerDeniedRPCs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "gnmi_server",
+	Name:      "denied_rpcs_total",
+	Help:      "Number of gNMI RPCs rejected because they are unauthenticated or not allowed",
+}, []string{"user", "rpc", "reason"})
+
+// gnmiAuthorizer authenticates the gNMI server clients and checks their RPCs against the access rules
+type gnmiAuthorizer struct {
+	htpasswd      map[string]string
+	rules         []*gnmiAccessRule
+	defaultAction string
+}
+
+type gnmiAccessRule struct {
+	users   map[string]struct{}
+	rpcs    map[string]struct{}
+	targets []string
+	paths   []*gnmi.Path
+	action  string
+}
+
+func newGNMIAuthorizer(cfg *config.GNMIServerAuth) (*gnmiAuthorizer, error) {
+	ga := &gnmiAuthorizer{
+		rules:         make([]*gnmiAccessRule, 0, len(cfg.Rules)),
+		defaultAction: cfg.DefaultAction,
+	}
+	if cfg.HtpasswdFile != "" {
+		var err error
+		ga.htpasswd, err = readHtpasswdFile(cfg.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range cfg.Rules {
+		ar := &gnmiAccessRule{
+			targets: r.Targets,
+			paths:   make([]*gnmi.Path, 0, len(r.Paths)),
+			action:  r.Action,
+		}
+		if len(r.Users) > 0 {
+			ar.users = make(map[string]struct{}, len(r.Users))
+			for _, u := range r.Users {
+				ar.users[u] = struct{}{}
+			}
+		}
+		if len(r.RPCs) > 0 {
+			ar.rpcs = make(map[string]struct{}, len(r.RPCs))
+			for _, rpc := range r.RPCs {
+				ar.rpcs[rpc] = struct{}{}
+			}
+		}
+		for _, p := range r.Paths {
+			gp, err := utils.ParsePath(p)
+			if err != nil {
+				return nil, err
+			}
+			ar.paths = append(ar.paths, gp)
+		}
+		ga.rules = append(ga.rules, ar)
+	}
+	return ga, nil
+}
+
+// authenticate returns the username sent in the RPC metadata after checking its password.
+// Without an htpasswd file, the clients are anonymous and an empty username is returned.
+func (ga *gnmiAuthorizer) authenticate(ctx context.Context) (string, error) {
+	if ga.htpasswd == nil {
+		return "", nil
+	}
+	var username, password string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("username"); len(v) > 0 {
+			username = v[0]
+		}
+		if v := md.Get("password"); len(v) > 0 {
+			password = v[0]
+		}
+	}
+	if username == "" {
+		return "", status.Errorf(codes.Unauthenticated, "missing username")
+	}
+	hash, ok := ga.htpasswd[username]
+	if !ok || !checkHtpasswd(hash, password) {
+		return "", status.Errorf(codes.Unauthenticated, "invalid username or password")
+	}
+	return username, nil
+}
+
+// authorize checks that user is allowed to send rpc for all the paths on all the targets
+func (ga *gnmiAuthorizer) authorize(user, rpc string, targets []string, paths []*gnmi.Path) error {
+	for _, t := range targets {
+		for _, p := range paths {
+			if ga.action(user, rpc, t, p) != config.GNMIAccessAllow {
+				return status.Errorf(codes.PermissionDenied, "user %q is not allowed to %s path %q on target %q",
+					user, rpc, "/"+utils.GnmiPathToXPath(p, false), t)
+			}
+		}
+	}
+	return nil
+}
+
+// action returns the action of the first rule matching the request, or the default action
+func (ga *gnmiAuthorizer) action(user, rpc, target string, p *gnmi.Path) string {
+	for _, r := range ga.rules {
+		if r.matches(user, rpc, target, p) {
+			return r.action
+		}
+	}
+	return ga.defaultAction
+}
+
+func (r *gnmiAccessRule) matches(user, rpc, target string, p *gnmi.Path) bool {
+	if !matchSet(r.users, user) || !matchSet(r.rpcs, rpc) {
+		return false
+	}
+	if len(r.targets) > 0 {
+		found := false
+		for _, pattern := range r.targets {
+			if ok, _ := filepath.Match(pattern, target); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.paths) == 0 {
+		return true
+	}
+	for _, rp := range r.paths {
+		// an allow rule must contain the requested path,
+		// a deny rule applies as soon as the requested path overlaps the denied one.
+		if matchPath(rp, p, r.action == config.GNMIAccessDeny) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSet(set map[string]struct{}, v string) bool {
+	if set == nil {
+		return true
+	}
+	if _, ok := set["*"]; ok {
+		return true
+	}
+	_, ok := set[v]
+	return ok
+}
+
+// matchPath returns true if the rule path rp is a prefix of the requested path p,
+// if overlap is true, it also returns true if p is a prefix of rp.
+// Element names and key values equal to "*" match any value,
+// a key missing from one of the paths matches any value only if overlap is true.
+func matchPath(rp, p *gnmi.Path, overlap bool) bool {
+	if rp.GetOrigin() != "" && p.GetOrigin() != "" && rp.GetOrigin() != p.GetOrigin() {
+		return false
+	}
+	relems, elems := rp.GetElem(), p.GetElem()
+	if len(relems) > len(elems) && !overlap {
+		return false
+	}
+	for i := 0; i < len(relems) && i < len(elems); i++ {
+		re, e := relems[i], elems[i]
+		if re.GetName() != "*" && e.GetName() != "*" && re.GetName() != e.GetName() {
+			return false
+		}
+		if e.GetName() == "*" && re.GetName() != "*" && !overlap {
+			return false
+		}
+		for k, rv := range re.GetKey() {
+			v, ok := e.GetKey()[k]
+			if !ok || v == "*" {
+				if !overlap && rv != "*" {
+					return false
+				}
+				continue
+			}
+			if rv != "*" && rv != v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// fullPaths returns the paths prefixed with prefix
+func fullPaths(prefix *gnmi.Path, paths ...*gnmi.Path) []*gnmi.Path {
+	if len(paths) == 0 {
+		return []*gnmi.Path{{Origin: prefix.GetOrigin(), Elem: prefix.GetElem()}}
+	}
+	fps := make([]*gnmi.Path, 0, len(paths))
+	for _, p := range paths {
+		origin := p.GetOrigin()
+		if origin == "" {
+			origin = prefix.GetOrigin()
+		}
+		fps = append(fps, &gnmi.Path{Origin: origin, Elem: utils.PathElems(prefix, p)})
+	}
+	return fps
+}
+
+// authorizeGNMI authenticates the client sending an RPC and checks the RPC against the access rules.
+// It returns a gRPC status error if the RPC must be rejected.
+func (a *App) authorizeGNMI(ctx context.Context, rpc string, targets []string, paths []*gnmi.Path) error {
+	if a.gnmiAuth == nil {
+		return nil
+	}
+	user, err := a.gnmiAuth.authenticate(ctx)
+	if err != nil {
+		a.Logger.Printf("gNMI server: unauthenticated %s RPC: %v", rpc, err)
+		gnmiServerDeniedRPCs.WithLabelValues("", rpc, "unauthenticated").Inc()
+		return err
+	}
+	err = a.gnmiAuth.authorize(user, rpc, targets, paths)
+	if err != nil {
+		a.Logger.Printf("gNMI server: denied %s RPC: %v", rpc, err)
+		gnmiServerDeniedRPCs.WithLabelValues(user, rpc, "permission-denied").Inc()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/karimra/gnmic/config"
+	"github.com/karimra/gnmic/utils"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func mustParsePath(t *testing.T, p string) *gnmi.Path {
+	gp, err := utils.ParsePath(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gp
+}
+
+func TestGNMIAuthorizer(t *testing.T) {
+	ga, err := newGNMIAuthorizer(&config.GNMIServerAuth{
+		Rules: []*config.GNMIAccessRule{
+			{
+				Users:  []string{"admin"},
+				Action: config.GNMIAccessAllow,
+			},
+			{
+				RPCs:   []string{"set"},
+				Action: config.GNMIAccessDeny,
+			},
+			{
+				Users:   []string{"telemetry"},
+				Targets: []string{"leaf*"},
+				Paths:   []string{"/interfaces/interface[name=*]/state"},
+				Action:  config.GNMIAccessAllow,
+			},
+			{
+				Paths:  []string{"/system/aaa"},
+				Action: config.GNMIAccessDeny,
+			},
+			{
+				RPCs:   []string{"get", "subscribe"},
+				Action: config.GNMIAccessAllow,
+			},
+		},
+		DefaultAction: config.GNMIAccessDeny,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		user    string
+		rpc     string
+		targets []string
+		path    string
+		allowed bool
+	}{
+		{name: "admin_set", user: "admin", rpc: gnmiRPCSet, targets: []string{"leaf1"}, path: "/system", allowed: true},
+		{name: "user_set", user: "bob", rpc: gnmiRPCSet, targets: []string{"leaf1"}, path: "/system", allowed: false},
+		{name: "telemetry_interfaces", user: "telemetry", rpc: gnmiRPCSubscribe, targets: []string{"leaf1", "leaf2"},
+			path: "/interfaces/interface[name=ethernet-1/1]/state/counters", allowed: true},
+		{name: "telemetry_aaa", user: "telemetry", rpc: gnmiRPCGet, targets: []string{"leaf1"}, path: "/system/aaa/authentication", allowed: false},
+		{name: "overlapping_denied_path", user: "bob", rpc: gnmiRPCGet, targets: []string{"spine1"}, path: "/system", allowed: false},
+		{name: "get_other_path", user: "bob", rpc: gnmiRPCGet, targets: []string{"spine1"}, path: "/interfaces", allowed: true},
+		{name: "set_one_target_denied", user: "telemetry", rpc: gnmiRPCSet, targets: []string{"leaf1", "spine1"},
+			path: "/interfaces/interface[name=ethernet-1/1]/state", allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ga.authorize(tt.user, tt.rpc, tt.targets, []*gnmi.Path{mustParsePath(t, tt.path)})
+			if tt.allowed && err != nil {
+				t.Errorf("expected the request to be allowed, got: %v", err)
+			}
+			if !tt.allowed {
+				if err == nil {
+					t.Errorf("expected the request to be denied")
+				} else if status.Code(err) != codes.PermissionDenied {
+					t.Errorf("expected code %v, got %v", codes.PermissionDenied, status.Code(err))
+				}
+			}
+		})
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		rule    string
+		path    string
+		overlap bool
+		want    bool
+	}{
+		{rule: "/interfaces", path: "/interfaces/interface[name=1]", want: true},
+		{rule: "/interfaces/interface[name=1]", path: "/interfaces", want: false},
+		{rule: "/interfaces/interface[name=1]", path: "/interfaces", overlap: true, want: true},
+		{rule: "/interfaces/interface[name=1]", path: "/interfaces/interface", want: false},
+		{rule: "/interfaces/interface[name=1]", path: "/interfaces/interface", overlap: true, want: true},
+		{rule: "/interfaces/interface[name=1]", path: "/interfaces/interface[name=2]", overlap: true, want: false},
+		{rule: "/interfaces/interface[name=*]", path: "/interfaces/interface[name=2]/state", want: true},
+		{rule: "/interfaces/*/state", path: "/interfaces/interface/state", want: true},
+		{rule: "/interfaces/interface", path: "/interfaces/*", want: false},
+		{rule: "/system", path: "/", want: false},
+		{rule: "/system", path: "/", overlap: true, want: true},
+		{rule: "openconfig:/system", path: "srl:/system", want: false},
+	}
+	for _, tt := range tests {
+		got := matchPath(mustParsePath(t, tt.rule), mustParsePath(t, tt.path), tt.overlap)
+		if got != tt.want {
+			t.Errorf("rule %q, path %q, overlap=%v: expected %v, got %v", tt.rule, tt.path, tt.overlap, tt.want, got)
+		}
+	}
+}
+
+func TestGNMIAuthenticate(t *testing.T) {
+	f, err := ioutil.TempFile("", "htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	// bobpwd SHA1
+	f.WriteString("bob:{SHA}tjm75MZa6ep5tCaHAehlaoDJWxQ=\n")
+	f.Close()
+	ga, err := newGNMIAuthorizer(&config.GNMIServerAuth{HtpasswdFile: f.Name(), DefaultAction: config.GNMIAccessAllow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("username", "bob", "password", "bobpwd"))
+	user, err := ga.authenticate(ctx)
+	if err != nil || user != "bob" {
+		t.Errorf("expected user bob to be authenticated, got %q, %v", user, err)
+	}
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("username", "bob", "password", "wrong"))
+	_, err = ga.authenticate(ctx)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected code %v, got %v", codes.Unauthenticated, err)
+	}
+	_, err = ga.authenticate(context.Background())
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected code %v, got %v", codes.Unauthenticated, err)
+	}
+	// without an htpasswd file the client sent username is ignored
+	ga, err = newGNMIAuthorizer(&config.GNMIServerAuth{DefaultAction: config.GNMIAccessAllow})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("username", "admin"))
+	user, err = ga.authenticate(ctx)
+	if err != nil || user != "" {
+		t.Errorf("expected an anonymous user, got %q, %v", user, err)
+	}
+}
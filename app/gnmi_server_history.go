@@ -0,0 +1,196 @@
+package app
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/karimra/gnmic/utils"
+	"github.com/openconfig/gnmi/ctree"
+	"github.com/openconfig/gnmi/path"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmi/proto/gnmi_ext"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// historyRequest is a validated gNMI History extension
+type historyRequest struct {
+	start time.Time
+	end   time.Time
+	// true if the request is for the state at time end
+	snapshot bool
+	// true if the live updates are sent after the history replay
+	live bool
+}
+
+// getHistoryRequest returns the History extension of a SubscribeRequest if present,
+// the returned error is a gRPC status error.
+func (a *App) getHistoryRequest(req *gnmi.SubscribeRequest) (*historyRequest, error) {
+	var h *gnmi_ext.History
+	for _, ext := range req.GetExtension() {
+		if ext.GetHistory() != nil {
+			h = ext.GetHistory()
+			break
+		}
+	}
+	if h == nil {
+		return nil, nil
+	}
+	if a.history == nil {
+		return nil, status.Errorf(codes.Unimplemented, "history extension is not enabled")
+	}
+	mode := req.GetSubscribe().GetMode()
+	if mode == gnmi.SubscriptionList_POLL {
+		return nil, status.Errorf(codes.InvalidArgument, "history extension is not supported with POLL subscriptions")
+	}
+	now := time.Now()
+	switch r := h.GetRequest().(type) {
+	case *gnmi_ext.History_SnapshotTime:
+		if r.SnapshotTime <= 0 || r.SnapshotTime > now.UnixNano() {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid history snapshot time %d", r.SnapshotTime)
+		}
+		return &historyRequest{end: time.Unix(0, r.SnapshotTime), snapshot: true}, nil
+	case *gnmi_ext.History_Range:
+		hr := &historyRequest{
+			start: time.Unix(0, r.Range.GetStart()),
+			end:   time.Unix(0, r.Range.GetEnd()),
+		}
+		// a range without end or ending in the future continues with the live updates
+		if r.Range.GetEnd() == 0 || hr.end.After(now) {
+			hr.end = now
+			hr.live = mode == gnmi.SubscriptionList_STREAM
+		}
+		if hr.start.After(hr.end) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid history range: start %d is after end %d", r.Range.GetStart(), r.Range.GetEnd())
+		}
+		return hr, nil
+	}
+	return nil, status.Errorf(codes.InvalidArgument, "history extension must have a snapshot time or a range")
+}
+
+// replayHistory inserts the notifications matching the subscription and the history request in the stream client queue
+func (a *App) replayHistory(sc *streamClient, hr *historyRequest) error {
+	subPaths := make([]*gnmi.Path, 0, len(sc.req.GetSubscribe().GetSubscription()))
+	for _, sub := range sc.req.GetSubscribe().GetSubscription() {
+		subPaths = append(subPaths, sub.GetPath())
+	}
+	subPaths = fullPaths(sc.req.GetSubscribe().GetPrefix(), subPaths...)
+
+	if hr.snapshot {
+		return a.replayHistorySnapshot(sc, hr.end, subPaths)
+	}
+	return a.history.Range(sc.target, hr.start, hr.end, func(n *gnmi.Notification) error {
+		fn := filterNotification(n, subPaths)
+		if fn == nil {
+			return nil
+		}
+		_, err := sc.queue.Insert(ctree.DetachedLeaf(fn))
+		return err
+	})
+}
+
+type historyLeaf struct {
+	prefix *gnmi.Path
+	path   *gnmi.Path
+	upd    *gnmi.Update
+	ts     int64
+}
+
+// replayHistorySnapshot inserts the last value of each leaf matching subPaths at time t
+func (a *App) replayHistorySnapshot(sc *streamClient, t time.Time, subPaths []*gnmi.Path) error {
+	leaves := make(map[string]*historyLeaf)
+	err := a.history.Range(sc.target, time.Unix(0, 0), t, func(n *gnmi.Notification) error {
+		fn := filterNotification(n, subPaths)
+		if fn == nil {
+			return nil
+		}
+		target := fn.GetPrefix().GetTarget()
+		for _, d := range fn.GetDelete() {
+			dp := &gnmi.Path{Origin: fn.GetPrefix().GetOrigin(), Elem: utils.PathElems(fn.GetPrefix(), d)}
+			for k, l := range leaves {
+				if l.prefix.GetTarget() == target && matchPath(dp, l.path, false) {
+					delete(leaves, k)
+				}
+			}
+		}
+		for _, upd := range fn.GetUpdate() {
+			p := &gnmi.Path{Origin: fn.GetPrefix().GetOrigin(), Elem: utils.PathElems(fn.GetPrefix(), upd.GetPath())}
+			k := target + "/" + strings.Join(path.ToStrings(p, false), "/")
+			leaves[k] = &historyLeaf{prefix: fn.GetPrefix(), path: p, upd: upd, ts: fn.GetTimestamp()}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sorted := make([]*historyLeaf, 0, len(leaves))
+	for _, l := range leaves {
+		sorted = append(sorted, l)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ts < sorted[j].ts })
+	for _, l := range sorted {
+		_, err = sc.queue.Insert(ctree.DetachedLeaf(&gnmi.Notification{
+			Timestamp: l.ts,
+			Prefix:    l.prefix,
+			Update:    []*gnmi.Update{l.upd},
+		}))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleHistorySubscription replays the history then terminates the subscription
+func (a *App) handleHistorySubscription(sc *streamClient, hr *historyRequest) {
+	a.Logger.Printf("replaying history to target %q from %s to %s", sc.target, hr.start, hr.end)
+	defer sc.queue.Close()
+	err := a.replayHistory(sc, hr)
+	if err != nil {
+		a.Logger.Printf("failed to replay history to target %q: %v", sc.target, err)
+		sc.errChan <- err
+		return
+	}
+	_, err = sc.queue.Insert(syncMarker{})
+	if err != nil {
+		a.Logger.Printf("failed to insert sync response into queue: %v", err)
+	}
+}
+
+// filterNotification returns a notification with the updates and deletes of n matching one of the paths,
+// or nil if none matches.
+func filterNotification(n *gnmi.Notification, paths []*gnmi.Path) *gnmi.Notification {
+	matches := func(p *gnmi.Path) bool {
+		fp := &gnmi.Path{Origin: p.GetOrigin(), Elem: utils.PathElems(n.GetPrefix(), p)}
+		if fp.Origin == "" {
+			fp.Origin = n.GetPrefix().GetOrigin()
+		}
+		for _, sp := range paths {
+			if matchPath(sp, fp, false) {
+				return true
+			}
+		}
+		return false
+	}
+	var fn *gnmi.Notification
+	for _, upd := range n.GetUpdate() {
+		if !matches(upd.GetPath()) {
+			continue
+		}
+		if fn == nil {
+			fn = &gnmi.Notification{Timestamp: n.GetTimestamp(), Prefix: n.GetPrefix()}
+		}
+		fn.Update = append(fn.Update, upd)
+	}
+	for _, d := range n.GetDelete() {
+		if !matches(d) {
+			continue
+		}
+		if fn == nil {
+			fn = &gnmi.Notification{Timestamp: n.GetTimestamp(), Prefix: n.GetPrefix()}
+		}
+		fn.Delete = append(fn.Delete, d)
+	}
+	return fn
+}
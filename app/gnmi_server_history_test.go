@@ -0,0 +1,234 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/collector"
+	"github.com/karimra/gnmic/utils"
+	"github.com/openconfig/gnmi/coalesce"
+	"github.com/openconfig/gnmi/ctree"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmi/proto/gnmi_ext"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func historySubscribeRequest(mode gnmi.SubscriptionList_Mode, h *gnmi_ext.History) *gnmi.SubscribeRequest {
+	return &gnmi.SubscribeRequest{
+		Request: &gnmi.SubscribeRequest_Subscribe{
+			Subscribe: &gnmi.SubscriptionList{
+				Mode: mode,
+				Subscription: []*gnmi.Subscription{{
+					Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "interfaces"}}},
+				}},
+			},
+		},
+		Extension: []*gnmi_ext.Extension{{
+			Ext: &gnmi_ext.Extension_History{History: h},
+		}},
+	}
+}
+
+func TestGetHistoryRequest(t *testing.T) {
+	now := time.Now()
+	a := &App{history: collector.NewHistory(nil)}
+	tests := []struct {
+		name     string
+		req      *gnmi.SubscribeRequest
+		code     codes.Code
+		snapshot bool
+		live     bool
+	}{
+		{
+			name: "no_extension",
+			req:  &gnmi.SubscribeRequest{},
+		},
+		{
+			name: "snapshot",
+			req: historySubscribeRequest(gnmi.SubscriptionList_ONCE, &gnmi_ext.History{
+				Request: &gnmi_ext.History_SnapshotTime{SnapshotTime: now.Add(-time.Minute).UnixNano()},
+			}),
+			snapshot: true,
+		},
+		{
+			name: "snapshot_in_the_future",
+			req: historySubscribeRequest(gnmi.SubscriptionList_ONCE, &gnmi_ext.History{
+				Request: &gnmi_ext.History_SnapshotTime{SnapshotTime: now.Add(time.Hour).UnixNano()},
+			}),
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "range",
+			req: historySubscribeRequest(gnmi.SubscriptionList_STREAM, &gnmi_ext.History{
+				Request: &gnmi_ext.History_Range{Range: &gnmi_ext.TimeRange{
+					Start: now.Add(-time.Minute).UnixNano(),
+					End:   now.Add(-time.Second).UnixNano(),
+				}},
+			}),
+		},
+		{
+			name: "stream_range_without_end",
+			req: historySubscribeRequest(gnmi.SubscriptionList_STREAM, &gnmi_ext.History{
+				Request: &gnmi_ext.History_Range{Range: &gnmi_ext.TimeRange{
+					Start: now.Add(-time.Minute).UnixNano(),
+				}},
+			}),
+			live: true,
+		},
+		{
+			name: "once_range_without_end",
+			req: historySubscribeRequest(gnmi.SubscriptionList_ONCE, &gnmi_ext.History{
+				Request: &gnmi_ext.History_Range{Range: &gnmi_ext.TimeRange{
+					Start: now.Add(-time.Minute).UnixNano(),
+				}},
+			}),
+		},
+		{
+			name: "start_after_end",
+			req: historySubscribeRequest(gnmi.SubscriptionList_STREAM, &gnmi_ext.History{
+				Request: &gnmi_ext.History_Range{Range: &gnmi_ext.TimeRange{
+					Start: now.Add(-time.Second).UnixNano(),
+					End:   now.Add(-time.Minute).UnixNano(),
+				}},
+			}),
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "poll",
+			req: historySubscribeRequest(gnmi.SubscriptionList_POLL, &gnmi_ext.History{
+				Request: &gnmi_ext.History_SnapshotTime{SnapshotTime: now.Add(-time.Minute).UnixNano()},
+			}),
+			code: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hr, err := a.getHistoryRequest(tt.req)
+			if status.Code(err) != tt.code {
+				t.Fatalf("expected code %s, got err: %v", tt.code, err)
+			}
+			if err != nil || len(tt.req.GetExtension()) == 0 {
+				if hr != nil {
+					t.Fatalf("unexpected history request: %+v", hr)
+				}
+				return
+			}
+			if hr.snapshot != tt.snapshot || hr.live != tt.live {
+				t.Errorf("unexpected history request: %+v", hr)
+			}
+		})
+	}
+	_, err := (&App{}).getHistoryRequest(tests[1].req)
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected code Unimplemented when the history is disabled, got err: %v", err)
+	}
+}
+
+func historyUpdate(p string, v string) *gnmi.Update {
+	gp, _ := utils.ParsePath(p)
+	return &gnmi.Update{
+		Path: gp,
+		Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: v}},
+	}
+}
+
+func TestReplayHistorySnapshot(t *testing.T) {
+	now := time.Now()
+	a := &App{history: collector.NewHistory(nil)}
+	prefix := &gnmi.Path{Target: "router1"}
+	a.history.Add(&gnmi.Notification{
+		Timestamp: now.Add(-3 * time.Minute).UnixNano(),
+		Prefix:    prefix,
+		Update: []*gnmi.Update{
+			historyUpdate("interfaces/interface[name=1]/state/oper-status", "DOWN"),
+			historyUpdate("interfaces/interface[name=2]/state/oper-status", "UP"),
+			historyUpdate("system/name", "r1"),
+		},
+	})
+	a.history.Add(&gnmi.Notification{
+		Timestamp: now.Add(-2 * time.Minute).UnixNano(),
+		Prefix:    prefix,
+		Update: []*gnmi.Update{
+			historyUpdate("interfaces/interface[name=1]/state/oper-status", "UP"),
+		},
+	})
+	a.history.Add(&gnmi.Notification{
+		Timestamp: now.Add(-90 * time.Second).UnixNano(),
+		Prefix:    prefix,
+		Delete: []*gnmi.Path{
+			{Elem: []*gnmi.PathElem{{Name: "interfaces"}, {Name: "interface", Key: map[string]string{"name": "2"}}}},
+		},
+	})
+	// after the snapshot time
+	a.history.Add(&gnmi.Notification{
+		Timestamp: now.Add(-time.Minute).UnixNano(),
+		Prefix:    prefix,
+		Update: []*gnmi.Update{
+			historyUpdate("interfaces/interface[name=1]/state/oper-status", "DOWN"),
+		},
+	})
+
+	sc := &streamClient{
+		target: "router1",
+		req:    historySubscribeRequest(gnmi.SubscriptionList_ONCE, nil),
+		queue:  coalesce.NewQueue(),
+	}
+	err := a.replayHistory(sc, &historyRequest{end: now.Add(-80 * time.Second), snapshot: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.queue.Close()
+	got := make([]*gnmi.Notification, 0)
+	for {
+		item, _, err := sc.queue.Next(context.Background())
+		if coalesce.IsClosedQueue(err) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, item.(*ctree.Leaf).Value().(*gnmi.Notification))
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 notification, got %d: %v", len(got), got)
+	}
+	upd := got[0].GetUpdate()[0]
+	if xp := utils.GnmiPathToXPath(upd.GetPath(), false); xp != "interfaces/interface[name=1]/state/oper-status" {
+		t.Errorf("unexpected path %q", xp)
+	}
+	if upd.GetVal().GetStringVal() != "UP" {
+		t.Errorf("unexpected value %q", upd.GetVal().GetStringVal())
+	}
+}
+
+func TestFilterNotification(t *testing.T) {
+	n := &gnmi.Notification{
+		Prefix: &gnmi.Path{Target: "router1", Elem: []*gnmi.PathElem{{Name: "interfaces"}}},
+		Update: []*gnmi.Update{
+			historyUpdate("interface[name=1]/state/counters/in-octets", "1"),
+			historyUpdate("interface[name=2]/state/counters/in-octets", "2"),
+		},
+		Delete: []*gnmi.Path{
+			{Elem: []*gnmi.PathElem{{Name: "interface", Key: map[string]string{"name": "3"}}}},
+		},
+	}
+	p, _ := utils.ParsePath("interfaces/interface[name=2]")
+	fn := filterNotification(n, []*gnmi.Path{p})
+	if fn == nil || len(fn.GetUpdate()) != 1 || len(fn.GetDelete()) != 0 {
+		t.Fatalf("unexpected filtered notification: %v", fn)
+	}
+	if fn.GetUpdate()[0].GetVal().GetStringVal() != "2" {
+		t.Errorf("unexpected filtered update: %v", fn.GetUpdate()[0])
+	}
+	p, _ = utils.ParsePath("interfaces/interface[name=*]")
+	fn = filterNotification(n, []*gnmi.Path{p})
+	if fn == nil || len(fn.GetUpdate()) != 2 || len(fn.GetDelete()) != 1 {
+		t.Errorf("unexpected filtered notification: %v", fn)
+	}
+	p, _ = utils.ParsePath("system")
+	if fn = filterNotification(n, []*gnmi.Path{p}); fn != nil {
+		t.Errorf("expected no notification, got %v", fn)
+	}
+}
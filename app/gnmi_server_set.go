@@ -0,0 +1,274 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karimra/gnmic/target"
+	"github.com/karimra/gnmic/types"
+	"github.com/karimra/gnmic/utils"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// transactional Set per target outcomes
+const (
+	setOutcomeApplied        = "applied"
+	setOutcomeNotApplied     = "not-applied"
+	setOutcomeFailed         = "failed"
+	setOutcomeRolledBack     = "rolled-back"
+	setOutcomeRollbackFailed = "rollback-failed"
+)
+
+// setTransactionErrorReason is the reason of the ErrorInfo detail returned when a transactional Set fails
+const setTransactionErrorReason = "SET_TRANSACTION_FAILED"
+
+// transactional Set response header keys
+const (
+	setRollbackHeader = "gnmic-set-rollback"
+	// one "<target>=<outcome>" value per target
+	setOutcomeHeader = "gnmic-set-outcome"
+)
+
+// setTransaction holds the state of a transactional Set towards a single target
+type setTransaction struct {
+	name string
+	tc   *types.TargetConfig
+	t    *target.Target
+	// paths snapshot, nil for the paths not existing before the Set
+	snapshot map[*gnmi.Path][]*gnmi.Notification
+	rsp      *gnmi.SetResponse
+	outcome  string
+	err      error
+}
+
+// transactionalSet applies req to all targets or to none of them:
+// the paths affected by req are read from each target before the Set,
+// if the Set fails on any target, the read values are set back on the targets where it succeeded.
+func (a *App) transactionalSet(ctx context.Context, req *gnmi.SetRequest, targets map[string]*types.TargetConfig) (*gnmi.SetResponse, error) {
+	txs := make([]*setTransaction, 0, len(targets))
+	for name, tc := range targets {
+		txs = append(txs, &setTransaction{
+			name:    utils.GetHost(name),
+			tc:      tc,
+			outcome: setOutcomeNotApplied,
+		})
+	}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].name < txs[j].name })
+
+	// snapshot
+	a.runSetTransactions(txs, func(tx *setTransaction) {
+		tx.t = target.NewTarget(tx.tc)
+		tctx, cancel := context.WithTimeout(ctx, tx.tc.Timeout)
+		defer cancel()
+		err := tx.t.CreateGNMIClient(tctx)
+		if err != nil {
+			tx.err = err
+			return
+		}
+		tx.snapshot, tx.err = a.setSnapshot(ctx, tx, req)
+	})
+	if failed := failedSetTransactions(txs); len(failed) > 0 {
+		a.Logger.Printf("transactional Set aborted, failed to read the current config of target(s) %v", failed)
+		a.sendSetTransactionHeader(ctx, txs, false)
+		return nil, setTransactionError(txs, false, "failed to read the current config of target(s) %v", failed)
+	}
+
+	// apply and rollback are not bound to the client context: once the SetRequest is sent,
+	// a client cancelling the Set or its deadline expiring must not leave the targets half configured.
+	a.runSetTransactions(txs, func(tx *setTransaction) {
+		creq := proto.Clone(req).(*gnmi.SetRequest)
+		if creq.GetPrefix() == nil {
+			creq.Prefix = new(gnmi.Path)
+		}
+		if creq.GetPrefix().GetTarget() == "" || creq.GetPrefix().GetTarget() == "*" {
+			creq.Prefix.Target = tx.name
+		}
+		tctx, cancel := context.WithTimeout(context.Background(), tx.tc.Timeout)
+		defer cancel()
+		tx.rsp, tx.err = tx.t.Set(tctx, creq)
+		if tx.err != nil {
+			tx.outcome = setOutcomeFailed
+			return
+		}
+		tx.outcome = setOutcomeApplied
+	})
+	failed := failedSetTransactions(txs)
+	if len(failed) == 0 {
+		a.sendSetTransactionHeader(ctx, txs, false)
+		response := &gnmi.SetResponse{
+			Response:  make([]*gnmi.UpdateResult, 0),
+			Timestamp: time.Now().UnixNano(),
+		}
+		for _, tx := range txs {
+			for _, upd := range tx.rsp.GetResponse() {
+				if upd.GetPath() == nil {
+					upd.Path = new(gnmi.Path)
+				}
+				upd.Path.Target = tx.name
+				response.Response = append(response.Response, upd)
+			}
+		}
+		return response, nil
+	}
+
+	// rollback
+	a.Logger.Printf("transactional Set failed on target(s) %v, rolling back", failed)
+	a.runSetTransactions(txs, func(tx *setTransaction) {
+		if tx.outcome != setOutcomeApplied {
+			return
+		}
+		tctx, cancel := context.WithTimeout(context.Background(), tx.tc.Timeout)
+		defer cancel()
+		_, err := tx.t.Set(tctx, rollbackSetRequest(tx.name, tx.snapshot))
+		if err != nil {
+			a.Logger.Printf("target %q rollback failed: %v", tx.name, err)
+			tx.outcome = setOutcomeRollbackFailed
+			tx.err = err
+			return
+		}
+		tx.outcome = setOutcomeRolledBack
+	})
+	a.sendSetTransactionHeader(ctx, txs, true)
+	return nil, setTransactionError(txs, true, "Set failed on target(s) %v", failed)
+}
+
+// sendSetTransactionHeader sends each target outcome and whether a rollback happened
+// as the Set response header.
+func (a *App) sendSetTransactionHeader(ctx context.Context, txs []*setTransaction, rollback bool) {
+	md := metadata.Pairs(setRollbackHeader, fmt.Sprintf("%t", rollback))
+	for _, tx := range txs {
+		md.Append(setOutcomeHeader, tx.name+"="+tx.outcome)
+	}
+	err := grpc.SetHeader(ctx, md)
+	if err != nil {
+		a.Logger.Printf("failed to set the Set response header: %v", err)
+	}
+}
+
+// runSetTransactions runs fn for each transaction concurrently and waits for them to be done
+func (a *App) runSetTransactions(txs []*setTransaction, fn func(tx *setTransaction)) {
+	wg := new(sync.WaitGroup)
+	wg.Add(len(txs))
+	for _, tx := range txs {
+		go func(tx *setTransaction) {
+			defer wg.Done()
+			fn(tx)
+			if tx.err != nil {
+				a.Logger.Printf("target %q err: %v", tx.name, tx.err)
+			}
+		}(tx)
+	}
+	wg.Wait()
+}
+
+// setSnapshot reads the current config of the paths updated, replaced or deleted by req
+func (a *App) setSnapshot(ctx context.Context, tx *setTransaction, req *gnmi.SetRequest) (map[*gnmi.Path][]*gnmi.Notification, error) {
+	paths := make([]*gnmi.Path, 0, len(req.GetUpdate())+len(req.GetReplace())+len(req.GetDelete()))
+	for _, upd := range req.GetUpdate() {
+		paths = append(paths, upd.GetPath())
+	}
+	for _, upd := range req.GetReplace() {
+		paths = append(paths, upd.GetPath())
+	}
+	paths = append(paths, req.GetDelete()...)
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "username", tx.tc.UsernameString(), "password", tx.tc.PasswordString())
+	snapshot := make(map[*gnmi.Path][]*gnmi.Notification, len(paths))
+	for _, p := range fullPaths(req.GetPrefix(), paths...) {
+		getReq := &gnmi.GetRequest{
+			Prefix:   &gnmi.Path{Target: tx.name},
+			Path:     []*gnmi.Path{p},
+			Type:     gnmi.GetRequest_CONFIG,
+			Encoding: gnmi.Encoding_JSON_IETF,
+		}
+		tctx, cancel := context.WithTimeout(ctx, tx.tc.Timeout)
+		rsp, err := tx.t.Client.Get(tctx, getReq)
+		cancel()
+		if status.Code(err) == codes.NotFound {
+			// the path is deleted on rollback
+			snapshot[p] = nil
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read path %q: %v", "/"+utils.GnmiPathToXPath(p, false), err)
+		}
+		snapshot[p] = rsp.GetNotification()
+	}
+	return snapshot, nil
+}
+
+// rollbackSetRequest builds a SetRequest setting back the snapshot values:
+// each snapshot path is deleted then the values it had are set again.
+func rollbackSetRequest(name string, snapshot map[*gnmi.Path][]*gnmi.Notification) *gnmi.SetRequest {
+	req := &gnmi.SetRequest{
+		Prefix: &gnmi.Path{Target: name},
+		Delete: make([]*gnmi.Path, 0, len(snapshot)),
+		Update: make([]*gnmi.Update, 0, len(snapshot)),
+	}
+	for p, notifs := range snapshot {
+		req.Delete = append(req.Delete, p)
+		for _, n := range notifs {
+			for _, upd := range n.GetUpdate() {
+				origin := upd.GetPath().GetOrigin()
+				if origin == "" {
+					origin = n.GetPrefix().GetOrigin()
+				}
+				req.Update = append(req.Update, &gnmi.Update{
+					Path: &gnmi.Path{
+						Origin: origin,
+						Elem:   utils.PathElems(n.GetPrefix(), upd.GetPath()),
+					},
+					Val: upd.GetVal(),
+				})
+			}
+		}
+	}
+	return req
+}
+
+func failedSetTransactions(txs []*setTransaction) []string {
+	failed := make([]string, 0)
+	for _, tx := range txs {
+		if tx.err != nil {
+			failed = append(failed, tx.name)
+		}
+	}
+	return failed
+}
+
+// setTransactionError returns an Aborted status error with an ErrorInfo detail
+// holding each target outcome and whether a rollback happened.
+func setTransactionError(txs []*setTransaction, rollback bool, format string, args ...interface{}) error {
+	info := &errdetails.ErrorInfo{
+		Reason: setTransactionErrorReason,
+		Domain: "gnmic",
+		Metadata: map[string]string{
+			"rollback": fmt.Sprintf("%t", rollback),
+		},
+	}
+	outcomes := make([]string, 0, len(txs))
+	for _, tx := range txs {
+		info.Metadata[tx.name] = tx.outcome
+		if tx.err != nil {
+			outcomes = append(outcomes, fmt.Sprintf("target %q %s: %v", tx.name, tx.outcome, tx.err))
+			continue
+		}
+		outcomes = append(outcomes, fmt.Sprintf("target %q %s", tx.name, tx.outcome))
+	}
+	msg := fmt.Sprintf(format, args...) + ", rollback=" + info.Metadata["rollback"] + ": " + strings.Join(outcomes, "; ")
+	st, err := status.New(codes.Aborted, msg).WithDetails(info)
+	if err != nil {
+		return status.Error(codes.Aborted, msg)
+	}
+	return st.Err()
+}
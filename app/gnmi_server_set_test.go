@@ -0,0 +1,250 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/types"
+	"github.com/karimra/gnmic/utils"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeSetServer is a gNMI server storing leaves values by xpath
+type fakeSetServer struct {
+	gnmi.UnimplementedGNMIServer
+	m       sync.Mutex
+	leaves  map[string]*gnmi.TypedValue
+	failSet bool
+	// called before a Set fails
+	onFail func()
+	sets   int
+}
+
+// headerStream is a grpc.ServerTransportStream recording the header set by a handler
+type headerStream struct {
+	md metadata.MD
+}
+
+func (s *headerStream) Method() string { return "/gnmi.gNMI/Set" }
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.md = metadata.Join(s.md, md)
+	return nil
+}
+func (s *headerStream) SendHeader(md metadata.MD) error { return s.SetHeader(md) }
+func (s *headerStream) SetTrailer(metadata.MD) error    { return nil }
+
+func (s *fakeSetServer) Get(ctx context.Context, req *gnmi.GetRequest) (*gnmi.GetResponse, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	p := utils.GnmiPathToXPath(req.GetPath()[0], false)
+	v, ok := s.leaves[p]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "path %q not found", p)
+	}
+	return &gnmi.GetResponse{
+		Notification: []*gnmi.Notification{{
+			Update: []*gnmi.Update{{Path: req.GetPath()[0], Val: v}},
+		}},
+	}, nil
+}
+
+func (s *fakeSetServer) Set(ctx context.Context, req *gnmi.SetRequest) (*gnmi.SetResponse, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.sets++
+	if s.failSet {
+		if s.onFail != nil {
+			s.onFail()
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "invalid value")
+	}
+	rsp := new(gnmi.SetResponse)
+	for _, p := range req.GetDelete() {
+		delete(s.leaves, utils.GnmiPathToXPath(&gnmi.Path{Elem: utils.PathElems(req.GetPrefix(), p)}, false))
+		rsp.Response = append(rsp.Response, &gnmi.UpdateResult{Path: p, Op: gnmi.UpdateResult_DELETE})
+	}
+	for _, upd := range req.GetUpdate() {
+		s.leaves[utils.GnmiPathToXPath(&gnmi.Path{Elem: utils.PathElems(req.GetPrefix(), upd.GetPath())}, false)] = upd.GetVal()
+		rsp.Response = append(rsp.Response, &gnmi.UpdateResult{Path: upd.GetPath(), Op: gnmi.UpdateResult_UPDATE})
+	}
+	return rsp, nil
+}
+
+func startFakeSetServer(t *testing.T, name string, s *fakeSetServer) (*types.TargetConfig, *grpc.Server) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	gnmi.RegisterGNMIServer(srv, s)
+	go srv.Serve(l)
+	return &types.TargetConfig{
+		Name:     name,
+		Address:  l.Addr().String(),
+		Username: &emptyStr,
+		Password: &emptyStr,
+		Insecure: &trueBool,
+		Gzip:     &falseBool,
+		Timeout:  time.Second,
+	}, srv
+}
+
+var (
+	emptyStr  = ""
+	trueBool  = true
+	falseBool = false
+)
+
+func TestTransactionalSetRollback(t *testing.T) {
+	okServer := &fakeSetServer{leaves: map[string]*gnmi.TypedValue{
+		"system/name": {Value: &gnmi.TypedValue_StringVal{StringVal: "old"}},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the client cancels the Set once it is applied on the first target,
+	// the rollback still happens.
+	failServer := &fakeSetServer{leaves: map[string]*gnmi.TypedValue{}, failSet: true, onFail: func() {
+		for {
+			okServer.m.Lock()
+			sets := okServer.sets
+			okServer.m.Unlock()
+			if sets > 0 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+	}}
+	tc1, srv1 := startFakeSetServer(t, "target1", okServer)
+	defer srv1.Stop()
+	tc2, srv2 := startFakeSetServer(t, "target2", failServer)
+	defer srv2.Stop()
+
+	a := New()
+	a.Logger = log.New(ioutil.Discard, "", 0)
+	req := &gnmi.SetRequest{
+		Update: []*gnmi.Update{
+			{
+				Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}}},
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: "new"}},
+			},
+			{
+				Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "location"}}},
+				Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: "dc1"}},
+			},
+		},
+	}
+	_, err := a.transactionalSet(ctx, req, map[string]*types.TargetConfig{
+		tc1.Name: tc1,
+		tc2.Name: tc2,
+	})
+	if err == nil {
+		t.Fatal("expected the transactional Set to fail")
+	}
+	st := status.Convert(err)
+	if st.Code() != codes.Aborted {
+		t.Errorf("expected code %v, got %v", codes.Aborted, st.Code())
+	}
+	var info *errdetails.ErrorInfo
+	for _, d := range st.Details() {
+		if ei, ok := d.(*errdetails.ErrorInfo); ok {
+			info = ei
+		}
+	}
+	if info == nil {
+		t.Fatalf("missing ErrorInfo detail in %v", st.Details())
+	}
+	if info.Metadata["rollback"] != "true" {
+		t.Errorf("expected a rollback, got %v", info.Metadata)
+	}
+	if info.Metadata[tc1.Name] != setOutcomeRolledBack || info.Metadata[tc2.Name] != setOutcomeFailed {
+		t.Errorf("unexpected targets outcomes: %v", info.Metadata)
+	}
+	// the first target config is restored
+	okServer.m.Lock()
+	defer okServer.m.Unlock()
+	if okServer.sets != 2 {
+		t.Errorf("expected a Set and a rollback Set, got %d Set(s)", okServer.sets)
+	}
+	if v := okServer.leaves["system/name"].GetStringVal(); v != "old" {
+		t.Errorf("expected system/name to be rolled back to \"old\", got %q", v)
+	}
+	if _, ok := okServer.leaves["system/location"]; ok {
+		t.Errorf("expected system/location to be deleted on rollback")
+	}
+}
+
+func TestTransactionalSetOutcomeHeader(t *testing.T) {
+	tc1, srv1 := startFakeSetServer(t, "target1", &fakeSetServer{leaves: map[string]*gnmi.TypedValue{}})
+	defer srv1.Stop()
+	tc2, srv2 := startFakeSetServer(t, "target2", &fakeSetServer{leaves: map[string]*gnmi.TypedValue{}})
+	defer srv2.Stop()
+
+	a := New()
+	a.Logger = log.New(ioutil.Discard, "", 0)
+	stream := new(headerStream)
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+	rsp, err := a.transactionalSet(ctx, &gnmi.SetRequest{
+		Update: []*gnmi.Update{{
+			Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}}},
+			Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: "new"}},
+		}},
+	}, map[string]*types.TargetConfig{
+		tc1.Name: tc1,
+		tc2.Name: tc2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.GetResponse()) != 2 {
+		t.Errorf("got %d update results, want 2", len(rsp.GetResponse()))
+	}
+	if v := stream.md.Get(setRollbackHeader); len(v) != 1 || v[0] != "false" {
+		t.Errorf("unexpected rollback header: %v", v)
+	}
+	want := []string{"target1=" + setOutcomeApplied, "target2=" + setOutcomeApplied}
+	if v := stream.md.Get(setOutcomeHeader); !reflect.DeepEqual(v, want) {
+		t.Errorf("got outcome header %v, want %v", v, want)
+	}
+}
+
+func TestTransactionalSetSnapshotFailure(t *testing.T) {
+	tc, srv := startFakeSetServer(t, "target1", &fakeSetServer{leaves: map[string]*gnmi.TypedValue{}})
+	defer srv.Stop()
+	a := New()
+	a.Logger = log.New(ioutil.Discard, "", 0)
+	unreachable := *tc
+	unreachable.Name = "target2"
+	unreachable.Address = "127.0.0.1:1"
+	unreachable.Timeout = 100 * time.Millisecond
+	_, err := a.transactionalSet(context.Background(), &gnmi.SetRequest{
+		Delete: []*gnmi.Path{{Elem: []*gnmi.PathElem{{Name: "system"}}}},
+	}, map[string]*types.TargetConfig{
+		tc.Name:          tc,
+		unreachable.Name: &unreachable,
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("expected code %v, got %v", codes.Aborted, err)
+	}
+	var info *errdetails.ErrorInfo
+	for _, d := range status.Convert(err).Details() {
+		if ei, ok := d.(*errdetails.ErrorInfo); ok {
+			info = ei
+		}
+	}
+	if info == nil || info.Metadata["rollback"] != "false" || info.Metadata[tc.Name] != setOutcomeNotApplied {
+		t.Errorf("unexpected error details: %v", errors.New(status.Convert(err).Message()))
+	}
+}
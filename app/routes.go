@@ -5,6 +5,7 @@ import "net/http"
 func (a *App) routes() {
 	a.configRoutes()
 	a.targetRoutes()
+	a.alarmRoutes()
 }
 
 func (a *App) configRoutes() {
@@ -17,12 +18,24 @@ func (a *App) configRoutes() {
 	a.router.HandleFunc("/config/targets/{id}", a.handleConfigTargetsDelete).Methods(http.MethodDelete)
 	// config/subscriptions
 	a.router.HandleFunc("/config/subscriptions", a.handleConfigSubscriptions).Methods(http.MethodGet)
+	a.router.HandleFunc("/config/subscriptions/{id}", a.handleConfigSubscriptions).Methods(http.MethodGet)
+	a.router.HandleFunc("/config/subscriptions", a.handleConfigSubscriptionsPost).Methods(http.MethodPost)
+	a.router.HandleFunc("/config/subscriptions/{id}", a.handleConfigSubscriptionsPut).Methods(http.MethodPut)
+	a.router.HandleFunc("/config/subscriptions/{id}", a.handleConfigSubscriptionsDelete).Methods(http.MethodDelete)
 	// config/outputs
 	a.router.HandleFunc("/config/outputs", a.handleConfigOutputs).Methods(http.MethodGet)
+	a.router.HandleFunc("/config/outputs/{id}", a.handleConfigOutputs).Methods(http.MethodGet)
+	a.router.HandleFunc("/config/outputs", a.handleConfigOutputsPost).Methods(http.MethodPost)
+	a.router.HandleFunc("/config/outputs/{id}", a.handleConfigOutputsPut).Methods(http.MethodPut)
+	a.router.HandleFunc("/config/outputs/{id}", a.handleConfigOutputsDelete).Methods(http.MethodDelete)
 	// config/inputs
 	a.router.HandleFunc("/config/inputs", a.handleConfigInputs).Methods(http.MethodGet)
 	// config/processors
 	a.router.HandleFunc("/config/processors", a.handleConfigProcessors).Methods(http.MethodGet)
+	a.router.HandleFunc("/config/processors/{id}", a.handleConfigProcessors).Methods(http.MethodGet)
+	a.router.HandleFunc("/config/processors", a.handleConfigProcessorsPost).Methods(http.MethodPost)
+	a.router.HandleFunc("/config/processors/{id}", a.handleConfigProcessorsPut).Methods(http.MethodPut)
+	a.router.HandleFunc("/config/processors/{id}", a.handleConfigProcessorsDelete).Methods(http.MethodDelete)
 	// config/locker
 	a.router.HandleFunc("/config/clustering", a.handleConfigClustering).Methods(http.MethodGet)
 }
@@ -34,3 +47,8 @@ func (a *App) targetRoutes() {
 	a.router.HandleFunc("/targets/{id}", a.handleTargetsPost).Methods(http.MethodPost)
 	a.router.HandleFunc("/targets/{id}", a.handleTargetsDelete).Methods(http.MethodDelete)
 }
+
+func (a *App) alarmRoutes() {
+	// alarms
+	a.router.HandleFunc("/alarms", a.handleAlarmsGet).Methods(http.MethodGet)
+}
@@ -248,6 +248,10 @@ func (a *App) createCollectorOpts(cmd *cobra.Command) ([]collector.CollectorOpti
 	if err != nil {
 		return nil, fmt.Errorf("failed reading event processors config: %v", err)
 	}
+	routes, err := a.Config.GetOutputRoutes()
+	if err != nil {
+		return nil, fmt.Errorf("failed reading output routes config: %v", err)
+	}
 	rootDesc, err := a.LoadProtoFiles()
 	if err != nil {
 		return nil, fmt.Errorf("failed loading proto files: %v", err)
@@ -262,9 +266,17 @@ func (a *App) createCollectorOpts(cmd *cobra.Command) ([]collector.CollectorOpti
 		collector.WithInputs(inputsConfig),
 		collector.WithLocker(a.locker),
 		collector.WithProtoDescriptor(rootDesc),
+		collector.WithOutputRoutes(routes),
 	}
 	if a.Config.GnmiServer != nil {
 		opts = append(opts, collector.WithCache(a.c))
+		if a.Config.GnmiServer.History != nil {
+			a.history = collector.NewHistory(&collector.HistoryConfig{
+				Retention: a.Config.GnmiServer.History.Retention,
+				MaxSize:   a.Config.GnmiServer.History.MaxSize,
+			})
+			opts = append(opts, collector.WithHistory(a.history))
+		}
 	}
 	if a.Config.APIServer != nil && a.Config.APIServer.EnableMetrics {
 		a.reg = prometheus.NewRegistry()
@@ -17,6 +17,7 @@ import (
 	"github.com/karimra/gnmic/inputs"
 	"github.com/karimra/gnmic/lockers"
 	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/outputs/queue"
 	"github.com/karimra/gnmic/target"
 	"github.com/karimra/gnmic/types"
 	"github.com/karimra/gnmic/utils"
@@ -53,11 +54,17 @@ type Collector struct {
 	m             *sync.Mutex
 	Subscriptions map[string]*types.SubscriptionConfig
 
+	// protects the outputs and processors maps
+	outputsM      *sync.RWMutex
 	outputsConfig map[string]map[string]interface{}
 	Outputs       map[string]outputs.Output
+	outputQueues  map[string]*outputQueue
+	outputsWG     map[string]*sync.WaitGroup // ongoing writes per output
+	outputsInit   map[string]chan struct{}   // closed once the output Init returned
 
 	inputsConfig map[string]map[string]interface{}
 	Inputs       map[string]inputs.Input
+	inputsStart  map[string]chan struct{} // closed once the input Start returned
 
 	locker lockers.Locker
 
@@ -74,6 +81,10 @@ type Collector struct {
 
 	rootDesc desc.Descriptor
 	cache    *cache.Cache
+	history  *History
+
+	outputRoutes []*types.OutputRoute
+	router       *outputRouter
 }
 
 // New //
@@ -93,7 +104,12 @@ func New(config *Config, targetConfigs map[string]*types.TargetConfig, opts ...C
 		targetsConfig:  make(map[string]*types.TargetConfig),
 		Targets:        make(map[string]*target.Target),
 		Outputs:        make(map[string]outputs.Output),
+		outputsM:       new(sync.RWMutex),
+		outputQueues:   make(map[string]*outputQueue),
+		outputsWG:      make(map[string]*sync.WaitGroup),
+		outputsInit:    make(map[string]chan struct{}),
 		Inputs:         make(map[string]inputs.Input),
+		inputsStart:    make(map[string]chan struct{}),
 		targetsChan:    make(chan *target.Target),
 		activeTargets:  make(map[string]struct{}),
 		targetsLocksFn: make(map[string]context.CancelFunc),
@@ -101,6 +117,13 @@ func New(config *Config, targetConfigs map[string]*types.TargetConfig, opts ...C
 	for _, op := range opts {
 		op(c)
 	}
+	if len(c.outputRoutes) > 0 {
+		var err error
+		c.router, err = newOutputRouter(c.outputRoutes)
+		if err != nil {
+			c.logger.Printf("failed to init output routes: %v", err)
+		}
+	}
 	if config.Debug {
 		c.logger.Printf("starting collector with cfg=%+v", config)
 	}
@@ -109,6 +132,17 @@ func New(config *Config, targetConfigs map[string]*types.TargetConfig, opts ...C
 		grpcMetrics.EnableClientHandlingTimeHistogram()
 		c.reg.MustRegister(grpcMetrics)
 		c.dialOpts = append(c.dialOpts, grpc.WithStreamInterceptor(grpcMetrics.StreamClientInterceptor()))
+		if err := queue.RegisterMetrics(c.reg); err != nil {
+			c.logger.Printf("failed to register output queue metrics: %v", err)
+		}
+		if err := c.reg.Register(&targetsStatusCollector{c: c}); err != nil {
+			c.logger.Printf("failed to register targets status metrics: %v", err)
+		}
+		if c.router != nil {
+			if err := c.reg.Register(outputRouteHits); err != nil {
+				c.logger.Printf("failed to register output routes metrics: %v", err)
+			}
+		}
 	}
 
 	for _, tc := range targetConfigs {
@@ -172,6 +206,21 @@ func WithCache(ch *cache.Cache) CollectorOption {
 	}
 }
 
+// WithHistory sets the History recording the notifications added to the cache
+func WithHistory(h *History) CollectorOption {
+	return func(c *Collector) {
+		c.history = h
+	}
+}
+
+// WithOutputRoutes sets the routes selecting the outputs of the subscribe responses,
+// the responses not matching any route are sent to their target outputs.
+func WithOutputRoutes(routes []*types.OutputRoute) CollectorOption {
+	return func(c *Collector) {
+		c.outputRoutes = routes
+	}
+}
+
 func WithPrometheusRegistry(reg *prometheus.Registry) CollectorOption {
 	return func(c *Collector) {
 		c.reg = reg
@@ -427,15 +476,13 @@ func (c *Collector) AddSubscriptionConfig(sc *types.SubscriptionConfig) error {
 }
 
 func (c *Collector) DeleteSubscription(name string) error {
+	c.m.Lock()
+	defer c.m.Unlock()
 	if _, ok := c.Subscriptions[name]; !ok {
 		return fmt.Errorf("subscription %q does not exist", name)
 	}
-	c.m.Lock()
-	defer c.m.Unlock()
 	for _, t := range c.Targets {
-		if _, ok := t.SubscribeClients[name]; ok {
-			t.DeleteSubscription(name)
-		}
+		t.DeleteSubscription(name)
 	}
 	delete(c.Subscriptions, name)
 	return nil
@@ -460,7 +507,7 @@ func (c *Collector) Subscribe(ctx context.Context, tName string) error {
 			subRequests = append(subRequests, subscriptionRequest{name: sc.Name, req: req})
 		}
 		gnmiCtx, cancel := context.WithCancel(ctx)
-		t.Cfn = cancel
+		t.Ctx, t.Cfn = gnmiCtx, cancel
 	CRCLIENT:
 		if err := t.CreateGNMIClient(gnmiCtx, c.dialOpts...); err != nil {
 			if errors.Is(err, context.DeadlineExceeded) {
@@ -468,11 +515,21 @@ func (c *Collector) Subscribe(ctx context.Context, tName string) error {
 			} else {
 				c.logger.Printf("failed to initialize target %q: %v", tName, err)
 			}
-			c.logger.Printf("retrying target %q in %s", tName, t.Config.RetryTimer)
-			time.Sleep(t.Config.RetryTimer)
+			delay, ok := t.NextClientRetry(err)
+			if !ok {
+				c.logger.Printf("target %q: max reconnection attempts reached", tName)
+				return fmt.Errorf("failed to initialize target %q: %v", tName, err)
+			}
+			c.logger.Printf("retrying target %q in %s", tName, delay)
+			select {
+			case <-gnmiCtx.Done():
+				return gnmiCtx.Err()
+			case <-time.After(delay):
+			}
 			goto CRCLIENT
 
 		}
+		t.ResetClientRetry()
 		c.logger.Printf("target '%s' gNMI client created", t.Config.Name)
 
 		for _, sreq := range subRequests {
@@ -503,7 +560,7 @@ func (c *Collector) SubscribeOnce(ctx context.Context, tName string) error {
 			subRequests = append(subRequests, subscriptionRequest{name: sc.Name, req: req})
 		}
 		gnmiCtx, cancel := context.WithCancel(ctx)
-		t.Cfn = cancel
+		t.Ctx, t.Cfn = gnmiCtx, cancel
 	CRCLIENT:
 		if err := t.CreateGNMIClient(gnmiCtx, c.dialOpts...); err != nil {
 			if errors.Is(err, context.DeadlineExceeded) {
@@ -511,11 +568,21 @@ func (c *Collector) SubscribeOnce(ctx context.Context, tName string) error {
 			} else {
 				c.logger.Printf("failed to initialize target %q: %v", tName, err)
 			}
-			c.logger.Printf("retrying target %q in %s", tName, t.Config.RetryTimer)
-			time.Sleep(t.Config.RetryTimer)
+			delay, ok := t.NextClientRetry(err)
+			if !ok {
+				c.logger.Printf("target %q: max reconnection attempts reached", tName)
+				return fmt.Errorf("failed to initialize target %q: %v", tName, err)
+			}
+			c.logger.Printf("retrying target %q in %s", tName, delay)
+			select {
+			case <-gnmiCtx.Done():
+				return gnmiCtx.Err()
+			case <-time.After(delay):
+			}
 			goto CRCLIENT
 
 		}
+		t.ResetClientRetry()
 		c.logger.Printf("target '%s' gNMI client created", t.Config.Name)
 	OUTER:
 		for _, sreq := range subRequests {
@@ -539,7 +606,7 @@ func (c *Collector) SubscribeOnce(ctx context.Context, tName string) error {
 						return nil
 					default:
 						m := outputs.Meta{"source": t.Config.Name, "format": c.Config.Format, "subscription-name": sreq.name}
-						c.Export(ctx, rsp, m, t.Config.Outputs...)
+						c.Export(ctx, rsp, m, c.routeOutputs(rsp, m, t.Config)...)
 					}
 				}
 			}
@@ -552,7 +619,12 @@ func (c *Collector) SubscribeOnce(ctx context.Context, tName string) error {
 // Start start the prometheus server as well as a goroutine per target selecting on the response chan, the error chan and the ctx.Done() chan
 func (c *Collector) Start(ctx context.Context) {
 	defer func() {
-		for _, o := range c.Outputs {
+		c.outputsM.RLock()
+		defer c.outputsM.RUnlock()
+		for name, o := range c.Outputs {
+			if initDone, ok := c.outputsInit[name]; ok {
+				<-initDone
+			}
 			o.Close()
 		}
 	}()
@@ -597,9 +669,11 @@ func (c *Collector) Start(ctx context.Context) {
 						m["subscription-target"] = rsp.SubscriptionConfig.Target
 					}
 					if c.subscriptionMode(rsp.SubscriptionName) == "ONCE" {
-						c.Export(ctx, rsp.Response, m, t.Config.Outputs...)
+						c.Export(ctx, rsp.Response, m, c.routeOutputs(rsp.Response, m, t.Config)...)
 					} else {
-						go c.Export(ctx, rsp.Response, m, t.Config.Outputs...)
+						go func() {
+							c.Export(ctx, rsp.Response, m, c.routeOutputs(rsp.Response, m, t.Config)...)
+						}()
 					}
 					if remainingOnceSubscriptions > 0 {
 						if c.subscriptionMode(rsp.SubscriptionName) == "ONCE" {
@@ -731,6 +805,9 @@ func (c *Collector) updateCache(rsp *gnmi.SubscribeResponse, m outputs.Meta) {
 			c.logger.Printf("failed to update gNMI cache: %v", err)
 			return
 		}
+		if c.history != nil {
+			c.history.Add(r.Update)
+		}
 	}
 }
 
@@ -739,27 +816,42 @@ func (c *Collector) Export(ctx context.Context, rsp *gnmi.SubscribeResponse, m o
 		return
 	}
 	go c.updateCache(rsp, m)
-	wg := new(sync.WaitGroup)
+	type outputWrite struct {
+		o  outputs.Output
+		oq *outputQueue
+		wg *sync.WaitGroup
+	}
+	writes := make(map[string]outputWrite)
+	c.outputsM.RLock()
 	if len(outs) == 0 {
-		wg.Add(len(c.Outputs))
-		for _, o := range c.Outputs {
-			go func(o outputs.Output) {
-				defer wg.Done()
-				o.Write(ctx, rsp, m)
-			}(o)
-		}
-		wg.Wait()
-		return
+		for name, o := range c.Outputs {
+			writes[name] = outputWrite{o: o, oq: c.outputQueues[name], wg: c.outputsWG[name]}
+		}
+	} else {
+		for _, name := range outs {
+			if o, ok := c.Outputs[name]; ok {
+				writes[name] = outputWrite{o: o, oq: c.outputQueues[name], wg: c.outputsWG[name]}
+			}
+		}
 	}
-	for _, name := range outs {
-		if o, ok := c.Outputs[name]; ok {
-			wg.Add(1)
-			go func(o outputs.Output) {
-				defer wg.Done()
-				o.Write(ctx, rsp, m)
-			}(o)
+	// ongoing writes are tracked so that an output is drained before being closed
+	for _, ow := range writes {
+		if ow.wg != nil {
+			ow.wg.Add(1)
 		}
 	}
+	c.outputsM.RUnlock()
+	wg := new(sync.WaitGroup)
+	wg.Add(len(writes))
+	for name, ow := range writes {
+		go func(name string, ow outputWrite) {
+			defer wg.Done()
+			if ow.wg != nil {
+				defer ow.wg.Done()
+			}
+			c.writeOutput(ctx, name, ow.o, ow.oq, rsp, m)
+		}(name, ow)
+	}
 	wg.Wait()
 }
 
@@ -0,0 +1,204 @@
+package collector
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultHistoryRetention = time.Hour
+	defaultHistoryMaxSize   = 100 * 1024 * 1024
+)
+
+// HistoryConfig is the notifications history retention config
+type HistoryConfig struct {
+	// Retention is the maximum age of the kept notifications, based on their timestamp
+	Retention time.Duration `mapstructure:"retention,omitempty" json:"retention,omitempty"`
+	// MaxSize is the maximum size in bytes of the kept notifications, the oldest ones are dropped when it is reached
+	MaxSize int64 `mapstructure:"max-size,omitempty" json:"max-size,omitempty"`
+}
+
+// History keeps the notifications received from the targets for a limited time and size,
+// indexed per target by notification timestamp.
+type History struct {
+	cfg  *HistoryConfig
+	m    *sync.RWMutex
+	size int64
+	// target name to notifications sorted by timestamp
+	targets map[string]*historyTarget
+	// targets ordered by their oldest notification, the next one to evict
+	oldest historyHeap
+	now    func() time.Time
+}
+
+type historyEntry struct {
+	n    *gnmi.Notification
+	size int64
+}
+
+type historyTarget struct {
+	name    string
+	entries []*historyEntry
+	// index in the History oldest heap
+	index int
+}
+
+// historyHeap is a min-heap of targets ordered by the timestamp of their oldest notification
+type historyHeap []*historyTarget
+
+func (hh historyHeap) Len() int { return len(hh) }
+func (hh historyHeap) Less(i, j int) bool {
+	return hh[i].entries[0].n.GetTimestamp() < hh[j].entries[0].n.GetTimestamp()
+}
+func (hh historyHeap) Swap(i, j int) {
+	hh[i], hh[j] = hh[j], hh[i]
+	hh[i].index = i
+	hh[j].index = j
+}
+func (hh *historyHeap) Push(x interface{}) {
+	t := x.(*historyTarget)
+	t.index = len(*hh)
+	*hh = append(*hh, t)
+}
+func (hh *historyHeap) Pop() interface{} {
+	old := *hh
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	*hh = old[:n-1]
+	return t
+}
+
+// NewHistory creates a History using cfg, nil or zero fields are set to the defaults
+func NewHistory(cfg *HistoryConfig) *History {
+	if cfg == nil {
+		cfg = new(HistoryConfig)
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = defaultHistoryRetention
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaultHistoryMaxSize
+	}
+	return &History{
+		cfg:     cfg,
+		m:       new(sync.RWMutex),
+		targets: make(map[string]*historyTarget),
+		now:     time.Now,
+	}
+}
+
+// Add records notification n, its prefix target must be set.
+// n must not be modified after it is added.
+func (h *History) Add(n *gnmi.Notification) {
+	target := n.GetPrefix().GetTarget()
+	if target == "" {
+		return
+	}
+	e := &historyEntry{n: n, size: int64(proto.Size(n))}
+	h.m.Lock()
+	defer h.m.Unlock()
+	t, ok := h.targets[target]
+	if !ok {
+		t = &historyTarget{name: target, entries: []*historyEntry{e}}
+		h.targets[target] = t
+		heap.Push(&h.oldest, t)
+		h.size += e.size
+		h.evict()
+		return
+	}
+	// notifications are mostly received in order
+	i := len(t.entries)
+	if i > 0 && t.entries[i-1].n.GetTimestamp() > n.GetTimestamp() {
+		i = sort.Search(len(t.entries), func(j int) bool {
+			return t.entries[j].n.GetTimestamp() > n.GetTimestamp()
+		})
+	}
+	t.entries = append(t.entries, nil)
+	copy(t.entries[i+1:], t.entries[i:])
+	t.entries[i] = e
+	if i == 0 {
+		heap.Fix(&h.oldest, t.index)
+	}
+	h.size += e.size
+	h.evict()
+}
+
+// Range calls fn with the notifications of target timestamped between start and end included,
+// in timestamp order. Target "*" selects all the targets, each target notifications are ordered separately.
+// It stops at the first error returned by fn.
+// The notifications are selected under the read lock, fn is called once it is released.
+func (h *History) Range(target string, start, end time.Time, fn func(n *gnmi.Notification) error) error {
+	h.m.RLock()
+	selected := h.selectNotifications(target, start.UnixNano(), end.UnixNano())
+	h.m.RUnlock()
+	for _, ns := range selected {
+		for _, n := range ns {
+			if err := fn(n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// selectNotifications returns a copy of the notifications of target timestamped between s and e included,
+// one slice per target. It must be called with h.m locked.
+func (h *History) selectNotifications(target string, s, e int64) [][]*gnmi.Notification {
+	var names []string
+	if target == "*" {
+		names = make([]string, 0, len(h.targets))
+		for name := range h.targets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	} else if _, ok := h.targets[target]; ok {
+		names = []string{target}
+	}
+	r := make([][]*gnmi.Notification, 0, len(names))
+	for _, name := range names {
+		entries := h.targets[name].entries
+		i := sort.Search(len(entries), func(j int) bool {
+			return entries[j].n.GetTimestamp() >= s
+		})
+		j := sort.Search(len(entries), func(j int) bool {
+			return entries[j].n.GetTimestamp() > e
+		})
+		if i >= j {
+			continue
+		}
+		ns := make([]*gnmi.Notification, 0, j-i)
+		for _, entry := range entries[i:j] {
+			ns = append(ns, entry.n)
+		}
+		r = append(r, ns)
+	}
+	return r
+}
+
+// evict drops the oldest notifications while they are older than the retention
+// or the size is above the max size.
+// it must be called with h.m locked.
+func (h *History) evict() {
+	minTs := h.now().Add(-h.cfg.Retention).UnixNano()
+	for len(h.oldest) > 0 {
+		t := h.oldest[0]
+		if t.entries[0].n.GetTimestamp() >= minTs && h.size <= h.cfg.MaxSize {
+			return
+		}
+		h.size -= t.entries[0].size
+		t.entries[0] = nil
+		t.entries = t.entries[1:]
+		if len(t.entries) == 0 {
+			heap.Pop(&h.oldest)
+			delete(h.targets, t.name)
+			continue
+		}
+		heap.Fix(&h.oldest, 0)
+	}
+}
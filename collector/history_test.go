@@ -0,0 +1,139 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+func historyNotification(target string, ts time.Time, val string) *gnmi.Notification {
+	return &gnmi.Notification{
+		Timestamp: ts.UnixNano(),
+		Prefix:    &gnmi.Path{Target: target},
+		Update: []*gnmi.Update{{
+			Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "system"}, {Name: "name"}}},
+			Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: val}},
+		}},
+	}
+}
+
+func historyValues(t *testing.T, h *History, target string, start, end time.Time) []string {
+	vals := make([]string, 0)
+	err := h.Range(target, start, end, func(n *gnmi.Notification) error {
+		vals = append(vals, n.GetUpdate()[0].GetVal().GetStringVal())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vals
+}
+
+func TestHistoryRange(t *testing.T) {
+	now := time.Now()
+	h := NewHistory(&HistoryConfig{Retention: time.Hour})
+	h.Add(historyNotification("t1", now.Add(-3*time.Minute), "a"))
+	h.Add(historyNotification("t1", now.Add(-1*time.Minute), "c"))
+	// out of order notification
+	h.Add(historyNotification("t1", now.Add(-2*time.Minute), "b"))
+	h.Add(historyNotification("t2", now.Add(-2*time.Minute), "x"))
+	// without target
+	h.Add(historyNotification("", now, "ignored"))
+
+	got := historyValues(t, h, "t1", now.Add(-10*time.Minute), now)
+	if len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Errorf("unexpected t1 history: %v", got)
+	}
+	got = historyValues(t, h, "t1", now.Add(-2*time.Minute), now.Add(-2*time.Minute))
+	if len(got) != 1 || got[0] != "b" {
+		t.Errorf("unexpected t1 history range: %v", got)
+	}
+	got = historyValues(t, h, "*", now.Add(-150*time.Second), now)
+	if len(got) != 3 || got[0] != "b" || got[1] != "c" || got[2] != "x" {
+		t.Errorf("unexpected all targets history: %v", got)
+	}
+	got = historyValues(t, h, "t3", now.Add(-10*time.Minute), now)
+	if len(got) != 0 {
+		t.Errorf("unexpected unknown target history: %v", got)
+	}
+}
+
+func TestHistoryRetention(t *testing.T) {
+	now := time.Now()
+	h := NewHistory(&HistoryConfig{Retention: time.Minute})
+	h.Add(historyNotification("t1", now.Add(-2*time.Minute), "old"))
+	h.Add(historyNotification("t1", now.Add(-30*time.Second), "recent"))
+	got := historyValues(t, h, "t1", time.Unix(0, 0), now)
+	if len(got) != 1 || got[0] != "recent" {
+		t.Errorf("expected the old notification to be evicted, got %v", got)
+	}
+	// the current time moves past the retention
+	h.now = func() time.Time { return now.Add(time.Minute) }
+	h.Add(historyNotification("t2", now.Add(45*time.Second), "new"))
+	if _, ok := h.targets["t1"]; ok {
+		t.Errorf("expected target t1 history to be evicted")
+	}
+	if h.size != int64(proto.Size(historyNotification("t2", now.Add(45*time.Second), "new"))) {
+		t.Errorf("unexpected history size %d", h.size)
+	}
+}
+
+func TestHistoryMaxSize(t *testing.T) {
+	now := time.Now()
+	n := historyNotification("t1", now, "a")
+	size := int64(proto.Size(n))
+	h := NewHistory(&HistoryConfig{Retention: time.Hour, MaxSize: 2 * size})
+	h.Add(historyNotification("t1", now.Add(-3*time.Second), "a"))
+	h.Add(historyNotification("t2", now.Add(-2*time.Second), "b"))
+	h.Add(historyNotification("t1", now.Add(-1*time.Second), "c"))
+	got := historyValues(t, h, "*", time.Unix(0, 0), now)
+	if len(got) != 2 || got[0] != "c" || got[1] != "b" {
+		t.Errorf("expected the oldest notification to be evicted, got %v", got)
+	}
+	if h.size > h.cfg.MaxSize {
+		t.Errorf("history size %d is above max size %d", h.size, h.cfg.MaxSize)
+	}
+}
+
+func TestHistoryMaxSizeOutOfOrder(t *testing.T) {
+	now := time.Now()
+	size := int64(proto.Size(historyNotification("t1", now, "a")))
+	h := NewHistory(&HistoryConfig{Retention: time.Hour, MaxSize: 2 * size})
+	h.Add(historyNotification("t1", now.Add(-2*time.Second), "a"))
+	h.Add(historyNotification("t2", now.Add(-3*time.Second), "b"))
+	// t1 oldest notification is received last, it is the next one evicted
+	h.Add(historyNotification("t1", now.Add(-4*time.Second), "c"))
+	got := historyValues(t, h, "*", time.Unix(0, 0), now)
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("expected the oldest notification to be evicted, got %v", got)
+	}
+}
+
+func TestHistoryRangeUnlocked(t *testing.T) {
+	now := time.Now()
+	h := NewHistory(nil)
+	h.Add(historyNotification("t1", now.Add(-time.Second), "a"))
+	h.Add(historyNotification("t2", now.Add(-time.Second), "b"))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// notifications are added while the history is replayed
+		err := h.Range("*", time.Unix(0, 0), now, func(n *gnmi.Notification) error {
+			h.Add(historyNotification("t1", now, "c"))
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Add blocked by Range")
+	}
+	if got := historyValues(t, h, "t1", time.Unix(0, 0), now); len(got) != 3 {
+		t.Errorf("got %d t1 notifications, want 3", len(got))
+	}
+}
@@ -3,6 +3,7 @@ package collector
 import (
 	"context"
 	"fmt"
+	"sort"
 
 	"github.com/karimra/gnmic/inputs"
 	"github.com/karimra/gnmic/types"
@@ -42,23 +43,67 @@ func (c *Collector) initInput(ctx context.Context, name string, tcs map[string]*
 			c.logger.Printf("starting input type %q", inputType)
 			if initializer, ok := inputs.Inputs[inputType.(string)]; ok {
 				input := initializer()
+				c.outputsM.RLock()
+				eps := c.EventProcessorsConfig
+				c.outputsM.RUnlock()
+				started := make(chan struct{})
 				go func() {
+					defer close(started)
 					err := input.Start(ctx, name, cfg,
 						inputs.WithLogger(c.logger),
-						inputs.WithOutputs(c.Outputs),
+						// inputs look up the outputs for each message, following their changes
+						inputs.WithOutputsFunc(c.currentOutputs),
 						inputs.WithName(c.Config.Name),
-						inputs.WithEventProcessors(c.EventProcessorsConfig, c.logger, tcs),
+						inputs.WithEventProcessors(eps, c.logger, tcs),
+						inputs.WithRegister(c.reg),
 					)
 					if err != nil {
 						c.logger.Printf("failed to start input type %q: %v", inputType, err)
 					}
 				}()
 				c.Inputs[name] = input
+				c.inputsStart[name] = started
 			}
 		}
 	}
 }
 
+// restartInput closes the input called name, once it is started,
+// then starts it again with the current event processors.
+func (c *Collector) restartInput(ctx context.Context, name string) {
+	c.m.Lock()
+	input, ok := c.Inputs[name]
+	started := c.inputsStart[name]
+	delete(c.Inputs, name)
+	delete(c.inputsStart, name)
+	c.m.Unlock()
+	if ok {
+		<-started
+		err := input.Close()
+		if err != nil {
+			c.logger.Printf("failed to close input %q: %v", name, err)
+		}
+	}
+	c.initInput(ctx, name, c.targetsConfig)
+}
+
+// processorInputs returns the names of the inputs using the event processor called name
+func (c *Collector) processorInputs(name string) []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	ins := make([]string, 0)
+	for inName, cfg := range c.inputsConfig {
+		for _, ep := range OutputProcessors(cfg) {
+			if ep == name {
+				ins = append(ins, inName)
+				break
+			}
+		}
+	}
+	sort.Strings(ins)
+	return ins
+}
+
 func (c *Collector) InitInputs(ctx context.Context) {
 	for name := range c.inputsConfig {
 		c.initInput(ctx, name, c.targetsConfig)
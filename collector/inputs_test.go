@@ -0,0 +1,142 @@
+package collector
+
+import (
+	"context"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/inputs"
+	"github.com/karimra/gnmic/types"
+)
+
+// testInput is an input recording the outputs lookup it is started with
+type testInput struct {
+	m         sync.Mutex
+	outputsFn inputs.OutputsFunc
+	started   bool
+	closed    bool
+}
+
+func init() {
+	inputs.Register("test_input", func() inputs.Input {
+		return &testInput{}
+	})
+}
+
+func (i *testInput) Start(ctx context.Context, name string, cfg map[string]interface{}, opts ...inputs.Option) error {
+	for _, opt := range opts {
+		opt(i)
+	}
+	i.m.Lock()
+	defer i.m.Unlock()
+	i.started = true
+	return nil
+}
+func (i *testInput) Close() error {
+	i.m.Lock()
+	defer i.m.Unlock()
+	i.closed = true
+	return nil
+}
+func (i *testInput) SetLogger(*log.Logger) {}
+func (i *testInput) SetOutputs(fn inputs.OutputsFunc) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	i.outputsFn = fn
+}
+func (i *testInput) SetEventProcessors(map[string]map[string]interface{}, *log.Logger, map[string]*types.TargetConfig) {
+}
+func (i *testInput) SetName(string) {}
+
+func (i *testInput) state() (inputs.OutputsFunc, bool, bool) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	return i.outputsFn, i.started, i.closed
+}
+
+func startTestInput(t *testing.T, c *Collector, name string, cfg map[string]interface{}) *testInput {
+	if err := c.AddInput(name, cfg); err != nil {
+		t.Fatal(err)
+	}
+	c.initInput(context.Background(), name, nil)
+	c.m.Lock()
+	in := c.Inputs[name].(*testInput)
+	started := c.inputsStart[name]
+	c.m.Unlock()
+	<-started
+	return in
+}
+
+func TestInputOutputsChanges(t *testing.T) {
+	ctx := context.Background()
+	c := New(&Config{}, nil, WithLogger(nil))
+	in := startTestInput(t, c, "in1", map[string]interface{}{"type": "test_input"})
+	fn, _, _ := in.state()
+	if outs := inputs.SelectOutputs(fn, nil); len(outs) != 0 {
+		t.Fatalf("got %d outputs, want 0", len(outs))
+	}
+	// an output added after the input started
+	if err := c.UpdateOutput(ctx, "out1", map[string]interface{}{"type": "test_blocking"}); err != nil {
+		t.Fatal(err)
+	}
+	outs := inputs.SelectOutputs(fn, []string{"out1"})
+	if outs["out1"] == nil || outs["out1"] != c.currentOutputs()["out1"] {
+		t.Fatalf("input does not see the added output: %v", outs)
+	}
+	old := outs["out1"]
+	// a replaced output
+	if err := c.UpdateOutput(ctx, "out1", map[string]interface{}{"type": "test_blocking"}); err != nil {
+		t.Fatal(err)
+	}
+	if o := inputs.SelectOutputs(fn, nil)["out1"]; o == nil || o == old {
+		t.Fatal("input does not see the replaced output")
+	}
+	// a deleted output
+	if err := c.DeleteOutput("out1"); err != nil {
+		t.Fatal(err)
+	}
+	if outs := inputs.SelectOutputs(fn, nil); len(outs) != 0 {
+		t.Fatalf("input sees a deleted output: %v", outs)
+	}
+}
+
+func TestUpdateProcessorRestartsInputs(t *testing.T) {
+	ctx := context.Background()
+	c := New(&Config{}, nil, WithLogger(nil))
+	in1 := startTestInput(t, c, "in1", map[string]interface{}{
+		"type":             "test_input",
+		"event-processors": []interface{}{"proc1"},
+	})
+	in2 := startTestInput(t, c, "in2", map[string]interface{}{"type": "test_input"})
+	err := c.UpdateProcessor(ctx, "proc1", map[string]interface{}{"event-drop": map[string]interface{}{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, closed := in1.state(); !closed {
+		t.Error("input using the updated processor not closed")
+	}
+	c.m.Lock()
+	newIn1, newIn2 := c.Inputs["in1"], c.Inputs["in2"]
+	c.m.Unlock()
+	if newIn1 == nil || newIn1 == inputs.Input(in1) {
+		t.Fatal("input using the updated processor not restarted")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, started, _ := newIn1.(*testInput).state(); started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("restarted input not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if newIn2 != inputs.Input(in2) {
+		t.Error("input not using the updated processor restarted")
+	}
+	if err = c.DeleteProcessor("proc1"); err == nil {
+		t.Error("expected an error deleting a processor used by an input")
+	}
+}
@@ -3,13 +3,30 @@ package collector
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/outputs/queue"
 	"github.com/karimra/gnmic/types"
+	"github.com/openconfig/gnmi/proto/gnmi"
 )
 
+// outputQueue is the disk queue placed in front of an output
+type outputQueue struct {
+	q *queue.Queue
+	// stops replaying the queue to the output
+	cancel context.CancelFunc
+	// closed once the output is initialized, the replay starts then
+	ready chan struct{}
+	// closed once the replay is stopped
+	done chan struct{}
+}
+
 // AddOutput initializes an output called name, with config cfg if it does not already exist
 func (c *Collector) AddOutput(name string, cfg map[string]interface{}) error {
+	c.outputsM.Lock()
+	defer c.outputsM.Unlock()
 	if c.Outputs == nil {
 		c.Outputs = make(map[string]outputs.Output)
 	}
@@ -19,27 +36,47 @@ func (c *Collector) AddOutput(name string, cfg map[string]interface{}) error {
 	if _, ok := c.Outputs[name]; ok {
 		return fmt.Errorf("output '%s' already exists", name)
 	}
-	c.m.Lock()
-	defer c.m.Unlock()
 	c.outputsConfig[name] = cfg
 	return nil
 }
 
 func (c *Collector) InitOutput(ctx context.Context, name string, tcs map[string]*types.TargetConfig) {
-	c.m.Lock()
-	defer c.m.Unlock()
+	c.outputsM.Lock()
+	defer c.outputsM.Unlock()
 	if _, ok := c.Outputs[name]; ok {
 		return
 	}
+	c.initOutput(ctx, name, tcs, nil)
+}
+
+// initOutput creates the output called name and initializes it in the background,
+// it must be called with c.outputsM locked.
+// If prevQueue is not nil, the output takes over the disk queue of a previous instance.
+func (c *Collector) initOutput(ctx context.Context, name string, tcs map[string]*types.TargetConfig, prevQueue *outputQueue) {
 	if cfg, ok := c.outputsConfig[name]; ok {
 		if outType, ok := cfg["type"]; ok {
 			c.logger.Printf("starting output type %s", outType)
 			if initializer, ok := outputs.Outputs[outType.(string)]; ok {
 				out := initializer()
+				var oq *outputQueue
+				if qcfg, ok := cfg["queue"]; ok && qcfg != nil {
+					var err error
+					if prevQueue != nil {
+						oq, err = c.takeOverOutputQueue(ctx, name, out, prevQueue)
+					} else {
+						oq, err = c.initOutputQueue(ctx, name, out, qcfg)
+					}
+					if err != nil {
+						c.logger.Printf("failed to init output %q queue: %v", name, err)
+					}
+				}
+				eps := c.EventProcessorsConfig
+				initDone := make(chan struct{})
 				go func() {
+					defer close(initDone)
 					err := out.Init(ctx, name, cfg,
 						outputs.WithLogger(c.logger),
-						outputs.WithEventProcessors(c.EventProcessorsConfig, c.logger, c.targetsConfig),
+						outputs.WithEventProcessors(eps, c.logger, tcs),
 						outputs.WithRegister(c.reg),
 						outputs.WithName(c.Config.Name),
 						outputs.WithClusterName(c.Config.ClusterName),
@@ -47,30 +84,249 @@ func (c *Collector) InitOutput(ctx context.Context, name string, tcs map[string]
 					)
 					if err != nil {
 						c.logger.Printf("failed to init output type %q: %v", outType, err)
+						return
+					}
+					// the queued messages are replayed once the output is initialized
+					if oq != nil {
+						close(oq.ready)
 					}
 				}()
 				c.Outputs[name] = out
+				c.outputsWG[name] = new(sync.WaitGroup)
+				c.outputsInit[name] = initDone
 			}
 		}
 	}
 }
 
 func (c *Collector) InitOutputs(ctx context.Context) {
+	c.outputsM.RLock()
+	names := make([]string, 0, len(c.outputsConfig))
 	for name := range c.outputsConfig {
+		names = append(names, name)
+	}
+	c.outputsM.RUnlock()
+	for _, name := range names {
 		c.InitOutput(ctx, name, c.targetsConfig)
 	}
 }
 
+// DeleteOutput closes the output called name, once its ongoing writes are done.
 func (c *Collector) DeleteOutput(name string) error {
-	if c.Outputs == nil {
+	c.outputsM.Lock()
+	o, ok := c.Outputs[name]
+	if !ok {
+		c.outputsM.Unlock()
+		return fmt.Errorf("output '%s' does not exist", name)
+	}
+	wg := c.outputsWG[name]
+	oq := c.outputQueues[name]
+	initDone := c.outputsInit[name]
+	delete(c.Outputs, name)
+	delete(c.outputsWG, name)
+	delete(c.outputQueues, name)
+	delete(c.outputsInit, name)
+	delete(c.outputsConfig, name)
+	c.outputsM.Unlock()
+	c.closeOutput(name, o, oq, wg, initDone)
+	return nil
+}
+
+// UpdateOutput adds or replaces the output called name with config cfg.
+// The new instance is installed first, so that no message is dropped while
+// the existing instance is closed once its ongoing writes are done.
+// If both instances have a disk queue, the new one takes over the queue of the old one,
+// the queue settings changes apply once the queue is opened again.
+func (c *Collector) UpdateOutput(ctx context.Context, name string, cfg map[string]interface{}) error {
+	if _, ok := cfg["type"]; !ok {
+		return fmt.Errorf("missing output %q type", name)
+	}
+	c.outputsM.Lock()
+	if c.outputsConfig == nil {
+		c.outputsConfig = make(map[string]map[string]interface{})
+	}
+	o, exists := c.Outputs[name]
+	wg := c.outputsWG[name]
+	oq := c.outputQueues[name]
+	initDone := c.outputsInit[name]
+	delete(c.Outputs, name)
+	delete(c.outputsWG, name)
+	delete(c.outputQueues, name)
+	delete(c.outputsInit, name)
+	c.outputsConfig[name] = cfg
+	var prevQueue *outputQueue
+	if qcfg, ok := cfg["queue"]; ok && qcfg != nil && oq != nil {
+		prevQueue = oq
+		// the old instance stops replaying the queue, the new one starts once it is done
+		prevQueue.cancel()
+	}
+	c.initOutput(ctx, name, c.targetsConfig, prevQueue)
+	c.outputsM.Unlock()
+	if !exists {
 		return nil
 	}
-	if _, ok := c.Outputs[name]; !ok {
-		return fmt.Errorf("output '%s' does not exist", name)
+	if prevQueue != nil {
+		<-prevQueue.done
+		oq = nil
 	}
-	c.m.Lock()
-	defer c.m.Unlock()
-	o := c.Outputs[name]
-	o.Close()
+	c.closeOutput(name, o, oq, wg, initDone)
 	return nil
 }
+
+// currentOutputs returns a copy of the collector outputs
+func (c *Collector) currentOutputs() map[string]outputs.Output {
+	c.outputsM.RLock()
+	defer c.outputsM.RUnlock()
+	outs := make(map[string]outputs.Output, len(c.Outputs))
+	for n, o := range c.Outputs {
+		outs[n] = o
+	}
+	return outs
+}
+
+// OutputsConfig returns a copy of the collector outputs configs
+func (c *Collector) OutputsConfig() map[string]map[string]interface{} {
+	c.outputsM.RLock()
+	defer c.outputsM.RUnlock()
+	outs := make(map[string]map[string]interface{}, len(c.outputsConfig))
+	for n, cfg := range c.outputsConfig {
+		outs[n] = cfg
+	}
+	return outs
+}
+
+// closeOutput waits for the initialization and the ongoing writes of output o to be done,
+// then closes it along with its queue.
+func (c *Collector) closeOutput(name string, o outputs.Output, oq *outputQueue, wg *sync.WaitGroup, initDone chan struct{}) {
+	if initDone != nil {
+		<-initDone
+	}
+	if wg != nil {
+		c.logger.Printf("draining output %q", name)
+		wg.Wait()
+	}
+	if oq != nil {
+		oq.cancel()
+		oq.q.Close()
+	}
+	err := o.Close()
+	if err != nil {
+		c.logger.Printf("failed to close output %q: %v", name, err)
+	}
+	c.logger.Printf("output %q closed", name)
+}
+
+// initOutputQueue creates the disk queue of output `name`,
+// its messages are replayed once the output is initialized.
+// The queue is closed when ctx is done.
+func (c *Collector) initOutputQueue(ctx context.Context, name string, out outputs.Output, cfg interface{}) (*outputQueue, error) {
+	sw, ok := out.(outputs.SyncWriter)
+	if !ok {
+		return nil, fmt.Errorf("output %q does not support a disk queue", name)
+	}
+	qcfg := new(queue.Config)
+	err := outputs.DecodeConfig(cfg, qcfg)
+	if err != nil {
+		return nil, err
+	}
+	q, err := queue.Open(name, qcfg, c.logger)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		q.Close()
+	}()
+	oq := c.startReplay(ctx, name, q, sw, nil)
+	c.outputQueues[name] = oq
+	return oq, nil
+}
+
+// takeOverOutputQueue replays the disk queue of a previous instance of output `name` to out,
+// once the previous instance stopped replaying it and out is initialized.
+func (c *Collector) takeOverOutputQueue(ctx context.Context, name string, out outputs.Output, prev *outputQueue) (*outputQueue, error) {
+	sw, ok := out.(outputs.SyncWriter)
+	if !ok {
+		// the queue of the previous instance is closed along with it
+		prev.q.Close()
+		return nil, fmt.Errorf("output %q does not support a disk queue", name)
+	}
+	oq := c.startReplay(ctx, name, prev.q, sw, prev)
+	c.outputQueues[name] = oq
+	return oq, nil
+}
+
+// startReplay replays q to sw once the returned outputQueue is ready,
+// the replay stops without starting if the outputQueue is cancelled before.
+func (c *Collector) startReplay(ctx context.Context, name string, q *queue.Queue, sw outputs.SyncWriter, prev *outputQueue) *outputQueue {
+	ctx, cancel := context.WithCancel(ctx)
+	oq := &outputQueue{q: q, cancel: cancel, ready: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(oq.done)
+		if prev != nil {
+			<-prev.done
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-oq.ready:
+		}
+		c.replayQueue(ctx, name, q, sw)
+	}()
+	return oq
+}
+
+// writeOutput writes a subscribe response to an output, through its disk queue oq if it has one.
+func (c *Collector) writeOutput(ctx context.Context, name string, o outputs.Output, oq *outputQueue, rsp *gnmi.SubscribeResponse, m outputs.Meta) {
+	if oq == nil {
+		o.Write(ctx, rsp, m)
+		return
+	}
+	b, err := queue.EncodeMessage(rsp, m)
+	if err != nil {
+		c.logger.Printf("failed to encode message for output %q queue: %v", name, err)
+		return
+	}
+	err = oq.q.Enqueue(b)
+	if err != nil {
+		c.logger.Printf("failed to enqueue message for output %q: %v", name, err)
+	}
+}
+
+// replayQueue writes the queued messages to the output in order,
+// a message is removed from the queue only once the output has written it.
+func (c *Collector) replayQueue(ctx context.Context, name string, q *queue.Queue, sw outputs.SyncWriter) {
+	failing := false
+	for {
+		b, err := q.Peek(ctx)
+		if err != nil {
+			return
+		}
+		rsp, meta, err := queue.DecodeMessage(b)
+		if err != nil {
+			c.logger.Printf("output %q queue: dropping invalid message: %v", name, err)
+			q.Ack()
+			continue
+		}
+		for {
+			err = sw.WriteSync(ctx, rsp, meta)
+			if err == nil {
+				break
+			}
+			if !failing {
+				c.logger.Printf("output %q failed to write: %v, spooling messages to disk", name, err)
+				failing = true
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(q.RetryInterval()):
+			}
+		}
+		if failing {
+			c.logger.Printf("output %q recovered, %d messages queued", name, q.Len())
+			failing = false
+		}
+		q.Ack()
+	}
+}
@@ -0,0 +1,339 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/types"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+// blockingOutput is an output whose writes block until release is closed
+type blockingOutput struct {
+	m       *sync.Mutex
+	release chan struct{}
+	written int
+	closed  bool
+}
+
+var testOutputs = struct {
+	m   sync.Mutex
+	all []*blockingOutput
+}{}
+
+func init() {
+	outputs.Register("test_blocking", func() outputs.Output {
+		o := &blockingOutput{m: new(sync.Mutex), release: make(chan struct{})}
+		testOutputs.m.Lock()
+		testOutputs.all = append(testOutputs.all, o)
+		testOutputs.m.Unlock()
+		return o
+	})
+}
+
+func (o *blockingOutput) Init(context.Context, string, map[string]interface{}, ...outputs.Option) error {
+	return nil
+}
+func (o *blockingOutput) Write(ctx context.Context, _ proto.Message, _ outputs.Meta) {
+	<-o.release
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.closed {
+		panic("write to a closed output")
+	}
+	o.written++
+}
+func (o *blockingOutput) WriteEvent(context.Context, *formatters.EventMsg) {}
+func (o *blockingOutput) Close() error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.closed = true
+	return nil
+}
+func (o *blockingOutput) RegisterMetrics(*prometheus.Registry) {}
+func (o *blockingOutput) String() string                       { return "" }
+func (o *blockingOutput) SetLogger(*log.Logger)                {}
+func (o *blockingOutput) SetEventProcessors(map[string]map[string]interface{}, *log.Logger, map[string]*types.TargetConfig) {
+}
+func (o *blockingOutput) SetName(string)                                  {}
+func (o *blockingOutput) SetClusterName(string)                           {}
+func (o *blockingOutput) SetTargetsConfig(map[string]*types.TargetConfig) {}
+
+func (o *blockingOutput) isClosed() bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.closed
+}
+
+// syncOutput is an output supporting a disk queue, it counts the messages written synchronously
+type syncOutput struct {
+	blockingOutput
+	writes chan struct{}
+}
+
+var syncWrites = make(chan struct{}, 10)
+
+func init() {
+	outputs.Register("test_sync", func() outputs.Output {
+		return &syncOutput{blockingOutput: blockingOutput{m: new(sync.Mutex)}, writes: syncWrites}
+	})
+}
+
+func (o *syncOutput) WriteSync(context.Context, proto.Message, outputs.Meta) error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if o.closed {
+		panic("write to a closed output")
+	}
+	o.written++
+	o.writes <- struct{}{}
+	return nil
+}
+
+// failingSyncOutput is an output supporting a disk queue which fails to initialize
+type failingSyncOutput struct {
+	syncOutput
+}
+
+var failingSyncWrites = make(chan struct{}, 10)
+
+func init() {
+	outputs.Register("test_sync_init_error", func() outputs.Output {
+		return &failingSyncOutput{syncOutput{blockingOutput: blockingOutput{m: new(sync.Mutex)}, writes: failingSyncWrites}}
+	})
+}
+
+func (o *failingSyncOutput) Init(context.Context, string, map[string]interface{}, ...outputs.Option) error {
+	return errors.New("init failed")
+}
+
+func TestOutputQueueNotInitialized(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gnmic-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := New(&Config{}, nil, WithLogger(nil))
+	cfg := map[string]interface{}{
+		"type":  "test_sync_init_error",
+		"queue": map[string]interface{}{"directory": dir},
+	}
+	if err = c.UpdateOutput(ctx, "out1", cfg); err != nil {
+		t.Fatal(err)
+	}
+	c.Export(ctx, &gnmi.SubscribeResponse{}, outputs.Meta{})
+	select {
+	case <-failingSyncWrites:
+		t.Fatal("queued message written to an output not initialized")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if err = c.DeleteOutput("out1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// slowInitOutput is an output whose Init blocks until release is closed
+type slowInitOutput struct {
+	blockingOutput
+	initialized bool
+}
+
+var slowInitRelease = make(chan struct{})
+
+func init() {
+	outputs.Register("test_slow_init", func() outputs.Output {
+		return &slowInitOutput{blockingOutput: blockingOutput{m: new(sync.Mutex)}}
+	})
+}
+
+func (o *slowInitOutput) Init(context.Context, string, map[string]interface{}, ...outputs.Option) error {
+	<-slowInitRelease
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.initialized = true
+	return nil
+}
+
+func (o *slowInitOutput) Close() error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if !o.initialized {
+		panic("close of an output being initialized")
+	}
+	o.closed = true
+	return nil
+}
+
+func TestDeleteOutputInitializing(t *testing.T) {
+	c := New(&Config{}, nil, WithLogger(nil))
+	if err := c.UpdateOutput(context.Background(), "out1", map[string]interface{}{"type": "test_slow_init"}); err != nil {
+		t.Fatal(err)
+	}
+	o := c.Outputs["out1"].(*slowInitOutput)
+	deleted := make(chan struct{})
+	go func() {
+		if err := c.DeleteOutput("out1"); err != nil {
+			t.Error(err)
+		}
+		close(deleted)
+	}()
+	select {
+	case <-deleted:
+		t.Fatal("output deleted before its initialization is done")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(slowInitRelease)
+	select {
+	case <-deleted:
+	case <-time.After(time.Second):
+		t.Fatal("output not deleted after its initialization is done")
+	}
+	if !o.isClosed() {
+		t.Fatal("output not closed")
+	}
+}
+
+func TestUpdateOutputQueueTakeOver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gnmic-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := New(&Config{}, nil, WithLogger(nil))
+	cfg := map[string]interface{}{
+		"type":  "test_sync",
+		"queue": map[string]interface{}{"directory": dir},
+	}
+	if err = c.UpdateOutput(ctx, "out1", cfg); err != nil {
+		t.Fatal(err)
+	}
+	waitWrite := func() {
+		select {
+		case <-syncWrites:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for the queued message to be written")
+		}
+	}
+	c.Export(ctx, &gnmi.SubscribeResponse{}, outputs.Meta{})
+	waitWrite()
+	// the new instance replays the queue opened by the old one
+	if err = c.UpdateOutput(ctx, "out1", cfg); err != nil {
+		t.Fatal(err)
+	}
+	c.Export(ctx, &gnmi.SubscribeResponse{}, outputs.Meta{})
+	waitWrite()
+	c.outputsM.RLock()
+	o := c.Outputs["out1"].(*syncOutput)
+	c.outputsM.RUnlock()
+	if o.isClosed() || o.written != 1 {
+		t.Fatalf("unexpected new output state: closed=%v, written=%d", o.isClosed(), o.written)
+	}
+}
+
+func TestUpdateOutputDrain(t *testing.T) {
+	ctx := context.Background()
+	c := New(&Config{}, nil, WithLogger(nil))
+	cfg := map[string]interface{}{"type": "test_blocking"}
+	if err := c.UpdateOutput(ctx, "out1", cfg); err != nil {
+		t.Fatal(err)
+	}
+	old, ok := c.Outputs["out1"].(*blockingOutput)
+	if !ok {
+		t.Fatalf("unexpected output: %T", c.Outputs["out1"])
+	}
+	exported := make(chan struct{})
+	go func() {
+		c.Export(ctx, &gnmi.SubscribeResponse{}, outputs.Meta{})
+		close(exported)
+	}()
+	// wait for the write to start
+	time.Sleep(100 * time.Millisecond)
+
+	updated := make(chan struct{})
+	go func() {
+		if err := c.UpdateOutput(ctx, "out1", cfg); err != nil {
+			t.Error(err)
+		}
+		close(updated)
+	}()
+	select {
+	case <-updated:
+		t.Fatal("output replaced before its ongoing writes are done")
+	case <-time.After(200 * time.Millisecond):
+	}
+	if old.isClosed() {
+		t.Fatal("output closed before its ongoing writes are done")
+	}
+	// the new instance receives the messages while the old one is drained
+	c.outputsM.RLock()
+	o := c.Outputs["out1"]
+	c.outputsM.RUnlock()
+	if o == nil || o == outputs.Output(old) {
+		t.Fatal("new output not installed while the old one is drained")
+	}
+	close(old.release)
+	<-exported
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatal("output not replaced after its writes are done")
+	}
+	if !old.isClosed() || old.written != 1 {
+		t.Fatalf("unexpected old output state: closed=%v, written=%d", old.closed, old.written)
+	}
+	if o := c.Outputs["out1"]; o == nil || o == outputs.Output(old) {
+		t.Fatal("output not replaced")
+	}
+}
+
+func TestUpdateProcessor(t *testing.T) {
+	ctx := context.Background()
+	c := New(&Config{}, nil, WithLogger(nil))
+	err := c.UpdateOutput(ctx, "out1", map[string]interface{}{
+		"type":             "test_blocking",
+		"event-processors": []interface{}{"proc1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.UpdateOutput(ctx, "out2", map[string]interface{}{"type": "test_blocking"}); err != nil {
+		t.Fatal(err)
+	}
+	out1, out2 := c.Outputs["out1"], c.Outputs["out2"]
+	err = c.UpdateProcessor(ctx, "proc1", map[string]interface{}{"event-drop": map[string]interface{}{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Outputs["out1"] == out1 {
+		t.Error("output using the updated processor not re-initialized")
+	}
+	if c.Outputs["out2"] != out2 {
+		t.Error("output not using the updated processor re-initialized")
+	}
+	if err = c.DeleteProcessor("proc1"); err == nil {
+		t.Error("expected an error deleting a processor in use")
+	}
+	if err = c.DeleteOutput("out1"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.DeleteProcessor("proc1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.ProcessorsConfig()["proc1"]; ok {
+		t.Error("processor not deleted")
+	}
+}
@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// ProcessorsConfig returns a copy of the collector event processors configs
+func (c *Collector) ProcessorsConfig() map[string]map[string]interface{} {
+	c.outputsM.RLock()
+	defer c.outputsM.RUnlock()
+	eps := make(map[string]map[string]interface{}, len(c.EventProcessorsConfig))
+	for n, cfg := range c.EventProcessorsConfig {
+		eps[n] = cfg
+	}
+	return eps
+}
+
+// UpdateProcessor adds or replaces the event processor called name with config cfg,
+// the outputs and inputs using it are re-initialized with the new processors chain.
+func (c *Collector) UpdateProcessor(ctx context.Context, name string, cfg map[string]interface{}) error {
+	c.outputsM.Lock()
+	// the processors map is shared with the outputs being initialized, it is replaced instead of being modified.
+	eps := make(map[string]map[string]interface{}, len(c.EventProcessorsConfig)+1)
+	for n, epCfg := range c.EventProcessorsConfig {
+		eps[n] = epCfg
+	}
+	eps[name] = cfg
+	c.EventProcessorsConfig = eps
+	affected := c.processorOutputs(name)
+	c.outputsM.Unlock()
+
+	for _, outName := range affected {
+		c.logger.Printf("processor %q updated, re-initializing output %q", name, outName)
+		c.outputsM.RLock()
+		outCfg, ok := c.outputsConfig[outName]
+		c.outputsM.RUnlock()
+		if !ok {
+			continue
+		}
+		err := c.UpdateOutput(ctx, outName, outCfg)
+		if err != nil {
+			return err
+		}
+	}
+	for _, inName := range c.processorInputs(name) {
+		c.logger.Printf("processor %q updated, restarting input %q", name, inName)
+		c.restartInput(ctx, inName)
+	}
+	return nil
+}
+
+// DeleteProcessor deletes the event processor called name,
+// it fails if the processor is used by an output or an input.
+func (c *Collector) DeleteProcessor(name string) error {
+	ins := c.processorInputs(name)
+	c.outputsM.Lock()
+	defer c.outputsM.Unlock()
+	if _, ok := c.EventProcessorsConfig[name]; !ok {
+		return fmt.Errorf("processor %q does not exist", name)
+	}
+	if outs := c.processorOutputs(name); len(outs) > 0 {
+		return fmt.Errorf("processor %q is used by output(s): %v", name, outs)
+	}
+	if len(ins) > 0 {
+		return fmt.Errorf("processor %q is used by input(s): %v", name, ins)
+	}
+	eps := make(map[string]map[string]interface{}, len(c.EventProcessorsConfig))
+	for n, epCfg := range c.EventProcessorsConfig {
+		if n != name {
+			eps[n] = epCfg
+		}
+	}
+	c.EventProcessorsConfig = eps
+	return nil
+}
+
+// processorOutputs returns the names of the outputs using the event processor called name,
+// it must be called with c.outputsM locked.
+func (c *Collector) processorOutputs(name string) []string {
+	outs := make([]string, 0)
+	for outName, cfg := range c.outputsConfig {
+		for _, ep := range OutputProcessors(cfg) {
+			if ep == name {
+				outs = append(outs, outName)
+				break
+			}
+		}
+	}
+	sort.Strings(outs)
+	return outs
+}
+
+// OutputProcessors returns the event processors names listed in an output config
+func OutputProcessors(cfg map[string]interface{}) []string {
+	switch eps := cfg["event-processors"].(type) {
+	case []string:
+		return eps
+	case []interface{}:
+		names := make([]string, 0, len(eps))
+		for _, ep := range eps {
+			if s, ok := ep.(string); ok {
+				names = append(names, s)
+			}
+		}
+		return names
+	case string:
+		return []string{eps}
+	}
+	return nil
+}
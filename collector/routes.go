@@ -0,0 +1,161 @@
+package collector
+
+import (
+	"github.com/itchyny/gojq"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/types"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var outputRouteHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "output_route",
+	Name:      "hits_total",
+	Help:      "Number of subscribe responses matched by an output route",
+}, []string{"route"})
+
+// outputRouter selects the outputs of the subscribe responses using the output routes
+type outputRouter struct {
+	routes       []*outputRoute
+	defaultRoute *outputRoute
+}
+
+type outputRoute struct {
+	cfg           *types.OutputRoute
+	subscriptions map[string]struct{}
+	targetTags    map[string]struct{}
+	cond          *gojq.Code
+}
+
+func newOutputRouter(routes []*types.OutputRoute) (*outputRouter, error) {
+	r := &outputRouter{
+		routes: make([]*outputRoute, 0, len(routes)),
+	}
+	for _, rc := range routes {
+		or := &outputRoute{cfg: rc}
+		if len(rc.Subscriptions) > 0 {
+			or.subscriptions = make(map[string]struct{}, len(rc.Subscriptions))
+			for _, s := range rc.Subscriptions {
+				or.subscriptions[s] = struct{}{}
+			}
+		}
+		if len(rc.TargetTags) > 0 {
+			or.targetTags = make(map[string]struct{}, len(rc.TargetTags))
+			for _, t := range rc.TargetTags {
+				or.targetTags[t] = struct{}{}
+			}
+		}
+		if rc.Condition != "" {
+			q, err := gojq.Parse(rc.Condition)
+			if err != nil {
+				return nil, err
+			}
+			or.cond, err = gojq.Compile(q)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if rc.Default {
+			r.defaultRoute = or
+			continue
+		}
+		r.routes = append(r.routes, or)
+	}
+	return r, nil
+}
+
+// route returns the outputs of the routes matching rsp,
+// false is returned if neither a route nor the default route matched.
+func (r *outputRouter) route(rsp *gnmi.SubscribeResponse, m outputs.Meta, tags []string) ([]string, bool) {
+	var outs []string
+	var matched bool
+	// the response events are only built if a route with a condition is evaluated
+	var evs []*formatters.EventMsg
+	var evsDone bool
+	for _, or := range r.routes {
+		if !or.matchSubscription(m["subscription-name"]) || !or.matchTargetTags(tags) {
+			continue
+		}
+		if or.cond != nil {
+			if !evsDone {
+				evs, _ = formatters.ResponseToEventMsgs(m["subscription-name"], rsp, m)
+				evsDone = true
+			}
+			if !or.matchCondition(evs) {
+				continue
+			}
+		}
+		matched = true
+		outputRouteHits.WithLabelValues(or.cfg.Name).Inc()
+		outs = appendOutputs(outs, or.cfg.Outputs)
+		if !or.cfg.Fallthrough {
+			break
+		}
+	}
+	if matched {
+		return outs, true
+	}
+	if r.defaultRoute != nil {
+		outputRouteHits.WithLabelValues(r.defaultRoute.cfg.Name).Inc()
+		return r.defaultRoute.cfg.Outputs, true
+	}
+	return nil, false
+}
+
+func (or *outputRoute) matchSubscription(name string) bool {
+	if or.subscriptions == nil {
+		return true
+	}
+	_, ok := or.subscriptions[name]
+	return ok
+}
+
+func (or *outputRoute) matchTargetTags(tags []string) bool {
+	if or.targetTags == nil {
+		return true
+	}
+	for _, t := range tags {
+		if _, ok := or.targetTags[t]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (or *outputRoute) matchCondition(evs []*formatters.EventMsg) bool {
+	for _, e := range evs {
+		ok, err := formatters.CheckCondition(or.cond, e)
+		if err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+func appendOutputs(outs []string, names []string) []string {
+OUTER:
+	for _, name := range names {
+		for _, o := range outs {
+			if o == name {
+				continue OUTER
+			}
+		}
+		outs = append(outs, name)
+	}
+	return outs
+}
+
+// routeOutputs returns the names of the outputs rsp is sent to:
+// the outputs of the matching routes if any, the target outputs otherwise.
+func (c *Collector) routeOutputs(rsp *gnmi.SubscribeResponse, m outputs.Meta, tc *types.TargetConfig) []string {
+	if c.router == nil {
+		return tc.Outputs
+	}
+	outs, ok := c.router.route(rsp, m, tc.Tags)
+	if !ok {
+		return tc.Outputs
+	}
+	return outs
+}
@@ -0,0 +1,135 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/types"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var testOutputRoutes = []*types.OutputRoute{
+	{
+		Name:          "core-counters",
+		Subscriptions: []string{"counters"},
+		TargetTags:    []string{"core"},
+		Outputs:       []string{"influxdb"},
+		Fallthrough:   true,
+	},
+	{
+		Name:      "core-errors",
+		Condition: `.values["/interfaces/interface/state/counters/in-errors"] > 0`,
+		Outputs:   []string{"influxdb", "alerts"},
+	},
+	{
+		Name:       "edge",
+		TargetTags: []string{"edge"},
+		Outputs:    []string{"kafka"},
+	},
+	{
+		Name:    "default",
+		Default: true,
+		Outputs: []string{"file"},
+	},
+}
+
+func routeTestResponse(path string, v uint64) *gnmi.SubscribeResponse {
+	return &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: 42,
+				Update: []*gnmi.Update{{
+					Path: &gnmi.Path{Elem: []*gnmi.PathElem{
+						{Name: "interfaces"},
+						{Name: "interface", Key: map[string]string{"name": "1"}},
+						{Name: "state"},
+						{Name: "counters"},
+						{Name: path},
+					}},
+					Val: &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: v}},
+				}},
+			},
+		},
+	}
+}
+
+func TestOutputRouter(t *testing.T) {
+	r, err := newOutputRouter(testOutputRoutes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		rsp     *gnmi.SubscribeResponse
+		sub     string
+		tags    []string
+		outs    []string
+		matched bool
+	}{
+		{
+			name:    "core_counters_fallthrough_no_errors",
+			rsp:     routeTestResponse("in-octets", 10),
+			sub:     "counters",
+			tags:    []string{"core"},
+			outs:    []string{"influxdb"},
+			matched: true,
+		},
+		{
+			name:    "core_counters_fallthrough_errors",
+			rsp:     routeTestResponse("in-errors", 1),
+			sub:     "counters",
+			tags:    []string{"core", "dc1"},
+			outs:    []string{"influxdb", "alerts"},
+			matched: true,
+		},
+		{
+			name:    "edge",
+			rsp:     routeTestResponse("in-octets", 10),
+			sub:     "counters",
+			tags:    []string{"edge"},
+			outs:    []string{"kafka"},
+			matched: true,
+		},
+		{
+			name:    "default",
+			rsp:     routeTestResponse("in-octets", 10),
+			sub:     "other",
+			outs:    []string{"file"},
+			matched: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outs, ok := r.route(tt.rsp, outputs.Meta{"subscription-name": tt.sub, "source": "router1"}, tt.tags)
+			if ok != tt.matched {
+				t.Fatalf("expected matched=%t, got %t", tt.matched, ok)
+			}
+			if len(outs) != len(tt.outs) {
+				t.Fatalf("expected outputs %v, got %v", tt.outs, outs)
+			}
+			for i := range outs {
+				if outs[i] != tt.outs[i] {
+					t.Fatalf("expected outputs %v, got %v", tt.outs, outs)
+				}
+			}
+		})
+	}
+	if v := testutil.ToFloat64(outputRouteHits.WithLabelValues("core-counters")); v < 2 {
+		t.Errorf("expected at least 2 hits for route core-counters, got %v", v)
+	}
+}
+
+func TestRouteOutputsWithoutDefault(t *testing.T) {
+	c := New(&Config{}, nil, WithLogger(nil), WithOutputRoutes(testOutputRoutes[:3]))
+	tc := &types.TargetConfig{Name: "router1", Outputs: []string{"file"}}
+	outs := c.routeOutputs(routeTestResponse("in-octets", 10), outputs.Meta{"subscription-name": "other"}, tc)
+	if len(outs) != 1 || outs[0] != "file" {
+		t.Errorf("expected the target outputs, got %v", outs)
+	}
+	tc.Tags = []string{"edge"}
+	outs = c.routeOutputs(routeTestResponse("in-octets", 10), outputs.Meta{"subscription-name": "other"}, tc)
+	if len(outs) != 1 || outs[0] != "kafka" {
+		t.Errorf("expected the edge route outputs, got %v", outs)
+	}
+}
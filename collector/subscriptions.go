@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"errors"
+
+	"github.com/karimra/gnmic/target"
+	"github.com/karimra/gnmic/types"
+)
+
+// SubscriptionsConfig returns a copy of the collector subscriptions configs
+func (c *Collector) SubscriptionsConfig() map[string]*types.SubscriptionConfig {
+	c.m.Lock()
+	defer c.m.Unlock()
+	subs := make(map[string]*types.SubscriptionConfig, len(c.Subscriptions))
+	for n, sc := range c.Subscriptions {
+		subs[n] = sc
+	}
+	return subs
+}
+
+// UpdateSubscriptionConfig adds or replaces the subscription config sc,
+// then (re)subscribes the running targets it applies to.
+// Targets not connected yet use the new config once their gNMI client is created.
+func (c *Collector) UpdateSubscriptionConfig(sc *types.SubscriptionConfig) error {
+	if sc.Name == "" {
+		return errors.New("missing subscription name")
+	}
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.Subscriptions == nil {
+		c.Subscriptions = make(map[string]*types.SubscriptionConfig)
+	}
+	_, exists := c.Subscriptions[sc.Name]
+	c.Subscriptions[sc.Name] = sc
+	for name, t := range c.Targets {
+		if !c.subscriptionApplies(name, sc.Name) {
+			continue
+		}
+		if exists {
+			// stops the running subscription
+			t.DeleteSubscription(sc.Name)
+		}
+		err := c.subscribeTarget(t, sc)
+		if err != nil {
+			c.logger.Printf("target %q, failed to apply subscription %q: %v", name, sc.Name, err)
+		}
+	}
+	return nil
+}
+
+// subscriptionApplies returns true if the subscription subName applies to the target tName,
+// it must be called with c.m locked.
+func (c *Collector) subscriptionApplies(tName, subName string) bool {
+	tc, ok := c.targetsConfig[tName]
+	if !ok {
+		return false
+	}
+	// targets without subscriptions use all of them
+	if len(tc.Subscriptions) == 0 {
+		return true
+	}
+	for _, s := range tc.Subscriptions {
+		if s == subName {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Collector) subscribeTarget(t *target.Target, sc *types.SubscriptionConfig) error {
+	req, err := sc.CreateSubscribeRequest(t.Config.Name)
+	if err != nil {
+		return err
+	}
+	t.AddSubscription(sc)
+	if t.Client == nil || t.Ctx == nil || t.Ctx.Err() != nil {
+		return nil
+	}
+	c.logger.Printf("sending gNMI SubscribeRequest: subscribe='%+v', mode='%+v', encoding='%+v', to %s",
+		req, req.GetSubscribe().GetMode(), req.GetSubscribe().GetEncoding(), t.Config.Name)
+	go t.Subscribe(t.Ctx, req, sc.Name)
+	return nil
+}
@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"github.com/karimra/gnmic/target"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var targetStateDesc = prometheus.NewDesc(
+	"gnmic_target_state",
+	"Target lifecycle state, 1 for the current state of the target, 0 otherwise",
+	[]string{"name", "state"}, nil,
+)
+
+var targetSubscriptionStateDesc = prometheus.NewDesc(
+	"gnmic_target_subscription_state",
+	"Target subscription lifecycle state, 1 for the current state of the subscription, 0 otherwise",
+	[]string{"name", "subscription", "state"}, nil,
+)
+
+var targetSubscriptionLastResponseDesc = prometheus.NewDesc(
+	"gnmic_target_subscription_last_response_timestamp_seconds",
+	"Unix time of the last response received from the target for a subscription",
+	[]string{"name", "subscription"}, nil,
+)
+
+// targetsStatusCollector exposes the targets states as gauges,
+// they are computed at scrape time so that deleted targets are not reported.
+type targetsStatusCollector struct {
+	c *Collector
+}
+
+func (tc *targetsStatusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- targetStateDesc
+	ch <- targetSubscriptionStateDesc
+	ch <- targetSubscriptionLastResponseDesc
+}
+
+func (tc *targetsStatusCollector) Collect(ch chan<- prometheus.Metric) {
+	tc.c.m.Lock()
+	statuses := make(map[string]*target.Status, len(tc.c.Targets))
+	for name, t := range tc.c.Targets {
+		statuses[name] = t.Status()
+	}
+	tc.c.m.Unlock()
+
+	for name, s := range statuses {
+		if s.State == "" {
+			continue
+		}
+		for _, st := range target.States {
+			ch <- prometheus.MustNewConstMetric(targetStateDesc, prometheus.GaugeValue, stateValue(s.State, st), name, st)
+		}
+		for subName, ss := range s.Subscriptions {
+			for _, st := range target.SubscriptionStates {
+				ch <- prometheus.MustNewConstMetric(targetSubscriptionStateDesc, prometheus.GaugeValue, stateValue(ss.State, st), name, subName, st)
+			}
+			if ss.LastResponse != nil {
+				ch <- prometheus.MustNewConstMetric(targetSubscriptionLastResponseDesc, prometheus.GaugeValue,
+					float64(ss.LastResponse.UnixNano())/1e9, name, subName)
+			}
+		}
+	}
+}
+
+func stateValue(state, s string) float64 {
+	if state == s {
+		return 1
+	}
+	return 0
+}
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"time"
+
+	"github.com/mitchellh/mapstructure"
 )
 
 const (
@@ -10,6 +14,12 @@ const (
 	defaultAPIServerTimeout = 10 * time.Second
 )
 
+// API server roles
+const (
+	APIRoleReadOnly  = "read-only"
+	APIRoleReadWrite = "read-write"
+)
+
 type APIServer struct {
 	Address string        `mapstructure:"address,omitempty"`
 	Timeout time.Duration `mapstructure:"timeout,omitempty"`
@@ -21,6 +31,30 @@ type APIServer struct {
 	//
 	EnableMetrics bool `mapstructure:"enable-metrics,omitempty"`
 	Debug         bool `mapstructure:"debug,omitempty"`
+	// Auth
+	Auth *APIAuth `mapstructure:"auth,omitempty"`
+}
+
+// APIAuth is the API server authentication and authorization config
+type APIAuth struct {
+	// static bearer tokens
+	Tokens []*APIToken `mapstructure:"tokens,omitempty" json:"tokens,omitempty"`
+	// HTTP basic authentication users file
+	HtpasswdFile string `mapstructure:"htpasswd-file,omitempty" json:"htpasswd-file,omitempty"`
+	// use the TLS client certificate common name as user identity
+	ClientCert bool `mapstructure:"client-cert,omitempty" json:"client-cert,omitempty"`
+	// role name to users list
+	Roles map[string][]string `mapstructure:"roles,omitempty" json:"roles,omitempty"`
+	// role of the authenticated users not listed in Roles
+	DefaultRole string `mapstructure:"default-role,omitempty" json:"default-role,omitempty"`
+	// token used by the cluster members to call each other's API, it has the read-write role
+	ClusterToken string `mapstructure:"cluster-token,omitempty" json:"-"`
+}
+
+// APIToken is a static bearer token
+type APIToken struct {
+	User  string `mapstructure:"user,omitempty" json:"user,omitempty"`
+	Token string `mapstructure:"token,omitempty" json:"-"`
 }
 
 func (c *Config) GetAPIServer() error {
@@ -40,6 +74,13 @@ func (c *Config) GetAPIServer() error {
 
 	c.APIServer.EnableMetrics = os.ExpandEnv(c.FileConfig.GetString("api-server/enable-metrics")) == "true"
 	c.APIServer.Debug = os.ExpandEnv(c.FileConfig.GetString("api-server/debug")) == "true"
+	if c.FileConfig.IsSet("api-server/auth") {
+		auth, err := c.getAPIAuth()
+		if err != nil {
+			return err
+		}
+		c.APIServer.Auth = auth
+	}
 	c.setAPIServerDefaults()
 	return nil
 }
@@ -52,3 +93,42 @@ func (c *Config) setAPIServerDefaults() {
 		c.APIServer.Timeout = defaultAPIServerTimeout
 	}
 }
+
+func (c *Config) getAPIAuth() (*APIAuth, error) {
+	auth := new(APIAuth)
+	err := mapstructure.Decode(c.FileConfig.Get("api-server/auth"), auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode api-server auth config: %v", err)
+	}
+	auth.HtpasswdFile = os.ExpandEnv(auth.HtpasswdFile)
+	auth.ClusterToken = os.ExpandEnv(auth.ClusterToken)
+	for i, t := range auth.Tokens {
+		if t == nil {
+			return nil, fmt.Errorf("api-server auth: empty token at index %d", i)
+		}
+		t.User = os.ExpandEnv(t.User)
+		t.Token = os.ExpandEnv(t.Token)
+		if t.User == "" || t.Token == "" {
+			return nil, fmt.Errorf("api-server auth: token at index %d must have a user and a token", i)
+		}
+	}
+	for role := range auth.Roles {
+		if !validAPIRole(role) {
+			return nil, fmt.Errorf("api-server auth: unknown role %q", role)
+		}
+	}
+	if auth.DefaultRole != "" && !validAPIRole(auth.DefaultRole) {
+		return nil, fmt.Errorf("api-server auth: unknown default-role %q", auth.DefaultRole)
+	}
+	if auth.ClientCert && c.FileConfig.GetString("api-server/ca-file") == "" {
+		return nil, errors.New("api-server auth: client-cert requires a ca-file to verify the client certificates")
+	}
+	if len(auth.Tokens) == 0 && auth.HtpasswdFile == "" && !auth.ClientCert && auth.ClusterToken == "" {
+		return nil, errors.New("api-server auth: no authentication method configured")
+	}
+	return auth, nil
+}
+
+func validAPIRole(role string) bool {
+	return role == APIRoleReadOnly || role == APIRoleReadWrite
+}
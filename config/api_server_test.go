@@ -0,0 +1,114 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var getAPIServerAuthTestSet = map[string]struct {
+	envs    []string
+	in      []byte
+	out     *APIAuth
+	wantErr bool
+}{
+	"no_auth": {
+		in: []byte(`
+api-server:
+  address: :7890
+`),
+		out: nil,
+	},
+	"tokens_and_roles": {
+		envs: []string{"GNMIC_TEST_API_TOKEN=secret"},
+		in: []byte(`
+api-server:
+  address: :7890
+  auth:
+    tokens:
+      - user: ci
+        token: ${GNMIC_TEST_API_TOKEN}
+    htpasswd-file: /etc/gnmic/htpasswd
+    roles:
+      read-write: [ci]
+      read-only: [bob]
+    default-role: read-only
+`),
+		out: &APIAuth{
+			Tokens:       []*APIToken{{User: "ci", Token: "secret"}},
+			HtpasswdFile: "/etc/gnmic/htpasswd",
+			Roles: map[string][]string{
+				"read-write": {"ci"},
+				"read-only":  {"bob"},
+			},
+			DefaultRole: "read-only",
+		},
+	},
+	"unknown_role": {
+		in: []byte(`
+api-server:
+  auth:
+    htpasswd-file: /etc/gnmic/htpasswd
+    roles:
+      admin: [bob]
+`),
+		wantErr: true,
+	},
+	"token_without_user": {
+		in: []byte(`
+api-server:
+  auth:
+    tokens:
+      - token: secret
+`),
+		wantErr: true,
+	},
+	"client_cert_without_ca": {
+		in: []byte(`
+api-server:
+  auth:
+    client-cert: true
+`),
+		wantErr: true,
+	},
+	"no_method": {
+		in: []byte(`
+api-server:
+  auth:
+    default-role: read-only
+`),
+		wantErr: true,
+	},
+}
+
+func TestGetAPIServerAuth(t *testing.T) {
+	for name, data := range getAPIServerAuthTestSet {
+		t.Run(name, func(t *testing.T) {
+			for _, e := range data.envs {
+				p := strings.SplitN(e, "=", 2)
+				os.Setenv(p[0], p[1])
+			}
+			cfg := New()
+			cfg.FileConfig.SetConfigType("yaml")
+			err := cfg.FileConfig.ReadConfig(bytes.NewBuffer(data.in))
+			if err != nil {
+				t.Fatalf("failed reading config: %v", err)
+			}
+			err = cfg.GetAPIServer()
+			if data.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got auth config: %+v", cfg.APIServer.Auth)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(cfg.APIServer.Auth, data.out) {
+				t.Errorf("expected %+v, got %+v", data.out, cfg.APIServer.Auth)
+			}
+		})
+	}
+}
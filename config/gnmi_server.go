@@ -1,9 +1,15 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/karimra/gnmic/utils"
+	"github.com/mitchellh/mapstructure"
 )
 
 const (
@@ -26,6 +32,8 @@ type gnmiServer struct {
 	MinHeartbeatInterval  time.Duration `mapstructure:"min-heartbeat-interval,omitempty"`
 	MaxSubscriptions      int64         `mapstructure:"max-subscriptions,omitempty"`
 	MaxUnaryRPC           int64         `mapstructure:"max-unary-rpc,omitempty"`
+	// if true, a Set RPC is applied to all its targets or to none of them
+	TransactionalSet bool `mapstructure:"transactional-set,omitempty"`
 	// TLS
 	SkipVerify bool   `mapstructure:"skip-verify,omitempty"`
 	CaFile     string `mapstructure:"ca-file,omitempty"`
@@ -36,6 +44,43 @@ type gnmiServer struct {
 	Debug         bool `mapstructure:"debug,omitempty"`
 	// ServiceRegistration
 	ServiceRegistration *serviceRegistration `mapstructure:"service-registration,omitempty"`
+	// Auth
+	Auth *GNMIServerAuth `mapstructure:"auth,omitempty"`
+	// History
+	History *GNMIServerHistory `mapstructure:"history,omitempty"`
+}
+
+// GNMIServerHistory is the retention config of the notifications replayed using the gNMI History extension
+type GNMIServerHistory struct {
+	Retention time.Duration `mapstructure:"retention,omitempty" json:"retention,omitempty"`
+	MaxSize   int64         `mapstructure:"max-size,omitempty" json:"max-size,omitempty"`
+}
+
+// gNMI server access rules actions
+const (
+	GNMIAccessAllow = "allow"
+	GNMIAccessDeny  = "deny"
+)
+
+// GNMIServerAuth is the gNMI server authentication and authorization config
+type GNMIServerAuth struct {
+	// users file, if set the clients must send a valid username and password in the RPC metadata,
+	// otherwise the clients are anonymous and the rules cannot match specific users.
+	HtpasswdFile string `mapstructure:"htpasswd-file,omitempty" json:"htpasswd-file,omitempty"`
+	// access rules, evaluated in order, the first matching rule applies
+	Rules []*GNMIAccessRule `mapstructure:"rules,omitempty" json:"rules,omitempty"`
+	// action applied to the requests not matching any rule
+	DefaultAction string `mapstructure:"default-action,omitempty" json:"default-action,omitempty"`
+}
+
+// GNMIAccessRule allows or denies RPCs to users, per target and path prefix.
+// An empty list matches any value.
+type GNMIAccessRule struct {
+	Users   []string `mapstructure:"users,omitempty" json:"users,omitempty"`
+	RPCs    []string `mapstructure:"rpcs,omitempty" json:"rpcs,omitempty"`
+	Targets []string `mapstructure:"targets,omitempty" json:"targets,omitempty"`
+	Paths   []string `mapstructure:"paths,omitempty" json:"paths,omitempty"`
+	Action  string   `mapstructure:"action,omitempty" json:"action,omitempty"`
 }
 
 type serviceRegistration struct {
@@ -81,8 +126,23 @@ func (c *Config) GetGNMIServer() error {
 	c.GnmiServer.CertFile = os.ExpandEnv(c.FileConfig.GetString("gnmi-server/cert-file"))
 	c.GnmiServer.KeyFile = os.ExpandEnv(c.FileConfig.GetString("gnmi-server/key-file"))
 
+	c.GnmiServer.TransactionalSet = os.ExpandEnv(c.FileConfig.GetString("gnmi-server/transactional-set")) == "true"
+
 	c.GnmiServer.EnableMetrics = os.ExpandEnv(c.FileConfig.GetString("gnmi-server/enable-metrics")) == "true"
 	c.GnmiServer.Debug = os.ExpandEnv(c.FileConfig.GetString("gnmi-server/debug")) == "true"
+	if c.FileConfig.IsSet("gnmi-server/auth") {
+		auth, err := c.getGNMIServerAuth()
+		if err != nil {
+			return err
+		}
+		c.GnmiServer.Auth = auth
+	}
+	if c.FileConfig.IsSet("gnmi-server/history") {
+		c.GnmiServer.History = &GNMIServerHistory{
+			Retention: c.FileConfig.GetDuration("gnmi-server/history/retention"),
+			MaxSize:   c.FileConfig.GetInt64("gnmi-server/history/max-size"),
+		}
+	}
 	c.setGnmiServerDefaults()
 
 	if !c.FileConfig.IsSet("gnmi-server/service-registration") {
@@ -136,3 +196,57 @@ func (c *Config) setGnmiServerServiceRegistrationDefaults() {
 	deregisterTimer := c.GnmiServer.ServiceRegistration.CheckInterval * time.Duration(c.GnmiServer.ServiceRegistration.MaxFail)
 	c.GnmiServer.ServiceRegistration.DeregisterAfter = deregisterTimer.String()
 }
+
+func (c *Config) getGNMIServerAuth() (*GNMIServerAuth, error) {
+	auth := new(GNMIServerAuth)
+	err := mapstructure.Decode(c.FileConfig.Get("gnmi-server/auth"), auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode gnmi-server auth config: %v", err)
+	}
+	auth.HtpasswdFile = os.ExpandEnv(auth.HtpasswdFile)
+	if auth.DefaultAction == "" {
+		auth.DefaultAction = GNMIAccessDeny
+	}
+	if !validGNMIAccessAction(auth.DefaultAction) {
+		return nil, fmt.Errorf("gnmi-server auth: unknown default-action %q", auth.DefaultAction)
+	}
+	for i, r := range auth.Rules {
+		if r == nil {
+			return nil, fmt.Errorf("gnmi-server auth: empty rule at index %d", i)
+		}
+		if !validGNMIAccessAction(r.Action) {
+			return nil, fmt.Errorf("gnmi-server auth: rule %d: unknown action %q", i, r.Action)
+		}
+		// without a users file the username sent by the clients cannot be trusted
+		if auth.HtpasswdFile == "" {
+			for _, u := range r.Users {
+				if u != "*" {
+					return nil, fmt.Errorf("gnmi-server auth: rule %d: users require an htpasswd-file", i)
+				}
+			}
+		}
+		for j, rpc := range r.RPCs {
+			r.RPCs[j] = strings.ToLower(rpc)
+			switch r.RPCs[j] {
+			case "get", "set", "subscribe", "*":
+			default:
+				return nil, fmt.Errorf("gnmi-server auth: rule %d: unknown rpc %q", i, rpc)
+			}
+		}
+		for _, t := range r.Targets {
+			if _, err = filepath.Match(t, ""); err != nil {
+				return nil, fmt.Errorf("gnmi-server auth: rule %d: invalid target pattern %q: %v", i, t, err)
+			}
+		}
+		for _, p := range r.Paths {
+			if _, err = utils.ParsePath(p); err != nil {
+				return nil, fmt.Errorf("gnmi-server auth: rule %d: invalid path %q: %v", i, p, err)
+			}
+		}
+	}
+	return auth, nil
+}
+
+func validGNMIAccessAction(action string) bool {
+	return action == GNMIAccessAllow || action == GNMIAccessDeny
+}
@@ -0,0 +1,103 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+)
+
+var getGNMIServerAuthTestSet = map[string]struct {
+	in      []byte
+	wantErr bool
+}{
+	"valid_rules": {
+		in: []byte(`
+gnmi-server:
+  auth:
+    htpasswd-file: /etc/gnmic/gnmi-users
+    rules:
+      - users: [telemetry]
+        rpcs: [Get, subscribe]
+        targets: ["leaf*"]
+        paths: ["/interfaces/interface[name=*]/state"]
+        action: allow
+`),
+	},
+	"users_without_htpasswd_file": {
+		in: []byte(`
+gnmi-server:
+  auth:
+    rules:
+      - users: [admin]
+        rpcs: [set]
+        action: allow
+`),
+		wantErr: true,
+	},
+	"unknown_action": {
+		in: []byte(`
+gnmi-server:
+  auth:
+    rules:
+      - rpcs: [set]
+        action: reject
+`),
+		wantErr: true,
+	},
+	"unknown_rpc": {
+		in: []byte(`
+gnmi-server:
+  auth:
+    rules:
+      - rpcs: [capabilities]
+        action: deny
+`),
+		wantErr: true,
+	},
+	"invalid_path": {
+		in: []byte(`
+gnmi-server:
+  auth:
+    rules:
+      - paths: ["/interfaces/interface[name=1]]"]
+        action: deny
+`),
+		wantErr: true,
+	},
+	"unknown_default_action": {
+		in: []byte(`
+gnmi-server:
+  auth:
+    default-action: maybe
+`),
+		wantErr: true,
+	},
+}
+
+func TestGetGNMIServerAuth(t *testing.T) {
+	for name, data := range getGNMIServerAuthTestSet {
+		t.Run(name, func(t *testing.T) {
+			cfg := New()
+			cfg.FileConfig.SetConfigType("yaml")
+			err := cfg.FileConfig.ReadConfig(bytes.NewBuffer(data.in))
+			if err != nil {
+				t.Fatalf("failed reading config: %v", err)
+			}
+			err = cfg.GetGNMIServer()
+			if data.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if cfg.GnmiServer.Auth.DefaultAction != GNMIAccessDeny {
+				t.Errorf("expected default action %q, got %q", GNMIAccessDeny, cfg.GnmiServer.Auth.DefaultAction)
+			}
+			if cfg.GnmiServer.Auth.Rules[0].RPCs[0] != "get" {
+				t.Errorf("expected rpc names to be lower cased, got %q", cfg.GnmiServer.Auth.Rules[0].RPCs[0])
+			}
+		})
+	}
+}
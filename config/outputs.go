@@ -2,6 +2,7 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sort"
 
@@ -71,6 +72,28 @@ func (c *Config) GetOutputs() (map[string]map[string]interface{}, error) {
 	return filteredOutputs, nil
 }
 
+// SetOutputConfigDefaults validates an output config created at runtime
+// and applies the same defaults as the outputs read from the config file.
+func (c *Config) SetOutputConfigDefaults(outCfg map[string]interface{}) error {
+	convert(outCfg)
+	outType, ok := outCfg["type"].(string)
+	if !ok || outType == "" {
+		return errors.New("missing output 'type'")
+	}
+	if !strInlist(outType, outputs.OutputTypes) {
+		return fmt.Errorf("unknown output type: %q", outType)
+	}
+	if _, ok := outputs.Outputs[outType]; !ok {
+		return fmt.Errorf("unknown output type: %q", outType)
+	}
+	format, ok := outCfg["format"]
+	if !ok || (ok && format == "") {
+		outCfg["format"] = c.FileConfig.GetString("format")
+	}
+	expandMapEnv(outCfg)
+	return nil
+}
+
 func convert(i interface{}) interface{} {
 	switch x := i.(type) {
 	case map[interface{}]interface{}:
@@ -39,6 +39,33 @@ func (c *Config) GetEventProcessors() (map[string]map[string]interface{}, error)
 	return c.Processors, nil
 }
 
+// SetProcessorConfigDefaults validates an event processor config created at runtime
+// by decoding it, the processor is initialized by the outputs using it.
+func (c *Config) SetProcessorConfigDefaults(epc map[string]interface{}) error {
+	if len(epc) != 1 {
+		return fmt.Errorf("a processor config must have exactly one type, got %d", len(epc))
+	}
+	err := c.validateProcessorConfig(epc)
+	if err != nil {
+		return err
+	}
+	for k, v := range epc {
+		epc[k] = convert(v)
+	}
+	expandMapEnv(epc)
+	for epType, cfg := range epc {
+		in, ok := formatters.EventProcessors[epType]
+		if !ok {
+			continue
+		}
+		err = formatters.DecodeConfig(cfg, in())
+		if err != nil {
+			return fmt.Errorf("invalid %q processor config: %v", epType, err)
+		}
+	}
+	return nil
+}
+
 func (c *Config) validateProcessorConfig(pcfg map[string]interface{}) error {
 	for epType := range pcfg {
 		if !strInlist(epType, formatters.EventProcessorTypes) {
@@ -127,3 +127,33 @@ func TestGetProcessors(t *testing.T) {
 		})
 	}
 }
+
+func TestSetProcessorConfigDefaults(t *testing.T) {
+	cfg := New()
+	cfg.SetLogger()
+	// validating does not initialize the processor, the reference file is read once used
+	err := cfg.SetProcessorConfigDefaults(map[string]interface{}{
+		"event-enrich": map[string]interface{}{
+			"file":     "/nonexistent/inventory.csv",
+			"key-tags": []interface{}{"source"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = cfg.SetProcessorConfigDefaults(map[string]interface{}{
+		"event-enrich": map[string]interface{}{
+			"key-tags": "source",
+			"interval": map[string]interface{}{},
+		},
+	})
+	if err == nil {
+		t.Fatal("expected a decoding error")
+	}
+	err = cfg.SetProcessorConfigDefaults(map[string]interface{}{
+		"event-unknown": map[string]interface{}{},
+	})
+	if err == nil {
+		t.Fatal("expected an unknown type error")
+	}
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/itchyny/gojq"
+	"github.com/karimra/gnmic/types"
+	"github.com/mitchellh/mapstructure"
+)
+
+// GetOutputRoutes reads and validates the `output-routes` section
+func (c *Config) GetOutputRoutes() ([]*types.OutputRoute, error) {
+	if !c.FileConfig.IsSet("output-routes") {
+		return nil, nil
+	}
+	routes := make([]*types.OutputRoute, 0)
+	err := mapstructure.Decode(c.FileConfig.Get("output-routes"), &routes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode output-routes config: %v", err)
+	}
+	names := make(map[string]struct{}, len(routes))
+	var defaultRoute string
+	for i, r := range routes {
+		if r == nil {
+			return nil, fmt.Errorf("output-routes: empty route at index %d", i)
+		}
+		if r.Name == "" {
+			return nil, fmt.Errorf("output-routes: route at index %d is missing a name", i)
+		}
+		if _, ok := names[r.Name]; ok {
+			return nil, fmt.Errorf("output-routes: duplicate route name %q", r.Name)
+		}
+		names[r.Name] = struct{}{}
+		if len(r.Outputs) == 0 {
+			return nil, fmt.Errorf("output-routes: route %q has no outputs", r.Name)
+		}
+		if r.Default {
+			if defaultRoute != "" {
+				return nil, fmt.Errorf("output-routes: routes %q and %q are both set as default", defaultRoute, r.Name)
+			}
+			if len(r.Subscriptions) > 0 || len(r.TargetTags) > 0 || r.Condition != "" {
+				return nil, fmt.Errorf("output-routes: default route %q cannot have subscriptions, target-tags or condition", r.Name)
+			}
+			defaultRoute = r.Name
+		}
+		r.Condition = strings.TrimSpace(r.Condition)
+		if r.Condition != "" {
+			if _, err = gojq.Parse(r.Condition); err != nil {
+				return nil, fmt.Errorf("output-routes: route %q: invalid condition: %v", r.Name, err)
+			}
+		}
+	}
+	if c.Debug {
+		c.logger.Printf("output-routes: %+v", routes)
+	}
+	return routes, nil
+}
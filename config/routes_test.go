@@ -0,0 +1,123 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+)
+
+var getOutputRoutesTestSet = map[string]struct {
+	in      []byte
+	want    int
+	wantErr bool
+}{
+	"no_routes": {
+		in: []byte(`
+outputs:
+  out1:
+    type: file
+`),
+	},
+	"valid_routes": {
+		in: []byte(`
+output-routes:
+  - name: core-counters
+    subscriptions: [counters]
+    target-tags: [core]
+    condition: '.values | has("/interfaces/interface/state/counters/in-octets")'
+    outputs: [influxdb]
+    fallthrough: true
+  - name: edge
+    target-tags: [edge]
+    outputs: [kafka]
+  - name: default
+    default: true
+    outputs: [file]
+`),
+		want: 3,
+	},
+	"missing_name": {
+		in: []byte(`
+output-routes:
+  - outputs: [kafka]
+`),
+		wantErr: true,
+	},
+	"duplicate_name": {
+		in: []byte(`
+output-routes:
+  - name: r1
+    outputs: [kafka]
+  - name: r1
+    outputs: [file]
+`),
+		wantErr: true,
+	},
+	"missing_outputs": {
+		in: []byte(`
+output-routes:
+  - name: r1
+    subscriptions: [sub1]
+`),
+		wantErr: true,
+	},
+	"invalid_condition": {
+		in: []byte(`
+output-routes:
+  - name: r1
+    condition: '.tags.source =='
+    outputs: [kafka]
+`),
+		wantErr: true,
+	},
+	"multiple_defaults": {
+		in: []byte(`
+output-routes:
+  - name: r1
+    default: true
+    outputs: [kafka]
+  - name: r2
+    default: true
+    outputs: [file]
+`),
+		wantErr: true,
+	},
+	"default_with_criteria": {
+		in: []byte(`
+output-routes:
+  - name: r1
+    default: true
+    target-tags: [core]
+    outputs: [kafka]
+`),
+		wantErr: true,
+	},
+}
+
+func TestGetOutputRoutes(t *testing.T) {
+	for name, data := range getOutputRoutesTestSet {
+		t.Run(name, func(t *testing.T) {
+			cfg := New()
+			cfg.FileConfig.SetConfigType("yaml")
+			err := cfg.FileConfig.ReadConfig(bytes.NewBuffer(data.in))
+			if err != nil {
+				t.Fatalf("failed reading config: %v", err)
+			}
+			routes, err := cfg.GetOutputRoutes()
+			if data.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(routes) != data.want {
+				t.Fatalf("expected %d routes, got %d", data.want, len(routes))
+			}
+			if data.want > 0 && (!routes[0].Fallthrough || routes[0].TargetTags[0] != "core" || !routes[2].Default) {
+				t.Errorf("unexpected routes: %+v", routes)
+			}
+		})
+	}
+}
@@ -144,6 +144,28 @@ func (c *Config) GetSubscriptionsFromFile() []*types.SubscriptionConfig {
 	return subscriptions
 }
 
+// SetSubscriptionConfigDefaults applies the config file subscriptions defaults to sc,
+// then validates it against the other subscriptions in subs.
+// It is used for the subscriptions created at runtime.
+func (c *Config) SetSubscriptionConfigDefaults(sc *types.SubscriptionConfig, subs map[string]*types.SubscriptionConfig) error {
+	if sc.Name == "" {
+		return errors.New("missing subscription name")
+	}
+	c.setSubscriptionDefaults(sc, nil)
+	expandSubscriptionEnv(sc)
+	// validates the paths, mode and encoding
+	_, err := sc.CreateSubscribeRequest("")
+	if err != nil {
+		return err
+	}
+	allSubs := make(map[string]*types.SubscriptionConfig, len(subs)+1)
+	for n, s := range subs {
+		allSubs[n] = s
+	}
+	allSubs[sc.Name] = sc
+	return validateSubscriptionsConfig(allSubs)
+}
+
 func validateSubscriptionsConfig(subs map[string]*types.SubscriptionConfig) error {
 	var hasPoll bool
 	var hasOnce bool
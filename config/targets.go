@@ -129,6 +129,26 @@ func (c *Config) GetTargets() (map[string]*types.TargetConfig, error) {
 	return c.Targets, nil
 }
 
+// getBackoff reads the global reconnection backoff config,
+// it applies to the targets without a backoff config and completes the partial ones.
+func (c *Config) getBackoff() (*types.BackoffConfig, error) {
+	bc := new(types.BackoffConfig)
+	decoder, err := mapstructure.NewDecoder(
+		&mapstructure.DecoderConfig{
+			DecodeHook: mapstructure.StringToTimeDurationHookFunc(),
+			Result:     bc,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	err = decoder.Decode(c.FileConfig.Get("backoff"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode backoff config: %v", err)
+	}
+	return bc, nil
+}
+
 func readUsername() (string, error) {
 	var username string
 	fmt.Print("username: ")
@@ -203,6 +223,17 @@ func (c *Config) SetTargetConfigDefaults(tc *types.TargetConfig) error {
 	if tc.RetryTimer == 0 {
 		tc.RetryTimer = c.Retry
 	}
+	if c.FileConfig.IsSet("backoff") {
+		defBackoff, err := c.getBackoff()
+		if err != nil {
+			return err
+		}
+		if tc.Backoff == nil {
+			tc.Backoff = defBackoff
+		} else {
+			tc.Backoff.SetDefaults(defBackoff)
+		}
+	}
 	if tc.TLSVersion == "" {
 		tc.TLSVersion = c.TLSVersion
 	}
@@ -6,6 +6,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/karimra/gnmic/types"
 )
@@ -266,6 +267,66 @@ targets:
 		},
 		outErr: nil,
 	},
+	"targets_with_backoff": {
+		in: []byte(`
+port: 57400
+backoff:
+  initial-delay: 1s
+  max-delay: 1m
+  multiplier: 2
+  jitter: 0.2
+targets:
+  10.1.1.1:
+    username: admin
+    password: admin
+  10.1.1.2:
+    username: admin
+    password: admin
+    backoff:
+      max-delay: 30s
+      max-attempts: 10
+`),
+		out: map[string]*types.TargetConfig{
+			"10.1.1.1": {
+				Address:    "10.1.1.1:57400",
+				Name:       "10.1.1.1",
+				Password:   &adminStr,
+				Username:   &adminStr,
+				Token:      &emptyStr,
+				TLSCert:    &emptyStr,
+				TLSKey:     &emptyStr,
+				Insecure:   &falseBool,
+				SkipVerify: &falseBool,
+				Gzip:       &falseBool,
+				Backoff: &types.BackoffConfig{
+					InitialDelay: time.Second,
+					MaxDelay:     time.Minute,
+					Multiplier:   2,
+					Jitter:       0.2,
+				},
+			},
+			"10.1.1.2": {
+				Address:    "10.1.1.2:57400",
+				Name:       "10.1.1.2",
+				Password:   &adminStr,
+				Username:   &adminStr,
+				Token:      &emptyStr,
+				TLSCert:    &emptyStr,
+				TLSKey:     &emptyStr,
+				Insecure:   &falseBool,
+				SkipVerify: &falseBool,
+				Gzip:       &falseBool,
+				Backoff: &types.BackoffConfig{
+					InitialDelay: time.Second,
+					MaxDelay:     30 * time.Second,
+					Multiplier:   2,
+					Jitter:       0.2,
+					MaxAttempts:  10,
+				},
+			},
+		},
+		outErr: nil,
+	},
 }
 
 func TestGetTargets(t *testing.T) {
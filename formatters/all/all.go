@@ -2,16 +2,20 @@ package all
 
 import (
 	_ "github.com/karimra/gnmic/formatters/event_add_tag"
+	_ "github.com/karimra/gnmic/formatters/event_aggregate"
 	_ "github.com/karimra/gnmic/formatters/event_allow"
 	_ "github.com/karimra/gnmic/formatters/event_convert"
 	_ "github.com/karimra/gnmic/formatters/event_date_string"
 	_ "github.com/karimra/gnmic/formatters/event_delete"
 	_ "github.com/karimra/gnmic/formatters/event_drop"
+	_ "github.com/karimra/gnmic/formatters/event_enrich"
 	_ "github.com/karimra/gnmic/formatters/event_extract_tags"
 	_ "github.com/karimra/gnmic/formatters/event_group_by"
 	_ "github.com/karimra/gnmic/formatters/event_jq"
 	_ "github.com/karimra/gnmic/formatters/event_merge"
 	_ "github.com/karimra/gnmic/formatters/event_override_ts"
+	_ "github.com/karimra/gnmic/formatters/event_rate"
+	_ "github.com/karimra/gnmic/formatters/event_starlark"
 	_ "github.com/karimra/gnmic/formatters/event_strings"
 	_ "github.com/karimra/gnmic/formatters/event_to_tag"
 	_ "github.com/karimra/gnmic/formatters/event_trigger"
@@ -0,0 +1,382 @@
+package event_aggregate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/types"
+)
+
+const (
+	processorType = "event-aggregate"
+	loggingPrefix = "[" + processorType + "] "
+	defaultWindow = time.Minute
+)
+
+var defaultFunctions = []string{"min", "max", "mean", "sum", "count"}
+
+// emittingOutputs are the outputs starting the event emitters
+var emittingOutputs = []string{"prometheus", "prometheus_write", "influxdb", "otlp", "elasticsearch"}
+
+// Aggregate buffers the values with key matching one of regexes over a time window,
+// and emits their aggregates at the end of each window.
+type Aggregate struct {
+	Values       []string      `mapstructure:"value-names,omitempty" json:"value-names,omitempty"`
+	Tags         []string      `mapstructure:"tags,omitempty" json:"tags,omitempty"`
+	Window       time.Duration `mapstructure:"window,omitempty" json:"window,omitempty"`
+	Slide        time.Duration `mapstructure:"slide,omitempty" json:"slide,omitempty"`
+	Functions    []string      `mapstructure:"functions,omitempty" json:"functions,omitempty"`
+	DropOriginal bool          `mapstructure:"drop-original,omitempty" json:"drop-original,omitempty"`
+	Debug        bool          `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+
+	values []*regexp.Regexp
+	fns    []*aggFn
+
+	m      sync.Mutex
+	groups map[string]*group
+	// set by the formatters.WithEmitting option
+	emitting bool
+	logger   *log.Logger
+}
+
+// group holds the samples of the events sharing the same name and key tags
+type group struct {
+	name   string
+	tags   map[string]string
+	values map[string][]sample
+}
+
+type sample struct {
+	t time.Time
+	v float64
+}
+
+type aggFn struct {
+	name string
+	fn   func(sorted []float64) float64
+}
+
+func init() {
+	formatters.Register(processorType, func() formatters.EventProcessor {
+		return &Aggregate{
+			logger: log.New(ioutil.Discard, "", 0),
+		}
+	})
+}
+
+func (p *Aggregate) Init(cfg interface{}, opts ...formatters.Option) error {
+	err := formatters.DecodeConfig(cfg, p)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if !p.emitting {
+		return fmt.Errorf("%s can only be used by outputs emitting events: %s",
+			processorType, strings.Join(emittingOutputs, ", "))
+	}
+	p.values = make([]*regexp.Regexp, 0, len(p.Values))
+	for _, reg := range p.Values {
+		re, err := regexp.Compile(reg)
+		if err != nil {
+			return err
+		}
+		p.values = append(p.values, re)
+	}
+	if p.Window <= 0 {
+		p.Window = defaultWindow
+	}
+	if p.Slide <= 0 {
+		p.Slide = p.Window
+	}
+	if p.Slide > p.Window {
+		return fmt.Errorf("slide %s must not be greater than window %s", p.Slide, p.Window)
+	}
+	if len(p.Functions) == 0 {
+		p.Functions = defaultFunctions
+	}
+	p.fns = make([]*aggFn, 0, len(p.Functions))
+	for _, name := range p.Functions {
+		fn, err := newAggFn(name)
+		if err != nil {
+			return err
+		}
+		p.fns = append(p.fns, fn)
+	}
+	p.groups = make(map[string]*group)
+	if p.logger.Writer() != ioutil.Discard {
+		b, err := json.Marshal(p)
+		if err != nil {
+			p.logger.Printf("initialized processor '%s': %+v", processorType, p)
+			return nil
+		}
+		p.logger.Printf("initialized processor '%s': %s", processorType, string(b))
+	}
+	return nil
+}
+
+func (p *Aggregate) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
+	p.m.Lock()
+	defer p.m.Unlock()
+	now := time.Now()
+	result := make([]*formatters.EventMsg, 0, len(es))
+	for _, e := range es {
+		if e == nil {
+			continue
+		}
+		key, tags, ok := p.groupKey(e)
+		if !ok {
+			result = append(result, e)
+			continue
+		}
+		matched := false
+		for k, v := range e.Values {
+			if !p.match(k) {
+				continue
+			}
+			f, err := toFloat(v)
+			if err != nil {
+				p.logger.Printf("value %q: %v", k, err)
+				continue
+			}
+			g, ok := p.groups[key]
+			if !ok {
+				g = &group{
+					name:   e.Name,
+					tags:   tags,
+					values: make(map[string][]sample),
+				}
+				p.groups[key] = g
+			}
+			g.values[k] = append(g.values[k], sample{t: now, v: f})
+			matched = true
+			if p.DropOriginal {
+				delete(e.Values, k)
+			}
+		}
+		// drop the events left without values after removing the aggregated ones
+		if matched && p.DropOriginal && len(e.Values) == 0 && len(e.Deletes) == 0 {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+// Emit passes the aggregated events to fn every slide interval, until ctx is done.
+func (p *Aggregate) Emit(ctx context.Context, fn func(...*formatters.EventMsg)) {
+	ticker := time.NewTicker(p.Slide)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			evs := p.aggregate(now)
+			if p.Debug {
+				p.logger.Printf("emitting %d aggregated event(s)", len(evs))
+			}
+			if len(evs) > 0 {
+				fn(evs...)
+			}
+		}
+	}
+}
+
+func (p *Aggregate) EnableEmitting() {
+	p.emitting = true
+}
+
+func (p *Aggregate) WithLogger(l *log.Logger) {
+	if p.Debug && l != nil {
+		p.logger = log.New(l.Writer(), loggingPrefix, l.Flags())
+	} else if p.Debug {
+		p.logger = log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds)
+	}
+}
+
+func (p *Aggregate) WithTargets(tcs map[string]*types.TargetConfig) {}
+
+// aggregate builds an event per group from the samples received within
+// the window ending at now, the samples leaving the window are removed.
+func (p *Aggregate) aggregate(now time.Time) []*formatters.EventMsg {
+	p.m.Lock()
+	defer p.m.Unlock()
+	tumbling := p.Slide == p.Window
+	start := now.Add(-p.Window)
+	keys := make([]string, 0, len(p.groups))
+	for k := range p.groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := make([]*formatters.EventMsg, 0, len(keys))
+	for _, k := range keys {
+		g := p.groups[k]
+		e := &formatters.EventMsg{
+			Name:      g.name,
+			Timestamp: now.UnixNano(),
+			Tags:      make(map[string]string, len(g.tags)),
+			Values:    make(map[string]interface{}),
+		}
+		for tk, tv := range g.tags {
+			e.Tags[tk] = tv
+		}
+		for vn, samples := range g.values {
+			if !tumbling {
+				i := 0
+				for i < len(samples) && !samples[i].t.After(start) {
+					i++
+				}
+				if i > 0 {
+					samples = append(samples[:0:0], samples[i:]...)
+					g.values[vn] = samples
+				}
+				if len(samples) == 0 {
+					delete(g.values, vn)
+					continue
+				}
+			}
+			vs := make([]float64, 0, len(samples))
+			for _, s := range samples {
+				vs = append(vs, s.v)
+			}
+			sort.Float64s(vs)
+			for _, fn := range p.fns {
+				e.Values[vn+"_"+fn.name] = fn.fn(vs)
+			}
+		}
+		if tumbling || len(g.values) == 0 {
+			delete(p.groups, k)
+		}
+		if len(e.Values) > 0 {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// groupKey returns the key identifying the event group and the group tags.
+// The event is not aggregated if it is missing one of the configured tags.
+func (p *Aggregate) groupKey(e *formatters.EventMsg) (string, map[string]string, bool) {
+	names := p.Tags
+	if len(names) == 0 {
+		names = make([]string, 0, len(e.Tags))
+		for k := range e.Tags {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+	}
+	tags := make(map[string]string, len(names))
+	sb := strings.Builder{}
+	sb.WriteString(e.Name)
+	for _, n := range names {
+		v, ok := e.Tags[n]
+		if !ok {
+			return "", nil, false
+		}
+		tags[n] = v
+		sb.WriteString("\x00")
+		sb.WriteString(n)
+		sb.WriteString("=")
+		sb.WriteString(v)
+	}
+	return sb.String(), tags, true
+}
+
+func (p *Aggregate) match(k string) bool {
+	for _, re := range p.values {
+		if re.MatchString(k) {
+			return true
+		}
+	}
+	return false
+}
+
+func newAggFn(name string) (*aggFn, error) {
+	switch name {
+	case "min":
+		return &aggFn{name: name, fn: func(vs []float64) float64 { return vs[0] }}, nil
+	case "max":
+		return &aggFn{name: name, fn: func(vs []float64) float64 { return vs[len(vs)-1] }}, nil
+	case "sum":
+		return &aggFn{name: name, fn: sum}, nil
+	case "mean", "avg":
+		return &aggFn{name: name, fn: func(vs []float64) float64 { return sum(vs) / float64(len(vs)) }}, nil
+	case "count":
+		return &aggFn{name: name, fn: func(vs []float64) float64 { return float64(len(vs)) }}, nil
+	}
+	if strings.HasPrefix(name, "p") {
+		pc, err := strconv.ParseFloat(name[1:], 64)
+		if err == nil && pc > 0 && pc <= 100 {
+			return &aggFn{name: name, fn: func(vs []float64) float64 { return percentile(vs, pc) }}, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported aggregation function %q", name)
+}
+
+func sum(vs []float64) float64 {
+	var s float64
+	for _, v := range vs {
+		s += v
+	}
+	return s
+}
+
+// percentile returns the nearest-rank percentile of the sorted values
+func percentile(sorted []float64, pc float64) float64 {
+	rank := int(math.Ceil(pc / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), nil
+	case int8:
+		return float64(v), nil
+	case int16:
+		return float64(v), nil
+	case int32:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint:
+		return float64(v), nil
+	case uint8:
+		return float64(v), nil
+	case uint16:
+		return float64(v), nil
+	case uint32:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("cannot aggregate value %q", v)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("cannot aggregate value %v, type %T", v, v)
+	}
+}
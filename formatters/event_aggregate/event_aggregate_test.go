@@ -0,0 +1,148 @@
+package event_aggregate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/karimra/gnmic/formatters"
+)
+
+func newAggregate(t *testing.T, cfg map[string]interface{}) *Aggregate {
+	p := formatters.EventProcessors[processorType]().(*Aggregate)
+	err := p.Init(cfg, formatters.WithEmitting())
+	if err != nil {
+		t.Fatalf("failed to initialize processor: %v", err)
+	}
+	return p
+}
+
+func TestEventAggregate(t *testing.T) {
+	p := newAggregate(t, map[string]interface{}{
+		"value-names": []string{"^cpu$"},
+		"tags":        []string{"source"},
+		"functions":   []string{"min", "max", "mean", "sum", "count", "p50", "p95"},
+	})
+	for i := 1; i <= 10; i++ {
+		out := p.Apply(
+			&formatters.EventMsg{
+				Name:   "sub1",
+				Tags:   map[string]string{"source": "r1", "cpu_id": "0"},
+				Values: map[string]interface{}{"cpu": uint8(i), "name": "cpu0"},
+			},
+			// not aggregated, missing the source tag
+			&formatters.EventMsg{
+				Name:   "sub1",
+				Values: map[string]interface{}{"cpu": 100},
+			},
+		)
+		if len(out) != 2 {
+			t.Fatalf("got %d events, original events should be kept", len(out))
+		}
+	}
+	now := time.Unix(60, 0)
+	out := p.aggregate(now)
+	want := []*formatters.EventMsg{
+		{
+			Name:      "sub1",
+			Timestamp: now.UnixNano(),
+			Tags:      map[string]string{"source": "r1"},
+			Values: map[string]interface{}{
+				"cpu_min":   float64(1),
+				"cpu_max":   float64(10),
+				"cpu_mean":  float64(5.5),
+				"cpu_sum":   float64(55),
+				"cpu_count": float64(10),
+				"cpu_p50":   float64(5),
+				"cpu_p95":   float64(10),
+			},
+		},
+	}
+	if !cmp.Equal(out, want) {
+		t.Errorf("unexpected aggregated events: %s", cmp.Diff(want, out))
+	}
+	// tumbling window, the next window starts empty
+	if out = p.aggregate(now.Add(time.Minute)); len(out) != 0 {
+		t.Errorf("got %d aggregated events from an empty window", len(out))
+	}
+}
+
+func TestEventAggregateSliding(t *testing.T) {
+	p := newAggregate(t, map[string]interface{}{
+		"value-names":   []string{"cpu"},
+		"window":        "1m",
+		"slide":         "10s",
+		"functions":     []string{"max", "count"},
+		"drop-original": true,
+	})
+	out := p.Apply(&formatters.EventMsg{
+		Name:   "sub1",
+		Tags:   map[string]string{"source": "r1"},
+		Values: map[string]interface{}{"cpu": "42"},
+	})
+	if len(out) != 0 {
+		t.Fatalf("got %d events, original event should be dropped", len(out))
+	}
+	now := time.Now()
+	for _, d := range []time.Duration{10 * time.Second, 50 * time.Second} {
+		out = p.aggregate(now.Add(d))
+		if len(out) != 1 {
+			t.Fatalf("got %d aggregated events after %s, want 1", len(out), d)
+		}
+		if out[0].Values["cpu_max"] != float64(42) || out[0].Values["cpu_count"] != float64(1) {
+			t.Errorf("unexpected aggregated values: %v", out[0].Values)
+		}
+	}
+	// the sample left the window
+	if out = p.aggregate(now.Add(70 * time.Second)); len(out) != 0 {
+		t.Errorf("got %d aggregated events, want 0", len(out))
+	}
+	if len(p.groups) != 0 {
+		t.Errorf("got %d groups, want 0", len(p.groups))
+	}
+}
+
+func TestEventAggregateNotEmitting(t *testing.T) {
+	p := formatters.EventProcessors[processorType]()
+	err := p.Init(map[string]interface{}{"value-names": []string{"cpu"}})
+	if err == nil {
+		t.Fatal("expected an error when the processor is not used by an output emitting events")
+	}
+}
+
+func TestEmit(t *testing.T) {
+	p := newAggregate(t, map[string]interface{}{
+		"value-names": []string{"cpu"},
+		"window":      "10ms",
+		"functions":   []string{"sum"},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	evCh := make(chan []*formatters.EventMsg, 1)
+	go p.Emit(ctx, func(evs ...*formatters.EventMsg) { evCh <- evs })
+	p.Apply(&formatters.EventMsg{Values: map[string]interface{}{"cpu": 1.5}})
+	select {
+	case evs := <-evCh:
+		if len(evs) != 1 || evs[0].Values["cpu_sum"] != 1.5 {
+			t.Errorf("unexpected emitted events: %v", evs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the aggregated events")
+	}
+}
+
+func TestInit(t *testing.T) {
+	tests := []map[string]interface{}{
+		{"value-names": []string{"("}},
+		{"functions": []string{"median"}},
+		{"functions": []string{"p101"}},
+		{"window": "10s", "slide": "1m"},
+	}
+	for _, cfg := range tests {
+		p := formatters.EventProcessors[processorType]()
+		if err := p.Init(cfg, formatters.WithEmitting()); err == nil {
+			t.Errorf("expected an error for config %v", cfg)
+		}
+	}
+}
@@ -0,0 +1,485 @@
+package event_enrich
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/types"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	processorType       = "event-enrich"
+	loggingPrefix       = "[" + processorType + "] "
+	defaultInterval     = 30 * time.Second
+	defaultTimeout      = 10 * time.Second
+	defaultOnMiss       = "pass"
+	defaultUnknownValue = "unknown"
+)
+
+// Enrich adds tags and values to the event messages, looked up in a reference table
+// read from a file or an HTTP endpoint using the values of the key tags.
+type Enrich struct {
+	File         string        `mapstructure:"file,omitempty" json:"file,omitempty"`
+	URL          string        `mapstructure:"url,omitempty" json:"url,omitempty"`
+	Format       string        `mapstructure:"format,omitempty" json:"format,omitempty"`
+	Interval     time.Duration `mapstructure:"interval,omitempty" json:"interval,omitempty"`
+	Timeout      time.Duration `mapstructure:"timeout,omitempty" json:"timeout,omitempty"`
+	KeyTags      []string      `mapstructure:"key-tags,omitempty" json:"key-tags,omitempty"`
+	KeyColumns   []string      `mapstructure:"key-columns,omitempty" json:"key-columns,omitempty"`
+	Tags         []string      `mapstructure:"tags,omitempty" json:"tags,omitempty"`
+	Values       []string      `mapstructure:"values,omitempty" json:"values,omitempty"`
+	OnMiss       string        `mapstructure:"on-miss,omitempty" json:"on-miss,omitempty"`
+	UnknownValue string        `mapstructure:"unknown-value,omitempty" json:"unknown-value,omitempty"`
+	Debug        bool          `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+
+	client *http.Client
+
+	m     sync.RWMutex
+	table *table
+	// local file modification time and size at the last load
+	modTime time.Time
+	size    int64
+
+	// closed to stop reloading the reference data
+	done      chan struct{}
+	closeOnce sync.Once
+	logger    *log.Logger
+}
+
+// table is the reference data, indexed by the key columns values
+type table struct {
+	// columns added as tags
+	tags []string
+	rows map[string]map[string]interface{}
+}
+
+func init() {
+	formatters.Register(processorType, func() formatters.EventProcessor {
+		return &Enrich{
+			logger: log.New(ioutil.Discard, "", 0),
+		}
+	})
+}
+
+func (p *Enrich) Init(cfg interface{}, opts ...formatters.Option) error {
+	err := formatters.DecodeConfig(cfg, p)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	err = p.setDefaults()
+	if err != nil {
+		return err
+	}
+	p.table = &table{
+		tags: p.Tags,
+		rows: make(map[string]map[string]interface{}),
+	}
+	err = p.load()
+	if err != nil {
+		// the file is expected to be readable when the processor is initialized,
+		// an HTTP endpoint is polled again after the interval.
+		if p.File != "" {
+			return err
+		}
+		p.logger.Printf("failed to load reference data from %q: %v", p.URL, err)
+	}
+	p.done = make(chan struct{})
+	if p.File != "" {
+		err = p.watchFile()
+		if err != nil {
+			p.logger.Printf("failed to watch %q, polling it every %s: %v", p.File, p.Interval, err)
+			go p.poll()
+		}
+	} else {
+		go p.poll()
+	}
+	if p.logger.Writer() != ioutil.Discard {
+		b, err := json.Marshal(p)
+		if err != nil {
+			p.logger.Printf("initialized processor '%s': %+v", processorType, p)
+			return nil
+		}
+		p.logger.Printf("initialized processor '%s': %s", processorType, string(b))
+	}
+	return nil
+}
+
+func (p *Enrich) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
+	p.m.RLock()
+	t := p.table
+	p.m.RUnlock()
+	result := make([]*formatters.EventMsg, 0, len(es))
+	for _, e := range es {
+		if e == nil {
+			continue
+		}
+		row, ok := t.lookup(p.key(e))
+		if !ok {
+			switch p.OnMiss {
+			case "drop":
+				if p.Debug {
+					p.logger.Printf("dropping event without reference data: %+v", e)
+				}
+				continue
+			case "tag":
+				if e.Tags == nil {
+					e.Tags = make(map[string]string)
+				}
+				for _, c := range t.tags {
+					e.Tags[c] = p.UnknownValue
+				}
+			}
+			result = append(result, e)
+			continue
+		}
+		for _, c := range t.tags {
+			v, ok := row[c]
+			if !ok {
+				continue
+			}
+			if e.Tags == nil {
+				e.Tags = make(map[string]string)
+			}
+			e.Tags[c] = stringValue(v)
+		}
+		for _, c := range p.Values {
+			v, ok := row[c]
+			if !ok {
+				continue
+			}
+			if e.Values == nil {
+				e.Values = make(map[string]interface{})
+			}
+			e.Values[c] = v
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+func (p *Enrich) WithLogger(l *log.Logger) {
+	if p.Debug && l != nil {
+		p.logger = log.New(l.Writer(), loggingPrefix, l.Flags())
+	} else if p.Debug {
+		p.logger = log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds)
+	}
+}
+
+func (p *Enrich) WithTargets(tcs map[string]*types.TargetConfig) {}
+
+// Close stops reloading the reference data
+func (p *Enrich) Close() {
+	p.closeOnce.Do(func() {
+		if p.done != nil {
+			close(p.done)
+		}
+	})
+}
+
+func (p *Enrich) setDefaults() error {
+	if p.File == "" && p.URL == "" {
+		return errors.New("one of file or url must be set")
+	}
+	if p.File != "" && p.URL != "" {
+		return errors.New("file and url are mutually exclusive")
+	}
+	if p.Format == "" {
+		switch strings.ToLower(filepath.Ext(p.File)) {
+		case ".csv":
+			p.Format = "csv"
+		case ".yaml", ".yml":
+			p.Format = "yaml"
+		default:
+			p.Format = "json"
+		}
+	}
+	switch p.Format {
+	case "csv", "json", "yaml":
+	default:
+		return fmt.Errorf("unsupported format %q, must be one of csv, json or yaml", p.Format)
+	}
+	if len(p.KeyTags) == 0 {
+		return errors.New("missing key-tags")
+	}
+	if len(p.KeyColumns) == 0 {
+		p.KeyColumns = p.KeyTags
+	}
+	if len(p.KeyColumns) != len(p.KeyTags) {
+		return fmt.Errorf("got %d key-columns for %d key-tags", len(p.KeyColumns), len(p.KeyTags))
+	}
+	if p.OnMiss == "" {
+		p.OnMiss = defaultOnMiss
+	}
+	switch p.OnMiss {
+	case "pass", "drop", "tag":
+	default:
+		return fmt.Errorf("unsupported on-miss %q, must be one of pass, drop or tag", p.OnMiss)
+	}
+	if p.UnknownValue == "" {
+		p.UnknownValue = defaultUnknownValue
+	}
+	if p.Interval <= 0 {
+		p.Interval = defaultInterval
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = defaultTimeout
+	}
+	p.client = &http.Client{Timeout: p.Timeout}
+	return nil
+}
+
+// poll reloads the reference data every interval, until the processor is closed
+func (p *Enrich) poll() {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.reload()
+		}
+	}
+}
+
+// watchFile reloads the reference file when it changes, until the processor is closed.
+// The file directory is watched, so that a file replaced by a rename is reloaded as well.
+func (p *Enrich) watchFile() error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	fn := filepath.Clean(p.File)
+	err = w.Add(filepath.Dir(fn))
+	if err != nil {
+		w.Close()
+		return err
+	}
+	go func() {
+		defer w.Close()
+		for {
+			select {
+			case <-p.done:
+				return
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(e.Name) != fn || e.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+				p.reload()
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				p.logger.Printf("file %q watch error: %v", p.File, err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *Enrich) reload() {
+	err := p.load()
+	if err != nil {
+		p.logger.Printf("failed to reload reference data: %v", err)
+	}
+}
+
+// load reads and parses the reference data, the current table is kept
+// if the local file did not change or in case of error.
+func (p *Enrich) load() error {
+	var b []byte
+	var fi os.FileInfo
+	var err error
+	if p.File != "" {
+		fi, err = os.Stat(p.File)
+		if err != nil {
+			return err
+		}
+		p.m.RLock()
+		unchanged := fi.ModTime().Equal(p.modTime) && fi.Size() == p.size
+		p.m.RUnlock()
+		if unchanged {
+			return nil
+		}
+		b, err = ioutil.ReadFile(p.File)
+	} else {
+		b, err = p.fetch()
+	}
+	if err != nil {
+		return err
+	}
+	t, err := p.parse(b)
+	if err != nil {
+		return err
+	}
+	p.m.Lock()
+	p.table = t
+	// a file that could not be parsed, e.g. read while being written,
+	// is read again on its next change.
+	if fi != nil {
+		p.modTime = fi.ModTime()
+		p.size = fi.Size()
+	}
+	p.m.Unlock()
+	p.logger.Printf("loaded %d reference row(s)", len(t.rows))
+	return nil
+}
+
+func (p *Enrich) fetch() ([]byte, error) {
+	rsp, err := p.client.Get(p.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return nil, fmt.Errorf("unexpected response status %q", rsp.Status)
+	}
+	return ioutil.ReadAll(rsp.Body)
+}
+
+// parse builds a table from a CSV file with a header row,
+// or from a JSON or YAML list of objects.
+func (p *Enrich) parse(b []byte) (*table, error) {
+	var records []map[string]interface{}
+	var columns []string
+	switch p.Format {
+	case "csv":
+		r := csv.NewReader(bytes.NewReader(b))
+		r.TrimLeadingSpace = true
+		header, err := r.Read()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV header: %v", err)
+		}
+		columns = header
+		for {
+			line, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			rec := make(map[string]interface{}, len(header))
+			for i, c := range header {
+				rec[c] = line[i]
+			}
+			records = append(records, rec)
+		}
+	case "json":
+		err := json.Unmarshal(b, &records)
+		if err != nil {
+			return nil, err
+		}
+	case "yaml":
+		err := yaml.Unmarshal(b, &records)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if columns == nil {
+		seen := make(map[string]struct{})
+		for _, rec := range records {
+			for c := range rec {
+				if _, ok := seen[c]; !ok {
+					seen[c] = struct{}{}
+					columns = append(columns, c)
+				}
+			}
+		}
+	}
+	t := &table{
+		tags: p.tagColumns(columns),
+		rows: make(map[string]map[string]interface{}, len(records)),
+	}
+	for i, rec := range records {
+		vs := make([]string, 0, len(p.KeyColumns))
+		for _, c := range p.KeyColumns {
+			v, ok := rec[c]
+			if !ok {
+				return nil, fmt.Errorf("row %d is missing key column %q", i+1, c)
+			}
+			vs = append(vs, stringValue(v))
+		}
+		key := strings.Join(vs, "\x00")
+		if _, ok := t.rows[key]; ok && p.Debug {
+			p.logger.Printf("row %d overrides a previous row with the same key %v", i+1, vs)
+		}
+		t.rows[key] = rec
+	}
+	return t, nil
+}
+
+// key returns the lookup key built from the event key tags values,
+// false is returned if one of them is missing.
+func (p *Enrich) key(e *formatters.EventMsg) (string, bool) {
+	vs := make([]string, 0, len(p.KeyTags))
+	for _, t := range p.KeyTags {
+		v, ok := e.Tags[t]
+		if !ok {
+			return "", false
+		}
+		vs = append(vs, v)
+	}
+	return strings.Join(vs, "\x00"), true
+}
+
+// tagColumns returns the columns added as tags,
+// all the non key columns if neither tags nor values are configured.
+func (p *Enrich) tagColumns(columns []string) []string {
+	if len(p.Tags) > 0 || len(p.Values) > 0 {
+		return p.Tags
+	}
+	cols := make([]string, 0, len(columns))
+OUTER:
+	for _, c := range columns {
+		for _, kc := range p.KeyColumns {
+			if c == kc {
+				continue OUTER
+			}
+		}
+		cols = append(cols, c)
+	}
+	return cols
+}
+
+// stringValue formats a column value as a tag value,
+// the numbers decoded from JSON or YAML are formatted without an exponent, e.g 12000000 and not 1.2e+07.
+func stringValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	}
+	return fmt.Sprint(v)
+}
+
+func (t *table) lookup(key string, ok bool) (map[string]interface{}, bool) {
+	if !ok {
+		return nil, false
+	}
+	row, ok := t.rows[key]
+	return row, ok
+}
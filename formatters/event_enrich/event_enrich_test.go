@@ -0,0 +1,281 @@
+package event_enrich
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/karimra/gnmic/formatters"
+)
+
+const csvData = `router,interface,circuit_id,customer,speed
+r1,ethernet-1/1,CID-001,ACME,10
+r1,ethernet-1/2,CID-002,Globex,100
+`
+
+const jsonData = `[
+  {"router": "r1", "interface": "ethernet-1/1", "circuit_id": "CID-001", "customer": "ACME", "speed": 10}
+]`
+
+const yamlData = `
+- router: r1
+  interface: ethernet-1/1
+  circuit_id: CID-001
+  customer: ACME
+  speed: 10
+`
+
+func writeFile(t *testing.T, dir, name, data string) string {
+	fn := filepath.Join(dir, name)
+	err := ioutil.WriteFile(fn, []byte(data), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func event(router, itf string) *formatters.EventMsg {
+	return &formatters.EventMsg{
+		Name:   "sub1",
+		Tags:   map[string]string{"source": router, "interface_name": itf},
+		Values: map[string]interface{}{"in-octets": 42},
+	}
+}
+
+func TestEventEnrich(t *testing.T) {
+	dir, err := ioutil.TempDir("", "event_enrich")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	csvFile := writeFile(t, dir, "circuits.csv", csvData)
+	tests := []struct {
+		name   string
+		cfg    map[string]interface{}
+		input  []*formatters.EventMsg
+		output []*formatters.EventMsg
+	}{
+		{
+			name: "csv_all_columns_as_tags",
+			cfg: map[string]interface{}{
+				"file":        csvFile,
+				"key-tags":    []string{"source", "interface_name"},
+				"key-columns": []string{"router", "interface"},
+			},
+			input: []*formatters.EventMsg{event("r1", "ethernet-1/2"), event("r2", "ethernet-1/1")},
+			output: []*formatters.EventMsg{
+				{
+					Name: "sub1",
+					Tags: map[string]string{
+						"source": "r1", "interface_name": "ethernet-1/2",
+						"circuit_id": "CID-002", "customer": "Globex", "speed": "100",
+					},
+					Values: map[string]interface{}{"in-octets": 42},
+				},
+				event("r2", "ethernet-1/1"),
+			},
+		},
+		{
+			name: "json_tags_and_values",
+			cfg: map[string]interface{}{
+				"file":        writeFile(t, dir, "circuits.json", jsonData),
+				"key-tags":    []string{"source", "interface_name"},
+				"key-columns": []string{"router", "interface"},
+				"tags":        []string{"circuit_id"},
+				"values":      []string{"speed"},
+			},
+			input: []*formatters.EventMsg{event("r1", "ethernet-1/1")},
+			output: []*formatters.EventMsg{
+				{
+					Name:   "sub1",
+					Tags:   map[string]string{"source": "r1", "interface_name": "ethernet-1/1", "circuit_id": "CID-001"},
+					Values: map[string]interface{}{"in-octets": 42, "speed": float64(10)},
+				},
+			},
+		},
+		{
+			name: "yaml_miss_tag",
+			cfg: map[string]interface{}{
+				"file":        writeFile(t, dir, "circuits.yaml", yamlData),
+				"key-tags":    []string{"source", "interface_name"},
+				"key-columns": []string{"router", "interface"},
+				"tags":        []string{"customer"},
+				"on-miss":     "tag",
+			},
+			input: []*formatters.EventMsg{event("r1", "ethernet-1/1"), event("r1", "ethernet-1/9")},
+			output: []*formatters.EventMsg{
+				{
+					Name:   "sub1",
+					Tags:   map[string]string{"source": "r1", "interface_name": "ethernet-1/1", "customer": "ACME"},
+					Values: map[string]interface{}{"in-octets": 42},
+				},
+				{
+					Name:   "sub1",
+					Tags:   map[string]string{"source": "r1", "interface_name": "ethernet-1/9", "customer": "unknown"},
+					Values: map[string]interface{}{"in-octets": 42},
+				},
+			},
+		},
+		{
+			name: "miss_drop",
+			cfg: map[string]interface{}{
+				"file":        csvFile,
+				"key-tags":    []string{"source", "interface_name"},
+				"key-columns": []string{"router", "interface"},
+				"tags":        []string{"customer"},
+				"on-miss":     "drop",
+			},
+			input: []*formatters.EventMsg{
+				event("r3", "ethernet-1/1"),
+				// missing key tag
+				{Name: "sub1", Tags: map[string]string{"source": "r1"}},
+			},
+			output: []*formatters.EventMsg{},
+		},
+		{
+			name: "json_integral_number_key",
+			cfg: map[string]interface{}{
+				"file":     writeFile(t, dir, "ifindexes.json", `[{"ifindex": 12000000, "speed": 25000000000}]`),
+				"key-tags": []string{"ifindex"},
+			},
+			input: []*formatters.EventMsg{{Name: "sub1", Tags: map[string]string{"ifindex": "12000000"}}},
+			output: []*formatters.EventMsg{
+				{Name: "sub1", Tags: map[string]string{"ifindex": "12000000", "speed": "25000000000"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := formatters.EventProcessors[processorType]()
+			err := p.Init(tt.cfg)
+			if err != nil {
+				t.Fatalf("failed to initialize processor: %v", err)
+			}
+			defer p.(formatters.Closer).Close()
+			outs := p.Apply(tt.input...)
+			if !cmp.Equal(outs, tt.output) {
+				t.Errorf("unexpected output: %s", cmp.Diff(tt.output, outs))
+			}
+		})
+	}
+}
+
+func TestFileReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "event_enrich")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := writeFile(t, dir, "sites.csv", "source,site\nr1,PAR1\n")
+	p := formatters.EventProcessors[processorType]().(*Enrich)
+	err = p.Init(map[string]interface{}{
+		"file":     fn,
+		"key-tags": []string{"source"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	// the watched file is reloaded without processing events
+	writeFile(t, dir, "sites.csv", "source,site\nr1,LON10\n")
+	var out []*formatters.EventMsg
+	for i := 0; i < 100; i++ {
+		p.m.RLock()
+		row, _ := p.table.lookup("r1", true)
+		p.m.RUnlock()
+		if row["site"] == "LON10" {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	out = p.Apply(&formatters.EventMsg{Tags: map[string]string{"source": "r1"}})
+	if out[0].Tags["site"] != "LON10" {
+		t.Fatalf("got site %q after reload, want %q", out[0].Tags["site"], "LON10")
+	}
+	// an invalid file keeps the current data
+	writeFile(t, dir, "sites.csv", "source,site\nr1\n")
+	if err = p.load(); err == nil {
+		t.Errorf("expected an error loading an invalid file")
+	}
+	out = p.Apply(&formatters.EventMsg{Tags: map[string]string{"source": "r1"}})
+	if out[0].Tags["site"] != "LON10" {
+		t.Errorf("got site %q after a failed reload, want %q", out[0].Tags["site"], "LON10")
+	}
+}
+
+func TestHTTPReload(t *testing.T) {
+	var m sync.Mutex
+	site := "PAR1"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+		w.Write([]byte(`[{"source": "r1", "site": "` + site + `"}]`))
+	}))
+	defer srv.Close()
+	p := formatters.EventProcessors[processorType]().(*Enrich)
+	err := p.Init(map[string]interface{}{
+		"url":      srv.URL,
+		"key-tags": []string{"source"},
+		"interval": "10ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	out := p.Apply(&formatters.EventMsg{Tags: map[string]string{"source": "r1"}})
+	if out[0].Tags["site"] != "PAR1" {
+		t.Fatalf("got site %q, want %q", out[0].Tags["site"], "PAR1")
+	}
+	m.Lock()
+	site = "LON10"
+	m.Unlock()
+	for i := 0; i < 100; i++ {
+		time.Sleep(20 * time.Millisecond)
+		p.m.RLock()
+		row, _ := p.table.lookup("r1", true)
+		p.m.RUnlock()
+		if row["site"] == "LON10" {
+			break
+		}
+	}
+	out = p.Apply(&formatters.EventMsg{Tags: map[string]string{"source": "r1"}})
+	if out[0].Tags["site"] != "LON10" {
+		t.Fatalf("reference data not reloaded from the HTTP endpoint")
+	}
+	// the endpoint is not polled once the processor is closed
+	p.Close()
+	// wait for an ongoing reload to be done
+	time.Sleep(50 * time.Millisecond)
+	m.Lock()
+	site = "PAR1"
+	m.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	out = p.Apply(&formatters.EventMsg{Tags: map[string]string{"source": "r1"}})
+	if out[0].Tags["site"] != "LON10" {
+		t.Errorf("reference data reloaded after the processor is closed")
+	}
+}
+
+func TestInit(t *testing.T) {
+	tests := []map[string]interface{}{
+		{"key-tags": []string{"source"}},
+		{"file": "sites.csv", "url": "http://localhost", "key-tags": []string{"source"}},
+		{"url": "http://localhost", "format": "xml", "key-tags": []string{"source"}},
+		{"url": "http://localhost"},
+		{"url": "http://localhost", "key-tags": []string{"source"}, "key-columns": []string{"a", "b"}},
+		{"url": "http://localhost", "key-tags": []string{"source"}, "on-miss": "fail"},
+		{"file": "/does/not/exist.csv", "key-tags": []string{"source"}},
+	}
+	for _, cfg := range tests {
+		p := formatters.EventProcessors[processorType]()
+		if err := p.Init(cfg); err == nil {
+			t.Errorf("expected an error for config %v", cfg)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package event_rate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/types"
+)
+
+const (
+	processorType     = "event-rate"
+	loggingPrefix     = "[" + processorType + "] "
+	defaultMode       = "replace"
+	defaultSuffix     = "_rate"
+	defaultBits       = 64
+	defaultExpiration = 10 * time.Minute
+)
+
+// Rate replaces or augments the counter values with key matching one of regexes,
+// with their per-second rate of change, computed from the previous value of the same series.
+type Rate struct {
+	Values      []string      `mapstructure:"value-names,omitempty" json:"value-names,omitempty"`
+	Mode        string        `mapstructure:"mode,omitempty" json:"mode,omitempty"`
+	Suffix      string        `mapstructure:"suffix,omitempty" json:"suffix,omitempty"`
+	CounterBits int           `mapstructure:"counter-bits,omitempty" json:"counter-bits,omitempty"`
+	Expiration  time.Duration `mapstructure:"expiration,omitempty" json:"expiration,omitempty"`
+	Debug       bool          `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+
+	values []*regexp.Regexp
+	// counters maximum value, used to compute the delta after a wrap
+	max uint64
+
+	m           sync.Mutex
+	series      map[string]*sample
+	lastCleanup time.Time
+	logger      *log.Logger
+}
+
+// sample is the last value seen for a series,
+// integer counters are kept as uint64 to avoid losing precision.
+type sample struct {
+	ts      int64
+	isFloat bool
+	u       uint64
+	f       float64
+	seen    time.Time
+}
+
+func init() {
+	formatters.Register(processorType, func() formatters.EventProcessor {
+		return &Rate{
+			logger: log.New(ioutil.Discard, "", 0),
+		}
+	})
+}
+
+func (r *Rate) Init(cfg interface{}, opts ...formatters.Option) error {
+	err := formatters.DecodeConfig(cfg, r)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.values = make([]*regexp.Regexp, 0, len(r.Values))
+	for _, reg := range r.Values {
+		re, err := regexp.Compile(reg)
+		if err != nil {
+			return err
+		}
+		r.values = append(r.values, re)
+	}
+	if r.Mode == "" {
+		r.Mode = defaultMode
+	}
+	if r.Mode != "replace" && r.Mode != "add" {
+		return fmt.Errorf("unsupported mode %q, must be 'replace' or 'add'", r.Mode)
+	}
+	if r.Suffix == "" {
+		r.Suffix = defaultSuffix
+	}
+	if r.CounterBits == 0 {
+		r.CounterBits = defaultBits
+	}
+	switch r.CounterBits {
+	case 32:
+		r.max = math.MaxUint32
+	case 64:
+		r.max = math.MaxUint64
+	default:
+		return fmt.Errorf("unsupported counter-bits %d, must be 32 or 64", r.CounterBits)
+	}
+	if r.Expiration <= 0 {
+		r.Expiration = defaultExpiration
+	}
+	r.series = make(map[string]*sample)
+	if r.logger.Writer() != ioutil.Discard {
+		b, err := json.Marshal(r)
+		if err != nil {
+			r.logger.Printf("initialized processor '%s': %+v", processorType, r)
+			return nil
+		}
+		r.logger.Printf("initialized processor '%s': %s", processorType, string(b))
+	}
+	return nil
+}
+
+func (r *Rate) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
+	r.m.Lock()
+	defer r.m.Unlock()
+	now := time.Now()
+	if now.Sub(r.lastCleanup) >= r.Expiration {
+		r.expire(now)
+		r.lastCleanup = now
+	}
+	result := make([]*formatters.EventMsg, 0, len(es))
+	for _, e := range es {
+		if e == nil {
+			continue
+		}
+		// collect the matching names first, the added rate values must not be matched
+		names := make([]string, 0, len(e.Values))
+		for k := range e.Values {
+			if r.match(k) {
+				names = append(names, k)
+			}
+		}
+		for _, k := range names {
+			rate, ok := r.rate(seriesKey(e, k), e.Timestamp, e.Values[k], now)
+			if r.Mode == "add" {
+				if ok {
+					e.Values[k+r.Suffix] = rate
+				}
+				continue
+			}
+			if ok {
+				e.Values[k] = rate
+				continue
+			}
+			delete(e.Values, k)
+		}
+		// drop the events left without values after removing
+		// the counters a rate could not be computed for.
+		if len(names) > 0 && len(e.Values) == 0 && len(e.Deletes) == 0 {
+			continue
+		}
+		result = append(result, e)
+	}
+	return result
+}
+
+func (r *Rate) WithLogger(l *log.Logger) {
+	if r.Debug && l != nil {
+		r.logger = log.New(l.Writer(), loggingPrefix, l.Flags())
+	} else if r.Debug {
+		r.logger = log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds)
+	}
+}
+
+func (r *Rate) WithTargets(tcs map[string]*types.TargetConfig) {}
+
+func (r *Rate) match(k string) bool {
+	for _, re := range r.values {
+		if re.MatchString(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// rate stores the value as the last sample of the series and returns
+// the per-second rate since the previous sample.
+// No rate is returned for the first sample of a series, after a counter reset
+// or if the timestamp did not move forward.
+func (r *Rate) rate(key string, ts int64, v interface{}, now time.Time) (float64, bool) {
+	cur, err := newSample(ts, v)
+	if err != nil {
+		r.logger.Printf("series %q: %v", key, err)
+		return 0, false
+	}
+	cur.seen = now
+	prev, ok := r.series[key]
+	if ok && cur.ts <= prev.ts {
+		r.logger.Printf("series %q: ignoring sample with timestamp %d, last sample timestamp is %d", key, cur.ts, prev.ts)
+		return 0, false
+	}
+	r.series[key] = cur
+	if !ok || now.Sub(prev.seen) > r.Expiration {
+		return 0, false
+	}
+	dt := float64(cur.ts-prev.ts) / float64(time.Second)
+	if cur.isFloat || prev.isFloat {
+		pf, cf := prev.float(), cur.float()
+		if cf < pf {
+			r.logger.Printf("series %q: counter reset from %v to %v", key, pf, cf)
+			return 0, false
+		}
+		return (cf - pf) / dt, true
+	}
+	if cur.u >= prev.u {
+		return float64(cur.u-prev.u) / dt, true
+	}
+	// a decrease is a wrap if the previous value was in the upper half
+	// of the counter range and the new one is in the lower half.
+	if prev.u <= r.max && prev.u > r.max/2 && cur.u <= r.max/2 {
+		delta := r.max - prev.u + cur.u + 1
+		r.logger.Printf("series %q: counter wrap from %d to %d", key, prev.u, cur.u)
+		return float64(delta) / dt, true
+	}
+	r.logger.Printf("series %q: counter reset from %d to %d", key, prev.u, cur.u)
+	return 0, false
+}
+
+// expire deletes the series not updated since the expiration time
+func (r *Rate) expire(now time.Time) {
+	for k, s := range r.series {
+		if now.Sub(s.seen) > r.Expiration {
+			delete(r.series, k)
+		}
+	}
+}
+
+func (s *sample) float() float64 {
+	if s.isFloat {
+		return s.f
+	}
+	return float64(s.u)
+}
+
+func newSample(ts int64, v interface{}) (*sample, error) {
+	s := &sample{ts: ts}
+	switch v := v.(type) {
+	case uint:
+		s.u = uint64(v)
+	case uint8:
+		s.u = uint64(v)
+	case uint16:
+		s.u = uint64(v)
+	case uint32:
+		s.u = uint64(v)
+	case uint64:
+		s.u = v
+	case int:
+		return intSample(s, int64(v))
+	case int8:
+		return intSample(s, int64(v))
+	case int16:
+		return intSample(s, int64(v))
+	case int32:
+		return intSample(s, int64(v))
+	case int64:
+		return intSample(s, v)
+	case float32:
+		s.isFloat = true
+		s.f = float64(v)
+	case float64:
+		s.isFloat = true
+		s.f = v
+	case string:
+		if u, err := strconv.ParseUint(v, 10, 64); err == nil {
+			s.u = u
+			return s, nil
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot compute the rate of value %q", v)
+		}
+		s.isFloat = true
+		s.f = f
+	default:
+		return nil, fmt.Errorf("cannot compute the rate of value %v, type %T", v, v)
+	}
+	return s, nil
+}
+
+func intSample(s *sample, i int64) (*sample, error) {
+	if i < 0 {
+		s.isFloat = true
+		s.f = float64(i)
+		return s, nil
+	}
+	s.u = uint64(i)
+	return s, nil
+}
+
+// seriesKey identifies a series by the event name, its tags and the value name
+func seriesKey(e *formatters.EventMsg, valueName string) string {
+	tags := make([]string, 0, len(e.Tags))
+	for k, v := range e.Tags {
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+	sb := strings.Builder{}
+	sb.WriteString(e.Name)
+	sb.WriteString("\x00")
+	sb.WriteString(strings.Join(tags, "\x00"))
+	sb.WriteString("\x00")
+	sb.WriteString(valueName)
+	return sb.String()
+}
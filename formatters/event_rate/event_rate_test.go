@@ -0,0 +1,250 @@
+package event_rate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/karimra/gnmic/formatters"
+)
+
+type item struct {
+	input  []*formatters.EventMsg
+	output []*formatters.EventMsg
+}
+
+const sec = int64(time.Second)
+
+var testset = map[string]struct {
+	processorType string
+	processor     map[string]interface{}
+	tests         []item
+}{
+	"replace": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"value-names": []string{".*octets$"},
+		},
+		tests: []item{
+			{
+				input:  nil,
+				output: make([]*formatters.EventMsg, 0),
+			},
+			// first sample, the counter is removed and the event dropped
+			{
+				input: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 10 * sec,
+						Tags:      map[string]string{"interface_name": "ethernet-1/1"},
+						Values:    map[string]interface{}{"in-octets": uint64(1000)},
+					},
+				},
+				output: make([]*formatters.EventMsg, 0),
+			},
+			// other values are kept
+			{
+				input: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 20 * sec,
+						Tags:      map[string]string{"interface_name": "ethernet-1/1"},
+						Values:    map[string]interface{}{"in-octets": uint64(3000), "oper-state": "up"},
+					},
+				},
+				output: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 20 * sec,
+						Tags:      map[string]string{"interface_name": "ethernet-1/1"},
+						Values:    map[string]interface{}{"in-octets": float64(200), "oper-state": "up"},
+					},
+				},
+			},
+			// a different tag set is a different series
+			{
+				input: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 30 * sec,
+						Tags:      map[string]string{"interface_name": "ethernet-1/2"},
+						Values:    map[string]interface{}{"in-octets": "5000"},
+					},
+					{
+						Name:      "sub1",
+						Timestamp: 30 * sec,
+						Tags:      map[string]string{"interface_name": "ethernet-1/1"},
+						Values:    map[string]interface{}{"in-octets": "4000"},
+					},
+				},
+				output: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 30 * sec,
+						Tags:      map[string]string{"interface_name": "ethernet-1/1"},
+						Values:    map[string]interface{}{"in-octets": float64(100)},
+					},
+				},
+			},
+			// counter reset
+			{
+				input: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 40 * sec,
+						Tags:      map[string]string{"interface_name": "ethernet-1/1"},
+						Values:    map[string]interface{}{"in-octets": 10},
+					},
+				},
+				output: make([]*formatters.EventMsg, 0),
+			},
+			// rate computed from the value after the reset
+			{
+				input: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 45 * sec,
+						Tags:      map[string]string{"interface_name": "ethernet-1/1"},
+						Values:    map[string]interface{}{"in-octets": 60},
+					},
+				},
+				output: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 45 * sec,
+						Tags:      map[string]string{"interface_name": "ethernet-1/1"},
+						Values:    map[string]interface{}{"in-octets": float64(10)},
+					},
+				},
+			},
+		},
+	},
+	"add_32bits": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"value-names":  []string{".*octets"},
+			"mode":         "add",
+			"counter-bits": 32,
+		},
+		tests: []item{
+			{
+				input: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 10 * sec,
+						Values:    map[string]interface{}{"in-octets": uint32(4294967000)},
+					},
+				},
+				output: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 10 * sec,
+						Values:    map[string]interface{}{"in-octets": uint32(4294967000)},
+					},
+				},
+			},
+			// counter wrap
+			{
+				input: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 12 * sec,
+						Values:    map[string]interface{}{"in-octets": uint32(704)},
+					},
+				},
+				output: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 12 * sec,
+						Values:    map[string]interface{}{"in-octets": uint32(704), "in-octets_rate": float64(500)},
+					},
+				},
+			},
+			// same timestamp
+			{
+				input: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 12 * sec,
+						Values:    map[string]interface{}{"in-octets": uint32(800)},
+					},
+				},
+				output: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 12 * sec,
+						Values:    map[string]interface{}{"in-octets": uint32(800)},
+					},
+				},
+			},
+		},
+	},
+}
+
+func TestEventRate(t *testing.T) {
+	for name, ts := range testset {
+		if pi, ok := formatters.EventProcessors[ts.processorType]; ok {
+			t.Log("found processor")
+			p := pi()
+			err := p.Init(ts.processor)
+			if err != nil {
+				t.Errorf("failed to initialize processors: %v", err)
+				return
+			}
+			t.Logf("processor: %+v", p)
+			for i, item := range ts.tests {
+				t.Run(name, func(t *testing.T) {
+					t.Logf("running test item %d", i)
+					outs := p.Apply(item.input...)
+					if len(outs) != len(item.output) {
+						t.Fatalf("failed at %s item %d, expected %d events, got %d: %v", name, i, len(item.output), len(outs), outs)
+					}
+					for j := range outs {
+						if !cmp.Equal(outs[j], item.output[j]) {
+							t.Logf("failed at %s item %d, index %d", name, i, j)
+							t.Logf("expected: %#v", item.output[j])
+							t.Logf("     got: %#v", outs[j])
+							t.Fail()
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestEventRateExpiration(t *testing.T) {
+	r := formatters.EventProcessors[processorType]().(*Rate)
+	err := r.Init(map[string]interface{}{
+		"value-names": []string{"octets"},
+		"expiration":  "1m",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if _, ok := r.rate("s1", 10*sec, 100, now.Add(-2*time.Minute)); ok {
+		t.Fatalf("got a rate for the first sample")
+	}
+	if _, ok := r.rate("s1", 20*sec, 200, now); ok {
+		t.Errorf("got a rate from an expired sample")
+	}
+	r.expire(now.Add(2 * time.Minute))
+	if len(r.series) != 0 {
+		t.Errorf("got %d series after expiration, want 0", len(r.series))
+	}
+}
+
+func TestInit(t *testing.T) {
+	tests := []map[string]interface{}{
+		{"value-names": []string{"("}},
+		{"mode": "derivative"},
+		{"counter-bits": 16},
+	}
+	for _, cfg := range tests {
+		p := formatters.EventProcessors[processorType]()
+		if err := p.Init(cfg); err == nil {
+			t.Errorf("expected an error for config %v", cfg)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package event_starlark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/types"
+	starjson "go.starlark.net/lib/json"
+	starmath "go.starlark.net/lib/math"
+	startime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+)
+
+const (
+	processorType   = "event-starlark"
+	loggingPrefix   = "[" + processorType + "] "
+	entrypoint      = "apply"
+	defaultMaxSteps = 1000000
+)
+
+// Starlark runs a Starlark script on the event messages,
+// the script apply(events) function returns the resulting events.
+type Starlark struct {
+	Script   string `mapstructure:"script,omitempty" json:"script,omitempty"`
+	File     string `mapstructure:"file,omitempty" json:"file,omitempty"`
+	MaxSteps uint64 `mapstructure:"max-steps,omitempty" json:"max-steps,omitempty"`
+	Debug    bool   `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+
+	m     sync.Mutex
+	apply starlark.Value
+	// state is kept between the calls to apply
+	state  *starlark.Dict
+	logger *log.Logger
+}
+
+func init() {
+	formatters.Register(processorType, func() formatters.EventProcessor {
+		return &Starlark{
+			logger: log.New(ioutil.Discard, "", 0),
+		}
+	})
+}
+
+func (p *Starlark) Init(cfg interface{}, opts ...formatters.Option) error {
+	err := formatters.DecodeConfig(cfg, p)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.Script == "" && p.File == "" {
+		return errors.New("one of script or file must be set")
+	}
+	if p.Script != "" && p.File != "" {
+		return errors.New("script and file are mutually exclusive")
+	}
+	if p.MaxSteps == 0 {
+		p.MaxSteps = defaultMaxSteps
+	}
+	filename := p.File
+	var src interface{}
+	if p.Script != "" {
+		filename = processorType
+		src = p.Script
+	}
+	p.state = starlark.NewDict(0)
+	predeclared := starlark.StringDict{
+		"state": p.state,
+		"json":  starjson.Module,
+		"math":  starmath.Module,
+		"time":  startime.Module,
+	}
+	globals, err := starlark.ExecFile(p.newThread(), filename, src, predeclared)
+	if err != nil {
+		return fmt.Errorf("failed to load script: %v", scriptError(err))
+	}
+	fn, ok := globals[entrypoint]
+	if !ok {
+		return fmt.Errorf("script does not define an %q function", entrypoint)
+	}
+	if _, ok := fn.(starlark.Callable); !ok {
+		return fmt.Errorf("%q is not a function, got %s", entrypoint, fn.Type())
+	}
+	p.apply = fn
+	if p.logger.Writer() != ioutil.Discard {
+		b, err := json.Marshal(p)
+		if err != nil {
+			p.logger.Printf("initialized processor '%s': %+v", processorType, p)
+			return nil
+		}
+		p.logger.Printf("initialized processor '%s': %s", processorType, string(b))
+	}
+	return nil
+}
+
+func (p *Starlark) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
+	p.m.Lock()
+	defer p.m.Unlock()
+	evs := make([]starlark.Value, 0, len(es))
+	for _, e := range es {
+		if e == nil {
+			continue
+		}
+		evs = append(evs, toStarlarkEvent(e))
+	}
+	res, err := starlark.Call(p.newThread(), p.apply, starlark.Tuple{starlark.NewList(evs)}, nil)
+	if err != nil {
+		p.logger.Printf("failed to run %q: %v", entrypoint, scriptError(err))
+		return es
+	}
+	result, err := fromStarlarkEvents(res)
+	if err != nil {
+		p.logger.Printf("unexpected %q result: %v", entrypoint, err)
+		return es
+	}
+	return result
+}
+
+func (p *Starlark) WithLogger(l *log.Logger) {
+	if p.Debug && l != nil {
+		p.logger = log.New(l.Writer(), loggingPrefix, l.Flags())
+	} else if p.Debug {
+		p.logger = log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds)
+	}
+}
+
+func (p *Starlark) WithTargets(tcs map[string]*types.TargetConfig) {}
+
+// newThread returns a thread limited to MaxSteps execution steps,
+// loading other modules is not allowed.
+func (p *Starlark) newThread() *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: processorType,
+		Print: func(_ *starlark.Thread, msg string) {
+			p.logger.Print(msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(p.MaxSteps)
+	return thread
+}
+
+// scriptError adds the backtrace to the script evaluation errors
+func scriptError(err error) error {
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		return errors.New(evalErr.Backtrace())
+	}
+	return err
+}
+
+func toStarlarkEvent(e *formatters.EventMsg) *starlark.Dict {
+	tags := starlark.NewDict(len(e.Tags))
+	for k, v := range e.Tags {
+		tags.SetKey(starlark.String(k), starlark.String(v))
+	}
+	values := starlark.NewDict(len(e.Values))
+	for k, v := range e.Values {
+		values.SetKey(starlark.String(k), toStarlarkValue(v))
+	}
+	deletes := make([]starlark.Value, 0, len(e.Deletes))
+	for _, d := range e.Deletes {
+		deletes = append(deletes, starlark.String(d))
+	}
+	ev := starlark.NewDict(5)
+	ev.SetKey(starlark.String("name"), starlark.String(e.Name))
+	ev.SetKey(starlark.String("timestamp"), starlark.MakeInt64(e.Timestamp))
+	ev.SetKey(starlark.String("tags"), tags)
+	ev.SetKey(starlark.String("values"), values)
+	ev.SetKey(starlark.String("deletes"), starlark.NewList(deletes))
+	return ev
+}
+
+func toStarlarkValue(v interface{}) starlark.Value {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None
+	case string:
+		return starlark.String(v)
+	case bool:
+		return starlark.Bool(v)
+	case int:
+		return starlark.MakeInt(v)
+	case int8:
+		return starlark.MakeInt64(int64(v))
+	case int16:
+		return starlark.MakeInt64(int64(v))
+	case int32:
+		return starlark.MakeInt64(int64(v))
+	case int64:
+		return starlark.MakeInt64(v)
+	case uint:
+		return starlark.MakeUint(v)
+	case uint8:
+		return starlark.MakeUint64(uint64(v))
+	case uint16:
+		return starlark.MakeUint64(uint64(v))
+	case uint32:
+		return starlark.MakeUint64(uint64(v))
+	case uint64:
+		return starlark.MakeUint64(v)
+	case float32:
+		return starlark.Float(v)
+	case float64:
+		return starlark.Float(v)
+	case []interface{}:
+		l := make([]starlark.Value, 0, len(v))
+		for _, item := range v {
+			l = append(l, toStarlarkValue(item))
+		}
+		return starlark.NewList(l)
+	case map[string]interface{}:
+		d := starlark.NewDict(len(v))
+		for k, item := range v {
+			d.SetKey(starlark.String(k), toStarlarkValue(item))
+		}
+		return d
+	default:
+		return starlark.String(fmt.Sprint(v))
+	}
+}
+
+// fromStarlarkEvents converts the value returned by apply,
+// a list of events or None, to event messages.
+func fromStarlarkEvents(v starlark.Value) ([]*formatters.EventMsg, error) {
+	if v == starlark.None {
+		return []*formatters.EventMsg{}, nil
+	}
+	iterable, ok := v.(starlark.Indexable)
+	if !ok {
+		return nil, fmt.Errorf("expected a list of events, got %s", v.Type())
+	}
+	result := make([]*formatters.EventMsg, 0, iterable.Len())
+	for i := 0; i < iterable.Len(); i++ {
+		e, err := fromStarlarkEvent(iterable.Index(i))
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %v", i, err)
+		}
+		result = append(result, e)
+	}
+	return result, nil
+}
+
+func fromStarlarkEvent(v starlark.Value) (*formatters.EventMsg, error) {
+	d, ok := v.(*starlark.Dict)
+	if !ok {
+		return nil, fmt.Errorf("expected a dict, got %s", v.Type())
+	}
+	e := new(formatters.EventMsg)
+	for _, item := range d.Items() {
+		k, ok := starlark.AsString(item[0])
+		if !ok {
+			return nil, fmt.Errorf("unexpected key %s", item[0])
+		}
+		switch k {
+		case "name":
+			e.Name, ok = starlark.AsString(item[1])
+			if !ok {
+				return nil, fmt.Errorf("name must be a string, got %s", item[1].Type())
+			}
+		case "timestamp":
+			ts, ok := item[1].(starlark.Int)
+			if !ok {
+				return nil, fmt.Errorf("timestamp must be an int, got %s", item[1].Type())
+			}
+			e.Timestamp, ok = ts.Int64()
+			if !ok {
+				return nil, fmt.Errorf("timestamp %s out of range", ts)
+			}
+		case "tags":
+			tags, ok := item[1].(*starlark.Dict)
+			if !ok {
+				return nil, fmt.Errorf("tags must be a dict, got %s", item[1].Type())
+			}
+			e.Tags = make(map[string]string, tags.Len())
+			for _, tag := range tags.Items() {
+				tk, ok := starlark.AsString(tag[0])
+				if !ok {
+					return nil, fmt.Errorf("unexpected tag name %s", tag[0])
+				}
+				tv, ok := starlark.AsString(tag[1])
+				if !ok {
+					tv = tag[1].String()
+				}
+				e.Tags[tk] = tv
+			}
+		case "values":
+			values, ok := item[1].(*starlark.Dict)
+			if !ok {
+				return nil, fmt.Errorf("values must be a dict, got %s", item[1].Type())
+			}
+			e.Values = make(map[string]interface{}, values.Len())
+			for _, val := range values.Items() {
+				vk, ok := starlark.AsString(val[0])
+				if !ok {
+					return nil, fmt.Errorf("unexpected value name %s", val[0])
+				}
+				e.Values[vk] = fromStarlarkValue(val[1])
+			}
+		case "deletes":
+			deletes, ok := item[1].(starlark.Indexable)
+			if !ok {
+				return nil, fmt.Errorf("deletes must be a list, got %s", item[1].Type())
+			}
+			if deletes.Len() == 0 {
+				continue
+			}
+			e.Deletes = make([]string, 0, deletes.Len())
+			for i := 0; i < deletes.Len(); i++ {
+				del, ok := starlark.AsString(deletes.Index(i))
+				if !ok {
+					return nil, fmt.Errorf("deletes must be strings, got %s", deletes.Index(i).Type())
+				}
+				e.Deletes = append(e.Deletes, del)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected event field %q", k)
+		}
+	}
+	if len(e.Tags) == 0 {
+		e.Tags = nil
+	}
+	if len(e.Values) == 0 {
+		e.Values = nil
+	}
+	return e, nil
+}
+
+// fromStarlarkValue converts a Starlark value to a Go value,
+// integers are converted to int64, or uint64 if they do not fit.
+func fromStarlarkValue(v starlark.Value) interface{} {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil
+	case starlark.String:
+		return string(v)
+	case starlark.Bool:
+		return bool(v)
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i
+		}
+		if u, ok := v.Uint64(); ok {
+			return u
+		}
+		f := float64(v.Float())
+		if math.IsInf(f, 0) {
+			return v.String()
+		}
+		return f
+	case starlark.Float:
+		return float64(v)
+	case *starlark.Dict:
+		m := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			k, ok := starlark.AsString(item[0])
+			if !ok {
+				k = item[0].String()
+			}
+			m[k] = fromStarlarkValue(item[1])
+		}
+		return m
+	case starlark.Indexable:
+		l := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			l = append(l, fromStarlarkValue(v.Index(i)))
+		}
+		return l
+	default:
+		return v.String()
+	}
+}
@@ -0,0 +1,213 @@
+package event_starlark
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/karimra/gnmic/formatters"
+)
+
+type item struct {
+	input  []*formatters.EventMsg
+	output []*formatters.EventMsg
+}
+
+var testset = map[string]struct {
+	processorType string
+	processor     map[string]interface{}
+	tests         []item
+}{
+	"reshape": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"script": `
+def apply(events):
+    for e in events:
+        e["name"] = e["name"].upper()
+        for k, v in e["values"].items():
+            if k.endswith("/oper-state"):
+                e["tags"]["oper_state"] = v
+                e["values"].pop(k)
+            elif type(v) == "int":
+                e["values"][k] = v * 8
+    return events
+`,
+		},
+		tests: []item{
+			{
+				input:  nil,
+				output: []*formatters.EventMsg{},
+			},
+			{
+				input: []*formatters.EventMsg{
+					{
+						Name:      "sub1",
+						Timestamp: 42,
+						Tags:      map[string]string{"interface_name": "ethernet-1/1"},
+						Values: map[string]interface{}{
+							"/interface/oper-state":          "up",
+							"/interface/statistics/in-bytes": uint64(100),
+							"/interface/mtu":                 "9000",
+						},
+						Deletes: []string{"/interface/description"},
+					},
+				},
+				output: []*formatters.EventMsg{
+					{
+						Name:      "SUB1",
+						Timestamp: 42,
+						Tags:      map[string]string{"interface_name": "ethernet-1/1", "oper_state": "up"},
+						Values: map[string]interface{}{
+							"/interface/statistics/in-bytes": int64(800),
+							"/interface/mtu":                 "9000",
+						},
+						Deletes: []string{"/interface/description"},
+					},
+				},
+			},
+		},
+	},
+	"state": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"script": `
+def apply(events):
+    result = []
+    for e in events:
+        key = e["tags"]["source"]
+        prev = state.get(key)
+        state[key] = e["values"]["counter"]
+        if prev == None:
+            continue
+        e["values"]["delta"] = e["values"]["counter"] - prev
+        result.append(e)
+    return result
+`,
+		},
+		tests: []item{
+			{
+				input: []*formatters.EventMsg{
+					{Tags: map[string]string{"source": "r1"}, Values: map[string]interface{}{"counter": 10}},
+				},
+				output: []*formatters.EventMsg{},
+			},
+			{
+				input: []*formatters.EventMsg{
+					{Tags: map[string]string{"source": "r1"}, Values: map[string]interface{}{"counter": 15}},
+					{Tags: map[string]string{"source": "r2"}, Values: map[string]interface{}{"counter": 1}},
+				},
+				output: []*formatters.EventMsg{
+					{Tags: map[string]string{"source": "r1"}, Values: map[string]interface{}{"counter": int64(15), "delta": int64(5)}},
+				},
+			},
+		},
+	},
+	"drop_all": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"script": `
+def apply(events):
+    return None
+`,
+		},
+		tests: []item{
+			{
+				input:  []*formatters.EventMsg{{Name: "sub1"}},
+				output: []*formatters.EventMsg{},
+			},
+		},
+	},
+	"execution_budget": {
+		processorType: processorType,
+		processor: map[string]interface{}{
+			"max-steps": 1000,
+			"script": `
+def apply(events):
+    for i in range(1000000):
+        pass
+    return []
+`,
+		},
+		tests: []item{
+			// the script is interrupted, the events are returned unchanged
+			{
+				input:  []*formatters.EventMsg{{Name: "sub1"}},
+				output: []*formatters.EventMsg{{Name: "sub1"}},
+			},
+		},
+	},
+}
+
+func TestEventStarlark(t *testing.T) {
+	for name, ts := range testset {
+		if pi, ok := formatters.EventProcessors[ts.processorType]; ok {
+			t.Log("found processor")
+			p := pi()
+			err := p.Init(ts.processor)
+			if err != nil {
+				t.Errorf("failed to initialize processors: %v", err)
+				return
+			}
+			t.Logf("processor: %+v", p)
+			for i, item := range ts.tests {
+				t.Run(name, func(t *testing.T) {
+					t.Logf("running test item %d", i)
+					outs := p.Apply(item.input...)
+					if !cmp.Equal(outs, item.output) {
+						t.Errorf("failed at %s item %d: %s", name, i, cmp.Diff(item.output, outs))
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestScriptFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "event_starlark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "script.star")
+	err = ioutil.WriteFile(fn, []byte(`
+def apply(events):
+    return [e for e in events if e["tags"].get("source") == "r1"]
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := formatters.EventProcessors[processorType]()
+	err = p.Init(map[string]interface{}{"file": fn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outs := p.Apply(
+		&formatters.EventMsg{Tags: map[string]string{"source": "r1"}},
+		&formatters.EventMsg{Tags: map[string]string{"source": "r2"}},
+	)
+	if len(outs) != 1 || outs[0].Tags["source"] != "r1" {
+		t.Errorf("unexpected output: %v", outs)
+	}
+}
+
+func TestInit(t *testing.T) {
+	tests := map[string]map[string]interface{}{
+		"missing script":     {},
+		"script and file":    {"script": "def apply(events):\n    return events\n", "file": "script.star"},
+		"missing file":       {"file": "/does/not/exist.star"},
+		"syntax error":       {"script": "def apply(events)\n"},
+		"missing entrypoint": {"script": "x = 1\n"},
+		"not a function":     {"script": "apply = 1\n"},
+		"load not allowed":   {"script": "load('other.star', 'f')\ndef apply(events):\n    return events\n"},
+		"infinite top level": {"script": "def f():\n    for i in range(100000000):\n        pass\nf()\n", "max-steps": 100},
+	}
+	for name, cfg := range tests {
+		p := formatters.EventProcessors[processorType]()
+		if err := p.Init(cfg); err == nil {
+			t.Errorf("%s: expected an error for config %v", name, cfg)
+		}
+	}
+}
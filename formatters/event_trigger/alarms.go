@@ -0,0 +1,141 @@
+package event_trigger
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+)
+
+// state is the trigger state of a key
+type state struct {
+	occurrencesTimes []time.Time
+	lastTrigger      time.Time
+	// set when the alarm is raised, until it is cleared.
+	active bool
+}
+
+// Alarm is an alarm raised by an event-trigger processor with a clear condition
+type Alarm struct {
+	Processor string               `json:"processor,omitempty"`
+	Name      string               `json:"name,omitempty"`
+	Key       map[string]string    `json:"key,omitempty"`
+	RaisedAt  time.Time            `json:"raised-at,omitempty"`
+	Event     *formatters.EventMsg `json:"event,omitempty"`
+}
+
+// alarms holds the active alarms of all the event-trigger processors
+var alarms = &alarmStore{alarms: make(map[string]*storedAlarm)}
+
+type alarmStore struct {
+	m      sync.RWMutex
+	alarms map[string]*storedAlarm
+}
+
+// storedAlarm is an active alarm along with the time an event was last seen for its key,
+// it expires if no event is seen for its key within expiry.
+type storedAlarm struct {
+	alarm    *Alarm
+	lastSeen time.Time
+	expiry   time.Duration
+}
+
+func (a *storedAlarm) expired(now time.Time) bool {
+	return a.lastSeen.Add(a.expiry).Before(now)
+}
+
+// ActiveAlarms returns the active alarms sorted by their raise time
+func ActiveAlarms() []*Alarm {
+	return alarms.list()
+}
+
+// raise adds the alarm if it is not already active,
+// the same alarm can be raised by the processor instances of different outputs.
+func (s *alarmStore) raise(key string, a *Alarm, expiry time.Duration) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, ok := s.alarms[key]; !ok {
+		s.alarms[key] = &storedAlarm{alarm: a, lastSeen: a.RaisedAt, expiry: expiry}
+	}
+}
+
+// touch records that an event was seen at time now for the alarm key, if it is active.
+func (s *alarmStore) touch(key string, now time.Time) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if a, ok := s.alarms[key]; ok {
+		a.lastSeen = now
+	}
+}
+
+// expire deletes the alarms without events seen for their key within their expiry,
+// e.g the alarms of deleted targets.
+func (s *alarmStore) expire(now time.Time) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for k, a := range s.alarms {
+		if a.expired(now) {
+			delete(s.alarms, k)
+		}
+	}
+}
+
+// copyEvent returns a copy of the event stored with an alarm,
+// the event itself can be modified by the next processors.
+func copyEvent(e *formatters.EventMsg) *formatters.EventMsg {
+	c := &formatters.EventMsg{
+		Name:      e.Name,
+		Timestamp: e.Timestamp,
+		Tags:      make(map[string]string, len(e.Tags)),
+		Values:    make(map[string]interface{}, len(e.Values)),
+	}
+	for k, v := range e.Tags {
+		c.Tags[k] = v
+	}
+	for k, v := range e.Values {
+		c.Values[k] = v
+	}
+	if e.Deletes != nil {
+		c.Deletes = append(make([]string, 0, len(e.Deletes)), e.Deletes...)
+	}
+	return c
+}
+
+func (s *alarmStore) clear(key string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.alarms, key)
+}
+
+func (s *alarmStore) exists(key string) bool {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	_, ok := s.alarms[key]
+	return ok
+}
+
+// list returns a copy of the alarms not expired yet
+func (s *alarmStore) list() []*Alarm {
+	now := time.Now()
+	s.m.RLock()
+	defer s.m.RUnlock()
+	result := make([]*Alarm, 0, len(s.alarms))
+	for _, a := range s.alarms {
+		if a.expired(now) {
+			continue
+		}
+		c := *a.alarm
+		result = append(result, &c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].RaisedAt.Equal(result[j].RaisedAt) {
+			if result[i].Processor == result[j].Processor {
+				return result[i].Name < result[j].Name
+			}
+			return result[i].Processor < result[j].Processor
+		}
+		return result[i].RaisedAt.Before(result[j].RaisedAt)
+	})
+	return result
+}
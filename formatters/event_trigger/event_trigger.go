@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/itchyny/gojq"
@@ -23,6 +24,8 @@ const (
 	processorType    = "event-trigger"
 	loggingPrefix    = "[" + processorType + "] "
 	defaultCondition = `any([true])`
+
+	defaultAlarmExpiry = 24 * time.Hour
 )
 
 // Trigger triggers an action when certain conditions are met
@@ -31,17 +34,27 @@ type Trigger struct {
 	MinOccurrences int                      `mapstructure:"min-occurrences,omitempty"`
 	MaxOccurrences int                      `mapstructure:"max-occurrences,omitempty"`
 	Window         time.Duration            `mapstructure:"window,omitempty"`
+	KeyTags        []string                 `mapstructure:"key-tags,omitempty"`
+	ClearCondition string                   `mapstructure:"clear-condition,omitempty"`
+	AlarmName      string                   `mapstructure:"alarm-name,omitempty"`
+	AlarmExpiry    time.Duration            `mapstructure:"alarm-expiry,omitempty"`
 	Actions        []map[string]interface{} `mapstructure:"actions,omitempty"`
+	ClearActions   []map[string]interface{} `mapstructure:"clear-actions,omitempty"`
 	Vars           map[string]interface{}   `mapstructure:"vars,omitempty"`
 	VarsFile       string                   `mapstructure:"vars-file,omitempty"`
 	Debug          bool                     `mapstructure:"debug,omitempty"`
 
-	occurrencesTimes []time.Time
-	lastTrigger      time.Time
-	code             *gojq.Code
-	actions          []actions.Action
-	vars             map[string]interface{}
+	code         *gojq.Code
+	clearCode    *gojq.Code
+	actions      []actions.Action
+	clearActions []actions.Action
+	vars         map[string]interface{}
+
+	m           sync.Mutex
+	states      map[string]*state
+	lastCleanup time.Time
 
+	name    string
 	targets map[string]*types.TargetConfig
 	logger  *log.Logger
 }
@@ -71,16 +84,27 @@ func (p *Trigger) Init(cfg interface{}, opts ...formatters.Option) error {
 	if err != nil {
 		return err
 	}
-
-	if err != nil {
-		return err
-	}
-	for _, a := range p.Actions {
-		err = p.initializeAction(a)
+	if p.ClearCondition = strings.TrimSpace(p.ClearCondition); p.ClearCondition != "" {
+		q, err = gojq.Parse(p.ClearCondition)
+		if err != nil {
+			return err
+		}
+		p.clearCode, err = gojq.Compile(q)
 		if err != nil {
 			return err
 		}
 	}
+	p.actions, err = p.initializeActions(p.Actions)
+	if err != nil {
+		return err
+	}
+	if len(p.ClearActions) > 0 && p.clearCode == nil {
+		return errors.New("clear-actions are set without a clear-condition")
+	}
+	p.clearActions, err = p.initializeActions(p.ClearActions)
+	if err != nil {
+		return err
+	}
 	err = p.readVars()
 	if err != nil {
 		return err
@@ -96,11 +120,42 @@ func (p *Trigger) Init(cfg interface{}, opts ...formatters.Option) error {
 }
 
 func (p *Trigger) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
+	p.m.Lock()
+	defer p.m.Unlock()
 	now := time.Now()
+	if now.Sub(p.lastCleanup) >= p.Window {
+		p.cleanup(now)
+		p.lastCleanup = now
+	}
 	for _, e := range es {
 		if e == nil {
 			continue
 		}
+		key, keyTags := p.key(e)
+		s, ok := p.states[key]
+		if !ok {
+			s = new(state)
+			p.states[key] = s
+		}
+		// an active alarm is not raised again, until it is cleared.
+		// the clear condition is also evaluated if the alarm was raised
+		// by a previous instance of the processor.
+		if s.active || (p.clearCode != nil && alarms.exists(key)) {
+			if !p.evalClearCondition(e) {
+				alarms.touch(key, now)
+			} else {
+				alarms.clear(key)
+				if s.active {
+					s.active = false
+					s.occurrencesTimes = nil
+					p.logger.Printf("alarm %q cleared: %v", p.alarmName(), keyTags)
+					p.triggerActions(p.clearActions, e)
+				}
+			}
+			if s.active {
+				continue
+			}
+		}
 		res, err := formatters.CheckCondition(p.code, e)
 		if err != nil {
 			p.logger.Printf("failed evaluating condition %q: %v", p.Condition, err)
@@ -110,8 +165,19 @@ func (p *Trigger) Apply(es ...*formatters.EventMsg) []*formatters.EventMsg {
 			p.logger.Printf("msg=%+v, condition %q result: (%T)%v", e, p.Condition, res, res)
 		}
 		if res {
-			if p.evalOccurrencesWithinWindow(now) {
-				p.triggerActions(e)
+			if p.evalOccurrencesWithinWindow(s, now) {
+				if p.clearCode != nil {
+					s.active = true
+					alarms.raise(key, &Alarm{
+						Processor: p.name,
+						Name:      p.alarmName(),
+						Key:       keyTags,
+						RaisedAt:  now,
+						Event:     copyEvent(e),
+					}, p.AlarmExpiry)
+					p.logger.Printf("alarm %q raised: %v", p.alarmName(), keyTags)
+				}
+				p.triggerActions(p.actions, e)
 			}
 		}
 	}
@@ -133,9 +199,27 @@ func (p *Trigger) WithTargets(tcs map[string]*types.TargetConfig) {
 	p.targets = tcs
 }
 
-func (p *Trigger) initializeAction(cfg map[string]interface{}) error {
+// WithName sets the processor name, the alarms are identified by
+// the processor name, the alarm name and the key tags values.
+func (p *Trigger) WithName(name string) {
+	p.name = name
+}
+
+func (p *Trigger) initializeActions(cfgs []map[string]interface{}) ([]actions.Action, error) {
+	acts := make([]actions.Action, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		act, err := p.initializeAction(cfg)
+		if err != nil {
+			return nil, err
+		}
+		acts = append(acts, act)
+	}
+	return acts, nil
+}
+
+func (p *Trigger) initializeAction(cfg map[string]interface{}) (actions.Action, error) {
 	if len(cfg) == 0 {
-		return errors.New("missing action definition")
+		return nil, errors.New("missing action definition")
 	}
 	if actType, ok := cfg["type"]; ok {
 		switch actType := actType.(type) {
@@ -144,17 +228,16 @@ func (p *Trigger) initializeAction(cfg map[string]interface{}) error {
 				act := in()
 				err := act.Init(cfg, actions.WithLogger(p.logger), actions.WithTargets(p.targets))
 				if err != nil {
-					return err
+					return nil, err
 				}
-				p.actions = append(p.actions, act)
-				return nil
+				return act, nil
 			}
-			return fmt.Errorf("unknown action type %q", actType)
+			return nil, fmt.Errorf("unknown action type %q", actType)
 		default:
-			return fmt.Errorf("unexpected action field type %T", actType)
+			return nil, fmt.Errorf("unexpected action field type %T", actType)
 		}
 	}
-	return errors.New("missing type field under action")
+	return nil, errors.New("missing type field under action")
 }
 
 func (p *Trigger) String() string {
@@ -181,6 +264,10 @@ func (p *Trigger) setDefaults() error {
 	if p.Window <= 0 {
 		p.Window = time.Minute
 	}
+	if p.AlarmExpiry <= 0 {
+		p.AlarmExpiry = defaultAlarmExpiry
+	}
+	p.states = make(map[string]*state)
 	return nil
 }
 
@@ -202,10 +289,13 @@ func (p *Trigger) readVars() error {
 	return nil
 }
 
-func (p *Trigger) triggerActions(e *formatters.EventMsg) {
+func (p *Trigger) triggerActions(acts []actions.Action, e *formatters.EventMsg) {
+	if len(acts) == 0 {
+		return
+	}
 	go func() {
 		env := make(map[string]interface{})
-		for _, act := range p.actions {
+		for _, act := range acts {
 			res, err := act.Run(e, env, p.vars)
 			if err != nil {
 				p.logger.Printf("trigger action %q failed: %+v", act.NName(), err)
@@ -217,15 +307,15 @@ func (p *Trigger) triggerActions(e *formatters.EventMsg) {
 	}()
 }
 
-func (p *Trigger) evalOccurrencesWithinWindow(now time.Time) bool {
-	if p.occurrencesTimes == nil {
-		p.occurrencesTimes = make([]time.Time, 0)
+func (p *Trigger) evalOccurrencesWithinWindow(s *state, now time.Time) bool {
+	if s.occurrencesTimes == nil {
+		s.occurrencesTimes = make([]time.Time, 0)
 	}
-	occurrencesInWindow := make([]time.Time, 0, len(p.occurrencesTimes))
+	occurrencesInWindow := make([]time.Time, 0, len(s.occurrencesTimes))
 	if p.Debug {
-		p.logger.Printf("occurrencesTimes: %v", p.occurrencesTimes)
+		p.logger.Printf("occurrencesTimes: %v", s.occurrencesTimes)
 	}
-	for _, t := range p.occurrencesTimes {
+	for _, t := range s.occurrencesTimes {
 		if t.Add(p.Window).After(now) {
 			if p.Debug {
 				p.logger.Printf("time=%s + %s is after now=%s", t, p.Window, now)
@@ -233,11 +323,11 @@ func (p *Trigger) evalOccurrencesWithinWindow(now time.Time) bool {
 			occurrencesInWindow = append(occurrencesInWindow, t)
 		}
 	}
-	p.occurrencesTimes = append(occurrencesInWindow, now)
-	numOccurrences := len(p.occurrencesTimes)
+	s.occurrencesTimes = append(occurrencesInWindow, now)
+	numOccurrences := len(s.occurrencesTimes)
 	if numOccurrences > p.MaxOccurrences {
-		p.occurrencesTimes = p.occurrencesTimes[numOccurrences-p.MaxOccurrences-1:]
-		numOccurrences = len(p.occurrencesTimes)
+		s.occurrencesTimes = s.occurrencesTimes[numOccurrences-p.MaxOccurrences-1:]
+		numOccurrences = len(s.occurrencesTimes)
 	}
 
 	if p.Debug {
@@ -245,13 +335,75 @@ func (p *Trigger) evalOccurrencesWithinWindow(now time.Time) bool {
 	}
 
 	if numOccurrences >= p.MinOccurrences && numOccurrences <= p.MaxOccurrences {
-		p.lastTrigger = now
+		s.lastTrigger = now
 		return true
 	}
 	// check last trigger
-	if numOccurrences > p.MinOccurrences && p.lastTrigger.Add(p.Window).Before(now) {
-		p.lastTrigger = now
+	if numOccurrences > p.MinOccurrences && s.lastTrigger.Add(p.Window).Before(now) {
+		s.lastTrigger = now
 		return true
 	}
 	return false
 }
+
+func (p *Trigger) evalClearCondition(e *formatters.EventMsg) bool {
+	res, err := formatters.CheckCondition(p.clearCode, e)
+	if err != nil {
+		p.logger.Printf("failed evaluating clear condition %q: %v", p.ClearCondition, err)
+		return false
+	}
+	if p.Debug {
+		p.logger.Printf("msg=%+v, clear condition %q result: (%T)%v", e, p.ClearCondition, res, res)
+	}
+	return res
+}
+
+// key returns the key identifying the state the event is evaluated against,
+// and the values of the key tags found in the event.
+func (p *Trigger) key(e *formatters.EventMsg) (string, map[string]string) {
+	keyTags := make(map[string]string, len(p.KeyTags))
+	sb := strings.Builder{}
+	sb.WriteString(p.name)
+	sb.WriteString("\x00")
+	sb.WriteString(p.alarmName())
+	for _, t := range p.KeyTags {
+		v, ok := e.Tags[t]
+		if !ok {
+			continue
+		}
+		keyTags[t] = v
+		sb.WriteString("\x00")
+		sb.WriteString(t)
+		sb.WriteString("=")
+		sb.WriteString(v)
+	}
+	return sb.String(), keyTags
+}
+
+func (p *Trigger) alarmName() string {
+	if p.AlarmName != "" {
+		return p.AlarmName
+	}
+	return p.Condition
+}
+
+// cleanup deletes the inactive states without occurrences or triggers within the window,
+// and the states of the alarms expired or cleared by another instance of the processor.
+func (p *Trigger) cleanup(now time.Time) {
+	alarms.expire(now)
+	for k, s := range p.states {
+		if s.active {
+			if !alarms.exists(k) {
+				delete(p.states, k)
+			}
+			continue
+		}
+		if s.lastTrigger.Add(p.Window).After(now) {
+			continue
+		}
+		if n := len(s.occurrencesTimes); n > 0 && s.occurrencesTimes[n-1].Add(p.Window).After(now) {
+			continue
+		}
+		delete(p.states, k)
+	}
+}
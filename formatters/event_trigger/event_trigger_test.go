@@ -3,6 +3,7 @@ package event_trigger
 import (
 	"log"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -160,16 +161,19 @@ var testset = map[string]struct {
 
 var triggerOccWindowTestSet = map[string]struct {
 	t   *Trigger
+	s   *state
 	now time.Time
 	out bool
 }{
 	"defaults_0_occurrences": {
 		t: &Trigger{
-			logger:           log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
-			Debug:            true,
-			MinOccurrences:   1,
-			MaxOccurrences:   1,
-			Window:           time.Minute,
+			logger:         log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+			Debug:          true,
+			MinOccurrences: 1,
+			MaxOccurrences: 1,
+			Window:         time.Minute,
+		},
+		s: &state{
 			occurrencesTimes: []time.Time{},
 		},
 		out: true,
@@ -182,6 +186,8 @@ var triggerOccWindowTestSet = map[string]struct {
 			MinOccurrences: 1,
 			MaxOccurrences: 1,
 			Window:         time.Minute,
+		},
+		s: &state{
 			occurrencesTimes: []time.Time{
 				time.Now().Add(-time.Second),
 			},
@@ -197,6 +203,8 @@ var triggerOccWindowTestSet = map[string]struct {
 			MinOccurrences: 1,
 			MaxOccurrences: 1,
 			Window:         time.Minute,
+		},
+		s: &state{
 			occurrencesTimes: []time.Time{
 				time.Now().Add(-time.Hour),
 			},
@@ -206,11 +214,13 @@ var triggerOccWindowTestSet = map[string]struct {
 	},
 	"2max_1min_without_occurrences": {
 		t: &Trigger{
-			logger:           log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
-			Debug:            true,
-			MinOccurrences:   1,
-			MaxOccurrences:   2,
-			Window:           time.Minute,
+			logger:         log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+			Debug:          true,
+			MinOccurrences: 1,
+			MaxOccurrences: 2,
+			Window:         time.Minute,
+		},
+		s: &state{
 			occurrencesTimes: []time.Time{},
 		},
 		out: true,
@@ -223,6 +233,8 @@ var triggerOccWindowTestSet = map[string]struct {
 			MinOccurrences: 1,
 			MaxOccurrences: 2,
 			Window:         time.Minute,
+		},
+		s: &state{
 			occurrencesTimes: []time.Time{
 				time.Now().Add(-30 * time.Second),
 			},
@@ -237,6 +249,8 @@ var triggerOccWindowTestSet = map[string]struct {
 			MinOccurrences: 1,
 			MaxOccurrences: 2,
 			Window:         time.Minute,
+		},
+		s: &state{
 			occurrencesTimes: []time.Time{
 				time.Now().Add(-10 * time.Second),
 				time.Now().Add(-30 * time.Second),
@@ -248,11 +262,13 @@ var triggerOccWindowTestSet = map[string]struct {
 	},
 	"2max_2min_without_occurrences": {
 		t: &Trigger{
-			logger:           log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
-			Debug:            true,
-			MinOccurrences:   2,
-			MaxOccurrences:   2,
-			Window:           time.Minute,
+			logger:         log.New(os.Stderr, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+			Debug:          true,
+			MinOccurrences: 2,
+			MaxOccurrences: 2,
+			Window:         time.Minute,
+		},
+		s: &state{
 			occurrencesTimes: []time.Time{},
 		},
 		out: false,
@@ -265,6 +281,8 @@ var triggerOccWindowTestSet = map[string]struct {
 			MinOccurrences: 2,
 			MaxOccurrences: 2,
 			Window:         time.Minute,
+		},
+		s: &state{
 			occurrencesTimes: []time.Time{
 				time.Now().Add(-30 * time.Second),
 			},
@@ -279,6 +297,8 @@ var triggerOccWindowTestSet = map[string]struct {
 			MinOccurrences: 2,
 			MaxOccurrences: 2,
 			Window:         time.Minute,
+		},
+		s: &state{
 			occurrencesTimes: []time.Time{
 				time.Now().Add(-10 * time.Second),
 				time.Now().Add(-30 * time.Second),
@@ -295,6 +315,8 @@ var triggerOccWindowTestSet = map[string]struct {
 			MinOccurrences: 2,
 			MaxOccurrences: 2,
 			Window:         time.Minute,
+		},
+		s: &state{
 			occurrencesTimes: []time.Time{
 				time.Now().Add(-10 * time.Second),
 				time.Now().Add(-30 * time.Second),
@@ -342,7 +364,7 @@ func TestEventTrigger(t *testing.T) {
 func TestOccurrenceTrigger(t *testing.T) {
 	for name, ts := range triggerOccWindowTestSet {
 		t.Run(name, func(t *testing.T) {
-			ok := ts.t.evalOccurrencesWithinWindow(ts.now)
+			ok := ts.t.evalOccurrencesWithinWindow(ts.s, ts.now)
 			t.Logf("%q result: %v", name, ok)
 			if ok != ts.out {
 				t.Errorf("failed at %s , expected %+v, got: %+v", name, ts.out, ok)
@@ -350,3 +372,172 @@ func TestOccurrenceTrigger(t *testing.T) {
 		})
 	}
 }
+
+func TestPerKeyState(t *testing.T) {
+	p := formatters.EventProcessors[processorType]().(*Trigger)
+	err := p.Init(map[string]interface{}{
+		"condition":       `.values["oper-state"] == "down"`,
+		"key-tags":        []string{"source", "interface_name"},
+		"min-occurrences": 2,
+		"max-occurrences": 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := func(source, itf string) *formatters.EventMsg {
+		return &formatters.EventMsg{
+			Tags:   map[string]string{"source": source, "interface_name": itf},
+			Values: map[string]interface{}{"oper-state": "down"},
+		}
+	}
+	p.Apply(down("r1", "ethernet-1/1"), down("r1", "ethernet-1/1"), down("r2", "ethernet-1/1"))
+	if len(p.states) != 2 {
+		t.Fatalf("got %d states, want 2", len(p.states))
+	}
+	for k, s := range p.states {
+		n := len(s.occurrencesTimes)
+		if strings.Contains(k, "r1") && (n != 2 || s.lastTrigger.IsZero()) {
+			t.Errorf("r1 state should be triggered: %+v", s)
+		}
+		if strings.Contains(k, "r2") && (n != 1 || !s.lastTrigger.IsZero()) {
+			t.Errorf("r2 state should not be triggered by r1 occurrences: %+v", s)
+		}
+	}
+}
+
+func TestAlarmRaiseClear(t *testing.T) {
+	p := formatters.EventProcessors[processorType]().(*Trigger)
+	err := p.Init(map[string]interface{}{
+		"alarm-name":      "interface-down",
+		"condition":       `.values["oper-state"] == "down"`,
+		"clear-condition": `.values["oper-state"] == "up"`,
+		"key-tags":        []string{"source", "interface_name"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := func(st string) *formatters.EventMsg {
+		return &formatters.EventMsg{
+			Tags:   map[string]string{"source": "r1", "interface_name": "ethernet-1/1"},
+			Values: map[string]interface{}{"oper-state": st},
+		}
+	}
+	p.Apply(state("down"))
+	as := ActiveAlarms()
+	if len(as) != 1 || as[0].Name != "interface-down" || as[0].Key["interface_name"] != "ethernet-1/1" {
+		t.Fatalf("unexpected active alarms: %+v", as)
+	}
+	raisedAt := as[0].RaisedAt
+	// still down, the alarm is not raised again
+	p.Apply(state("down"))
+	if as = ActiveAlarms(); len(as) != 1 || !as[0].RaisedAt.Equal(raisedAt) {
+		t.Fatalf("unexpected active alarms: %+v", as)
+	}
+	p.Apply(state("up"))
+	if as = ActiveAlarms(); len(as) != 0 {
+		t.Fatalf("alarm not cleared: %+v", as)
+	}
+	for _, s := range p.states {
+		if s.active {
+			t.Errorf("state still active after clear: %+v", s)
+		}
+	}
+	// raised again after being cleared
+	p.Apply(state("down"))
+	if as = ActiveAlarms(); len(as) != 1 {
+		t.Fatalf("got %d active alarms, want 1", len(as))
+	}
+	// an alarm raised by a replaced instance is cleared by the new one
+	p2 := formatters.EventProcessors[processorType]().(*Trigger)
+	err = p2.Init(map[string]interface{}{
+		"alarm-name":      "interface-down",
+		"condition":       `.values["oper-state"] == "down"`,
+		"clear-condition": `.values["oper-state"] == "up"`,
+		"key-tags":        []string{"source", "interface_name"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2.Apply(state("up"))
+	if as = ActiveAlarms(); len(as) != 0 {
+		t.Fatalf("alarm not cleared: %+v", as)
+	}
+}
+
+func TestAlarmNamespace(t *testing.T) {
+	cfg := map[string]interface{}{
+		"condition":       `.values["oper-state"] == "down"`,
+		"clear-condition": `.values["oper-state"] == "up"`,
+		"key-tags":        []string{"source"},
+	}
+	ps := make([]*Trigger, 0, 2)
+	for _, name := range []string{"trigger1", "trigger2"} {
+		p := formatters.EventProcessors[processorType]().(*Trigger)
+		if err := p.Init(cfg, formatters.WithName(name)); err != nil {
+			t.Fatal(err)
+		}
+		ps = append(ps, p)
+	}
+	state := func(st string) *formatters.EventMsg {
+		return &formatters.EventMsg{
+			Tags:   map[string]string{"source": "r1"},
+			Values: map[string]interface{}{"oper-state": st},
+		}
+	}
+	for _, p := range ps {
+		p.Apply(state("down"))
+	}
+	as := ActiveAlarms()
+	if len(as) != 2 || as[0].Processor != "trigger1" || as[1].Processor != "trigger2" {
+		t.Fatalf("unexpected active alarms: %+v", as)
+	}
+	// processors with the same condition do not clear each other's alarms
+	ps[0].Apply(state("up"))
+	if as = ActiveAlarms(); len(as) != 1 || as[0].Processor != "trigger2" {
+		t.Fatalf("unexpected active alarms: %+v", as)
+	}
+	ps[1].Apply(state("up"))
+	if as = ActiveAlarms(); len(as) != 0 {
+		t.Fatalf("alarm not cleared: %+v", as)
+	}
+}
+
+func TestAlarmExpiry(t *testing.T) {
+	p := formatters.EventProcessors[processorType]().(*Trigger)
+	err := p.Init(map[string]interface{}{
+		"condition":       `.values["oper-state"] == "down"`,
+		"clear-condition": `.values["oper-state"] == "up"`,
+		"key-tags":        []string{"source"},
+		"alarm-expiry":    "1h",
+	}, formatters.WithName("expiring"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Apply(&formatters.EventMsg{
+		Tags:   map[string]string{"source": "r1"},
+		Values: map[string]interface{}{"oper-state": "down"},
+	})
+	if as := ActiveAlarms(); len(as) != 1 {
+		t.Fatalf("got %d active alarms, want 1", len(as))
+	}
+	// no event seen for the alarm key within the expiry
+	p.cleanup(time.Now().Add(2 * time.Hour))
+	if as := ActiveAlarms(); len(as) != 0 {
+		t.Fatalf("alarm not expired: %+v", as)
+	}
+	if len(p.states) != 0 {
+		t.Errorf("got %d states after the alarm expired, want 0", len(p.states))
+	}
+}
+
+func TestInitClearActions(t *testing.T) {
+	p := formatters.EventProcessors[processorType]()
+	err := p.Init(map[string]interface{}{
+		"clear-actions": []map[string]interface{}{
+			{"name": "dummy", "type": "http"},
+		},
+	})
+	if err == nil {
+		t.Errorf("expected an error for clear-actions without a clear-condition")
+	}
+}
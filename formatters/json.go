@@ -291,3 +291,77 @@ func (o *MarshalOptions) formatSetResponse(m *gnmi.SetResponse, meta map[string]
 	}
 	return json.Marshal(msg)
 }
+
+// ToSubscribeResponse converts a notification formatted as json back to a gNMI SubscribeResponse.
+// String and boolean values are set as typed values, the other values are JSON encoded.
+func (n *NotificationRspMsg) ToSubscribeResponse() (*gnmi.SubscribeResponse, error) {
+	prefix, err := xpathToPath(n.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	prefix.Target = n.Target
+	notif := &gnmi.Notification{
+		Timestamp: n.Timestamp,
+		Prefix:    prefix,
+		Update:    make([]*gnmi.Update, 0, len(n.Updates)),
+		Delete:    make([]*gnmi.Path, 0, len(n.Deletes)),
+	}
+	if notif.Timestamp == 0 && n.Time != nil {
+		notif.Timestamp = n.Time.UnixNano()
+	}
+	for _, u := range n.Updates {
+		p, err := xpathToPath(u.Path)
+		if err != nil {
+			return nil, err
+		}
+		// the values map holds a single value keyed by the path elements names
+		for _, v := range u.Values {
+			tv, err := typedValue(v)
+			if err != nil {
+				return nil, err
+			}
+			notif.Update = append(notif.Update, &gnmi.Update{Path: p, Val: tv})
+			break
+		}
+	}
+	for _, d := range n.Deletes {
+		p, err := xpathToPath(d)
+		if err != nil {
+			return nil, err
+		}
+		notif.Delete = append(notif.Delete, p)
+	}
+	return &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{Update: notif},
+	}, nil
+}
+
+// xpathToPath parses an xpath produced by utils.GnmiPathToXPath,
+// which has no leading '/' after its origin
+func xpathToPath(xp string) (*gnmi.Path, error) {
+	var origin string
+	if idx := strings.Index(xp, ":"); idx > 0 && !strings.ContainsAny(xp[:idx], "/[") {
+		origin = xp[:idx]
+		xp = xp[idx+1:]
+	}
+	p, err := utils.ParsePath(xp)
+	if err != nil {
+		return nil, err
+	}
+	p.Origin = origin
+	return p, nil
+}
+
+func typedValue(v interface{}) (*gnmi.TypedValue, error) {
+	switch v := v.(type) {
+	case string:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: v}}, nil
+	case bool:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_BoolVal{BoolVal: v}}, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonVal{JsonVal: b}}, nil
+}
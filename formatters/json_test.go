@@ -0,0 +1,63 @@
+package formatters
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNotificationRspMsgToSubscribeResponse(t *testing.T) {
+	rsp := &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: 42,
+				Prefix: &gnmi.Path{
+					Origin: "openconfig",
+					Target: "router1",
+					Elem: []*gnmi.PathElem{
+						{Name: "interfaces"},
+						{Name: "interface", Key: map[string]string{"name": "ethernet-1/1"}},
+					},
+				},
+				Update: []*gnmi.Update{
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "state"}, {Name: "description"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: "uplink"}},
+					},
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "state"}, {Name: "enabled"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_BoolVal{BoolVal: true}},
+					},
+					{
+						Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "state"}, {Name: "mtu"}}},
+						Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: 9000}},
+					},
+				},
+				Delete: []*gnmi.Path{
+					{Elem: []*gnmi.PathElem{{Name: "state"}, {Name: "counters"}}},
+				},
+			},
+		},
+	}
+	mo := &MarshalOptions{Format: "json"}
+	b, err := mo.Marshal(rsp, map[string]string{"source": "router1:57400", "subscription-name": "sub1"})
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	msg := new(NotificationRspMsg)
+	err = json.Unmarshal(b, msg)
+	if err != nil {
+		t.Fatalf("failed to unmarshal: %v", err)
+	}
+	got, err := msg.ToSubscribeResponse()
+	if err != nil {
+		t.Fatalf("failed to convert: %v", err)
+	}
+	want := proto.Clone(rsp).(*gnmi.SubscribeResponse)
+	want.GetUpdate().Update[2].Val = &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonVal{JsonVal: []byte("9000")}}
+	if !proto.Equal(got, want) {
+		t.Errorf("unexpected response:\ngot:  %v\nwant: %v", got, want)
+	}
+}
@@ -1,6 +1,7 @@
 package formatters
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
@@ -28,6 +29,10 @@ var EventProcessorTypes = []string{
 	"event-trigger",
 	"event-write",
 	"event-group-by",
+	"event-rate",
+	"event-aggregate",
+	"event-enrich",
+	"event-starlark",
 }
 
 type Initializer func() EventProcessor
@@ -45,6 +50,53 @@ type EventProcessor interface {
 	WithLogger(l *log.Logger)
 }
 
+// EventEmitter is implemented by the event processors producing events
+// outside of Apply, e.g. at the end of a time window.
+type EventEmitter interface {
+	// Emit runs until ctx is done, the produced events are passed to fn.
+	Emit(ctx context.Context, fn func(...*EventMsg))
+	// EnableEmitting is called by the WithEmitting option, an emitter should fail
+	// to initialize without it since nothing would run its Emit method.
+	EnableEmitting()
+}
+
+// Closer is implemented by the event processors running in the background,
+// e.g. to reload their data.
+type Closer interface {
+	Close()
+}
+
+// CloseProcessors stops the background work of the processors found in eps,
+// it is called by the outputs and inputs when they are closed.
+func CloseProcessors(eps []EventProcessor) {
+	for _, ep := range eps {
+		if c, ok := ep.(Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// StartEmitters starts the event emitters found in eps.
+// The events emitted by a processor are passed through the processors
+// following it in eps before being written using fn.
+func StartEmitters(ctx context.Context, eps []EventProcessor, fn func(*EventMsg)) {
+	for i, ep := range eps {
+		em, ok := ep.(EventEmitter)
+		if !ok {
+			continue
+		}
+		next := eps[i+1:]
+		go em.Emit(ctx, func(evs ...*EventMsg) {
+			for _, p := range next {
+				evs = p.Apply(evs...)
+			}
+			for _, ev := range evs {
+				fn(ev)
+			}
+		})
+	}
+}
+
 func DecodeConfig(src, dst interface{}) error {
 	decoder, err := mapstructure.NewDecoder(
 		&mapstructure.DecoderConfig{
@@ -70,6 +122,25 @@ func WithTargets(tcs map[string]*types.TargetConfig) Option {
 	}
 }
 
+// WithName passes the configured processor name to the processors using it,
+// i.e the ones implementing a WithName(string) method.
+func WithName(name string) Option {
+	return func(p EventProcessor) {
+		if np, ok := p.(interface{ WithName(string) }); ok {
+			np.WithName(name)
+		}
+	}
+}
+
+// WithEmitting is used by the outputs starting the event emitters with StartEmitters.
+func WithEmitting() Option {
+	return func(p EventProcessor) {
+		if em, ok := p.(EventEmitter); ok {
+			em.EnableEmitting()
+		}
+	}
+}
+
 func CheckCondition(code *gojq.Code, e *EventMsg) (bool, error) {
 	var res interface{}
 	if code != nil {
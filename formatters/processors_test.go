@@ -0,0 +1,54 @@
+package formatters
+
+import (
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/types"
+)
+
+// testProcessor appends its name to the events name,
+// it emits a single event when it implements EventEmitter.
+type testProcessor struct {
+	name string
+}
+
+func (p *testProcessor) Init(interface{}, ...Option) error { return nil }
+func (p *testProcessor) Apply(es ...*EventMsg) []*EventMsg {
+	for _, e := range es {
+		e.Name += "-" + p.name
+	}
+	return es
+}
+func (p *testProcessor) WithTargets(map[string]*types.TargetConfig) {}
+func (p *testProcessor) WithLogger(*log.Logger)                     {}
+
+type testEmitter struct {
+	testProcessor
+}
+
+func (p *testEmitter) Emit(ctx context.Context, fn func(...*EventMsg)) {
+	fn(&EventMsg{Name: "emitted"})
+}
+
+func (p *testEmitter) EnableEmitting() {}
+
+func TestStartEmitters(t *testing.T) {
+	eps := []EventProcessor{
+		&testProcessor{name: "p1"},
+		&testEmitter{testProcessor{name: "e1"}},
+		&testProcessor{name: "p2"},
+	}
+	evCh := make(chan *EventMsg, 1)
+	StartEmitters(context.Background(), eps, func(ev *EventMsg) { evCh <- ev })
+	select {
+	case ev := <-evCh:
+		if ev.Name != "emitted-p2" {
+			t.Errorf("got event name %q, want %q", ev.Name, "emitted-p2")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the emitted event")
+	}
+}
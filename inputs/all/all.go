@@ -1,7 +1,10 @@
 package all
 
 import (
+	_ "github.com/karimra/gnmic/inputs/jetstream_input"
 	_ "github.com/karimra/gnmic/inputs/kafka_input"
+	_ "github.com/karimra/gnmic/inputs/mqtt_input"
 	_ "github.com/karimra/gnmic/inputs/nats_input"
+	_ "github.com/karimra/gnmic/inputs/redis_input"
 	_ "github.com/karimra/gnmic/inputs/stan_input"
 )
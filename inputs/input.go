@@ -6,23 +6,55 @@ import (
 
 	"github.com/karimra/gnmic/outputs"
 	"github.com/karimra/gnmic/types"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Input interface {
 	Start(context.Context, string, map[string]interface{}, ...Option) error
 	Close() error
 	SetLogger(*log.Logger)
-	SetOutputs(map[string]outputs.Output)
+	SetOutputs(OutputsFunc)
 	SetEventProcessors(map[string]map[string]interface{}, *log.Logger, map[string]*types.TargetConfig)
 	SetName(string)
 }
 
+// MetricsRegisterer is implemented by inputs exposing their own metrics
+type MetricsRegisterer interface {
+	RegisterMetrics(*prometheus.Registry)
+}
+
 type Initializer func() Input
 
+// OutputsFunc returns the outputs by name,
+// an input calls it for each message so that it writes to the current outputs.
+type OutputsFunc func() map[string]outputs.Output
+
+// SelectOutputs returns the outputs returned by fn which are listed in names,
+// or all of them if names is empty.
+func SelectOutputs(fn OutputsFunc, names []string) map[string]outputs.Output {
+	if fn == nil {
+		return nil
+	}
+	outs := fn()
+	if len(names) == 0 {
+		return outs
+	}
+	selected := make(map[string]outputs.Output, len(names))
+	for _, name := range names {
+		if o, ok := outs[name]; ok {
+			selected[name] = o
+		}
+	}
+	return selected
+}
+
 var InputTypes = []string{
 	"nats",
 	"stan",
 	"kafka",
+	"mqtt",
+	"redis",
+	"jetstream",
 }
 
 var Inputs = map[string]Initializer{}
@@ -41,7 +73,14 @@ func WithLogger(logger *log.Logger) Option {
 
 func WithOutputs(outs map[string]outputs.Output) Option {
 	return func(i Input) {
-		i.SetOutputs(outs)
+		i.SetOutputs(func() map[string]outputs.Output { return outs })
+	}
+}
+
+// WithOutputsFunc sets the function returning the outputs an input writes to
+func WithOutputsFunc(fn OutputsFunc) Option {
+	return func(i Input) {
+		i.SetOutputs(fn)
 	}
 }
 
@@ -56,3 +95,12 @@ func WithEventProcessors(eps map[string]map[string]interface{}, log *log.Logger,
 		i.SetEventProcessors(eps, log, tcs)
 	}
 }
+
+// WithRegister registers the input metrics if it implements MetricsRegisterer
+func WithRegister(reg *prometheus.Registry) Option {
+	return func(i Input) {
+		if mr, ok := i.(MetricsRegisterer); ok && reg != nil {
+			mr.RegisterMetrics(reg)
+		}
+	}
+}
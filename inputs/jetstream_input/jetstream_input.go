@@ -0,0 +1,507 @@
+package jetstream_input
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/inputs"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/types"
+	"github.com/karimra/gnmic/utils"
+	"github.com/nats-io/nats.go"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	loggingPrefix           = "[jetstream_input] "
+	natsReconnectBufferSize = 100 * 1024 * 1024
+	defaultAddress          = "localhost:4222"
+	natsConnectWait         = 2 * time.Second
+	defaultFormat           = "event"
+	defaultStream           = "telemetry"
+	defaultDeliverPolicy    = "all"
+	defaultAckWait          = 30 * time.Second
+	defaultFetchBatchSize   = 100
+	defaultFetchWait        = time.Second
+	defaultNumWorkers       = 1
+
+	// message headers set by the jetstream output, in their canonical MIME form
+	sourceHeader           = "Gnmic-Source"
+	subscriptionNameHeader = "Gnmic-Subscription-Name"
+)
+
+// errDecode is returned for messages that can't be decoded,
+// they are terminated instead of being redelivered.
+var errDecode = errors.New("failed to decode message")
+
+func init() {
+	inputs.Register("jetstream", func() inputs.Input {
+		return &JetStreamInput{
+			Cfg:    &Config{},
+			logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+			wg:     new(sync.WaitGroup),
+		}
+	})
+}
+
+// JetStreamInput reads messages from a NATS JetStream stream using a durable pull consumer,
+// a message is acknowledged once it is written to the outputs.
+type JetStreamInput struct {
+	Cfg    *Config
+	cfn    context.CancelFunc
+	logger *log.Logger
+
+	wg        *sync.WaitGroup
+	outputsFn inputs.OutputsFunc
+	evps      []formatters.EventProcessor
+}
+
+// Config //
+type Config struct {
+	Name            string        `mapstructure:"name,omitempty"`
+	Address         string        `mapstructure:"address,omitempty"`
+	Username        string        `mapstructure:"username,omitempty"`
+	Password        string        `mapstructure:"password,omitempty"`
+	TLS             *tlsConfig    `mapstructure:"tls,omitempty"`
+	ConnectTimeWait time.Duration `mapstructure:"connect-time-wait,omitempty"`
+	Stream          string        `mapstructure:"stream,omitempty"`
+	Subject         string        `mapstructure:"subject,omitempty"`
+	Durable         string        `mapstructure:"durable,omitempty"`
+	DeliverPolicy   string        `mapstructure:"deliver-policy,omitempty"`
+	AckWait         time.Duration `mapstructure:"ack-wait,omitempty"`
+	MaxDeliver      int           `mapstructure:"max-deliver,omitempty"`
+	MaxAckPending   int           `mapstructure:"max-ack-pending,omitempty"`
+	FetchBatchSize  int           `mapstructure:"fetch-batch-size,omitempty"`
+	FetchWait       time.Duration `mapstructure:"fetch-wait,omitempty"`
+	Format          string        `mapstructure:"format,omitempty"`
+	Debug           bool          `mapstructure:"debug,omitempty"`
+	NumWorkers      int           `mapstructure:"num-workers,omitempty"`
+	EnableMetrics   bool          `mapstructure:"enable-metrics,omitempty"`
+	Outputs         []string      `mapstructure:"outputs,omitempty"`
+	EventProcessors []string      `mapstructure:"event-processors,omitempty"`
+}
+
+type tlsConfig struct {
+	CaFile     string `mapstructure:"ca-file,omitempty"`
+	CertFile   string `mapstructure:"cert-file,omitempty"`
+	KeyFile    string `mapstructure:"key-file,omitempty"`
+	SkipVerify bool   `mapstructure:"skip-verify,omitempty"`
+}
+
+// Start //
+func (n *JetStreamInput) Start(ctx context.Context, name string, cfg map[string]interface{}, opts ...inputs.Option) error {
+	err := outputs.DecodeConfig(cfg, n.Cfg)
+	if err != nil {
+		return err
+	}
+	if n.Cfg.Name == "" {
+		n.Cfg.Name = name
+	}
+	// the durable consumer name defaults to the input name,
+	// before it gets prefixed by the instance name.
+	if n.Cfg.Durable == "" {
+		n.Cfg.Durable = n.Cfg.Name
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	err = n.setDefaults()
+	if err != nil {
+		return err
+	}
+	n.warnUnsyncedOutputs()
+	initMetrics()
+	ctx, n.cfn = context.WithCancel(ctx)
+	n.logger.Printf("input starting with config: %+v", n.Cfg)
+	n.wg.Add(n.Cfg.NumWorkers)
+	for i := 0; i < n.Cfg.NumWorkers; i++ {
+		go n.worker(ctx, i)
+	}
+	return nil
+}
+
+func (n *JetStreamInput) worker(ctx context.Context, idx int) {
+	defer n.wg.Done()
+	var nc *nats.Conn
+	var sub *nats.Subscription
+	var err error
+	workerLogPrefix := fmt.Sprintf("worker-%d", idx)
+	n.logger.Printf("%s starting", workerLogPrefix)
+	cfg := *n.Cfg
+	cfg.Name = fmt.Sprintf("%s-%d", cfg.Name, idx)
+START:
+	if ctx.Err() != nil {
+		return
+	}
+	nc, err = n.createNATSConn(&cfg)
+	if err != nil {
+		n.logger.Printf("%s failed to create NATS connection: %v", workerLogPrefix, err)
+		time.Sleep(n.Cfg.ConnectTimeWait)
+		goto START
+	}
+	sub, err = n.pullSubscribe(nc)
+	if err != nil {
+		n.logger.Printf("%s failed to create JetStream subscription: %v", workerLogPrefix, err)
+		nc.Close()
+		time.Sleep(n.Cfg.ConnectTimeWait)
+		goto START
+	}
+	defer nc.Close()
+	n.logger.Printf("%s bound to consumer %q on stream %q", workerLogPrefix, n.Cfg.Durable, n.Cfg.Stream)
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		fctx, cancel := context.WithTimeout(ctx, n.Cfg.FetchWait)
+		msgs, err := sub.Fetch(n.Cfg.FetchBatchSize, nats.Context(fctx))
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if err == context.DeadlineExceeded || err == nats.ErrTimeout {
+				continue
+			}
+			n.logger.Printf("%s failed to fetch messages: %v", workerLogPrefix, err)
+			if !sub.IsValid() {
+				nc.Close()
+				time.Sleep(n.Cfg.ConnectTimeWait)
+				goto START
+			}
+			time.Sleep(n.Cfg.ConnectTimeWait)
+			continue
+		}
+		for _, m := range msgs {
+			err = n.handleMessage(ctx, m)
+			switch {
+			case err == nil:
+				err = m.Ack()
+			case errors.Is(err, errDecode):
+				if n.Cfg.Debug {
+					n.logger.Printf("%s %v", workerLogPrefix, err)
+				}
+				// redelivering a message that can't be decoded won't help
+				err = m.Term()
+			default:
+				n.logger.Printf("%s failed to write message to outputs, it will be redelivered: %v", workerLogPrefix, err)
+				if n.Cfg.EnableMetrics {
+					JetStreamInputNumberOfFailedMsgs.WithLabelValues(n.Cfg.Name, "write_error").Inc()
+				}
+				err = m.Nak()
+			}
+			if err != nil {
+				n.logger.Printf("%s failed to acknowledge message: %v", workerLogPrefix, err)
+			}
+		}
+	}
+}
+
+// pullSubscribe creates the durable consumer if it does not exist and binds to it
+func (n *JetStreamInput) pullSubscribe(nc *nats.Conn) (*nats.Subscription, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	_, err = js.ConsumerInfo(n.Cfg.Stream, n.Cfg.Durable)
+	if err != nil {
+		if !strings.Contains(err.Error(), "not found") {
+			return nil, fmt.Errorf("failed to get consumer %q info: %v", n.Cfg.Durable, err)
+		}
+		_, err = js.AddConsumer(n.Cfg.Stream, n.consumerConfig())
+		if err != nil && !strings.Contains(err.Error(), "already in use") {
+			return nil, fmt.Errorf("failed to create consumer %q: %v", n.Cfg.Durable, err)
+		}
+	}
+	return js.PullSubscribe(n.Cfg.Subject, n.Cfg.Durable, nats.BindStream(n.Cfg.Stream))
+}
+
+func (n *JetStreamInput) consumerConfig() *nats.ConsumerConfig {
+	cc := &nats.ConsumerConfig{
+		Durable:       n.Cfg.Durable,
+		DeliverPolicy: nats.DeliverAllPolicy,
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       n.Cfg.AckWait,
+		MaxDeliver:    n.Cfg.MaxDeliver,
+		FilterSubject: n.Cfg.Subject,
+		MaxAckPending: n.Cfg.MaxAckPending,
+	}
+	switch n.Cfg.DeliverPolicy {
+	case "new":
+		cc.DeliverPolicy = nats.DeliverNewPolicy
+	case "last":
+		cc.DeliverPolicy = nats.DeliverLastPolicy
+	}
+	return cc
+}
+
+// handleMessage decodes a message and writes it to the outputs.
+// An error wrapping errDecode is returned if the message is invalid,
+// any other error means that at least one output failed to write the message.
+func (n *JetStreamInput) handleMessage(ctx context.Context, m *nats.Msg) error {
+	if n.Cfg.EnableMetrics {
+		JetStreamInputNumberOfReceivedMsgs.WithLabelValues(n.Cfg.Name, m.Subject).Inc()
+		JetStreamInputNumberOfReceivedBytes.WithLabelValues(n.Cfg.Name, m.Subject).Add(float64(len(m.Data)))
+	}
+	if n.Cfg.Debug {
+		n.logger.Printf("received msg, subject=%s, len=%d, data=%s", m.Subject, len(m.Data), string(m.Data))
+	}
+	if len(m.Data) == 0 {
+		return nil
+	}
+	switch n.Cfg.Format {
+	case "event":
+		evMsgs := make([]*formatters.EventMsg, 0, 1)
+		err := json.Unmarshal(m.Data, &evMsgs)
+		if err != nil {
+			if n.Cfg.EnableMetrics {
+				JetStreamInputNumberOfFailedMsgs.WithLabelValues(n.Cfg.Name, "unmarshal_error").Inc()
+			}
+			return fmt.Errorf("%w: %v", errDecode, err)
+		}
+		for _, p := range n.evps {
+			evMsgs = p.Apply(evMsgs...)
+		}
+		var errs []string
+		for _, o := range inputs.SelectOutputs(n.outputsFn, n.Cfg.Outputs) {
+			sw, ok := o.(outputs.EventSyncWriter)
+			for _, ev := range evMsgs {
+				if !ok {
+					o.WriteEvent(ctx, ev)
+					continue
+				}
+				if err := sw.WriteEventSync(ctx, ev); err != nil {
+					errs = append(errs, err.Error())
+					break
+				}
+			}
+		}
+		if len(errs) > 0 {
+			return errors.New(strings.Join(errs, "; "))
+		}
+	case "proto":
+		rsp := new(gnmi.SubscribeResponse)
+		err := proto.Unmarshal(m.Data, rsp)
+		if err != nil {
+			if n.Cfg.EnableMetrics {
+				JetStreamInputNumberOfFailedMsgs.WithLabelValues(n.Cfg.Name, "unmarshal_error").Inc()
+			}
+			return fmt.Errorf("%w: %v", errDecode, err)
+		}
+		meta := msgMeta(m)
+		var errs []string
+		for _, o := range inputs.SelectOutputs(n.outputsFn, n.Cfg.Outputs) {
+			sw, ok := o.(outputs.SyncWriter)
+			if !ok {
+				o.Write(ctx, rsp, meta)
+				continue
+			}
+			if err := sw.WriteSync(ctx, rsp, meta); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		if len(errs) > 0 {
+			return errors.New(strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// warnUnsyncedOutputs logs the outputs not able to report whether a message was written,
+// the messages are acknowledged once they are handed to them.
+func (n *JetStreamInput) warnUnsyncedOutputs() {
+	for name, o := range inputs.SelectOutputs(n.outputsFn, n.Cfg.Outputs) {
+		var ok bool
+		switch n.Cfg.Format {
+		case "event":
+			_, ok = o.(outputs.EventSyncWriter)
+		case "proto":
+			_, ok = o.(outputs.SyncWriter)
+		}
+		if !ok {
+			n.logger.Printf("output %q does not support synchronous writes of %s messages, the messages are acknowledged without waiting for it", name, n.Cfg.Format)
+		}
+	}
+}
+
+// msgMeta reads the source and subscription name from the message headers,
+// or from the subject if it was built using a subject-prefix.
+func msgMeta(m *nats.Msg) outputs.Meta {
+	meta := outputs.Meta{}
+	if m.Header.Get(sourceHeader) != "" || m.Header.Get(subscriptionNameHeader) != "" {
+		if s := m.Header.Get(sourceHeader); s != "" {
+			meta["source"] = s
+		}
+		if s := m.Header.Get(subscriptionNameHeader); s != "" {
+			meta["subscription-name"] = s
+		}
+		return meta
+	}
+	subjectSections := strings.SplitN(m.Subject, ".", 3)
+	if len(subjectSections) == 3 {
+		meta["source"] = strings.ReplaceAll(subjectSections[1], "-", ".")
+		meta["subscription-name"] = subjectSections[2]
+	}
+	return meta
+}
+
+// Close //
+func (n *JetStreamInput) Close() error {
+	formatters.CloseProcessors(n.evps)
+	if n.cfn != nil {
+		n.cfn()
+	}
+	n.wg.Wait()
+	return nil
+}
+
+// SetLogger //
+func (n *JetStreamInput) SetLogger(logger *log.Logger) {
+	if logger != nil && n.logger != nil {
+		n.logger.SetOutput(logger.Writer())
+		n.logger.SetFlags(logger.Flags())
+	}
+}
+
+// SetOutputs //
+func (n *JetStreamInput) SetOutputs(fn inputs.OutputsFunc) {
+	n.outputsFn = fn
+}
+
+func (n *JetStreamInput) SetName(name string) {
+	sb := strings.Builder{}
+	if name != "" {
+		sb.WriteString(name)
+		sb.WriteString("-")
+	}
+	sb.WriteString(n.Cfg.Name)
+	sb.WriteString("-jetstream-sub")
+	n.Cfg.Name = sb.String()
+}
+
+func (n *JetStreamInput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]*types.TargetConfig) {
+	for _, epName := range n.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				if err != nil {
+					n.logger.Printf("failed initializing event processor %q of type=%q: %v", epName, epType, err)
+					continue
+				}
+				n.evps = append(n.evps, ep)
+				n.logger.Printf("added event processor %q of type=%q to jetstream input", epName, epType)
+			}
+		}
+	}
+}
+
+// RegisterMetrics implements inputs.MetricsRegisterer
+func (n *JetStreamInput) RegisterMetrics(reg *prometheus.Registry) {
+	if !n.Cfg.EnableMetrics {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		n.logger.Printf("failed to register metric: %+v", err)
+	}
+}
+
+// helper functions
+
+func (n *JetStreamInput) setDefaults() error {
+	if n.Cfg.Format == "" {
+		n.Cfg.Format = defaultFormat
+	}
+	n.Cfg.Format = strings.ToLower(n.Cfg.Format)
+	if !(n.Cfg.Format == "event" || n.Cfg.Format == "proto") {
+		return fmt.Errorf("unsupported input format %q", n.Cfg.Format)
+	}
+	if n.Cfg.Name == "" {
+		n.Cfg.Name = "gnmic-" + uuid.New().String()
+	}
+	if n.Cfg.Durable == "" {
+		n.Cfg.Durable = n.Cfg.Name
+	}
+	if strings.ContainsAny(n.Cfg.Durable, ".*> ") {
+		return fmt.Errorf("invalid durable consumer name %q", n.Cfg.Durable)
+	}
+	if n.Cfg.Stream == "" {
+		n.Cfg.Stream = defaultStream
+	}
+	if n.Cfg.DeliverPolicy == "" {
+		n.Cfg.DeliverPolicy = defaultDeliverPolicy
+	}
+	n.Cfg.DeliverPolicy = strings.ToLower(n.Cfg.DeliverPolicy)
+	switch n.Cfg.DeliverPolicy {
+	case "all", "new", "last":
+	default:
+		return fmt.Errorf("unsupported deliver-policy %q, must be one of 'all', 'new' or 'last'", n.Cfg.DeliverPolicy)
+	}
+	if n.Cfg.Address == "" {
+		n.Cfg.Address = defaultAddress
+	}
+	if n.Cfg.ConnectTimeWait <= 0 {
+		n.Cfg.ConnectTimeWait = natsConnectWait
+	}
+	if n.Cfg.AckWait <= 0 {
+		n.Cfg.AckWait = defaultAckWait
+	}
+	if n.Cfg.FetchBatchSize <= 0 {
+		n.Cfg.FetchBatchSize = defaultFetchBatchSize
+	}
+	if n.Cfg.FetchWait <= 0 {
+		n.Cfg.FetchWait = defaultFetchWait
+	}
+	if n.Cfg.NumWorkers <= 0 {
+		n.Cfg.NumWorkers = defaultNumWorkers
+	}
+	return nil
+}
+
+func (n *JetStreamInput) createNATSConn(c *Config) (*nats.Conn, error) {
+	opts := []nats.Option{
+		nats.Name(c.Name),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(n.Cfg.ConnectTimeWait),
+		nats.ReconnectBufSize(natsReconnectBufferSize),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			n.logger.Printf("NATS error: %v", err)
+		}),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			n.logger.Printf("Disconnected from NATS: %v", err)
+		}),
+		nats.ClosedHandler(func(c *nats.Conn) {
+			n.logger.Println("NATS connection is closed")
+		}),
+	}
+	if c.Username != "" && c.Password != "" {
+		opts = append(opts, nats.UserInfo(c.Username, c.Password))
+	}
+	if c.TLS != nil {
+		tlsCfg, err := utils.NewTLSConfig(c.TLS.CaFile, c.TLS.CertFile, c.TLS.KeyFile, c.TLS.SkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		if tlsCfg == nil {
+			tlsCfg = &tls.Config{}
+		}
+		opts = append(opts, nats.Secure(tlsCfg))
+	}
+	return nats.Connect(c.Address, opts...)
+}
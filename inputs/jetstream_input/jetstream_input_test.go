@@ -0,0 +1,318 @@
+package jetstream_input
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/inputs"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/types"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+// testOutput records the messages and events written by the input,
+// WriteSync and WriteEventSync fail if err is set
+type testOutput struct {
+	m    sync.Mutex
+	err  error
+	msgs []proto.Message
+	evs  []*formatters.EventMsg
+}
+
+func (o *testOutput) Init(context.Context, string, map[string]interface{}, ...outputs.Option) error {
+	return nil
+}
+func (o *testOutput) Write(_ context.Context, m proto.Message, _ outputs.Meta) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.msgs = append(o.msgs, m)
+}
+func (o *testOutput) WriteSync(ctx context.Context, m proto.Message, meta outputs.Meta) error {
+	if err := o.writeErr(); err != nil {
+		return err
+	}
+	o.Write(ctx, m, meta)
+	return nil
+}
+func (o *testOutput) WriteEvent(_ context.Context, ev *formatters.EventMsg) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.evs = append(o.evs, ev)
+}
+func (o *testOutput) WriteEventSync(ctx context.Context, ev *formatters.EventMsg) error {
+	if err := o.writeErr(); err != nil {
+		return err
+	}
+	o.WriteEvent(ctx, ev)
+	return nil
+}
+func (o *testOutput) Close() error                         { return nil }
+func (o *testOutput) RegisterMetrics(*prometheus.Registry) {}
+func (o *testOutput) String() string                       { return "" }
+func (o *testOutput) SetLogger(*log.Logger)                {}
+func (o *testOutput) SetEventProcessors(map[string]map[string]interface{}, *log.Logger, map[string]*types.TargetConfig) {
+}
+func (o *testOutput) SetName(string)                                  {}
+func (o *testOutput) SetClusterName(string)                           {}
+func (o *testOutput) SetTargetsConfig(map[string]*types.TargetConfig) {}
+
+func (o *testOutput) writeErr() error {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.err
+}
+
+func (o *testOutput) setErr(err error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.err = err
+}
+
+func (o *testOutput) numMsgs() int {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return len(o.msgs)
+}
+
+// startServer starts an in-process NATS server with JetStream enabled
+func startServer(t *testing.T, dir string) *server.Server {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		NoLog:     true,
+		NoSigs:    true,
+		JetStream: true,
+		StoreDir:  dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	return s
+}
+
+func TestRedelivery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jetstream_input")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := startServer(t, dir)
+	defer s.Shutdown()
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = js.AddStream(&nats.StreamConfig{
+		Name:     "gnmic",
+		Subjects: []string{"telemetry.>"},
+		Storage:  nats.MemoryStorage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := proto.Marshal(&gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{Timestamp: 42},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = js.Publish("telemetry.router1.sub1", b); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the output fails to write the message, it is not acknowledged
+	out := &testOutput{err: errors.New("output down")}
+	n := &JetStreamInput{Cfg: &Config{}, logger: log.New(ioutil.Discard, "", 0), wg: new(sync.WaitGroup)}
+	err = n.Start(ctx, "relay", map[string]interface{}{
+		"address":    s.ClientURL(),
+		"stream":     "gnmic",
+		"subject":    "telemetry.>",
+		"format":     "proto",
+		"fetch-wait": "100ms",
+	}, inputs.WithOutputs(map[string]outputs.Output{"out1": out}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+	time.Sleep(300 * time.Millisecond)
+	if out.numMsgs() != 0 {
+		t.Fatalf("got %d written msgs while the output is down", out.numMsgs())
+	}
+	// the message is redelivered once the output recovers
+	out.setErr(nil)
+	deadline := time.Now().Add(5 * time.Second)
+	for out.numMsgs() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("message not redelivered after the output recovered")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	for {
+		ci, err := js.ConsumerInfo("gnmic", "relay")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ci.NumAckPending == 0 && ci.NumPending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("message not acknowledged: %+v", ci)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestHandleMessage(t *testing.T) {
+	rsp := &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{Timestamp: 42},
+		},
+	}
+	b, err := proto.Marshal(rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		data       []byte
+		outErr     error
+		wantDecode bool
+		wantErr    bool
+		wantMsgs   int
+	}{
+		{name: "written", data: b, wantMsgs: 1},
+		{name: "invalid message", data: []byte("not proto"), wantErr: true, wantDecode: true},
+		{name: "output failure", data: b, outErr: errors.New("output down"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &testOutput{err: tt.outErr}
+			n := &JetStreamInput{
+				Cfg:    &Config{Format: "proto"},
+				logger: log.New(ioutil.Discard, "", 0),
+			}
+			inputs.WithOutputs(map[string]outputs.Output{"out1": out})(n)
+			msg := nats.NewMsg("telemetry")
+			msg.Data = tt.data
+			err := n.handleMessage(context.Background(), msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if errors.Is(err, errDecode) != tt.wantDecode {
+				t.Errorf("got error %v, want decode error: %v", err, tt.wantDecode)
+			}
+			if len(out.msgs) != tt.wantMsgs {
+				t.Errorf("got %d written msgs, want %d", len(out.msgs), tt.wantMsgs)
+			}
+		})
+	}
+}
+
+func TestHandleEventMessage(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		outErr     error
+		wantDecode bool
+		wantErr    bool
+		wantEvents int
+	}{
+		{name: "written", data: []byte(`[{"name":"sub1"},{"name":"sub1"}]`), wantEvents: 2},
+		{name: "invalid message", data: []byte("not json"), wantErr: true, wantDecode: true},
+		{name: "output failure", data: []byte(`[{"name":"sub1"}]`), outErr: errors.New("output down"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := &testOutput{err: tt.outErr}
+			n := &JetStreamInput{
+				Cfg:    &Config{Format: "event"},
+				logger: log.New(ioutil.Discard, "", 0),
+			}
+			inputs.WithOutputs(map[string]outputs.Output{"out1": out})(n)
+			msg := nats.NewMsg("telemetry")
+			msg.Data = tt.data
+			err := n.handleMessage(context.Background(), msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if errors.Is(err, errDecode) != tt.wantDecode {
+				t.Errorf("got error %v, want decode error: %v", err, tt.wantDecode)
+			}
+			if len(out.evs) != tt.wantEvents {
+				t.Errorf("got %d written events, want %d", len(out.evs), tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestMsgMeta(t *testing.T) {
+	msg := nats.NewMsg("telemetry.router1.sub1")
+	msg.Header.Set(sourceHeader, "router1:57400")
+	msg.Header.Set(subscriptionNameHeader, "sub1")
+	meta := msgMeta(msg)
+	if meta["source"] != "router1:57400" || meta["subscription-name"] != "sub1" {
+		t.Errorf("unexpected meta from headers: %v", meta)
+	}
+	meta = msgMeta(&nats.Msg{Subject: "telemetry.10-1-1-1:57400.sub1"})
+	if meta["source"] != "10.1.1.1:57400" || meta["subscription-name"] != "sub1" {
+		t.Errorf("unexpected meta from subject: %v", meta)
+	}
+}
+
+func TestSetDefaults(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *Config
+		wantErr bool
+	}{
+		{name: "defaults", cfg: &Config{Name: "relay"}},
+		{name: "deliver new", cfg: &Config{Name: "relay", DeliverPolicy: "New"}},
+		{name: "unsupported format", cfg: &Config{Format: "json"}, wantErr: true},
+		{name: "unsupported deliver policy", cfg: &Config{DeliverPolicy: "first"}, wantErr: true},
+		{name: "invalid durable name", cfg: &Config{Durable: "gnmic.relay"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &JetStreamInput{Cfg: tt.cfg}
+			err := n.setDefaults()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if n.Cfg.Durable != "relay" || n.Cfg.Stream != defaultStream || n.Cfg.AckWait != defaultAckWait {
+				t.Errorf("unexpected defaults: %+v", n.Cfg)
+			}
+			cc := n.consumerConfig()
+			if cc.AckPolicy != nats.AckExplicitPolicy || cc.Durable != "relay" {
+				t.Errorf("unexpected consumer config: %+v", cc)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package jetstream_input
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var JetStreamInputNumberOfReceivedMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "jetstream_input",
+	Name:      "number_of_jetstream_msgs_received_total",
+	Help:      "Number of msgs received by gnmic jetstream input",
+}, []string{"consumer_id", "subject"})
+
+var JetStreamInputNumberOfReceivedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "jetstream_input",
+	Name:      "number_of_received_jetstream_bytes_total",
+	Help:      "Number of bytes received by gnmic jetstream input",
+}, []string{"consumer_id", "subject"})
+
+var JetStreamInputNumberOfFailedMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "jetstream_input",
+	Name:      "number_of_jetstream_msgs_failed_total",
+	Help:      "Number of msgs received by gnmic jetstream input that could not be decoded or written",
+}, []string{"consumer_id", "reason"})
+
+func initMetrics() {
+	JetStreamInputNumberOfReceivedMsgs.WithLabelValues("", "").Add(0)
+	JetStreamInputNumberOfReceivedBytes.WithLabelValues("", "").Add(0)
+	JetStreamInputNumberOfFailedMsgs.WithLabelValues("", "").Add(0)
+}
+
+func registerMetrics(reg *prometheus.Registry) error {
+	initMetrics()
+	var err error
+	if err = reg.Register(JetStreamInputNumberOfReceivedMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(JetStreamInputNumberOfReceivedBytes); err != nil {
+		return err
+	}
+	if err = reg.Register(JetStreamInputNumberOfFailedMsgs); err != nil {
+		return err
+	}
+	return nil
+}
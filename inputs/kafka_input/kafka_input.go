@@ -47,12 +47,12 @@ func init() {
 
 // KafkaInput //
 type KafkaInput struct {
-	Cfg     *Config
-	cfn     context.CancelFunc
-	logger  sarama.StdLogger
-	wg      *sync.WaitGroup
-	outputs []outputs.Output
-	evps    []formatters.EventProcessor
+	Cfg       *Config
+	cfn       context.CancelFunc
+	logger    sarama.StdLogger
+	wg        *sync.WaitGroup
+	outputsFn inputs.OutputsFunc
+	evps      []formatters.EventProcessor
 }
 
 // Config //
@@ -97,6 +97,7 @@ func (k *KafkaInput) Start(ctx context.Context, name string, cfg map[string]inte
 	if err != nil {
 		return err
 	}
+	ctx, k.cfn = context.WithCancel(ctx)
 	k.wg.Add(k.Cfg.NumWorkers)
 	for i := 0; i < k.Cfg.NumWorkers; i++ {
 		go k.worker(ctx, i)
@@ -110,6 +111,9 @@ func (k *KafkaInput) worker(ctx context.Context, idx int) {
 	config := k.createConfig(idx)
 	workerLogPrefix := fmt.Sprintf("worker-%d", idx)
 START:
+	if ctx.Err() != nil {
+		return
+	}
 	k.logger.Printf("%s starting consumer group %s", workerLogPrefix, k.Cfg.GroupID)
 	consumerGrp, err := sarama.NewConsumerGroup(strings.Split(k.Cfg.Address, ","), k.Cfg.GroupID, config)
 	if err != nil {
@@ -139,7 +143,11 @@ START:
 			cons.ready = make(chan bool)
 		}
 	}()
-	<-cons.ready
+	select {
+	case <-ctx.Done():
+		return
+	case <-cons.ready:
+	}
 	k.logger.Printf("%s kafka consumer ready", workerLogPrefix)
 	for {
 		select {
@@ -168,7 +176,7 @@ START:
 				}
 
 				go func() {
-					for _, o := range k.outputs {
+					for _, o := range inputs.SelectOutputs(k.outputsFn, k.Cfg.Outputs) {
 						for _, ev := range evMsgs {
 							o.WriteEvent(ctx, ev)
 						}
@@ -185,7 +193,7 @@ START:
 				}
 				meta := outputs.Meta{}
 				go func() {
-					for _, o := range k.outputs {
+					for _, o := range inputs.SelectOutputs(k.outputsFn, k.Cfg.Outputs) {
 						o.Write(ctx, protoMsg, meta)
 					}
 				}()
@@ -199,7 +207,10 @@ START:
 }
 
 func (k *KafkaInput) Close() error {
-	k.cfn()
+	formatters.CloseProcessors(k.evps)
+	if k.cfn != nil {
+		k.cfn()
+	}
 	k.wg.Wait()
 	return nil
 }
@@ -211,18 +222,8 @@ func (k *KafkaInput) SetLogger(logger *log.Logger) {
 	}
 }
 
-func (k *KafkaInput) SetOutputs(outs map[string]outputs.Output) {
-	if len(k.Cfg.Outputs) == 0 {
-		for _, o := range outs {
-			k.outputs = append(k.outputs, o)
-		}
-		return
-	}
-	for _, name := range k.Cfg.Outputs {
-		if o, ok := outs[name]; ok {
-			k.outputs = append(k.outputs, o)
-		}
-	}
+func (k *KafkaInput) SetOutputs(fn inputs.OutputsFunc) {
+	k.outputsFn = fn
 }
 
 func (k *KafkaInput) SetName(name string) {
@@ -246,7 +247,7 @@ func (k *KafkaInput) SetEventProcessors(ps map[string]map[string]interface{}, lo
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
 				if err != nil {
 					k.logger.Printf("failed initializing event processor %q of type=%q: %v", epName, epType, err)
 					continue
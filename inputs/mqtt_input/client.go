@@ -0,0 +1,180 @@
+package mqtt_input
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/karimra/gnmic/utils"
+)
+
+// message is a received MQTT message
+type message struct {
+	topic   string
+	payload []byte
+	// MQTT 5 user properties
+	props map[string]string
+}
+
+// subscriber subscribes to a topic using either MQTT 3.1.1 or MQTT 5,
+// the subscription is renewed each time the client reconnects.
+type subscriber interface {
+	close()
+}
+
+// newSubscriber connects to the broker and calls handler with each message received on cfg.Topic
+func newSubscriber(ctx context.Context, cfg *Config, logger *log.Logger, handler func(*message)) (subscriber, error) {
+	var tlsCfg *tls.Config
+	var err error
+	if cfg.TLS != nil {
+		tlsCfg, err = utils.NewTLSConfig(cfg.TLS.CaFile, cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.SkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		if tlsCfg == nil {
+			tlsCfg = new(tls.Config)
+		}
+	}
+	brokerURL, err := parseBrokerURL(cfg.Address, tlsCfg != nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ProtocolVersion == 5 {
+		return newV5Subscriber(ctx, cfg, brokerURL, tlsCfg, logger, handler)
+	}
+	return newV3Subscriber(cfg, brokerURL, tlsCfg, logger, handler), nil
+}
+
+// parseBrokerURL parses the broker address, a scheme is added to addresses without one
+func parseBrokerURL(addr string, useTLS bool) (*url.URL, error) {
+	if !strings.Contains(addr, "://") {
+		if useTLS {
+			addr = "tls://" + addr
+		} else {
+			addr = "tcp://" + addr
+		}
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT broker address %q: %v", addr, err)
+	}
+	return u, nil
+}
+
+// MQTT 3.1.1 subscriber
+
+type v3Subscriber struct {
+	client mqtt.Client
+}
+
+func newV3Subscriber(cfg *Config, brokerURL *url.URL, tlsCfg *tls.Config, logger *log.Logger, handler func(*message)) *v3Subscriber {
+	onMsg := func(_ mqtt.Client, m mqtt.Message) {
+		handler(&message{topic: m.Topic(), payload: m.Payload()})
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(brokerURL.String()).
+		SetClientID(cfg.ClientID).
+		SetProtocolVersion(4).
+		SetKeepAlive(cfg.KeepAlive).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(cfg.ConnectTimeWait).
+		SetMaxReconnectInterval(cfg.ConnectTimeWait).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			logger.Printf("connected to MQTT broker %s, subscribing to %q", brokerURL.Host, cfg.Topic)
+			token := c.Subscribe(cfg.Topic, cfg.QoS, onMsg)
+			go func() {
+				token.Wait()
+				if err := token.Error(); err != nil {
+					logger.Printf("failed to subscribe to %q: %v", cfg.Topic, err)
+				}
+			}()
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logger.Printf("connection to MQTT broker %s lost: %v", brokerURL.Host, err)
+		})
+	if cfg.Username != "" {
+		opts.SetUsername(cfg.Username)
+		opts.SetPassword(cfg.Password)
+	}
+	if tlsCfg != nil {
+		opts.SetTLSConfig(tlsCfg)
+	}
+	s := &v3Subscriber{client: mqtt.NewClient(opts)}
+	// with ConnectRetry set, the connection is retried in the background until it succeeds
+	s.client.Connect()
+	return s
+}
+
+func (s *v3Subscriber) close() {
+	s.client.Disconnect(uint(time.Second / time.Millisecond))
+}
+
+// MQTT 5 subscriber
+
+type v5Subscriber struct {
+	cm *autopaho.ConnectionManager
+}
+
+func newV5Subscriber(ctx context.Context, cfg *Config, brokerURL *url.URL, tlsCfg *tls.Config, logger *log.Logger, handler func(*message)) (*v5Subscriber, error) {
+	cliCfg := autopaho.ClientConfig{
+		BrokerUrls:        []*url.URL{brokerURL},
+		TlsCfg:            tlsCfg,
+		KeepAlive:         uint16(cfg.KeepAlive.Seconds()),
+		ConnectRetryDelay: cfg.ConnectTimeWait,
+		OnConnectionUp: func(cm *autopaho.ConnectionManager, _ *paho.Connack) {
+			logger.Printf("connected to MQTT broker %s, subscribing to %q", brokerURL.Host, cfg.Topic)
+			_, err := cm.Subscribe(ctx, &paho.Subscribe{
+				Subscriptions: map[string]paho.SubscribeOptions{
+					cfg.Topic: {QoS: cfg.QoS},
+				},
+			})
+			if err != nil {
+				logger.Printf("failed to subscribe to %q: %v", cfg.Topic, err)
+			}
+		},
+		OnConnectError: func(err error) {
+			logger.Printf("failed to connect to MQTT broker %s: %v", brokerURL.Host, err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID: cfg.ClientID,
+			Router: paho.NewSingleHandlerRouter(func(p *paho.Publish) {
+				m := &message{topic: p.Topic, payload: p.Payload}
+				if p.Properties != nil && len(p.Properties.User) > 0 {
+					m.props = make(map[string]string, len(p.Properties.User))
+					for _, u := range p.Properties.User {
+						m.props[u.Key] = u.Value
+					}
+				}
+				handler(m)
+			}),
+			OnClientError: func(err error) {
+				logger.Printf("MQTT client error: %v", err)
+			},
+			OnServerDisconnect: func(d *paho.Disconnect) {
+				logger.Printf("disconnected by MQTT broker %s: reason code %d", brokerURL.Host, d.ReasonCode)
+			},
+		},
+	}
+	if cfg.Username != "" {
+		cliCfg.SetUsernamePassword(cfg.Username, []byte(cfg.Password))
+	}
+	cm, err := autopaho.NewConnection(ctx, cliCfg)
+	if err != nil {
+		return nil, err
+	}
+	return &v5Subscriber{cm: cm}, nil
+}
+
+func (s *v5Subscriber) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.cm.Disconnect(ctx)
+}
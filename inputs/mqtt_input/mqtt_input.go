@@ -0,0 +1,345 @@
+package mqtt_input
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/inputs"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/types"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	loggingPrefix          = "[mqtt_input] "
+	defaultAddress         = "localhost:1883"
+	defaultFormat          = "event"
+	defaultTopic           = "telemetry/#"
+	defaultProtocolVersion = 4
+	defaultNumWorkers      = 1
+	defaultBufferSize      = 100
+	defaultKeepAlive       = 30 * time.Second
+	mqttConnectWait        = 2 * time.Second
+)
+
+func init() {
+	inputs.Register("mqtt", func() inputs.Input {
+		return &MqttInput{
+			Cfg:    &Config{},
+			logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+			wg:     new(sync.WaitGroup),
+		}
+	})
+}
+
+// MqttInput //
+type MqttInput struct {
+	Cfg    *Config
+	cfn    context.CancelFunc
+	logger *log.Logger
+
+	wg        *sync.WaitGroup
+	msgChan   chan *message
+	sub       subscriber
+	outputsFn inputs.OutputsFunc
+	evps      []formatters.EventProcessor
+}
+
+// Config //
+type Config struct {
+	Name            string        `mapstructure:"name,omitempty"`
+	Address         string        `mapstructure:"address,omitempty"`
+	ProtocolVersion int           `mapstructure:"protocol-version,omitempty"`
+	ClientID        string        `mapstructure:"client-id,omitempty"`
+	Username        string        `mapstructure:"username,omitempty"`
+	Password        string        `mapstructure:"password,omitempty"`
+	TLS             *tlsConfig    `mapstructure:"tls,omitempty"`
+	Topic           string        `mapstructure:"topic,omitempty"`
+	QoS             byte          `mapstructure:"qos,omitempty"`
+	KeepAlive       time.Duration `mapstructure:"keep-alive,omitempty"`
+	ConnectTimeWait time.Duration `mapstructure:"connect-time-wait,omitempty"`
+	Format          string        `mapstructure:"format,omitempty"`
+	Debug           bool          `mapstructure:"debug,omitempty"`
+	NumWorkers      int           `mapstructure:"num-workers,omitempty"`
+	BufferSize      int           `mapstructure:"buffer-size,omitempty"`
+	Outputs         []string      `mapstructure:"outputs,omitempty"`
+	EventProcessors []string      `mapstructure:"event-processors,omitempty"`
+}
+
+type tlsConfig struct {
+	CaFile     string `mapstructure:"ca-file,omitempty"`
+	CertFile   string `mapstructure:"cert-file,omitempty"`
+	KeyFile    string `mapstructure:"key-file,omitempty"`
+	SkipVerify bool   `mapstructure:"skip-verify,omitempty"`
+}
+
+// Start //
+func (m *MqttInput) Start(ctx context.Context, name string, cfg map[string]interface{}, opts ...inputs.Option) error {
+	err := outputs.DecodeConfig(cfg, m.Cfg)
+	if err != nil {
+		return err
+	}
+	if m.Cfg.Name == "" {
+		m.Cfg.Name = name
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	err = m.setDefaults()
+	if err != nil {
+		return err
+	}
+	ctx, m.cfn = context.WithCancel(ctx)
+	m.logger.Printf("input starting with config: %+v", m.Cfg)
+	m.msgChan = make(chan *message, m.Cfg.BufferSize)
+	m.sub, err = newSubscriber(ctx, m.Cfg, m.logger, func(msg *message) {
+		select {
+		case <-ctx.Done():
+		case m.msgChan <- msg:
+		}
+	})
+	if err != nil {
+		m.cfn()
+		return err
+	}
+	m.wg.Add(m.Cfg.NumWorkers)
+	for i := 0; i < m.Cfg.NumWorkers; i++ {
+		go m.worker(ctx, i)
+	}
+	return nil
+}
+
+func (m *MqttInput) worker(ctx context.Context, idx int) {
+	defer m.wg.Done()
+	workerLogPrefix := fmt.Sprintf("worker-%d", idx)
+	m.logger.Printf("%s starting", workerLogPrefix)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-m.msgChan:
+			if len(msg.payload) == 0 {
+				continue
+			}
+			if m.Cfg.Debug {
+				m.logger.Printf("received msg, topic=%s, len=%d, data=%s", msg.topic, len(msg.payload), string(msg.payload))
+			}
+			switch m.Cfg.Format {
+			case "event":
+				evMsgs, err := decodeEvents(msg.payload)
+				if err != nil {
+					if m.Cfg.Debug {
+						m.logger.Printf("%s failed to unmarshal event msg: %v", workerLogPrefix, err)
+					}
+					continue
+				}
+				for _, p := range m.evps {
+					evMsgs = p.Apply(evMsgs...)
+				}
+				go func() {
+					for _, o := range inputs.SelectOutputs(m.outputsFn, m.Cfg.Outputs) {
+						for _, ev := range evMsgs {
+							o.WriteEvent(ctx, ev)
+						}
+					}
+				}()
+			default:
+				rsp, meta, err := decodeResponse(m.Cfg.Format, msg)
+				if err != nil {
+					if m.Cfg.Debug {
+						m.logger.Printf("%s failed to unmarshal %s msg: %v", workerLogPrefix, m.Cfg.Format, err)
+					}
+					continue
+				}
+				go func() {
+					for _, o := range inputs.SelectOutputs(m.outputsFn, m.Cfg.Outputs) {
+						o.Write(ctx, rsp, meta)
+					}
+				}()
+			}
+		}
+	}
+}
+
+// Close //
+func (m *MqttInput) Close() error {
+	formatters.CloseProcessors(m.evps)
+	if m.cfn != nil {
+		m.cfn()
+	}
+	m.wg.Wait()
+	if m.sub != nil {
+		m.sub.close()
+	}
+	return nil
+}
+
+// SetLogger //
+func (m *MqttInput) SetLogger(logger *log.Logger) {
+	if logger != nil && m.logger != nil {
+		m.logger.SetOutput(logger.Writer())
+		m.logger.SetFlags(logger.Flags())
+	}
+}
+
+// SetOutputs //
+func (m *MqttInput) SetOutputs(fn inputs.OutputsFunc) {
+	m.outputsFn = fn
+}
+
+func (m *MqttInput) SetName(name string) {
+	sb := strings.Builder{}
+	if name != "" {
+		sb.WriteString(name)
+		sb.WriteString("-")
+	}
+	sb.WriteString(m.Cfg.Name)
+	sb.WriteString("-mqtt-sub")
+	m.Cfg.Name = sb.String()
+}
+
+func (m *MqttInput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]*types.TargetConfig) {
+	for _, epName := range m.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				if err != nil {
+					m.logger.Printf("failed initializing event processor %q of type=%q: %v", epName, epType, err)
+					continue
+				}
+				m.evps = append(m.evps, ep)
+				m.logger.Printf("added event processor %q of type=%q to mqtt input", epName, epType)
+			}
+		}
+	}
+}
+
+// helper functions
+
+func (m *MqttInput) setDefaults() error {
+	if m.Cfg.Format == "" {
+		m.Cfg.Format = defaultFormat
+	}
+	m.Cfg.Format = strings.ToLower(m.Cfg.Format)
+	switch m.Cfg.Format {
+	case "event", "proto", "protojson", "json":
+	default:
+		return fmt.Errorf("unsupported input format %q", m.Cfg.Format)
+	}
+	if m.Cfg.ProtocolVersion == 0 {
+		m.Cfg.ProtocolVersion = defaultProtocolVersion
+	}
+	if m.Cfg.ProtocolVersion != 4 && m.Cfg.ProtocolVersion != 5 {
+		return fmt.Errorf("unsupported MQTT protocol-version %d, must be 4 (MQTT 3.1.1) or 5", m.Cfg.ProtocolVersion)
+	}
+	if m.Cfg.QoS > 2 {
+		return fmt.Errorf("invalid MQTT qos %d, must be 0, 1 or 2", m.Cfg.QoS)
+	}
+	if m.Cfg.Name == "" {
+		m.Cfg.Name = "gnmic-" + uuid.New().String()
+	}
+	if m.Cfg.ClientID == "" {
+		m.Cfg.ClientID = m.Cfg.Name
+	}
+	if m.Cfg.Topic == "" {
+		m.Cfg.Topic = defaultTopic
+	}
+	if m.Cfg.Address == "" {
+		m.Cfg.Address = defaultAddress
+	}
+	if m.Cfg.KeepAlive <= 0 {
+		m.Cfg.KeepAlive = defaultKeepAlive
+	}
+	if m.Cfg.ConnectTimeWait <= 0 {
+		m.Cfg.ConnectTimeWait = mqttConnectWait
+	}
+	if m.Cfg.NumWorkers <= 0 {
+		m.Cfg.NumWorkers = defaultNumWorkers
+	}
+	if m.Cfg.BufferSize <= 0 {
+		m.Cfg.BufferSize = defaultBufferSize
+	}
+	return nil
+}
+
+func decodeEvents(b []byte) ([]*formatters.EventMsg, error) {
+	evMsgs := make([]*formatters.EventMsg, 0, 1)
+	err := json.Unmarshal(b, &evMsgs)
+	if err != nil {
+		return nil, err
+	}
+	return evMsgs, nil
+}
+
+// decodeResponse decodes a proto, protojson or json formatted message into a SubscribeResponse.
+// For the proto and protojson formats, the source and subscription name are read from the MQTT 5
+// user properties if present, otherwise from the last 2 levels of a topic built with a topic-prefix.
+func decodeResponse(format string, msg *message) (*gnmi.SubscribeResponse, outputs.Meta, error) {
+	switch format {
+	case "proto", "protojson":
+		rsp := new(gnmi.SubscribeResponse)
+		var err error
+		if format == "proto" {
+			err = proto.Unmarshal(msg.payload, rsp)
+		} else {
+			err = protojson.Unmarshal(msg.payload, rsp)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		meta := outputs.Meta{}
+		if msg.props != nil {
+			for _, k := range []string{"source", "subscription-name"} {
+				if v, ok := msg.props[k]; ok {
+					meta[k] = v
+				}
+			}
+			return rsp, meta, nil
+		}
+		levels := strings.Split(msg.topic, "/")
+		if len(levels) >= 3 {
+			meta["source"] = levels[len(levels)-2]
+			meta["subscription-name"] = levels[len(levels)-1]
+		}
+		return rsp, meta, nil
+	case "json":
+		nmsg := new(formatters.NotificationRspMsg)
+		err := json.Unmarshal(msg.payload, nmsg)
+		if err != nil {
+			return nil, nil, err
+		}
+		rsp, err := nmsg.ToSubscribeResponse()
+		if err != nil {
+			return nil, nil, err
+		}
+		meta := outputs.Meta{}
+		if nmsg.Source != "" {
+			meta["source"] = nmsg.Source
+		}
+		if nmsg.SystemName != "" {
+			meta["system-name"] = nmsg.SystemName
+		}
+		if nmsg.SubscriptionName != "" {
+			meta["subscription-name"] = nmsg.SubscriptionName
+		}
+		return rsp, meta, nil
+	}
+	return nil, nil, errors.New("unsupported format")
+}
@@ -0,0 +1,103 @@
+package mqtt_input
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/karimra/gnmic/outputs"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var testResponse = &gnmi.SubscribeResponse{
+	Response: &gnmi.SubscribeResponse_Update{
+		Update: &gnmi.Notification{
+			Timestamp: 42,
+			Prefix:    &gnmi.Path{Target: "router1", Elem: []*gnmi.PathElem{{Name: "system"}}},
+			Update: []*gnmi.Update{
+				{
+					Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: "name"}}},
+					Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: "router1"}},
+				},
+			},
+		},
+	},
+}
+
+func TestDecodeResponse(t *testing.T) {
+	pb, err := proto.Marshal(testResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pjson, err := protojson.Marshal(testResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		format   string
+		msg      *message
+		wantMeta outputs.Meta
+	}{
+		{
+			name:   "proto with user properties",
+			format: "proto",
+			msg: &message{
+				topic:   "telemetry",
+				payload: pb,
+				props:   map[string]string{"source": "router1:57400", "subscription-name": "sub1", "other": "x"},
+			},
+			wantMeta: outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"},
+		},
+		{
+			name:     "proto with prefixed topic",
+			format:   "proto",
+			msg:      &message{topic: "telemetry/router1:57400/sub1", payload: pb},
+			wantMeta: outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"},
+		},
+		{
+			name:     "protojson with fixed topic",
+			format:   "protojson",
+			msg:      &message{topic: "telemetry", payload: pjson},
+			wantMeta: outputs.Meta{},
+		},
+		{
+			name:   "json",
+			format: "json",
+			msg: &message{
+				topic:   "telemetry",
+				payload: []byte(`{"source":"router1:57400","subscription-name":"sub1","timestamp":42,"prefix":"system","target":"router1","updates":[{"Path":"name","values":{"name":"router1"}}]}`),
+			},
+			wantMeta: outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp, meta, err := decodeResponse(tt.format, tt.msg)
+			if err != nil {
+				t.Fatalf("failed to decode: %v", err)
+			}
+			if !proto.Equal(rsp, testResponse) {
+				t.Errorf("unexpected response:\ngot:  %v\nwant: %v", rsp, testResponse)
+			}
+			if !reflect.DeepEqual(meta, tt.wantMeta) {
+				t.Errorf("got meta %v, want %v", meta, tt.wantMeta)
+			}
+		})
+	}
+}
+
+func TestDecodeEvents(t *testing.T) {
+	evs, err := decodeEvents([]byte(`[{"name":"sub1","timestamp":42,"tags":{"source":"router1"},"values":{"/system/name":"router1"}}]`))
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if len(evs) != 1 || evs[0].Name != "sub1" || evs[0].Tags["source"] != "router1" || evs[0].Values["/system/name"] != "router1" {
+		t.Errorf("unexpected events: %+v", evs)
+	}
+	_, err = decodeEvents([]byte(`{"name":"sub1"}`))
+	if err == nil {
+		t.Error("expected an error decoding a non list message")
+	}
+}
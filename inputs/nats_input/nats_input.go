@@ -48,9 +48,9 @@ type NatsInput struct {
 	cfn    context.CancelFunc
 	logger *log.Logger
 
-	wg      *sync.WaitGroup
-	outputs []outputs.Output
-	evps    []formatters.EventProcessor
+	wg        *sync.WaitGroup
+	outputsFn inputs.OutputsFunc
+	evps      []formatters.EventProcessor
 }
 
 // Config //
@@ -90,12 +90,13 @@ func (n *NatsInput) Start(ctx context.Context, name string, cfg map[string]inter
 	n.logger.Printf("input starting with config: %+v", n.Cfg)
 	n.wg.Add(n.Cfg.NumWorkers)
 	for i := 0; i < n.Cfg.NumWorkers; i++ {
-		go n.worker(ctx, i)
+		go n.worker(n.ctx, i)
 	}
 	return nil
 }
 
 func (n *NatsInput) worker(ctx context.Context, idx int) {
+	defer n.wg.Done()
 	var nc *nats.Conn
 	var err error
 	var msgChan chan *nats.Msg
@@ -104,6 +105,9 @@ func (n *NatsInput) worker(ctx context.Context, idx int) {
 	cfg := *n.Cfg
 	cfg.Name = fmt.Sprintf("%s-%d", cfg.Name, idx)
 START:
+	if ctx.Err() != nil {
+		return
+	}
 	nc, err = n.createNATSConn(&cfg)
 	if err != nil {
 		n.logger.Printf("%s failed to create NATS connection: %v", workerLogPrefix, err)
@@ -156,7 +160,7 @@ START:
 				}
 
 				go func() {
-					for _, o := range n.outputs {
+					for _, o := range inputs.SelectOutputs(n.outputsFn, n.Cfg.Outputs) {
 						for _, ev := range evMsgs {
 							o.WriteEvent(ctx, ev)
 						}
@@ -178,7 +182,7 @@ START:
 					meta["subscription-name"] = subjectSections[2]
 				}
 				go func() {
-					for _, o := range n.outputs {
+					for _, o := range inputs.SelectOutputs(n.outputsFn, n.Cfg.Outputs) {
 						o.Write(ctx, protoMsg, meta)
 					}
 				}()
@@ -190,7 +194,10 @@ START:
 
 // Close //
 func (n *NatsInput) Close() error {
-	n.cfn()
+	formatters.CloseProcessors(n.evps)
+	if n.cfn != nil {
+		n.cfn()
+	}
 	n.wg.Wait()
 	return nil
 }
@@ -204,18 +211,8 @@ func (n *NatsInput) SetLogger(logger *log.Logger) {
 }
 
 // SetOutputs //
-func (n *NatsInput) SetOutputs(outs map[string]outputs.Output) {
-	if len(n.Cfg.Outputs) == 0 {
-		for _, o := range outs {
-			n.outputs = append(n.outputs, o)
-		}
-		return
-	}
-	for _, name := range n.Cfg.Outputs {
-		if o, ok := outs[name]; ok {
-			n.outputs = append(n.outputs, o)
-		}
-	}
+func (n *NatsInput) SetOutputs(fn inputs.OutputsFunc) {
+	n.outputsFn = fn
 }
 
 func (n *NatsInput) SetName(name string) {
@@ -239,7 +236,7 @@ func (n *NatsInput) SetEventProcessors(ps map[string]map[string]interface{}, log
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
 				if err != nil {
 					n.logger.Printf("failed initializing event processor %q of type=%q: %v", epName, epType, err)
 					continue
@@ -0,0 +1,457 @@
+package redis_input
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/inputs"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/types"
+	"github.com/karimra/gnmic/utils"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	loggingPrefix            = "[redis_input] "
+	defaultAddress           = "localhost:6379"
+	defaultStream            = "telemetry:*"
+	defaultGroup             = "gnmic-consumers"
+	defaultStartID           = "$"
+	defaultFormat            = "event"
+	defaultNumWorkers        = 1
+	defaultCount             = 100
+	defaultBlock             = time.Second
+	defaultDiscoveryInterval = 10 * time.Second
+	defaultRecoveryWaitTime  = 2 * time.Second
+
+	// stream entries fields, as set by the redis output
+	dataField             = "data"
+	sourceField           = "source"
+	subscriptionNameField = "subscription-name"
+)
+
+func init() {
+	inputs.Register("redis", func() inputs.Input {
+		return &RedisInput{
+			Cfg:    &Config{},
+			logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+			wg:     new(sync.WaitGroup),
+			groups: make(map[string]struct{}),
+		}
+	})
+}
+
+// RedisInput reads messages from Redis streams as part of a consumer group
+type RedisInput struct {
+	Cfg    *Config
+	cfn    context.CancelFunc
+	logger *log.Logger
+	wg     *sync.WaitGroup
+	client *redis.Client
+
+	m sync.Mutex
+	// streams the consumer group was created on
+	groups map[string]struct{}
+
+	outputsFn inputs.OutputsFunc
+	evps      []formatters.EventProcessor
+}
+
+// Config //
+type Config struct {
+	Name              string        `mapstructure:"name,omitempty"`
+	Address           string        `mapstructure:"address,omitempty"`
+	Username          string        `mapstructure:"username,omitempty"`
+	Password          string        `mapstructure:"password,omitempty"`
+	DB                int           `mapstructure:"db,omitempty"`
+	TLS               *tlsConfig    `mapstructure:"tls,omitempty"`
+	Streams           []string      `mapstructure:"streams,omitempty"`
+	Group             string        `mapstructure:"group,omitempty"`
+	StartID           string        `mapstructure:"start-id,omitempty"`
+	Count             int64         `mapstructure:"count,omitempty"`
+	Block             time.Duration `mapstructure:"block,omitempty"`
+	DiscoveryInterval time.Duration `mapstructure:"discovery-interval,omitempty"`
+	RecoveryWaitTime  time.Duration `mapstructure:"recovery-wait-time,omitempty"`
+	Format            string        `mapstructure:"format,omitempty"`
+	Debug             bool          `mapstructure:"debug,omitempty"`
+	NumWorkers        int           `mapstructure:"num-workers,omitempty"`
+	EnableMetrics     bool          `mapstructure:"enable-metrics,omitempty"`
+	Outputs           []string      `mapstructure:"outputs,omitempty"`
+	EventProcessors   []string      `mapstructure:"event-processors,omitempty"`
+}
+
+type tlsConfig struct {
+	CaFile     string `mapstructure:"ca-file,omitempty"`
+	CertFile   string `mapstructure:"cert-file,omitempty"`
+	KeyFile    string `mapstructure:"key-file,omitempty"`
+	SkipVerify bool   `mapstructure:"skip-verify,omitempty"`
+}
+
+// Start //
+func (r *RedisInput) Start(ctx context.Context, name string, cfg map[string]interface{}, opts ...inputs.Option) error {
+	err := outputs.DecodeConfig(cfg, r.Cfg)
+	if err != nil {
+		return err
+	}
+	if r.Cfg.Name == "" {
+		r.Cfg.Name = name
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	err = r.setDefaults()
+	if err != nil {
+		return err
+	}
+	r.client, err = r.createClient()
+	if err != nil {
+		return err
+	}
+	initMetrics()
+	ctx, r.cfn = context.WithCancel(ctx)
+	r.logger.Printf("input starting with config: %+v", r.Cfg)
+	r.wg.Add(r.Cfg.NumWorkers)
+	for i := 0; i < r.Cfg.NumWorkers; i++ {
+		go r.worker(ctx, i)
+	}
+	return nil
+}
+
+func (r *RedisInput) worker(ctx context.Context, idx int) {
+	defer r.wg.Done()
+	consumer := fmt.Sprintf("%s-%d", r.Cfg.Name, idx)
+	workerLogPrefix := fmt.Sprintf("worker-%d", idx)
+	r.logger.Printf("%s starting consumer %s in group %s", workerLogPrefix, consumer, r.Cfg.Group)
+
+	var streams []string
+	var lastDiscovery time.Time
+	var err error
+	// start by reading the messages delivered to this consumer
+	// in a previous run but not acknowledged
+	pending := true
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(lastDiscovery) >= r.Cfg.DiscoveryInterval {
+			streams, err = r.discoverStreams(ctx)
+			if err != nil {
+				r.logger.Printf("%s failed to get streams: %v", workerLogPrefix, err)
+				r.sleep(ctx, r.Cfg.RecoveryWaitTime)
+				continue
+			}
+			lastDiscovery = time.Now()
+		}
+		if len(streams) == 0 {
+			r.sleep(ctx, r.Cfg.Block)
+			continue
+		}
+		id := ">"
+		if pending {
+			id = "0"
+		}
+		args := make([]string, 0, 2*len(streams))
+		args = append(args, streams...)
+		for range streams {
+			args = append(args, id)
+		}
+		res, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    r.Cfg.Group,
+			Consumer: consumer,
+			Streams:  args,
+			Count:    r.Cfg.Count,
+			Block:    r.Cfg.Block,
+		}).Result()
+		if err == redis.Nil {
+			pending = false
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			r.logger.Printf("%s failed to read from streams: %v", workerLogPrefix, err)
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				// a stream was deleted, discover the streams again
+				r.m.Lock()
+				r.groups = make(map[string]struct{})
+				r.m.Unlock()
+				lastDiscovery = time.Time{}
+			}
+			r.sleep(ctx, r.Cfg.RecoveryWaitTime)
+			continue
+		}
+		numMsgs := 0
+		for _, xs := range res {
+			if len(xs.Messages) == 0 {
+				continue
+			}
+			ids := make([]string, 0, len(xs.Messages))
+			for _, xm := range xs.Messages {
+				r.handleMessage(ctx, xs.Stream, xm)
+				ids = append(ids, xm.ID)
+			}
+			numMsgs += len(ids)
+			err = r.client.XAck(ctx, xs.Stream, r.Cfg.Group, ids...).Err()
+			if err != nil {
+				r.logger.Printf("%s failed to acknowledge %d messages from stream %s: %v", workerLogPrefix, len(ids), xs.Stream, err)
+			}
+		}
+		if pending && numMsgs == 0 {
+			pending = false
+		}
+	}
+}
+
+// handleMessage decodes a stream entry and writes it to the outputs,
+// invalid entries are dropped.
+func (r *RedisInput) handleMessage(ctx context.Context, stream string, xm redis.XMessage) {
+	data, ok := xm.Values[dataField].(string)
+	if !ok || len(data) == 0 {
+		if r.Cfg.EnableMetrics {
+			RedisInputNumberOfFailedMsgs.WithLabelValues(r.Cfg.Name, "missing_data").Inc()
+		}
+		return
+	}
+	if r.Cfg.EnableMetrics {
+		RedisInputNumberOfReceivedMsgs.WithLabelValues(r.Cfg.Name, stream).Inc()
+		RedisInputNumberOfReceivedBytes.WithLabelValues(r.Cfg.Name, stream).Add(float64(len(data)))
+	}
+	if r.Cfg.Debug {
+		r.logger.Printf("received msg, stream=%s, id=%s, len=%d, data=%s", stream, xm.ID, len(data), data)
+	}
+	switch r.Cfg.Format {
+	case "event":
+		evMsgs := make([]*formatters.EventMsg, 0, 1)
+		err := json.Unmarshal([]byte(data), &evMsgs)
+		if err != nil {
+			if r.Cfg.Debug {
+				r.logger.Printf("failed to unmarshal event msg: %v", err)
+			}
+			if r.Cfg.EnableMetrics {
+				RedisInputNumberOfFailedMsgs.WithLabelValues(r.Cfg.Name, "unmarshal_error").Inc()
+			}
+			return
+		}
+		for _, p := range r.evps {
+			evMsgs = p.Apply(evMsgs...)
+		}
+		for _, o := range inputs.SelectOutputs(r.outputsFn, r.Cfg.Outputs) {
+			for _, ev := range evMsgs {
+				o.WriteEvent(ctx, ev)
+			}
+		}
+	case "proto":
+		rsp := new(gnmi.SubscribeResponse)
+		err := proto.Unmarshal([]byte(data), rsp)
+		if err != nil {
+			if r.Cfg.Debug {
+				r.logger.Printf("failed to unmarshal proto msg: %v", err)
+			}
+			if r.Cfg.EnableMetrics {
+				RedisInputNumberOfFailedMsgs.WithLabelValues(r.Cfg.Name, "unmarshal_error").Inc()
+			}
+			return
+		}
+		meta := outputs.Meta{}
+		if s, ok := xm.Values[sourceField].(string); ok {
+			meta["source"] = s
+		}
+		if s, ok := xm.Values[subscriptionNameField].(string); ok {
+			meta["subscription-name"] = s
+		}
+		for _, o := range inputs.SelectOutputs(r.outputsFn, r.Cfg.Outputs) {
+			o.Write(ctx, rsp, meta)
+		}
+	}
+}
+
+// discoverStreams returns the configured streams, resolving the patterns,
+// and creates the consumer group on the streams seen for the first time.
+func (r *RedisInput) discoverStreams(ctx context.Context) ([]string, error) {
+	streams := make([]string, 0, len(r.Cfg.Streams))
+	seen := make(map[string]struct{})
+	for _, s := range r.Cfg.Streams {
+		if !strings.ContainsAny(s, "*?[") {
+			if _, ok := seen[s]; !ok {
+				seen[s] = struct{}{}
+				streams = append(streams, s)
+			}
+			continue
+		}
+		iter := r.client.ScanType(ctx, 0, s, 100, "stream").Iterator()
+		for iter.Next(ctx) {
+			if _, ok := seen[iter.Val()]; !ok {
+				seen[iter.Val()] = struct{}{}
+				streams = append(streams, iter.Val())
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	for _, s := range streams {
+		if _, ok := r.groups[s]; ok {
+			continue
+		}
+		err := r.client.XGroupCreateMkStream(ctx, s, r.Cfg.Group, r.Cfg.StartID).Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return nil, fmt.Errorf("failed to create consumer group %s on stream %s: %v", r.Cfg.Group, s, err)
+		}
+		r.groups[s] = struct{}{}
+	}
+	return streams, nil
+}
+
+func (r *RedisInput) sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// Close //
+func (r *RedisInput) Close() error {
+	formatters.CloseProcessors(r.evps)
+	if r.cfn != nil {
+		r.cfn()
+	}
+	r.wg.Wait()
+	if r.client != nil {
+		return r.client.Close()
+	}
+	return nil
+}
+
+// SetLogger //
+func (r *RedisInput) SetLogger(logger *log.Logger) {
+	if logger != nil && r.logger != nil {
+		r.logger.SetOutput(logger.Writer())
+		r.logger.SetFlags(logger.Flags())
+	}
+}
+
+// SetOutputs //
+func (r *RedisInput) SetOutputs(fn inputs.OutputsFunc) {
+	r.outputsFn = fn
+}
+
+func (r *RedisInput) SetName(name string) {
+	sb := strings.Builder{}
+	if name != "" {
+		sb.WriteString(name)
+		sb.WriteString("-")
+	}
+	sb.WriteString(r.Cfg.Name)
+	sb.WriteString("-redis-sub")
+	r.Cfg.Name = sb.String()
+}
+
+func (r *RedisInput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]*types.TargetConfig) {
+	for _, epName := range r.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				if err != nil {
+					r.logger.Printf("failed initializing event processor %q of type=%q: %v", epName, epType, err)
+					continue
+				}
+				r.evps = append(r.evps, ep)
+				r.logger.Printf("added event processor %q of type=%q to redis input", epName, epType)
+			}
+		}
+	}
+}
+
+// RegisterMetrics implements inputs.MetricsRegisterer
+func (r *RedisInput) RegisterMetrics(reg *prometheus.Registry) {
+	if !r.Cfg.EnableMetrics {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		r.logger.Printf("failed to register metric: %+v", err)
+	}
+}
+
+// helper functions
+
+func (r *RedisInput) setDefaults() error {
+	if r.Cfg.Format == "" {
+		r.Cfg.Format = defaultFormat
+	}
+	r.Cfg.Format = strings.ToLower(r.Cfg.Format)
+	if !(r.Cfg.Format == "event" || r.Cfg.Format == "proto") {
+		return fmt.Errorf("unsupported input format %q", r.Cfg.Format)
+	}
+	if r.Cfg.Name == "" {
+		r.Cfg.Name = "gnmic-" + uuid.New().String()
+	}
+	if r.Cfg.Address == "" {
+		r.Cfg.Address = defaultAddress
+	}
+	if len(r.Cfg.Streams) == 0 {
+		r.Cfg.Streams = []string{defaultStream}
+	}
+	if r.Cfg.Group == "" {
+		r.Cfg.Group = defaultGroup
+	}
+	if r.Cfg.StartID == "" {
+		r.Cfg.StartID = defaultStartID
+	}
+	if r.Cfg.Count <= 0 {
+		r.Cfg.Count = defaultCount
+	}
+	if r.Cfg.Block <= 0 {
+		r.Cfg.Block = defaultBlock
+	}
+	if r.Cfg.DiscoveryInterval <= 0 {
+		r.Cfg.DiscoveryInterval = defaultDiscoveryInterval
+	}
+	if r.Cfg.RecoveryWaitTime <= 0 {
+		r.Cfg.RecoveryWaitTime = defaultRecoveryWaitTime
+	}
+	if r.Cfg.NumWorkers <= 0 {
+		r.Cfg.NumWorkers = defaultNumWorkers
+	}
+	return nil
+}
+
+func (r *RedisInput) createClient() (*redis.Client, error) {
+	opts := &redis.Options{
+		Addr:     r.Cfg.Address,
+		Username: r.Cfg.Username,
+		Password: r.Cfg.Password,
+		DB:       r.Cfg.DB,
+	}
+	if r.Cfg.TLS != nil {
+		tlsCfg, err := utils.NewTLSConfig(r.Cfg.TLS.CaFile, r.Cfg.TLS.CertFile, r.Cfg.TLS.KeyFile, r.Cfg.TLS.SkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		if tlsCfg == nil {
+			tlsCfg = &tls.Config{}
+		}
+		opts.TLSConfig = tlsCfg
+	}
+	return redis.NewClient(opts), nil
+}
@@ -0,0 +1,182 @@
+package redis_input
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/inputs"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/types"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+// testOutput records the messages written by the input
+type testOutput struct {
+	m      sync.Mutex
+	events []*formatters.EventMsg
+	msgs   []proto.Message
+	metas  []outputs.Meta
+}
+
+func (o *testOutput) Init(context.Context, string, map[string]interface{}, ...outputs.Option) error {
+	return nil
+}
+func (o *testOutput) Write(_ context.Context, m proto.Message, meta outputs.Meta) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.msgs = append(o.msgs, m)
+	o.metas = append(o.metas, meta)
+}
+func (o *testOutput) WriteEvent(_ context.Context, ev *formatters.EventMsg) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.events = append(o.events, ev)
+}
+func (o *testOutput) Close() error                         { return nil }
+func (o *testOutput) RegisterMetrics(*prometheus.Registry) {}
+func (o *testOutput) String() string                       { return "" }
+func (o *testOutput) SetLogger(*log.Logger)                {}
+func (o *testOutput) SetEventProcessors(map[string]map[string]interface{}, *log.Logger, map[string]*types.TargetConfig) {
+}
+func (o *testOutput) SetName(string)                                  {}
+func (o *testOutput) SetClusterName(string)                           {}
+func (o *testOutput) SetTargetsConfig(map[string]*types.TargetConfig) {}
+
+func (o *testOutput) count() int {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return len(o.events) + len(o.msgs)
+}
+
+func newTestInput() *RedisInput {
+	return &RedisInput{
+		Cfg:    &Config{},
+		logger: log.New(ioutil.Discard, "", 0),
+		wg:     new(sync.WaitGroup),
+		groups: make(map[string]struct{}),
+	}
+}
+
+func startTestInput(t *testing.T, ctx context.Context, addr string, cfg map[string]interface{}) (*RedisInput, *testOutput) {
+	out := new(testOutput)
+	in := newTestInput()
+	cfg["address"] = addr
+	cfg["start-id"] = "0"
+	cfg["block"] = "100ms"
+	err := in.Start(ctx, "redis1", cfg, inputs.WithOutputs(map[string]outputs.Output{"out1": out}))
+	if err != nil {
+		t.Fatalf("failed to start input: %v", err)
+	}
+	return in, out
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRedisInputEvents(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer client.Close()
+	entries := []struct {
+		stream string
+		data   string
+	}{
+		{stream: "telemetry:router1:sub1", data: `[{"name":"sub1","timestamp":1,"tags":{"source":"router1"},"values":{"/a":1}}]`},
+		{stream: "telemetry:router1:sub1", data: `not an event`},
+		{stream: "telemetry:router2:sub1", data: `[{"name":"sub1","timestamp":2,"tags":{"source":"router2"},"values":{"/a":2}}]`},
+		// not matching the default streams pattern
+		{stream: "other", data: `[{"name":"sub1","timestamp":3}]`},
+	}
+	for _, e := range entries {
+		err = client.XAdd(ctx, &redis.XAddArgs{Stream: e.stream, Values: []interface{}{"data", e.data}}).Err()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	in, out := startTestInput(t, ctx, s.Addr(), map[string]interface{}{})
+	defer in.Close()
+	waitFor(t, func() bool { return out.count() == 2 })
+
+	// all the entries, including the invalid one, are acknowledged
+	waitFor(t, func() bool {
+		for _, stream := range []string{"telemetry:router1:sub1", "telemetry:router2:sub1"} {
+			p, err := client.XPending(ctx, stream, defaultGroup).Result()
+			if err != nil || p.Count != 0 {
+				return false
+			}
+		}
+		return true
+	})
+	if _, err := client.XPending(ctx, "other", defaultGroup).Result(); err == nil {
+		t.Error("unexpected consumer group on stream 'other'")
+	}
+}
+
+func TestRedisInputProto(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rsp := &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{Timestamp: 42},
+		},
+	}
+	b, err := proto.Marshal(rsp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer client.Close()
+	err = client.XAdd(ctx, &redis.XAddArgs{
+		Stream: "gnmic",
+		Values: []interface{}{"data", b, "source", "router1:57400", "subscription-name", "sub1"},
+	}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in, out := startTestInput(t, ctx, s.Addr(), map[string]interface{}{
+		"format":  "proto",
+		"streams": []string{"gnmic"},
+	})
+	defer in.Close()
+	waitFor(t, func() bool { return out.count() == 1 })
+
+	out.m.Lock()
+	defer out.m.Unlock()
+	if !proto.Equal(out.msgs[0], rsp) {
+		t.Errorf("got %v, want %v", out.msgs[0], rsp)
+	}
+	if out.metas[0]["source"] != "router1:57400" || out.metas[0]["subscription-name"] != "sub1" {
+		t.Errorf("unexpected meta: %v", out.metas[0])
+	}
+}
@@ -0,0 +1,45 @@
+package redis_input
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var RedisInputNumberOfReceivedMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "redis_input",
+	Name:      "number_of_redis_msgs_received_total",
+	Help:      "Number of msgs received by gnmic redis input",
+}, []string{"consumer_id", "stream"})
+
+var RedisInputNumberOfReceivedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "redis_input",
+	Name:      "number_of_received_redis_bytes_total",
+	Help:      "Number of bytes received by gnmic redis input",
+}, []string{"consumer_id", "stream"})
+
+var RedisInputNumberOfFailedMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "redis_input",
+	Name:      "number_of_redis_msgs_failed_total",
+	Help:      "Number of msgs received by gnmic redis input that could not be decoded",
+}, []string{"consumer_id", "reason"})
+
+func initMetrics() {
+	RedisInputNumberOfReceivedMsgs.WithLabelValues("", "").Add(0)
+	RedisInputNumberOfReceivedBytes.WithLabelValues("", "").Add(0)
+	RedisInputNumberOfFailedMsgs.WithLabelValues("", "").Add(0)
+}
+
+func registerMetrics(reg *prometheus.Registry) error {
+	initMetrics()
+	var err error
+	if err = reg.Register(RedisInputNumberOfReceivedMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(RedisInputNumberOfReceivedBytes); err != nil {
+		return err
+	}
+	if err = reg.Register(RedisInputNumberOfFailedMsgs); err != nil {
+		return err
+	}
+	return nil
+}
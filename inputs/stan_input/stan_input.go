@@ -49,9 +49,9 @@ type StanInput struct {
 	cfn    context.CancelFunc
 	logger *log.Logger
 
-	wg      *sync.WaitGroup
-	outputs []outputs.Output
-	evps    []formatters.EventProcessor
+	wg        *sync.WaitGroup
+	outputsFn inputs.OutputsFunc
+	evps      []formatters.EventProcessor
 }
 
 // Config //
@@ -91,7 +91,7 @@ func (s *StanInput) Start(ctx context.Context, name string, cfg map[string]inter
 	s.ctx, s.cfn = context.WithCancel(ctx)
 	s.wg.Add(s.Cfg.NumWorkers)
 	for i := 0; i < s.Cfg.NumWorkers; i++ {
-		go s.worker(ctx, i)
+		go s.worker(s.ctx, i)
 	}
 	return nil
 }
@@ -123,7 +123,10 @@ CRCONN:
 }
 
 func (s *StanInput) Close() error {
-	s.cfn()
+	formatters.CloseProcessors(s.evps)
+	if s.cfn != nil {
+		s.cfn()
+	}
 	s.wg.Wait()
 	return nil
 }
@@ -135,18 +138,8 @@ func (s *StanInput) SetLogger(logger *log.Logger) {
 	}
 }
 
-func (s *StanInput) SetOutputs(outs map[string]outputs.Output) {
-	if len(s.Cfg.Outputs) == 0 {
-		for _, o := range outs {
-			s.outputs = append(s.outputs, o)
-		}
-		return
-	}
-	for _, name := range s.Cfg.Outputs {
-		if o, ok := outs[name]; ok {
-			s.outputs = append(s.outputs, o)
-		}
-	}
+func (s *StanInput) SetOutputs(fn inputs.OutputsFunc) {
+	s.outputsFn = fn
 }
 
 func (s *StanInput) SetName(name string) {
@@ -170,7 +163,7 @@ func (s *StanInput) SetEventProcessors(ps map[string]map[string]interface{}, log
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
 				if err != nil {
 					s.logger.Printf("failed initializing event processor %q of type=%q: %v", epName, epType, err)
 					continue
@@ -282,7 +275,7 @@ func (s *StanInput) handleMsg(m *stan.Msg) {
 		}
 
 		go func() {
-			for _, o := range s.outputs {
+			for _, o := range inputs.SelectOutputs(s.outputsFn, s.Cfg.Outputs) {
 				for _, ev := range evMsgs {
 					o.WriteEvent(s.ctx, ev)
 				}
@@ -304,7 +297,7 @@ func (s *StanInput) handleMsg(m *stan.Msg) {
 			meta["subscription-name"] = subjectSections[2]
 		}
 		go func() {
-			for _, o := range s.outputs {
+			for _, o := range inputs.SelectOutputs(s.outputsFn, s.Cfg.Outputs) {
 				o.Write(s.ctx, protoMsg, meta)
 			}
 		}()
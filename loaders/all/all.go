@@ -5,4 +5,5 @@ import (
 	_ "github.com/karimra/gnmic/loaders/docker_loader"
 	_ "github.com/karimra/gnmic/loaders/file_loader"
 	_ "github.com/karimra/gnmic/loaders/http_loader"
+	_ "github.com/karimra/gnmic/loaders/k8s_loader"
 )
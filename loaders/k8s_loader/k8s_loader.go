@@ -0,0 +1,476 @@
+package k8s_loader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karimra/gnmic/loaders"
+	"github.com/karimra/gnmic/types"
+	"github.com/mitchellh/mapstructure"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	loggingPrefix           = "[k8s_loader] "
+	loaderType              = "kubernetes"
+	defaultAnnotationPrefix = "gnmic.io/"
+	defaultResyncPeriod     = 5 * time.Minute
+	defaultPortName         = "gnmi"
+	// minimum time between 2 target operations
+	debounceInterval = time.Second
+)
+
+const (
+	kindPod       = "pod"
+	kindService   = "service"
+	kindEndpoints = "endpoints"
+)
+
+func init() {
+	loaders.Register(loaderType, func() loaders.TargetLoader {
+		return &k8sLoader{
+			cfg:         new(cfg),
+			lastTargets: make(map[string]*types.TargetConfig),
+			logger:      log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		}
+	})
+}
+
+type k8sLoader struct {
+	cfg         *cfg
+	client      kubernetes.Interface
+	lastTargets map[string]*types.TargetConfig
+	logger      *log.Logger
+}
+
+type cfg struct {
+	// path to a kubeconfig file, if empty the in-cluster config is used,
+	// falling back to the default kubeconfig loading rules.
+	Kubeconfig string `json:"kubeconfig,omitempty" mapstructure:"kubeconfig,omitempty"`
+	// default namespace for the resources that do not set one,
+	// an empty value means all namespaces
+	Namespace string `json:"namespace,omitempty" mapstructure:"namespace,omitempty"`
+	// prefix of the annotations used to build the target configuration
+	AnnotationPrefix string `json:"annotation-prefix,omitempty" mapstructure:"annotation-prefix,omitempty"`
+	// period after which the watched resources are fully re-listed
+	ResyncPeriod time.Duration `json:"resync-period,omitempty" mapstructure:"resync-period,omitempty"`
+	// watched resources
+	Resources []*resourceDef `json:"resources,omitempty" mapstructure:"resources,omitempty"`
+	Debug     bool           `json:"debug,omitempty" mapstructure:"debug,omitempty"`
+	// if true, registers k8sLoader prometheus metrics with the provided
+	// prometheus registry
+	EnableMetrics bool `json:"enable-metrics,omitempty" mapstructure:"enable-metrics,omitempty"`
+}
+
+type resourceDef struct {
+	// one of pod, service, endpoints
+	Kind          string `json:"kind,omitempty" mapstructure:"kind,omitempty"`
+	Namespace     string `json:"namespace,omitempty" mapstructure:"namespace,omitempty"`
+	LabelSelector string `json:"label-selector,omitempty" mapstructure:"label-selector,omitempty"`
+	// gNMI port: a port number, a port name
+	// or an annotation name in the format `annotation=<annotation_name>`
+	Port   string                 `json:"port,omitempty" mapstructure:"port,omitempty"`
+	Config map[string]interface{} `json:"config,omitempty" mapstructure:"config,omitempty"`
+
+	informer cache.SharedIndexInformer
+}
+
+func (k *k8sLoader) Init(ctx context.Context, cfg map[string]interface{}, logger *log.Logger, opts ...loaders.Option) error {
+	err := loaders.DecodeConfig(cfg, k.cfg)
+	if err != nil {
+		return err
+	}
+	err = k.setDefaults()
+	if err != nil {
+		return err
+	}
+	for _, o := range opts {
+		o(k)
+	}
+	if logger != nil {
+		k.logger.SetOutput(logger.Writer())
+		k.logger.SetFlags(logger.Flags())
+	}
+	if k.client == nil {
+		k.client, err = k.createClient()
+		if err != nil {
+			return err
+		}
+	}
+	k.logger.Printf("initialized loader type %q: %s", loaderType, k)
+	return nil
+}
+
+func (k *k8sLoader) setDefaults() error {
+	if k.cfg.AnnotationPrefix == "" {
+		k.cfg.AnnotationPrefix = defaultAnnotationPrefix
+	}
+	if k.cfg.ResyncPeriod <= 0 {
+		k.cfg.ResyncPeriod = defaultResyncPeriod
+	}
+	if len(k.cfg.Resources) == 0 {
+		k.cfg.Resources = []*resourceDef{{Kind: kindPod}}
+	}
+	for _, r := range k.cfg.Resources {
+		r.Kind = strings.ToLower(r.Kind)
+		switch r.Kind {
+		case "":
+			r.Kind = kindPod
+		case kindPod, "pods":
+			r.Kind = kindPod
+		case kindService, "services", "svc":
+			r.Kind = kindService
+		case kindEndpoints, "endpoint":
+			r.Kind = kindEndpoints
+		default:
+			return fmt.Errorf("unknown resource kind %q", r.Kind)
+		}
+		if r.Namespace == "" {
+			r.Namespace = k.cfg.Namespace
+		}
+	}
+	return nil
+}
+
+func (k *k8sLoader) createClient() (kubernetes.Interface, error) {
+	var restConfig *rest.Config
+	var err error
+	if k.cfg.Kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", k.cfg.Kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+		if errors.Is(err, rest.ErrNotInCluster) {
+			restConfig, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+				clientcmd.NewDefaultClientConfigLoadingRules(),
+				&clientcmd.ConfigOverrides{},
+			).ClientConfig()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
+func (k *k8sLoader) Start(ctx context.Context) chan *loaders.TargetOperation {
+	opChan := make(chan *loaders.TargetOperation)
+	// notifyCh is used by the informers event handlers
+	// to trigger a targets list rebuild
+	notifyCh := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case notifyCh <- struct{}{}:
+		default:
+		}
+	}
+	handler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	}
+	for _, r := range k.cfg.Resources {
+		labelSelector := r.LabelSelector
+		factory := informers.NewSharedInformerFactoryWithOptions(k.client, k.cfg.ResyncPeriod,
+			informers.WithNamespace(r.Namespace),
+			informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+				opts.LabelSelector = labelSelector
+			}),
+		)
+		switch r.Kind {
+		case kindPod:
+			r.informer = factory.Core().V1().Pods().Informer()
+		case kindService:
+			r.informer = factory.Core().V1().Services().Informer()
+		case kindEndpoints:
+			r.informer = factory.Core().V1().Endpoints().Informer()
+		}
+		kind := r.Kind
+		err := r.informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+			k.logger.Printf("%s watch error: %v", kind, err)
+			k8sLoaderWatchError.WithLabelValues(loaderType, fmt.Sprintf("%v", err)).Add(1)
+		})
+		if err != nil {
+			k.logger.Printf("failed to set %s watch error handler: %v", kind, err)
+		}
+		r.informer.AddEventHandler(handler)
+		go r.informer.Run(ctx.Done())
+	}
+	go func() {
+		defer close(opChan)
+		for _, r := range k.cfg.Resources {
+			if !cache.WaitForCacheSync(ctx.Done(), r.informer.HasSynced) {
+				return
+			}
+		}
+		k.logger.Printf("%s caches synced", loaderType)
+		for {
+			readTargets := k.getTargets()
+			if k.cfg.Debug {
+				k.logger.Printf("k8s loader discovered %d target(s)", len(readTargets))
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case opChan <- k.diff(readTargets):
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-notifyCh:
+				// wait for further events before rebuilding the targets list
+				time.Sleep(debounceInterval)
+			}
+		}
+	}()
+	return opChan
+}
+
+func (k *k8sLoader) RegisterMetrics(reg *prometheus.Registry) {
+	if !k.cfg.EnableMetrics || reg == nil {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		k.logger.Printf("failed to register metrics: %v", err)
+	}
+}
+
+func (k *k8sLoader) getTargets() map[string]*types.TargetConfig {
+	readTargets := make(map[string]*types.TargetConfig)
+	for _, r := range k.cfg.Resources {
+		for _, obj := range r.informer.GetStore().List() {
+			var tcs []*types.TargetConfig
+			switch obj := obj.(type) {
+			case *corev1.Pod:
+				tcs = k.podTargets(r, obj)
+			case *corev1.Service:
+				tcs = k.serviceTargets(r, obj)
+			case *corev1.Endpoints:
+				tcs = k.endpointsTargets(r, obj)
+			}
+			for _, tc := range tcs {
+				if k.cfg.Debug {
+					k.logger.Printf("discovered target config %s from %s", tc, r.Kind)
+				}
+				readTargets[tc.Name] = tc
+			}
+		}
+	}
+	return readTargets
+}
+
+func (k *k8sLoader) podTargets(r *resourceDef, pod *corev1.Pod) []*types.TargetConfig {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == "" {
+		return nil
+	}
+	port := k.getPort(r.Port, pod.Annotations, func(name string) int32 {
+		for _, c := range pod.Spec.Containers {
+			for _, p := range c.Ports {
+				if p.Name == name {
+					return p.ContainerPort
+				}
+			}
+		}
+		return 0
+	})
+	if port == 0 {
+		k.logger.Printf("pod %s/%s: no gNMI port found", pod.Namespace, pod.Name)
+		return nil
+	}
+	tc, err := k.buildTarget(r, &pod.ObjectMeta, targetName(pod.Name, pod.Namespace), net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(port))))
+	if err != nil {
+		k.logger.Printf("pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return nil
+	}
+	return []*types.TargetConfig{tc}
+}
+
+func (k *k8sLoader) serviceTargets(r *resourceDef, svc *corev1.Service) []*types.TargetConfig {
+	if svc.Spec.ClusterIP == "" || svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		// headless services targets are discovered using their endpoints
+		return nil
+	}
+	port := k.getPort(r.Port, svc.Annotations, func(name string) int32 {
+		for _, p := range svc.Spec.Ports {
+			if p.Name == name {
+				return p.Port
+			}
+		}
+		return 0
+	})
+	if port == 0 {
+		k.logger.Printf("service %s/%s: no gNMI port found", svc.Namespace, svc.Name)
+		return nil
+	}
+	tc, err := k.buildTarget(r, &svc.ObjectMeta, targetName(svc.Name, svc.Namespace), net.JoinHostPort(svc.Spec.ClusterIP, strconv.Itoa(int(port))))
+	if err != nil {
+		k.logger.Printf("service %s/%s: %v", svc.Namespace, svc.Name, err)
+		return nil
+	}
+	return []*types.TargetConfig{tc}
+}
+
+func (k *k8sLoader) endpointsTargets(r *resourceDef, ep *corev1.Endpoints) []*types.TargetConfig {
+	tcs := make([]*types.TargetConfig, 0)
+	for _, subset := range ep.Subsets {
+		port := k.getPort(r.Port, ep.Annotations, func(name string) int32 {
+			for _, p := range subset.Ports {
+				if p.Name == name {
+					return p.Port
+				}
+			}
+			return 0
+		})
+		if port == 0 {
+			continue
+		}
+		for _, addr := range subset.Addresses {
+			name := targetName(addr.IP, ep.Namespace)
+			if addr.TargetRef != nil && addr.TargetRef.Name != "" {
+				name = targetName(addr.TargetRef.Name, ep.Namespace)
+			}
+			tc, err := k.buildTarget(r, &ep.ObjectMeta, name, net.JoinHostPort(addr.IP, strconv.Itoa(int(port))))
+			if err != nil {
+				k.logger.Printf("endpoints %s/%s: %v", ep.Namespace, ep.Name, err)
+				return nil
+			}
+			tcs = append(tcs, tc)
+		}
+	}
+	if len(tcs) == 0 && k.cfg.Debug {
+		k.logger.Printf("endpoints %s/%s: no ready address with a gNMI port found", ep.Namespace, ep.Name)
+	}
+	return tcs
+}
+
+// buildTarget creates a target config from the resource config,
+// the discovered name and address, then overrides its fields
+// with the values of the object annotations.
+func (k *k8sLoader) buildTarget(r *resourceDef, meta *metav1.ObjectMeta, name, address string) (*types.TargetConfig, error) {
+	tc := new(types.TargetConfig)
+	if r.Config != nil {
+		err := decodeTargetConfig(r.Config, tc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode config map: %v", err)
+		}
+	}
+	tc.Name = name
+	tc.Address = address
+	annotations := make(map[string]interface{})
+	for an, v := range meta.Annotations {
+		if !strings.HasPrefix(an, k.cfg.AnnotationPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(an, k.cfg.AnnotationPrefix)
+		if key == "port" {
+			continue
+		}
+		annotations[key] = v
+	}
+	if len(annotations) > 0 {
+		err := decodeTargetConfig(annotations, tc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode annotations: %v", err)
+		}
+	}
+	return tc, nil
+}
+
+// getPort returns the gNMI port number based on the resource port configuration:
+// a port number, an annotation name in the format `annotation=<name>` or a port name.
+// If the port is not configured, the annotation `<annotation-prefix>port` is checked
+// then the port named `gnmi`.
+func (k *k8sLoader) getPort(p string, annotations map[string]string, byName func(string) int32) int32 {
+	if p == "" {
+		if v, ok := annotations[k.cfg.AnnotationPrefix+"port"]; ok {
+			p = v
+		} else {
+			p = defaultPortName
+		}
+	}
+	if strings.HasPrefix(p, "annotation=") {
+		p = annotations[strings.TrimPrefix(p, "annotation=")]
+	}
+	if n, err := strconv.Atoi(p); err == nil {
+		return int32(n)
+	}
+	return byName(p)
+}
+
+func (k *k8sLoader) diff(m map[string]*types.TargetConfig) *loaders.TargetOperation {
+	result := loaders.Diff(k.lastTargets, m)
+	// targets with a changed address are deleted then re-added
+	for n, t := range m {
+		if lt, ok := k.lastTargets[n]; ok && lt.Address != t.Address {
+			result.Del = append(result.Del, n)
+			result.Add = append(result.Add, t)
+			k.lastTargets[n] = t
+		}
+	}
+	for _, t := range result.Add {
+		if _, ok := k.lastTargets[t.Name]; !ok {
+			k.lastTargets[t.Name] = t
+		}
+	}
+	for _, n := range result.Del {
+		if _, ok := m[n]; !ok {
+			delete(k.lastTargets, n)
+		}
+	}
+	k8sLoaderLoadedTargets.WithLabelValues(loaderType).Set(float64(len(result.Add)))
+	k8sLoaderDeletedTargets.WithLabelValues(loaderType).Set(float64(len(result.Del)))
+	if k.cfg.Debug {
+		b, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			k.logger.Printf("discovery diff result: %v", result)
+		} else {
+			k.logger.Printf("discovery diff result:\n%s", string(b))
+		}
+	}
+	return result
+}
+
+func (k *k8sLoader) String() string {
+	b, err := json.Marshal(k.cfg)
+	if err != nil {
+		return fmt.Sprintf("%+v", k.cfg)
+	}
+	return string(b)
+}
+
+/// helpers
+
+func targetName(name, namespace string) string {
+	return fmt.Sprintf("%s.%s", name, namespace)
+}
+
+func decodeTargetConfig(src map[string]interface{}, tc *types.TargetConfig) error {
+	decoder, err := mapstructure.NewDecoder(
+		&mapstructure.DecoderConfig{
+			DecodeHook: mapstructure.ComposeDecodeHookFunc(
+				mapstructure.StringToTimeDurationHookFunc(),
+				mapstructure.StringToSliceHookFunc(","),
+			),
+			WeaklyTypedInput: true,
+			Result:           tc,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(src)
+}
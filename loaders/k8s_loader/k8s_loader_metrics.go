@@ -0,0 +1,42 @@
+package k8s_loader
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var k8sLoaderLoadedTargets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "k8s_loader",
+	Name:      "number_of_loaded_targets",
+	Help:      "Number of new targets successfully loaded",
+}, []string{"loader_type"})
+
+var k8sLoaderDeletedTargets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "k8s_loader",
+	Name:      "number_of_deleted_targets",
+	Help:      "Number of targets successfully deleted",
+}, []string{"loader_type"})
+
+var k8sLoaderWatchError = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "k8s_loader",
+	Name:      "number_of_watch_errors",
+	Help:      "Number of watch errors",
+}, []string{"loader_type", "error"})
+
+func initMetrics() {
+	k8sLoaderLoadedTargets.WithLabelValues(loaderType).Set(0)
+	k8sLoaderDeletedTargets.WithLabelValues(loaderType).Set(0)
+	k8sLoaderWatchError.WithLabelValues(loaderType, "").Add(0)
+}
+
+func registerMetrics(reg *prometheus.Registry) error {
+	initMetrics()
+	var err error
+	if err = reg.Register(k8sLoaderLoadedTargets); err != nil {
+		return err
+	}
+	if err = reg.Register(k8sLoaderDeletedTargets); err != nil {
+		return err
+	}
+	return reg.Register(k8sLoaderWatchError)
+}
@@ -0,0 +1,213 @@
+package k8s_loader
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/loaders"
+	"github.com/karimra/gnmic/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newPod(name, ip string, labels, annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Labels:      labels,
+			Annotations: annotations,
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "router",
+					Ports: []corev1.ContainerPort{{Name: "gnmi", ContainerPort: 57400}},
+				},
+			},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			PodIP: ip,
+		},
+	}
+}
+
+func newTestLoader(t *testing.T, lcfg map[string]interface{}, objs ...interface{}) (*k8sLoader, *fake.Clientset) {
+	client := fake.NewSimpleClientset()
+	for _, o := range objs {
+		var err error
+		switch o := o.(type) {
+		case *corev1.Pod:
+			_, err = client.CoreV1().Pods(o.Namespace).Create(context.TODO(), o, metav1.CreateOptions{})
+		case *corev1.Service:
+			_, err = client.CoreV1().Services(o.Namespace).Create(context.TODO(), o, metav1.CreateOptions{})
+		case *corev1.Endpoints:
+			_, err = client.CoreV1().Endpoints(o.Namespace).Create(context.TODO(), o, metav1.CreateOptions{})
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	k := &k8sLoader{
+		cfg:         new(cfg),
+		client:      client,
+		lastTargets: make(map[string]*types.TargetConfig),
+		logger:      log.New(ioutil.Discard, loggingPrefix, log.LstdFlags),
+	}
+	if err := k.Init(context.TODO(), lcfg, nil); err != nil {
+		t.Fatalf("failed to init loader: %v", err)
+	}
+	return k, client
+}
+
+func waitOp(t *testing.T, opChan chan *loaders.TargetOperation) *loaders.TargetOperation {
+	select {
+	case op := <-opChan:
+		return op
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for a target operation")
+	}
+	return nil
+}
+
+func TestPodDiscovery(t *testing.T) {
+	k, client := newTestLoader(t,
+		map[string]interface{}{
+			"resources": []interface{}{
+				map[string]interface{}{
+					"kind":           "pod",
+					"label-selector": "app=router",
+					"config": map[string]interface{}{
+						"username": "admin",
+					},
+				},
+			},
+		},
+		newPod("router1", "10.0.0.1", map[string]string{"app": "router"}, map[string]string{
+			"gnmic.io/skip-verify": "true",
+			"gnmic.io/tags":        "a,b",
+		}),
+		newPod("other", "10.0.0.2", map[string]string{"app": "other"}, nil),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opChan := k.Start(ctx)
+
+	op := waitOp(t, opChan)
+	if len(op.Add) != 1 || len(op.Del) != 0 {
+		t.Fatalf("expected 1 added target, got %+v", op)
+	}
+	tc := op.Add[0]
+	if tc.Name != "router1.default" {
+		t.Errorf("unexpected target name %q", tc.Name)
+	}
+	if tc.Address != "10.0.0.1:57400" {
+		t.Errorf("unexpected target address %q", tc.Address)
+	}
+	if tc.Username == nil || *tc.Username != "admin" {
+		t.Errorf("expected username from the resource config, got %v", tc.Username)
+	}
+	if tc.SkipVerify == nil || !*tc.SkipVerify {
+		t.Errorf("expected skip-verify from the pod annotations, got %v", tc.SkipVerify)
+	}
+	if len(tc.Tags) != 2 {
+		t.Errorf("expected 2 tags from the pod annotations, got %v", tc.Tags)
+	}
+
+	// add a second pod with a port annotation
+	_, err := client.CoreV1().Pods("default").Create(ctx,
+		newPod("router2", "10.0.0.3", map[string]string{"app": "router"}, map[string]string{"gnmic.io/port": "6030"}),
+		metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	op = waitOp(t, opChan)
+	if len(op.Add) != 1 || op.Add[0].Address != "10.0.0.3:6030" {
+		t.Fatalf("expected router2 to be added with port 6030, got %+v", op)
+	}
+
+	// delete the first pod
+	err = client.CoreV1().Pods("default").Delete(ctx, "router1", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	op = waitOp(t, opChan)
+	if len(op.Del) != 1 || op.Del[0] != "router1.default" {
+		t.Fatalf("expected router1 to be deleted, got %+v", op)
+	}
+}
+
+func TestEndpointsDiscovery(t *testing.T) {
+	ep := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "gnmi-proxy",
+			Namespace: "default",
+		},
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses: []corev1.EndpointAddress{
+					{IP: "10.0.1.1", TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "proxy-0"}},
+					{IP: "10.0.1.2"},
+				},
+				Ports: []corev1.EndpointPort{
+					{Name: "http", Port: 80},
+					{Name: "grpc", Port: 9339},
+				},
+			},
+		},
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "gnmi-proxy",
+			Namespace: "default",
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+		},
+	}
+	k, _ := newTestLoader(t,
+		map[string]interface{}{
+			"resources": []interface{}{
+				map[string]interface{}{"kind": "endpoints", "port": "grpc"},
+				map[string]interface{}{"kind": "service", "port": "grpc"},
+			},
+		},
+		ep, svc,
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	op := waitOp(t, k.Start(ctx))
+	if len(op.Add) != 2 {
+		t.Fatalf("expected 2 added targets, got %+v", op)
+	}
+	expected := map[string]string{
+		"proxy-0.default":  "10.0.1.1:9339",
+		"10.0.1.2.default": "10.0.1.2:9339",
+	}
+	for _, tc := range op.Add {
+		if expected[tc.Name] != tc.Address {
+			t.Errorf("unexpected target %q with address %q", tc.Name, tc.Address)
+		}
+	}
+}
+
+func TestDiffAddressChange(t *testing.T) {
+	k := &k8sLoader{
+		cfg:         new(cfg),
+		lastTargets: map[string]*types.TargetConfig{"t1": {Name: "t1", Address: "10.0.0.1:57400"}},
+		logger:      log.New(ioutil.Discard, loggingPrefix, log.LstdFlags),
+	}
+	op := k.diff(map[string]*types.TargetConfig{"t1": {Name: "t1", Address: "10.0.0.2:57400"}})
+	if len(op.Del) != 1 || len(op.Add) != 1 {
+		t.Fatalf("expected the target to be deleted and re-added, got %+v", op)
+	}
+	if k.lastTargets["t1"].Address != "10.0.0.2:57400" {
+		t.Errorf("expected the last targets to be updated, got %v", k.lastTargets["t1"])
+	}
+}
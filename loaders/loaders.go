@@ -24,6 +24,7 @@ var LoadersTypes = []string{
 	"consul",
 	"docker",
 	"http",
+	"kubernetes",
 }
 
 func Register(name string, initFn Initializer) {
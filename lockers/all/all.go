@@ -2,4 +2,6 @@ package all
 
 import (
 	_ "github.com/karimra/gnmic/lockers/consul_locker"
+	_ "github.com/karimra/gnmic/lockers/etcd_locker"
+	_ "github.com/karimra/gnmic/lockers/k8s_locker"
 )
@@ -0,0 +1,299 @@
+package etcd_locker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/karimra/gnmic/lockers"
+	"github.com/karimra/gnmic/utils"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	defaultEndpoint    = "localhost:2379"
+	defaultSessionTTL  = 10 * time.Second
+	defaultRetryTimer  = 2 * time.Second
+	defaultDialTimeout = 5 * time.Second
+	loggingPrefix      = "[etcd_locker] "
+)
+
+func init() {
+	lockers.Register("etcd", func() lockers.Locker {
+		return &EtcdLocker{
+			Cfg:            &config{},
+			m:              new(sync.Mutex),
+			acquiredlocks:  make(map[string]*locks),
+			attemtinglocks: make(map[string]*locks),
+			logger:         log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+			services:       make(map[string]*service),
+		}
+	})
+}
+
+type EtcdLocker struct {
+	Cfg            *config
+	client         *clientv3.Client
+	logger         *log.Logger
+	m              *sync.Mutex
+	acquiredlocks  map[string]*locks
+	attemtinglocks map[string]*locks
+	services       map[string]*service
+}
+
+type config struct {
+	Endpoints   []string      `mapstructure:"endpoints,omitempty" json:"endpoints,omitempty"`
+	Username    string        `mapstructure:"username,omitempty" json:"username,omitempty"`
+	Password    string        `mapstructure:"password,omitempty" json:"password,omitempty"`
+	TLS         *tlsConfig    `mapstructure:"tls,omitempty" json:"tls,omitempty"`
+	DialTimeout time.Duration `mapstructure:"dial-timeout,omitempty" json:"dial-timeout,omitempty"`
+	SessionTTL  time.Duration `mapstructure:"session-ttl,omitempty" json:"session-ttl,omitempty"`
+	RetryTimer  time.Duration `mapstructure:"retry-timer,omitempty" json:"retry-timer,omitempty"`
+	Debug       bool          `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+}
+
+type tlsConfig struct {
+	CaFile     string `mapstructure:"ca-file,omitempty" json:"ca-file,omitempty"`
+	CertFile   string `mapstructure:"cert-file,omitempty" json:"cert-file,omitempty"`
+	KeyFile    string `mapstructure:"key-file,omitempty" json:"key-file,omitempty"`
+	SkipVerify bool   `mapstructure:"skip-verify,omitempty" json:"skip-verify,omitempty"`
+}
+
+type locks struct {
+	leaseID  clientv3.LeaseID
+	doneChan chan struct{}
+}
+
+func (e *EtcdLocker) Init(ctx context.Context, cfg map[string]interface{}, opts ...lockers.Option) error {
+	err := lockers.DecodeConfig(cfg, e.Cfg)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	err = e.setDefaults()
+	if err != nil {
+		return err
+	}
+	clientConfig := clientv3.Config{
+		Context:     ctx,
+		Endpoints:   e.Cfg.Endpoints,
+		DialTimeout: e.Cfg.DialTimeout,
+		Username:    e.Cfg.Username,
+		Password:    e.Cfg.Password,
+	}
+	if e.Cfg.TLS != nil {
+		clientConfig.TLS, err = utils.NewTLSConfig(e.Cfg.TLS.CaFile, e.Cfg.TLS.CertFile, e.Cfg.TLS.KeyFile, e.Cfg.TLS.SkipVerify)
+		if err != nil {
+			return err
+		}
+	}
+	e.client, err = clientv3.New(clientConfig)
+	if err != nil {
+		return err
+	}
+	e.logger.Printf("initialized etcd locker with cfg=%s", e)
+	return nil
+}
+
+// Lock blocks until the key is created with the given value,
+// attached to a lease owned by this locker.
+func (e *EtcdLocker) Lock(ctx context.Context, key string, val []byte) (bool, error) {
+	doneChan := make(chan struct{})
+	defer func() {
+		e.m.Lock()
+		defer e.m.Unlock()
+		delete(e.attemtinglocks, key)
+	}()
+	e.m.Lock()
+	e.attemtinglocks[key] = &locks{doneChan: doneChan}
+	e.m.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-doneChan:
+			return false, lockers.ErrCanceled
+		default:
+			lease, err := e.client.Grant(ctx, int64(e.Cfg.SessionTTL/time.Second))
+			if err != nil {
+				e.logger.Printf("failed creating lease: %v", err)
+				time.Sleep(e.Cfg.RetryTimer)
+				continue
+			}
+			rsp, err := e.client.Txn(ctx).
+				If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+				Then(clientv3.OpPut(key, string(val), clientv3.WithLease(lease.ID))).
+				Commit()
+			if err != nil {
+				e.logger.Printf("failed acquiring lock to %q: %v", key, err)
+				e.revoke(lease.ID)
+				time.Sleep(e.Cfg.RetryTimer)
+				continue
+			}
+			if rsp.Succeeded {
+				e.m.Lock()
+				defer e.m.Unlock()
+				select {
+				case <-doneChan:
+					// unlocked while acquiring
+					e.revoke(lease.ID)
+					return false, lockers.ErrCanceled
+				default:
+				}
+				e.acquiredlocks[key] = &locks{leaseID: lease.ID, doneChan: doneChan}
+				return true, nil
+			}
+			e.revoke(lease.ID)
+			if e.Cfg.Debug {
+				e.logger.Printf("failed acquiring lock to %q: already locked", key)
+			}
+			time.Sleep(e.Cfg.RetryTimer)
+		}
+	}
+}
+
+// KeepLock keeps the lease attached to the key alive,
+// until the key is unlocked or the lease is lost.
+func (e *EtcdLocker) KeepLock(ctx context.Context, key string) (chan struct{}, chan error) {
+	e.m.Lock()
+	var leaseID clientv3.LeaseID
+	doneChan := make(chan struct{})
+	if l, ok := e.acquiredlocks[key]; ok {
+		leaseID = l.leaseID
+		doneChan = l.doneChan
+	}
+	e.m.Unlock()
+	errChan := make(chan error, 1)
+	go func() {
+		if leaseID == 0 {
+			errChan <- fmt.Errorf("unknown key")
+			close(doneChan)
+			return
+		}
+		kctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		kaChan, err := e.client.KeepAlive(kctx, leaseID)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		for {
+			select {
+			case <-doneChan:
+				return
+			case _, ok := <-kaChan:
+				if !ok {
+					errChan <- fmt.Errorf("lost lease for key %q", key)
+					return
+				}
+			}
+		}
+	}()
+	return doneChan, errChan
+}
+
+func (e *EtcdLocker) IsLocked(ctx context.Context, key string) (bool, error) {
+	rsp, err := e.client.Get(ctx, key, clientv3.WithCountOnly())
+	if err != nil {
+		return false, err
+	}
+	return rsp.Count > 0, nil
+}
+
+func (e *EtcdLocker) Unlock(ctx context.Context, key string) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.unlock(ctx, key)
+}
+
+func (e *EtcdLocker) unlock(ctx context.Context, key string) error {
+	if lock, ok := e.acquiredlocks[key]; ok {
+		close(lock.doneChan)
+		delete(e.acquiredlocks, key)
+		// revoking the lease deletes the key
+		_, err := e.client.Revoke(ctx, lock.leaseID)
+		return err
+	}
+	if lock, ok := e.attemtinglocks[key]; ok {
+		close(lock.doneChan)
+		delete(e.attemtinglocks, key)
+		return nil
+	}
+	return errors.New("unknown key")
+}
+
+func (e *EtcdLocker) List(ctx context.Context, prefix string) (map[string]string, error) {
+	rsp, err := e.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	rs := make(map[string]string, len(rsp.Kvs))
+	for _, kv := range rsp.Kvs {
+		rs[string(kv.Key)] = string(kv.Value)
+	}
+	return rs, nil
+}
+
+func (e *EtcdLocker) Stop() error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for k := range e.acquiredlocks {
+		e.unlock(ctx, k)
+	}
+	for _, s := range e.services {
+		s.cancel()
+	}
+	return e.client.Close()
+}
+
+func (e *EtcdLocker) SetLogger(logger *log.Logger) {
+	if logger != nil && e.logger != nil {
+		e.logger.SetOutput(logger.Writer())
+		e.logger.SetFlags(logger.Flags())
+	}
+}
+
+// helpers
+
+func (e *EtcdLocker) setDefaults() error {
+	if len(e.Cfg.Endpoints) == 0 {
+		e.Cfg.Endpoints = []string{defaultEndpoint}
+	}
+	if e.Cfg.DialTimeout <= 0 {
+		e.Cfg.DialTimeout = defaultDialTimeout
+	}
+	// etcd lease TTLs have a second granularity
+	if e.Cfg.SessionTTL < time.Second {
+		e.Cfg.SessionTTL = defaultSessionTTL
+	}
+	if e.Cfg.RetryTimer <= 0 {
+		e.Cfg.RetryTimer = defaultRetryTimer
+	}
+	return nil
+}
+
+func (e *EtcdLocker) revoke(id clientv3.LeaseID) {
+	ctx, cancel := context.WithTimeout(context.Background(), e.Cfg.DialTimeout)
+	defer cancel()
+	_, err := e.client.Revoke(ctx, id)
+	if err != nil && e.Cfg.Debug {
+		e.logger.Printf("failed to revoke lease %x: %v", id, err)
+	}
+}
+
+func (e *EtcdLocker) String() string {
+	b, err := json.Marshal(e.Cfg)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
@@ -0,0 +1,208 @@
+package etcd_locker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/lockers"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+func startEtcd(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "gnmic-etcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	cfg.LogLevel = "error"
+	clientURL, _ := url.Parse("http://127.0.0.1:0")
+	peerURL, _ := url.Parse("http://127.0.0.1:0")
+	cfg.LCUrls = []url.URL{*clientURL}
+	cfg.ACUrls = []url.URL{*clientURL}
+	cfg.LPUrls = []url.URL{*peerURL}
+	cfg.APUrls = []url.URL{*peerURL}
+	cfg.InitialCluster = fmt.Sprintf("%s=%s", cfg.Name, peerURL.String())
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		e.Close()
+		os.RemoveAll(dir)
+		t.Fatal("embedded etcd server took too long to start")
+	}
+	return e.Clients[0].Addr().String(), func() {
+		e.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func newTestLocker(t *testing.T, endpoint string) *EtcdLocker {
+	l := &EtcdLocker{
+		Cfg:            &config{},
+		m:              new(sync.Mutex),
+		acquiredlocks:  make(map[string]*locks),
+		attemtinglocks: make(map[string]*locks),
+		logger:         log.New(ioutil.Discard, loggingPrefix, log.LstdFlags),
+		services:       make(map[string]*service),
+	}
+	err := l.Init(context.Background(), map[string]interface{}{
+		"endpoints":   []string{endpoint},
+		"session-ttl": "2s",
+		"retry-timer": "100ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestLock(t *testing.T) {
+	endpoint, stop := startEtcd(t)
+	defer stop()
+	l1 := newTestLocker(t, endpoint)
+	defer l1.Stop()
+	l2 := newTestLocker(t, endpoint)
+	defer l2.Stop()
+	ctx := context.Background()
+	key := "gnmic/cluster1/targets/target1"
+
+	ok, err := l1.Lock(ctx, key, []byte("instance1"))
+	if err != nil || !ok {
+		t.Fatalf("expected lock to be acquired: %v", err)
+	}
+	locked, err := l2.IsLocked(ctx, key)
+	if err != nil || !locked {
+		t.Fatalf("expected key to be locked: %v", err)
+	}
+	values, err := l2.List(ctx, "gnmic/cluster1/targets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[key] != "instance1" {
+		t.Fatalf("unexpected lock value: %v", values)
+	}
+	doneChan, errChan := l1.KeepLock(ctx, key)
+
+	// l2 cannot acquire the lock while l1 keeps it alive
+	lctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err = l2.Lock(lctx, key, []byte("instance2"))
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected the second lock attempt to time out, got %v", err)
+	}
+
+	// unlocking closes the KeepLock done channel and releases the key
+	err = l1.Unlock(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-doneChan:
+	case err := <-errChan:
+		t.Fatalf("unexpected KeepLock error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("KeepLock done channel not closed after unlock")
+	}
+	ok, err = l2.Lock(ctx, key, []byte("instance2"))
+	if err != nil || !ok {
+		t.Fatalf("expected lock to be acquired by the second locker: %v", err)
+	}
+}
+
+func TestLockCanceled(t *testing.T) {
+	endpoint, stop := startEtcd(t)
+	defer stop()
+	l1 := newTestLocker(t, endpoint)
+	defer l1.Stop()
+	l2 := newTestLocker(t, endpoint)
+	defer l2.Stop()
+	ctx := context.Background()
+	key := "gnmic/cluster1/leader"
+
+	if _, err := l1.Lock(ctx, key, []byte("instance1")); err != nil {
+		t.Fatal(err)
+	}
+	errCh := make(chan error)
+	go func() {
+		_, err := l2.Lock(ctx, key, []byte("instance2"))
+		errCh <- err
+	}()
+	time.Sleep(300 * time.Millisecond)
+	if err := l2.Unlock(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errCh:
+		if err != lockers.ErrCanceled {
+			t.Fatalf("expected ErrCanceled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("lock attempt not canceled")
+	}
+}
+
+func TestWatchServices(t *testing.T) {
+	endpoint, stop := startEtcd(t)
+	defer stop()
+	l := newTestLocker(t, endpoint)
+	defer l.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sChan := make(chan []*lockers.Service, 10)
+	go l.WatchServices(ctx, "cluster1-gnmic-api", []string{"cluster-name=cluster1"}, sChan, time.Minute)
+	waitServices := func(n int) []*lockers.Service {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case srvs := <-sChan:
+				if len(srvs) == n {
+					return srvs
+				}
+			case <-timeout:
+				t.Fatalf("timeout waiting for %d service(s)", n)
+			}
+		}
+	}
+	waitServices(0)
+
+	for i, tag := range []string{"cluster-name=cluster1", "cluster-name=cluster2"} {
+		go l.Register(ctx, &lockers.ServiceRegistration{
+			ID:      fmt.Sprintf("instance%d-api", i),
+			Name:    "cluster1-gnmic-api",
+			Address: "10.0.0.1",
+			Port:    7890 + i,
+			Tags:    []string{tag},
+			TTL:     2 * time.Second,
+		})
+	}
+	srvs := waitServices(1)
+	if srvs[0].ID != "instance0-api" || srvs[0].Address != "10.0.0.1:7890" {
+		t.Fatalf("unexpected service: %+v", srvs[0])
+	}
+	// the registration is kept alive beyond its TTL
+	time.Sleep(3 * time.Second)
+	values, err := l.List(ctx, servicesPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 {
+		t.Fatalf("expected 2 registered services, got %v", values)
+	}
+	if err = l.Deregister("instance0-api"); err != nil {
+		t.Fatal(err)
+	}
+	waitServices(0)
+}
@@ -0,0 +1,182 @@
+package etcd_locker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/karimra/gnmic/lockers"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	servicesPrefix      = "gnmic/services"
+	defaultWatchTimeout = 1 * time.Minute
+)
+
+type service struct {
+	key    string
+	cancel context.CancelFunc
+}
+
+func serviceKey(name, id string) string {
+	return fmt.Sprintf("%s/%s/%s", servicesPrefix, name, id)
+}
+
+// Register writes the service registration under a key attached to a lease with the service TTL,
+// then keeps the lease alive until the context is canceled or the service is deregistered.
+func (e *EtcdLocker) Register(ctx context.Context, s *lockers.ServiceRegistration) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	ttl := int64(s.TTL / time.Second)
+	if ttl <= 0 {
+		ttl = int64(e.Cfg.SessionTTL / time.Second)
+	}
+	key := serviceKey(s.Name, s.ID)
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	e.m.Lock()
+	e.services[s.ID] = &service{key: key, cancel: cancel}
+	e.m.Unlock()
+
+	lease, err := e.client.Grant(sctx, ttl)
+	if err != nil {
+		return err
+	}
+	defer e.revoke(lease.ID)
+	_, err = e.client.Put(sctx, key, string(b), clientv3.WithLease(lease.ID))
+	if err != nil {
+		return err
+	}
+	kaChan, err := e.client.KeepAlive(sctx, lease.ID)
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-sctx.Done():
+			return nil
+		case _, ok := <-kaChan:
+			if !ok {
+				select {
+				case <-sctx.Done():
+					return nil
+				default:
+					return fmt.Errorf("lost lease for service %q", s.ID)
+				}
+			}
+		}
+	}
+}
+
+func (e *EtcdLocker) Deregister(s string) error {
+	e.m.Lock()
+	srv, ok := e.services[s]
+	if ok {
+		srv.cancel()
+		delete(e.services, s)
+	}
+	e.m.Unlock()
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.Cfg.DialTimeout)
+	defer cancel()
+	_, err := e.client.Delete(ctx, srv.key)
+	return err
+}
+
+// WatchServices sends the list of registered services with the given name and tags to sChan,
+// each time a change is detected under the services key prefix.
+// The full list is re-read every watchTimeout.
+func (e *EtcdLocker) WatchServices(ctx context.Context, serviceName string, tags []string, sChan chan<- []*lockers.Service, watchTimeout time.Duration) error {
+	if watchTimeout <= 0 {
+		watchTimeout = defaultWatchTimeout
+	}
+	prefix := serviceKey(serviceName, "")
+	for {
+		rev, err := e.listServices(ctx, prefix, tags, sChan)
+		if err != nil {
+			return err
+		}
+		if e.Cfg.Debug {
+			e.logger.Printf("(re)starting watch service=%q, revision=%d", serviceName, rev)
+		}
+		wctx, cancel := context.WithTimeout(ctx, watchTimeout)
+		wChan := e.client.Watch(clientv3.WithRequireLeader(wctx), prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+	WATCH:
+		for {
+			select {
+			case <-ctx.Done():
+				cancel()
+				return ctx.Err()
+			case wrsp, ok := <-wChan:
+				if !ok {
+					// watch timeout, re-list
+					break WATCH
+				}
+				if err := wrsp.Err(); err != nil {
+					cancel()
+					return err
+				}
+				rev, err = e.listServices(ctx, prefix, tags, sChan)
+				if err != nil {
+					cancel()
+					return err
+				}
+			}
+		}
+		cancel()
+	}
+}
+
+func (e *EtcdLocker) listServices(ctx context.Context, prefix string, tags []string, sChan chan<- []*lockers.Service) (int64, error) {
+	rsp, err := e.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+	newSrvs := make([]*lockers.Service, 0, len(rsp.Kvs))
+	for _, kv := range rsp.Kvs {
+		s := new(lockers.ServiceRegistration)
+		err = json.Unmarshal(kv.Value, s)
+		if err != nil {
+			e.logger.Printf("failed to decode service %q: %v", kv.Key, err)
+			continue
+		}
+		if !hasTags(s.Tags, tags) {
+			continue
+		}
+		newSrvs = append(newSrvs, &lockers.Service{
+			ID:      s.ID,
+			Address: net.JoinHostPort(s.Address, strconv.Itoa(s.Port)),
+			Tags:    s.Tags,
+		})
+	}
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case sChan <- newSrvs:
+	}
+	return rsp.Header.Revision, nil
+}
+
+func hasTags(srvTags, tags []string) bool {
+	for _, t := range tags {
+		found := false
+		for _, st := range srvTags {
+			if st == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
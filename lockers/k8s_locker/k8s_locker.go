@@ -0,0 +1,434 @@
+package k8s_locker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/karimra/gnmic/lockers"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	defaultNamespace  = "default"
+	defaultLeaseTTL   = 10 * time.Second
+	defaultRetryTimer = 2 * time.Second
+	loggingPrefix     = "[k8s_locker] "
+	// file containing the namespace of the pod when running in a kubernetes cluster
+	namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "gnmic"
+	// annotation holding the original lock key
+	keyAnnotation = "gnmic.io/key"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+func init() {
+	lockers.Register("kubernetes", func() lockers.Locker {
+		return &K8sLocker{
+			Cfg:            &config{},
+			m:              new(sync.Mutex),
+			acquiredlocks:  make(map[string]*locks),
+			attemtinglocks: make(map[string]*locks),
+			logger:         log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+			services:       make(map[string]context.CancelFunc),
+		}
+	})
+}
+
+type K8sLocker struct {
+	Cfg            *config
+	client         kubernetes.Interface
+	logger         *log.Logger
+	m              *sync.Mutex
+	acquiredlocks  map[string]*locks
+	attemtinglocks map[string]*locks
+	services       map[string]context.CancelFunc
+}
+
+type config struct {
+	// path to a kubeconfig file, if empty the in-cluster config is used,
+	// falling back to the default kubeconfig loading rules.
+	Kubeconfig string `mapstructure:"kubeconfig,omitempty" json:"kubeconfig,omitempty"`
+	// namespace of the Leases and ConfigMaps, defaults to the pod namespace
+	Namespace   string        `mapstructure:"namespace,omitempty" json:"namespace,omitempty"`
+	LeaseTTL    time.Duration `mapstructure:"lease-ttl,omitempty" json:"lease-ttl,omitempty"`
+	RetryTimer  time.Duration `mapstructure:"retry-timer,omitempty" json:"retry-timer,omitempty"`
+	RenewPeriod time.Duration `mapstructure:"renew-period,omitempty" json:"renew-period,omitempty"`
+	Debug       bool          `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+}
+
+type locks struct {
+	holder   string
+	doneChan chan struct{}
+}
+
+func (k *K8sLocker) Init(ctx context.Context, cfg map[string]interface{}, opts ...lockers.Option) error {
+	err := lockers.DecodeConfig(cfg, k.Cfg)
+	if err != nil {
+		return err
+	}
+	for _, opt := range opts {
+		opt(k)
+	}
+	err = k.setDefaults()
+	if err != nil {
+		return err
+	}
+	if k.client == nil {
+		k.client, err = k.createClient()
+		if err != nil {
+			return err
+		}
+	}
+	k.logger.Printf("initialized kubernetes locker with cfg=%s", k)
+	return nil
+}
+
+// Lock blocks until the Lease corresponding to the key is held by this locker.
+// The Lease holder identity is set to the given value.
+func (k *K8sLocker) Lock(ctx context.Context, key string, val []byte) (bool, error) {
+	doneChan := make(chan struct{})
+	defer func() {
+		k.m.Lock()
+		defer k.m.Unlock()
+		delete(k.attemtinglocks, key)
+	}()
+	k.m.Lock()
+	k.attemtinglocks[key] = &locks{doneChan: doneChan}
+	k.m.Unlock()
+	holder := string(val)
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-doneChan:
+			return false, lockers.ErrCanceled
+		default:
+			acquired, err := k.tryLock(ctx, key, holder)
+			if err != nil {
+				k.logger.Printf("failed acquiring lock to %q: %v", key, err)
+				time.Sleep(k.Cfg.RetryTimer)
+				continue
+			}
+			if acquired {
+				k.m.Lock()
+				defer k.m.Unlock()
+				select {
+				case <-doneChan:
+					// unlocked while acquiring
+					k.deleteLease(ctx, key, holder)
+					return false, lockers.ErrCanceled
+				default:
+				}
+				k.acquiredlocks[key] = &locks{holder: holder, doneChan: doneChan}
+				return true, nil
+			}
+			if k.Cfg.Debug {
+				k.logger.Printf("failed acquiring lock to %q: already locked", key)
+			}
+			time.Sleep(k.Cfg.RetryTimer)
+		}
+	}
+}
+
+func (k *K8sLocker) tryLock(ctx context.Context, key, holder string) (bool, error) {
+	now := metav1.NowMicro()
+	leases := k.client.CoordinationV1().Leases(k.Cfg.Namespace)
+	lease, err := leases.Get(ctx, leaseName(key), metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		_, err = leases.Create(ctx, k.newLease(key, holder, now), metav1.CreateOptions{})
+		if kerrors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+	if isHeld(lease) {
+		return false, nil
+	}
+	// the lease expired or was released, take it over
+	lease.Spec.HolderIdentity = &holder
+	lease.Spec.LeaseDurationSeconds = k.leaseDurationSeconds()
+	lease.Spec.AcquireTime = &now
+	lease.Spec.RenewTime = &now
+	transitions := int32(1)
+	if lease.Spec.LeaseTransitions != nil {
+		transitions = *lease.Spec.LeaseTransitions + 1
+	}
+	lease.Spec.LeaseTransitions = &transitions
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if kerrors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// KeepLock renews the Lease corresponding to the key every renew-period,
+// until the key is unlocked or the Lease is lost.
+func (k *K8sLocker) KeepLock(ctx context.Context, key string) (chan struct{}, chan error) {
+	k.m.Lock()
+	holder := ""
+	doneChan := make(chan struct{})
+	if l, ok := k.acquiredlocks[key]; ok {
+		holder = l.holder
+		doneChan = l.doneChan
+	}
+	k.m.Unlock()
+	errChan := make(chan error, 1)
+	go func() {
+		if holder == "" {
+			errChan <- fmt.Errorf("unknown key")
+			close(doneChan)
+			return
+		}
+		ticker := time.NewTicker(k.Cfg.RenewPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-doneChan:
+				return
+			case <-ticker.C:
+				err := k.renew(ctx, key, holder)
+				if err != nil {
+					errChan <- err
+					return
+				}
+			}
+		}
+	}()
+	return doneChan, errChan
+}
+
+func (k *K8sLocker) renew(ctx context.Context, key, holder string) error {
+	leases := k.client.CoordinationV1().Leases(k.Cfg.Namespace)
+	lease, err := leases.Get(ctx, leaseName(key), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+		return fmt.Errorf("lost lock %q", key)
+	}
+	now := metav1.NowMicro()
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+func (k *K8sLocker) IsLocked(ctx context.Context, key string) (bool, error) {
+	lease, err := k.client.CoordinationV1().Leases(k.Cfg.Namespace).Get(ctx, leaseName(key), metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return isHeld(lease), nil
+}
+
+func (k *K8sLocker) Unlock(ctx context.Context, key string) error {
+	k.m.Lock()
+	defer k.m.Unlock()
+	return k.unlock(ctx, key)
+}
+
+func (k *K8sLocker) unlock(ctx context.Context, key string) error {
+	if lock, ok := k.acquiredlocks[key]; ok {
+		close(lock.doneChan)
+		delete(k.acquiredlocks, key)
+		return k.deleteLease(ctx, key, lock.holder)
+	}
+	if lock, ok := k.attemtinglocks[key]; ok {
+		close(lock.doneChan)
+		delete(k.attemtinglocks, key)
+		return nil
+	}
+	return errors.New("unknown key")
+}
+
+// List returns the keys of the held Leases starting with prefix,
+// mapped to their holder identity.
+func (k *K8sLocker) List(ctx context.Context, prefix string) (map[string]string, error) {
+	leaseList, err := k.client.CoordinationV1().Leases(k.Cfg.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: managedByLabel + "=" + managedByValue,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rs := make(map[string]string)
+	for i := range leaseList.Items {
+		lease := &leaseList.Items[i]
+		key := lease.Annotations[keyAnnotation]
+		if !strings.HasPrefix(key, prefix) || !isHeld(lease) {
+			continue
+		}
+		rs[key] = *lease.Spec.HolderIdentity
+	}
+	return rs, nil
+}
+
+func (k *K8sLocker) Stop() error {
+	k.m.Lock()
+	defer k.m.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for key := range k.acquiredlocks {
+		k.unlock(ctx, key)
+	}
+	for _, cfn := range k.services {
+		cfn()
+	}
+	return nil
+}
+
+func (k *K8sLocker) SetLogger(logger *log.Logger) {
+	if logger != nil && k.logger != nil {
+		k.logger.SetOutput(logger.Writer())
+		k.logger.SetFlags(logger.Flags())
+	}
+}
+
+// helpers
+
+func (k *K8sLocker) setDefaults() error {
+	if k.Cfg.Namespace == "" {
+		k.Cfg.Namespace = defaultNamespace
+		if b, err := ioutil.ReadFile(namespaceFile); err == nil {
+			k.Cfg.Namespace = strings.TrimSpace(string(b))
+		}
+	}
+	// Lease durations have a second granularity
+	if k.Cfg.LeaseTTL < time.Second {
+		k.Cfg.LeaseTTL = defaultLeaseTTL
+	}
+	if k.Cfg.RetryTimer <= 0 {
+		k.Cfg.RetryTimer = defaultRetryTimer
+	}
+	if k.Cfg.RenewPeriod <= 0 || k.Cfg.RenewPeriod >= k.Cfg.LeaseTTL {
+		k.Cfg.RenewPeriod = k.Cfg.LeaseTTL / 2
+	}
+	return nil
+}
+
+func (k *K8sLocker) createClient() (kubernetes.Interface, error) {
+	var restConfig *rest.Config
+	var err error
+	if k.Cfg.Kubeconfig != "" {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", k.Cfg.Kubeconfig)
+	} else {
+		restConfig, err = rest.InClusterConfig()
+		if errors.Is(err, rest.ErrNotInCluster) {
+			restConfig, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+				clientcmd.NewDefaultClientConfigLoadingRules(),
+				&clientcmd.ConfigOverrides{},
+			).ClientConfig()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
+func (k *K8sLocker) newLease(key, holder string, now metav1.MicroTime) *coordinationv1.Lease {
+	transitions := int32(0)
+	return &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        leaseName(key),
+			Namespace:   k.Cfg.Namespace,
+			Labels:      map[string]string{managedByLabel: managedByValue},
+			Annotations: map[string]string{keyAnnotation: key},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: k.leaseDurationSeconds(),
+			AcquireTime:          &now,
+			RenewTime:            &now,
+			LeaseTransitions:     &transitions,
+		},
+	}
+}
+
+func (k *K8sLocker) leaseDurationSeconds() *int32 {
+	d := int32(k.Cfg.LeaseTTL / time.Second)
+	return &d
+}
+
+// deleteLease deletes the Lease corresponding to the key, if it is held by holder.
+func (k *K8sLocker) deleteLease(ctx context.Context, key, holder string) error {
+	leases := k.client.CoordinationV1().Leases(k.Cfg.Namespace)
+	lease, err := leases.Get(ctx, leaseName(key), metav1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+		return nil
+	}
+	err = leases.Delete(ctx, lease.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+	})
+	if kerrors.IsNotFound(err) || kerrors.IsConflict(err) {
+		return nil
+	}
+	return err
+}
+
+func (k *K8sLocker) String() string {
+	b, err := json.Marshal(k.Cfg)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// isHeld returns true if the lease has a holder and was renewed within its duration.
+func isHeld(lease *coordinationv1.Lease) bool {
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+		return false
+	}
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+	return time.Now().Before(expiry)
+}
+
+// resourceName converts s into a valid kubernetes resource name,
+// a hash of s is appended to avoid collisions between sanitized names.
+func resourceName(prefix, s string) string {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	name := invalidNameChars.ReplaceAllString(strings.ToLower(s), "-")
+	name = strings.Trim(name, "-")
+	// max name length is 253, keep room for the prefix and the hash
+	if max := 253 - len(prefix) - 10; len(name) > max {
+		name = name[:max]
+	}
+	return fmt.Sprintf("%s%s-%08x", prefix, name, h.Sum32())
+}
+
+func leaseName(key string) string {
+	return resourceName("", key)
+}
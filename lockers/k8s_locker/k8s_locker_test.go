@@ -0,0 +1,183 @@
+package k8s_locker
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/lockers"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestLocker(t *testing.T, client kubernetes.Interface) *K8sLocker {
+	l := &K8sLocker{
+		Cfg:            &config{},
+		client:         client,
+		m:              new(sync.Mutex),
+		acquiredlocks:  make(map[string]*locks),
+		attemtinglocks: make(map[string]*locks),
+		logger:         log.New(ioutil.Discard, loggingPrefix, log.LstdFlags),
+		services:       make(map[string]context.CancelFunc),
+	}
+	err := l.Init(context.Background(), map[string]interface{}{
+		"namespace":    "gnmic",
+		"lease-ttl":    "2s",
+		"retry-timer":  "100ms",
+		"renew-period": "500ms",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestLock(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	l1 := newTestLocker(t, client)
+	l2 := newTestLocker(t, client)
+	ctx := context.Background()
+	key := "gnmic/cluster1/targets/router1"
+
+	ok, err := l1.Lock(ctx, key, []byte("instance1"))
+	if err != nil || !ok {
+		t.Fatalf("expected lock to be acquired: %v", err)
+	}
+	locked, err := l2.IsLocked(ctx, key)
+	if err != nil || !locked {
+		t.Fatalf("expected key to be locked: %v", err)
+	}
+	values, err := l2.List(ctx, "gnmic/cluster1/targets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values[key] != "instance1" {
+		t.Fatalf("unexpected locks list: %v", values)
+	}
+	doneChan, errChan := l1.KeepLock(ctx, key)
+
+	// l2 cannot acquire the lock while l1 renews it
+	lctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	_, err = l2.Lock(lctx, key, []byte("instance2"))
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected the second lock attempt to time out, got %v", err)
+	}
+	select {
+	case err := <-errChan:
+		t.Fatalf("unexpected KeepLock error: %v", err)
+	default:
+	}
+
+	err = l1.Unlock(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-doneChan:
+	case <-time.After(time.Second):
+		t.Fatal("KeepLock done channel not closed after unlock")
+	}
+	ok, err = l2.Lock(ctx, key, []byte("instance2"))
+	if err != nil || !ok {
+		t.Fatalf("expected lock to be acquired by the second locker: %v", err)
+	}
+}
+
+func TestLockExpired(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	l1 := newTestLocker(t, client)
+	l2 := newTestLocker(t, client)
+	ctx := context.Background()
+	key := "gnmic/cluster1/leader"
+
+	if _, err := l1.Lock(ctx, key, []byte("instance1")); err != nil {
+		t.Fatal(err)
+	}
+	// l1 does not renew the lease, l2 takes it over once expired
+	start := time.Now()
+	ok, err := l2.Lock(ctx, key, []byte("instance2"))
+	if err != nil || !ok {
+		t.Fatalf("expected lock to be acquired after expiry: %v", err)
+	}
+	if time.Since(start) < time.Second {
+		t.Errorf("lock acquired before the lease expired")
+	}
+	lease, err := client.CoordinationV1().Leases("gnmic").Get(ctx, leaseName(key), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *lease.Spec.HolderIdentity != "instance2" || *lease.Spec.LeaseTransitions != 1 {
+		t.Errorf("unexpected lease spec: %+v", lease.Spec)
+	}
+	// l1 KeepLock fails since it lost the lease
+	_, errChan := l1.KeepLock(ctx, key)
+	select {
+	case <-errChan:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected KeepLock to fail")
+	}
+}
+
+func TestWatchServices(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	l := newTestLocker(t, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sChan := make(chan []*lockers.Service, 10)
+	go l.WatchServices(ctx, "cluster1-gnmic-api", []string{"cluster-name=cluster1"}, sChan, time.Minute)
+	waitServices := func(n int) []*lockers.Service {
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case srvs := <-sChan:
+				if len(srvs) == n {
+					return srvs
+				}
+			case <-timeout:
+				t.Fatalf("timeout waiting for %d service(s)", n)
+			}
+		}
+	}
+	waitServices(0)
+
+	for i, tag := range []string{"cluster-name=cluster1", "cluster-name=cluster2"} {
+		go l.Register(ctx, &lockers.ServiceRegistration{
+			ID:      fmt.Sprintf("instance%d-api", i),
+			Name:    "cluster1-gnmic-api",
+			Address: "10.0.0.1",
+			Port:    7890 + i,
+			Tags:    []string{tag},
+			TTL:     time.Second,
+		})
+	}
+	srvs := waitServices(1)
+	if srvs[0].ID != "instance0-api" || srvs[0].Address != "10.0.0.1:7890" {
+		t.Fatalf("unexpected service: %+v", srvs[0])
+	}
+	if err := l.Deregister("instance0-api"); err != nil {
+		t.Fatal(err)
+	}
+	waitServices(0)
+}
+
+func TestResourceName(t *testing.T) {
+	names := map[string]bool{}
+	for _, key := range []string{"gnmic/c1/targets/r1:57400", "gnmic/c1/targets/r1_57400", "gnmic/c1/targets/R1-57400"} {
+		n := leaseName(key)
+		if names[n] {
+			t.Errorf("duplicate lease name %q", n)
+		}
+		names[n] = true
+		if errs := validation.IsDNS1123Subdomain(n); len(errs) > 0 {
+			t.Errorf("invalid lease name %q: %v", n, errs)
+		}
+	}
+}
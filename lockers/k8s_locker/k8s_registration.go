@@ -0,0 +1,245 @@
+package k8s_locker
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/karimra/gnmic/lockers"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	// label holding the sanitized service name
+	serviceLabel = "gnmic.io/service"
+	// ConfigMap data keys
+	serviceDataKey   = "service"
+	renewTimeDataKey = "renew-time"
+
+	serviceNamePrefix   = "gnmic-service-"
+	defaultWatchTimeout = 1 * time.Minute
+)
+
+// Register creates a labeled ConfigMap holding the service registration,
+// then refreshes its renew time every TTL/2 until the context is canceled or the service is deregistered.
+// Registrations not refreshed within their TTL are ignored by WatchServices.
+func (k *K8sLocker) Register(ctx context.Context, s *lockers.ServiceRegistration) error {
+	if s.TTL <= 0 {
+		s.TTL = k.Cfg.LeaseTTL
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	sctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	k.m.Lock()
+	k.services[s.ID] = cancel
+	k.m.Unlock()
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      resourceName(serviceNamePrefix, s.ID),
+			Namespace: k.Cfg.Namespace,
+			Labels: map[string]string{
+				managedByLabel: managedByValue,
+				serviceLabel:   labelValue(s.Name),
+			},
+		},
+		Data: map[string]string{
+			serviceDataKey:   string(b),
+			renewTimeDataKey: time.Now().Format(time.RFC3339Nano),
+		},
+	}
+	configMaps := k.client.CoreV1().ConfigMaps(k.Cfg.Namespace)
+	_, err = configMaps.Create(sctx, cm, metav1.CreateOptions{})
+	if kerrors.IsAlreadyExists(err) {
+		_, err = configMaps.Update(sctx, cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(s.TTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cm.Data[renewTimeDataKey] = time.Now().Format(time.RFC3339Nano)
+			_, err = configMaps.Update(sctx, cm, metav1.UpdateOptions{})
+			if err != nil {
+				return err
+			}
+		case <-sctx.Done():
+			return nil
+		}
+	}
+}
+
+func (k *K8sLocker) Deregister(s string) error {
+	k.m.Lock()
+	if cfn, ok := k.services[s]; ok {
+		cfn()
+		delete(k.services, s)
+	}
+	k.m.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := k.client.CoreV1().ConfigMaps(k.Cfg.Namespace).Delete(ctx, resourceName(serviceNamePrefix, s), metav1.DeleteOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// WatchServices sends the list of live services with the given name and tags to sChan,
+// each time the list changes.
+func (k *K8sLocker) WatchServices(ctx context.Context, serviceName string, tags []string, sChan chan<- []*lockers.Service, watchTimeout time.Duration) error {
+	if watchTimeout <= 0 {
+		watchTimeout = defaultWatchTimeout
+	}
+	listOpts := metav1.ListOptions{
+		LabelSelector: managedByLabel + "=" + managedByValue + "," + serviceLabel + "=" + labelValue(serviceName),
+	}
+	configMaps := k.client.CoreV1().ConfigMaps(k.Cfg.Namespace)
+	// the services list is re-evaluated every retry-timer to drop the expired registrations
+	ticker := time.NewTicker(k.Cfg.RetryTimer)
+	defer ticker.Stop()
+	var last string
+	for {
+		cms, err := configMaps.List(ctx, listOpts)
+		if err != nil {
+			return err
+		}
+		last, err = k.sendServices(ctx, cms.Items, tags, sChan, last, true)
+		if err != nil {
+			return err
+		}
+		if k.Cfg.Debug {
+			k.logger.Printf("(re)starting watch service=%q, resourceVersion=%s", serviceName, cms.ResourceVersion)
+		}
+		wopts := listOpts
+		wopts.ResourceVersion = cms.ResourceVersion
+		timeout := int64(watchTimeout / time.Second)
+		wopts.TimeoutSeconds = &timeout
+		w, err := configMaps.Watch(ctx, wopts)
+		if err != nil {
+			return err
+		}
+		items := make(map[string]corev1.ConfigMap, len(cms.Items))
+		for _, cm := range cms.Items {
+			items[cm.Name] = cm
+		}
+	WATCH:
+		for {
+			select {
+			case <-ctx.Done():
+				w.Stop()
+				return ctx.Err()
+			case ev, ok := <-w.ResultChan():
+				if !ok {
+					// watch timeout, re-list
+					break WATCH
+				}
+				cm, ok := ev.Object.(*corev1.ConfigMap)
+				if !ok {
+					w.Stop()
+					break WATCH
+				}
+				switch ev.Type {
+				case watch.Added, watch.Modified:
+					items[cm.Name] = *cm
+				case watch.Deleted:
+					delete(items, cm.Name)
+				}
+			case <-ticker.C:
+			}
+			list := make([]corev1.ConfigMap, 0, len(items))
+			for _, cm := range items {
+				list = append(list, cm)
+			}
+			last, err = k.sendServices(ctx, list, tags, sChan, last, false)
+			if err != nil {
+				w.Stop()
+				return err
+			}
+		}
+	}
+}
+
+// sendServices sends the live services matching tags to sChan,
+// if the list differs from the last sent one or force is true.
+// It returns the list identifier.
+func (k *K8sLocker) sendServices(ctx context.Context, cms []corev1.ConfigMap, tags []string, sChan chan<- []*lockers.Service, last string, force bool) (string, error) {
+	now := time.Now()
+	srvs := make([]*lockers.Service, 0, len(cms))
+	for _, cm := range cms {
+		s := new(lockers.ServiceRegistration)
+		err := json.Unmarshal([]byte(cm.Data[serviceDataKey]), s)
+		if err != nil {
+			k.logger.Printf("failed to decode service from ConfigMap %q: %v", cm.Name, err)
+			continue
+		}
+		renewTime, err := time.Parse(time.RFC3339Nano, cm.Data[renewTimeDataKey])
+		if err != nil || now.Sub(renewTime) > s.TTL {
+			continue
+		}
+		if !hasTags(s.Tags, tags) {
+			continue
+		}
+		srvs = append(srvs, &lockers.Service{
+			ID:      s.ID,
+			Address: net.JoinHostPort(s.Address, strconv.Itoa(s.Port)),
+			Tags:    s.Tags,
+		})
+	}
+	sort.Slice(srvs, func(i, j int) bool {
+		return srvs[i].ID < srvs[j].ID
+	})
+	ids := make([]string, 0, len(srvs))
+	for _, s := range srvs {
+		ids = append(ids, s.ID+"@"+s.Address)
+	}
+	current := strings.Join(ids, ",")
+	if !force && current == last {
+		return last, nil
+	}
+	select {
+	case <-ctx.Done():
+		return last, ctx.Err()
+	case sChan <- srvs:
+	}
+	return current, nil
+}
+
+func hasTags(srvTags, tags []string) bool {
+	for _, t := range tags {
+		found := false
+		for _, st := range srvTags {
+			if st == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// labelValue converts s into a valid label value
+func labelValue(s string) string {
+	v := invalidNameChars.ReplaceAllString(strings.ToLower(s), "-")
+	v = strings.Trim(v, "-")
+	if len(v) > 63 {
+		v = strings.Trim(v[:63], "-")
+	}
+	return v
+}
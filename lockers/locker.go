@@ -45,6 +45,8 @@ func WithLogger(logger *log.Logger) Option {
 
 var LockerTypes = []string{
 	"consul",
+	"etcd",
+	"kubernetes",
 }
 
 func Register(name string, initFn Initializer) {
@@ -0,0 +1,160 @@
+//go:build integration
+// +build integration
+
+// The tests in this file need an AMQP 0-9-1 broker, e.g. RabbitMQ, listening on
+// $AMQP_ADDRESS (default localhost:5672), run them with:
+//   go test -tags integration ./outputs/amqp_output/
+
+package amqp_output
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/outputs"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/streadway/amqp"
+	"google.golang.org/protobuf/proto"
+)
+
+const testExchange = "gnmic-test"
+
+func brokerAddress() string {
+	if addr := os.Getenv("AMQP_ADDRESS"); addr != "" {
+		return addr
+	}
+	return defaultAddress
+}
+
+func newTestOutput(t *testing.T, ctx context.Context, cfg map[string]interface{}) *AmqpOutput {
+	a := &AmqpOutput{Cfg: &Config{}, wg: new(sync.WaitGroup), logger: log.New(ioutil.Discard, "", 0)}
+	err := a.Init(ctx, "amqp1", cfg)
+	if err != nil {
+		t.Fatalf("failed to init output: %v", err)
+	}
+	return a
+}
+
+func dialBroker(t *testing.T) *amqp.Connection {
+	conn, err := amqp.Dial(brokerURL(brokerAddress(), false))
+	if err != nil {
+		t.Fatalf("failed to connect to the broker: %v", err)
+	}
+	return conn
+}
+
+// consume binds an exclusive queue to exchange and returns its deliveries.
+func consume(t *testing.T, exchange string) (<-chan amqp.Delivery, func()) {
+	conn := dialBroker(t)
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	err = ch.ExchangeDeclare(exchange, "topic", true, false, false, false, nil)
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	q, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err == nil {
+		err = ch.QueueBind(q.Name, "#", exchange, false, nil)
+	}
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	deliveries, err := ch.Consume(q.Name, "", true, true, false, false, nil)
+	if err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	return deliveries, func() { conn.Close() }
+}
+
+func TestWriteSync(t *testing.T) {
+	deliveries, stop := consume(t, testExchange)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := newTestOutput(t, ctx, map[string]interface{}{
+		"address":            brokerAddress(),
+		"exchange":           testExchange,
+		"declare-exchange":   true,
+		"publisher-confirms": true,
+		"format":             "proto",
+		"timeout":            "2s",
+		"recovery-wait-time": "100ms",
+	})
+	defer a.Close()
+
+	rsp := &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{Timestamp: 42},
+		},
+	}
+	meta := outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"}
+	// the first write can expire while the worker connects
+	deadline := time.Now().Add(5 * time.Second)
+	var err error
+	for {
+		if err = a.WriteSync(ctx, rsp, meta); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("failed to write: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	select {
+	case d := <-deliveries:
+		if d.RoutingKey != "telemetry.router1:57400.sub1" {
+			t.Errorf("unexpected routing key %q", d.RoutingKey)
+		}
+		if d.Headers[sourceHeader] != "router1:57400" || d.Headers[subscriptionNameHeader] != "sub1" {
+			t.Errorf("unexpected headers: %v", d.Headers)
+		}
+		got := new(gnmi.SubscribeResponse)
+		if err = proto.Unmarshal(d.Body, got); err != nil {
+			t.Fatalf("failed to unmarshal message: %v", err)
+		}
+		if !proto.Equal(got, rsp) {
+			t.Errorf("got message %v, want %v", got, rsp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not received from the broker")
+	}
+}
+
+func TestWriteSyncNotConfirmed(t *testing.T) {
+	// make sure the write fails because of the broker, not because it is unreachable
+	dialBroker(t).Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// the broker closes the channel publishing to an exchange that does not exist
+	a := newTestOutput(t, ctx, map[string]interface{}{
+		"address":            brokerAddress(),
+		"exchange":           "gnmic-test-missing",
+		"publisher-confirms": true,
+		"format":             "proto",
+		"timeout":            "2s",
+		"recovery-wait-time": "100ms",
+	})
+	defer a.Close()
+
+	rsp := &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{Timestamp: 42},
+		},
+	}
+	err := a.WriteSync(ctx, rsp, outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"})
+	if err == nil {
+		t.Fatal("expected an error writing to a missing exchange")
+	}
+}
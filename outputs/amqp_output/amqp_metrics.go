@@ -0,0 +1,56 @@
+package amqp_output
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var AmqpNumberOfSentMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "amqp_output",
+	Name:      "number_of_amqp_msgs_sent_success_total",
+	Help:      "Number of msgs successfully sent by gnmic amqp output",
+}, []string{"publisher_id", "routing_key"})
+
+var AmqpNumberOfSentBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "amqp_output",
+	Name:      "number_of_written_amqp_bytes_total",
+	Help:      "Number of bytes written by gnmic amqp output",
+}, []string{"publisher_id", "routing_key"})
+
+var AmqpNumberOfFailSendMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "amqp_output",
+	Name:      "number_of_amqp_msgs_sent_fail_total",
+	Help:      "Number of failed msgs sent by gnmic amqp output",
+}, []string{"publisher_id", "reason"})
+
+var AmqpSendDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "amqp_output",
+	Name:      "msg_send_duration_ns",
+	Help:      "gnmic amqp output send duration in ns",
+}, []string{"publisher_id"})
+
+func initMetrics() {
+	AmqpNumberOfSentMsgs.WithLabelValues("", "").Add(0)
+	AmqpNumberOfSentBytes.WithLabelValues("", "").Add(0)
+	AmqpNumberOfFailSendMsgs.WithLabelValues("", "").Add(0)
+	AmqpSendDuration.WithLabelValues("").Set(0)
+}
+
+func registerMetrics(reg *prometheus.Registry) error {
+	initMetrics()
+	var err error
+	if err = reg.Register(AmqpNumberOfSentMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(AmqpNumberOfSentBytes); err != nil {
+		return err
+	}
+	if err = reg.Register(AmqpNumberOfFailSendMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(AmqpSendDuration); err != nil {
+		return err
+	}
+	return nil
+}
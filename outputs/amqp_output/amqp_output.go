@@ -0,0 +1,578 @@
+package amqp_output
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/types"
+	"github.com/karimra/gnmic/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/streadway/amqp"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultAddress          = "localhost:5672"
+	defaultExchange         = "amq.topic"
+	defaultExchangeType     = "topic"
+	defaultRoutingKey       = `telemetry.{{ index . "source" }}.{{ index . "subscription-name" }}`
+	defaultTimeout          = 5 * time.Second
+	defaultNumWorkers       = 1
+	defaultFormat           = "event"
+	defaultRecoveryWaitTime = 10 * time.Second
+	loggingPrefix           = "[amqp_output] "
+
+	// message headers
+	sourceHeader           = "source"
+	subscriptionNameHeader = "subscription-name"
+)
+
+type protoMsg struct {
+	m    proto.Message
+	meta outputs.Meta
+	// set by WriteSync to get the write result
+	errCh chan error
+}
+
+func (m *protoMsg) done(err error) {
+	if m.errCh != nil {
+		m.errCh <- err
+	}
+}
+
+func init() {
+	outputs.Register("amqp", func() outputs.Output {
+		return &AmqpOutput{
+			Cfg:    &Config{},
+			wg:     new(sync.WaitGroup),
+			logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		}
+	})
+}
+
+// AmqpOutput publishes messages to an AMQP 0-9-1 exchange
+type AmqpOutput struct {
+	Cfg      *Config
+	logger   *log.Logger
+	mo       *formatters.MarshalOptions
+	cancelFn context.CancelFunc
+	msgChan  chan *protoMsg
+	wg       *sync.WaitGroup
+	evps     []formatters.EventProcessor
+
+	routingKeyTpl *template.Template
+	targetTpl     *template.Template
+	syncCache     outputs.SyncCache
+}
+
+// Config //
+type Config struct {
+	Name               string        `mapstructure:"name,omitempty" json:"name,omitempty"`
+	Address            string        `mapstructure:"address,omitempty" json:"address,omitempty"`
+	Username           string        `mapstructure:"username,omitempty" json:"username,omitempty"`
+	Password           string        `mapstructure:"password,omitempty" json:"-"`
+	VHost              string        `mapstructure:"vhost,omitempty" json:"vhost,omitempty"`
+	TLS                *tlsConfig    `mapstructure:"tls,omitempty" json:"tls,omitempty"`
+	Exchange           string        `mapstructure:"exchange,omitempty" json:"exchange,omitempty"`
+	ExchangeType       string        `mapstructure:"exchange-type,omitempty" json:"exchange-type,omitempty"`
+	DeclareExchange    bool          `mapstructure:"declare-exchange,omitempty" json:"declare-exchange,omitempty"`
+	RoutingKey         string        `mapstructure:"routing-key,omitempty" json:"routing-key,omitempty"`
+	PublisherConfirms  bool          `mapstructure:"publisher-confirms,omitempty" json:"publisher-confirms,omitempty"`
+	Persistent         bool          `mapstructure:"persistent,omitempty" json:"persistent,omitempty"`
+	Timeout            time.Duration `mapstructure:"timeout,omitempty" json:"timeout,omitempty"`
+	RecoveryWaitTime   time.Duration `mapstructure:"recovery-wait-time,omitempty" json:"recovery-wait-time,omitempty"`
+	Format             string        `mapstructure:"format,omitempty" json:"format,omitempty"`
+	AddTarget          string        `mapstructure:"add-target,omitempty" json:"add-target,omitempty"`
+	TargetTemplate     string        `mapstructure:"target-template,omitempty" json:"target-template,omitempty"`
+	NumWorkers         int           `mapstructure:"num-workers,omitempty" json:"num-workers,omitempty"`
+	Debug              bool          `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+	BufferSize         int           `mapstructure:"buffer-size,omitempty" json:"buffer-size,omitempty"`
+	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty" json:"override-timestamps,omitempty"`
+	EnableMetrics      bool          `mapstructure:"enable-metrics,omitempty" json:"enable-metrics,omitempty"`
+	EventProcessors    []string      `mapstructure:"event-processors,omitempty" json:"event-processors,omitempty"`
+}
+
+type tlsConfig struct {
+	CaFile     string `mapstructure:"ca-file,omitempty" json:"ca-file,omitempty"`
+	KeyFile    string `mapstructure:"key-file,omitempty" json:"key-file,omitempty"`
+	CertFile   string `mapstructure:"cert-file,omitempty" json:"cert-file,omitempty"`
+	SkipVerify bool   `mapstructure:"skip-verify,omitempty" json:"skip-verify,omitempty"`
+}
+
+func (a *AmqpOutput) String() string {
+	b, err := json.Marshal(a.Cfg)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (a *AmqpOutput) SetLogger(logger *log.Logger) {
+	if logger != nil && a.logger != nil {
+		a.logger.SetOutput(logger.Writer())
+		a.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (a *AmqpOutput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]*types.TargetConfig) {
+	for _, epName := range a.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				if err != nil {
+					a.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
+					continue
+				}
+				a.evps = append(a.evps, ep)
+				a.logger.Printf("added event processor '%s' of type=%s to amqp output", epName, epType)
+				continue
+			}
+			a.logger.Printf("%q event processor has an unknown type=%q", epName, epType)
+			continue
+		}
+		a.logger.Printf("%q event processor not found!", epName)
+	}
+}
+
+// Init //
+func (a *AmqpOutput) Init(ctx context.Context, name string, cfg map[string]interface{}, opts ...outputs.Option) error {
+	err := outputs.DecodeConfig(cfg, a.Cfg)
+	if err != nil {
+		return err
+	}
+	if a.Cfg.Name == "" {
+		a.Cfg.Name = name
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	err = a.setDefaults()
+	if err != nil {
+		return err
+	}
+	a.msgChan = make(chan *protoMsg, uint(a.Cfg.BufferSize))
+	a.mo = &formatters.MarshalOptions{
+		Format:     a.Cfg.Format,
+		OverrideTS: a.Cfg.OverrideTimestamps,
+	}
+	a.routingKeyTpl, err = template.New("routing-key").
+		Funcs(outputs.TemplateFuncs).
+		Parse(a.Cfg.RoutingKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse routing-key template: %v", err)
+	}
+	if a.Cfg.TargetTemplate == "" {
+		a.targetTpl = outputs.DefaultTargetTemplate
+	} else if a.Cfg.AddTarget != "" {
+		a.targetTpl, err = template.New("target-template").
+			Funcs(outputs.TemplateFuncs).
+			Parse(a.Cfg.TargetTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	dialCfg, err := a.createConfig()
+	if err != nil {
+		return err
+	}
+	initMetrics()
+	a.logger.Printf("initialized amqp output: %s", a.String())
+	ctx, a.cancelFn = context.WithCancel(ctx)
+	a.wg.Add(a.Cfg.NumWorkers)
+	for i := 0; i < a.Cfg.NumWorkers; i++ {
+		cfg := *dialCfg
+		cfg.Properties = amqp.Table{"connection_name": fmt.Sprintf("%s-%d", a.Cfg.Name, i)}
+		go a.worker(ctx, i, &cfg)
+	}
+	go func() {
+		<-ctx.Done()
+		a.Close()
+	}()
+	return nil
+}
+
+func (a *AmqpOutput) setDefaults() error {
+	if a.Cfg.Format == "" {
+		a.Cfg.Format = defaultFormat
+	}
+	if !(a.Cfg.Format == "event" || a.Cfg.Format == "protojson" || a.Cfg.Format == "prototext" || a.Cfg.Format == "proto" || a.Cfg.Format == "json") {
+		return fmt.Errorf("unsupported output format '%s' for output type amqp", a.Cfg.Format)
+	}
+	if a.Cfg.Address == "" {
+		a.Cfg.Address = defaultAddress
+	}
+	if a.Cfg.Exchange == "" {
+		a.Cfg.Exchange = defaultExchange
+	}
+	if a.Cfg.ExchangeType == "" {
+		a.Cfg.ExchangeType = defaultExchangeType
+	}
+	switch a.Cfg.ExchangeType {
+	case "direct", "fanout", "topic", "headers":
+	default:
+		return fmt.Errorf("unsupported exchange-type %q", a.Cfg.ExchangeType)
+	}
+	if a.Cfg.DeclareExchange && strings.HasPrefix(a.Cfg.Exchange, "amq.") {
+		return fmt.Errorf("exchange %q is reserved and cannot be declared", a.Cfg.Exchange)
+	}
+	if a.Cfg.RoutingKey == "" {
+		a.Cfg.RoutingKey = defaultRoutingKey
+	}
+	if a.Cfg.Timeout <= 0 {
+		a.Cfg.Timeout = defaultTimeout
+	}
+	if a.Cfg.RecoveryWaitTime <= 0 {
+		a.Cfg.RecoveryWaitTime = defaultRecoveryWaitTime
+	}
+	if a.Cfg.NumWorkers <= 0 {
+		a.Cfg.NumWorkers = defaultNumWorkers
+	}
+	if a.Cfg.Name == "" {
+		a.Cfg.Name = "gnmic-" + uuid.New().String()
+	}
+	return nil
+}
+
+// Write //
+func (a *AmqpOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta) {
+	if rsp == nil || a.mo == nil {
+		return
+	}
+
+	wctx, cancel := context.WithTimeout(ctx, a.Cfg.Timeout)
+	defer cancel()
+
+	select {
+	case <-ctx.Done():
+		return
+	case a.msgChan <- &protoMsg{m: rsp, meta: meta}:
+	case <-wctx.Done():
+		if a.Cfg.Debug {
+			a.logger.Printf("writing expired after %s, amqp output might not be initialized", a.Cfg.Timeout)
+		}
+		if a.Cfg.EnableMetrics {
+			AmqpNumberOfFailSendMsgs.WithLabelValues(a.Cfg.Name, "timeout").Inc()
+		}
+		return
+	}
+}
+
+// WriteSync implements outputs.SyncWriter,
+// with publisher-confirms, it returns once the message is confirmed by the broker.
+func (a *AmqpOutput) WriteSync(ctx context.Context, rsp proto.Message, meta outputs.Meta) error {
+	if rsp == nil {
+		return nil
+	}
+	if a.mo == nil {
+		return errors.New("amqp output not initialized")
+	}
+	wctx, cancel := context.WithTimeout(ctx, a.Cfg.Timeout)
+	defer cancel()
+
+	m := &protoMsg{m: rsp, meta: meta, errCh: make(chan error, 1)}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case a.msgChan <- m:
+	case <-wctx.Done():
+		return fmt.Errorf("writing expired after %s, amqp output might not be initialized", a.Cfg.Timeout)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-m.errCh:
+		return err
+	}
+}
+
+func (a *AmqpOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
+
+// Close //
+func (a *AmqpOutput) Close() error {
+	formatters.CloseProcessors(a.evps)
+	if a.cancelFn != nil {
+		a.cancelFn()
+	}
+	a.wg.Wait()
+	return nil
+}
+
+// Metrics //
+func (a *AmqpOutput) RegisterMetrics(reg *prometheus.Registry) {
+	if !a.Cfg.EnableMetrics {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		a.logger.Printf("failed to register metric: %v", err)
+	}
+}
+
+// publisher is an AMQP channel used by a single worker
+type publisher struct {
+	conn     *amqp.Connection
+	ch       *amqp.Channel
+	closed   chan *amqp.Error
+	confirms chan amqp.Confirmation
+}
+
+func (p *publisher) close() {
+	p.ch.Close()
+	p.conn.Close()
+}
+
+func (a *AmqpOutput) newPublisher(cfg *amqp.Config) (*publisher, error) {
+	conn, err := amqp.DialConfig(brokerURL(a.Cfg.Address, a.Cfg.TLS != nil), *cfg)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	p := &publisher{
+		conn:   conn,
+		ch:     ch,
+		closed: conn.NotifyClose(make(chan *amqp.Error, 1)),
+	}
+	if a.Cfg.DeclareExchange {
+		err = ch.ExchangeDeclare(a.Cfg.Exchange, a.Cfg.ExchangeType, true, false, false, false, nil)
+		if err != nil {
+			p.close()
+			return nil, fmt.Errorf("failed to declare exchange %q: %v", a.Cfg.Exchange, err)
+		}
+	}
+	if a.Cfg.PublisherConfirms {
+		err = ch.Confirm(false)
+		if err != nil {
+			p.close()
+			return nil, fmt.Errorf("failed to enable publisher confirms: %v", err)
+		}
+		p.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+	}
+	return p, nil
+}
+
+// publish sends a message to the exchange and, if publisher confirms are enabled,
+// waits for the broker confirmation.
+func (a *AmqpOutput) publish(ctx context.Context, p *publisher, key string, msg amqp.Publishing) error {
+	err := p.ch.Publish(a.Cfg.Exchange, key, false, false, msg)
+	if err != nil {
+		return err
+	}
+	if p.confirms == nil {
+		return nil
+	}
+	timer := time.NewTimer(a.Cfg.Timeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return fmt.Errorf("publish confirmation not received after %s", a.Cfg.Timeout)
+	case c, ok := <-p.confirms:
+		if !ok {
+			return errors.New("channel closed while waiting for publish confirmation")
+		}
+		if !c.Ack {
+			return fmt.Errorf("message %d not acknowledged by the broker", c.DeliveryTag)
+		}
+		return nil
+	}
+}
+
+func (a *AmqpOutput) worker(ctx context.Context, idx int, cfg *amqp.Config) {
+	var p *publisher
+	var err error
+	defer a.wg.Done()
+	workerLogPrefix := fmt.Sprintf("worker-%d", idx)
+	a.logger.Printf("%s starting", workerLogPrefix)
+CRPUB:
+	if ctx.Err() != nil {
+		return
+	}
+	p, err = a.newPublisher(cfg)
+	if err != nil {
+		a.logger.Printf("%s failed to create amqp publisher: %v", workerLogPrefix, err)
+		time.Sleep(a.Cfg.RecoveryWaitTime)
+		goto CRPUB
+	}
+	defer p.close()
+	a.logger.Printf("%s initialized amqp publisher: %s", workerLogPrefix, a.String())
+	for {
+		select {
+		case <-ctx.Done():
+			a.logger.Printf("%s shutting down", workerLogPrefix)
+			return
+		case err := <-p.closed:
+			a.logger.Printf("%s amqp connection closed: %v", workerLogPrefix, err)
+			p.close()
+			time.Sleep(a.Cfg.RecoveryWaitTime)
+			goto CRPUB
+		case m := <-a.msgChan:
+			err = outputs.AddSubscriptionTarget(m.m, m.meta, a.Cfg.AddTarget, a.targetTpl)
+			if err != nil {
+				a.logger.Printf("failed to add target to the response: %v", err)
+			}
+			b, err := a.syncCache.Marshal(m.m, m.errCh != nil, func() ([]byte, error) {
+				return a.mo.Marshal(m.m, m.meta, a.evps...)
+			})
+			if err != nil {
+				if a.Cfg.Debug {
+					a.logger.Printf("%s failed marshaling proto msg: %v", workerLogPrefix, err)
+				}
+				if a.Cfg.EnableMetrics {
+					AmqpNumberOfFailSendMsgs.WithLabelValues(a.Cfg.Name, "marshal_error").Inc()
+				}
+				// retrying a message that cannot be marshaled won't help
+				m.done(nil)
+				continue
+			}
+			if len(b) == 0 {
+				m.done(nil)
+				continue
+			}
+			key, err := a.routingKey(m.meta)
+			if err != nil {
+				a.logger.Printf("%s failed to build routing key: %v", workerLogPrefix, err)
+				if a.Cfg.EnableMetrics {
+					AmqpNumberOfFailSendMsgs.WithLabelValues(a.Cfg.Name, "routing_key_template_error").Inc()
+				}
+				m.done(nil)
+				continue
+			}
+			var start time.Time
+			if a.Cfg.EnableMetrics {
+				start = time.Now()
+			}
+			err = a.publish(ctx, p, key, a.newPublishing(b, m.meta))
+			if err != nil {
+				if a.Cfg.Debug {
+					a.logger.Printf("%s failed to publish amqp msg to exchange '%s' with routing key '%s': %v", workerLogPrefix, a.Cfg.Exchange, key, err)
+				}
+				if a.Cfg.EnableMetrics {
+					AmqpNumberOfFailSendMsgs.WithLabelValues(a.Cfg.Name, "publish_error").Inc()
+				}
+				m.done(err)
+				p.close()
+				time.Sleep(a.Cfg.RecoveryWaitTime)
+				goto CRPUB
+			}
+			if a.Cfg.EnableMetrics {
+				AmqpSendDuration.WithLabelValues(a.Cfg.Name).Set(float64(time.Since(start).Nanoseconds()))
+				AmqpNumberOfSentMsgs.WithLabelValues(a.Cfg.Name, key).Inc()
+				AmqpNumberOfSentBytes.WithLabelValues(a.Cfg.Name, key).Add(float64(len(b)))
+			}
+			m.done(nil)
+		}
+	}
+}
+
+func (a *AmqpOutput) newPublishing(b []byte, meta outputs.Meta) amqp.Publishing {
+	msg := amqp.Publishing{
+		ContentType: "application/json",
+		Timestamp:   time.Now(),
+		AppId:       a.Cfg.Name,
+		Body:        b,
+		Headers:     amqp.Table{},
+	}
+	switch a.Cfg.Format {
+	case "proto":
+		msg.ContentType = "application/octet-stream"
+	case "prototext":
+		msg.ContentType = "text/plain"
+	}
+	if a.Cfg.Persistent {
+		msg.DeliveryMode = amqp.Persistent
+	}
+	if s, ok := meta["source"]; ok {
+		msg.Headers[sourceHeader] = s
+	}
+	if s, ok := meta["subscription-name"]; ok {
+		msg.Headers[subscriptionNameHeader] = s
+	}
+	return msg
+}
+
+// routingKey executes the routing-key template,
+// dots and spaces in the metadata values are replaced so that they don't add words to the routing key.
+func (a *AmqpOutput) routingKey(meta outputs.Meta) (string, error) {
+	values := make(outputs.Meta, len(meta))
+	for k, v := range meta {
+		v = strings.ReplaceAll(v, ".", "-")
+		values[k] = strings.ReplaceAll(v, " ", "_")
+	}
+	sb := new(strings.Builder)
+	err := a.routingKeyTpl.Execute(sb, values)
+	if err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+func (a *AmqpOutput) createConfig() (*amqp.Config, error) {
+	cfg := &amqp.Config{
+		Vhost: a.Cfg.VHost,
+	}
+	if a.Cfg.Username != "" {
+		cfg.SASL = []amqp.Authentication{&amqp.PlainAuth{
+			Username: a.Cfg.Username,
+			Password: a.Cfg.Password,
+		}}
+	}
+	if a.Cfg.TLS != nil {
+		tlsCfg, err := utils.NewTLSConfig(a.Cfg.TLS.CaFile, a.Cfg.TLS.CertFile, a.Cfg.TLS.KeyFile, a.Cfg.TLS.SkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		if tlsCfg == nil {
+			tlsCfg = &tls.Config{}
+		}
+		cfg.TLSClientConfig = tlsCfg
+	}
+	return cfg, nil
+}
+
+// brokerURL adds a scheme to addresses without one
+func brokerURL(addr string, useTLS bool) string {
+	if strings.Contains(addr, "://") {
+		return addr
+	}
+	if useTLS {
+		return "amqps://" + addr
+	}
+	return "amqp://" + addr
+}
+
+func (a *AmqpOutput) SetName(name string) {
+	sb := strings.Builder{}
+	if name != "" {
+		sb.WriteString(name)
+		sb.WriteString("-")
+	}
+	sb.WriteString(a.Cfg.Name)
+	sb.WriteString("-amqp-pub")
+	a.Cfg.Name = sb.String()
+}
+
+func (a *AmqpOutput) SetClusterName(name string) {}
+
+func (a *AmqpOutput) SetTargetsConfig(map[string]*types.TargetConfig) {}
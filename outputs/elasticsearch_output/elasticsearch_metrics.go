@@ -0,0 +1,56 @@
+package elasticsearch_output
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var ElasticsearchNumberOfBulkRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "elasticsearch_output",
+	Name:      "number_of_bulk_requests_sent_success_total",
+	Help:      "Number of bulk requests successfully sent by gnmic elasticsearch output",
+}, []string{"name"})
+
+var ElasticsearchNumberOfIndexedDocuments = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "elasticsearch_output",
+	Name:      "number_of_documents_indexed_total",
+	Help:      "Number of documents successfully indexed by gnmic elasticsearch output",
+}, []string{"name"})
+
+var ElasticsearchNumberOfFailedDocuments = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "elasticsearch_output",
+	Name:      "number_of_documents_failed_total",
+	Help:      "Number of documents gnmic elasticsearch output failed to index",
+}, []string{"name", "reason"})
+
+var ElasticsearchBulkDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "elasticsearch_output",
+	Name:      "bulk_request_duration_ns",
+	Help:      "gnmic elasticsearch output bulk request duration in ns",
+}, []string{"name"})
+
+func initMetrics() {
+	ElasticsearchNumberOfBulkRequests.WithLabelValues("").Add(0)
+	ElasticsearchNumberOfIndexedDocuments.WithLabelValues("").Add(0)
+	ElasticsearchNumberOfFailedDocuments.WithLabelValues("", "").Add(0)
+	ElasticsearchBulkDuration.WithLabelValues("").Set(0)
+}
+
+func registerMetrics(reg *prometheus.Registry) error {
+	initMetrics()
+	var err error
+	if err = reg.Register(ElasticsearchNumberOfBulkRequests); err != nil {
+		return err
+	}
+	if err = reg.Register(ElasticsearchNumberOfIndexedDocuments); err != nil {
+		return err
+	}
+	if err = reg.Register(ElasticsearchNumberOfFailedDocuments); err != nil {
+		return err
+	}
+	if err = reg.Register(ElasticsearchBulkDuration); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,581 @@
+package elasticsearch_output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/types"
+	"github.com/karimra/gnmic/utils"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	outputType                 = "elasticsearch"
+	loggingPrefix              = "[elasticsearch_output] "
+	defaultAddress             = "http://localhost:9200"
+	defaultIndex               = `gnmic-{{ .Time.Format "2006.01.02" }}`
+	defaultTimeout             = 10 * time.Second
+	defaultFlushInterval       = 5 * time.Second
+	defaultBufferSize          = 1000
+	defaultMaxDocumentsPerBulk = 500
+	defaultMaxBulkSize         = 5 * 1024 * 1024
+	defaultMaxInFlight         = 1
+	defaultMaxRetries          = 3
+	defaultRetryBackoff        = 100 * time.Millisecond
+	userAgent                  = "gNMIc elasticsearch"
+)
+
+func init() {
+	outputs.Register(outputType, func() outputs.Output {
+		return &elasticsearchOutput{
+			Cfg:    &config{},
+			wg:     new(sync.WaitGroup),
+			logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		}
+	})
+}
+
+type elasticsearchOutput struct {
+	Cfg    *config
+	logger *log.Logger
+
+	cancelFn   context.CancelFunc
+	wg         *sync.WaitGroup
+	itemsCh    chan *bulkItem
+	batchCh    chan []*bulkItem
+	httpClient *http.Client
+	evps       []formatters.EventProcessor
+	// index of the next address to send a request to
+	next uint32
+
+	indexTpl  *template.Template
+	targetTpl *template.Template
+}
+
+type config struct {
+	Name                string            `mapstructure:"name,omitempty" json:"name,omitempty"`
+	Addresses           []string          `mapstructure:"addresses,omitempty" json:"addresses,omitempty"`
+	Index               string            `mapstructure:"index,omitempty" json:"index,omitempty"`
+	Timeout             time.Duration     `mapstructure:"timeout,omitempty" json:"timeout,omitempty"`
+	Headers             map[string]string `mapstructure:"headers,omitempty" json:"headers,omitempty"`
+	Authentication      *auth             `mapstructure:"authentication,omitempty" json:"authentication,omitempty"`
+	APIKey              string            `mapstructure:"api-key,omitempty" json:"-"`
+	TLS                 *tlsConfig        `mapstructure:"tls,omitempty" json:"tls,omitempty"`
+	FlushInterval       time.Duration     `mapstructure:"flush-interval,omitempty" json:"flush-interval,omitempty"`
+	BufferSize          int               `mapstructure:"buffer-size,omitempty" json:"buffer-size,omitempty"`
+	MaxDocumentsPerBulk int               `mapstructure:"max-documents-per-bulk,omitempty" json:"max-documents-per-bulk,omitempty"`
+	MaxBulkSize         int               `mapstructure:"max-bulk-size,omitempty" json:"max-bulk-size,omitempty"`
+	MaxInFlight         int               `mapstructure:"max-in-flight,omitempty" json:"max-in-flight,omitempty"`
+	MaxRetries          int               `mapstructure:"max-retries,omitempty" json:"max-retries,omitempty"`
+	RetryBackoff        time.Duration     `mapstructure:"retry-backoff,omitempty" json:"retry-backoff,omitempty"`
+	OverrideTimestamps  bool              `mapstructure:"override-timestamps,omitempty" json:"override-timestamps,omitempty"`
+	AddTarget           string            `mapstructure:"add-target,omitempty" json:"add-target,omitempty"`
+	TargetTemplate      string            `mapstructure:"target-template,omitempty" json:"target-template,omitempty"`
+	EventProcessors     []string          `mapstructure:"event-processors,omitempty" json:"event-processors,omitempty"`
+	EnableMetrics       bool              `mapstructure:"enable-metrics,omitempty" json:"enable-metrics,omitempty"`
+	Debug               bool              `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+}
+
+type auth struct {
+	Username string `mapstructure:"username,omitempty" json:"username,omitempty"`
+	Password string `mapstructure:"password,omitempty" json:"-"`
+}
+
+type tlsConfig struct {
+	CaFile     string `mapstructure:"ca-file,omitempty" json:"ca-file,omitempty"`
+	CertFile   string `mapstructure:"cert-file,omitempty" json:"cert-file,omitempty"`
+	KeyFile    string `mapstructure:"key-file,omitempty" json:"key-file,omitempty"`
+	SkipVerify bool   `mapstructure:"skip-verify,omitempty" json:"skip-verify,omitempty"`
+}
+
+// bulkItem is a single document index action of a bulk request
+type bulkItem struct {
+	// action and document lines, each terminated by a new line
+	body []byte
+}
+
+// document is the indexed event
+type document struct {
+	Timestamp string `json:"@timestamp"`
+	*formatters.EventMsg
+}
+
+// indexData is the data used to execute the index name template
+type indexData struct {
+	Name string
+	Tags map[string]string
+	Time time.Time
+}
+
+func (e *elasticsearchOutput) String() string {
+	b, err := json.Marshal(e.Cfg)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (e *elasticsearchOutput) SetLogger(logger *log.Logger) {
+	if logger != nil && e.logger != nil {
+		e.logger.SetOutput(logger.Writer())
+		e.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (e *elasticsearchOutput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]*types.TargetConfig) {
+	for _, epName := range e.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithEmitting())
+				if err != nil {
+					e.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
+					continue
+				}
+				e.evps = append(e.evps, ep)
+				e.logger.Printf("added event processor '%s' of type=%s to elasticsearch output", epName, epType)
+				continue
+			}
+			e.logger.Printf("%q event processor has an unknown type=%q", epName, epType)
+			continue
+		}
+		e.logger.Printf("%q event processor not found!", epName)
+	}
+}
+
+func (e *elasticsearchOutput) Init(ctx context.Context, name string, cfg map[string]interface{}, opts ...outputs.Option) error {
+	err := outputs.DecodeConfig(cfg, e.Cfg)
+	if err != nil {
+		return err
+	}
+	if e.Cfg.Name == "" {
+		e.Cfg.Name = name
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.Cfg.TargetTemplate == "" {
+		e.targetTpl = outputs.DefaultTargetTemplate
+	} else if e.Cfg.AddTarget != "" {
+		e.targetTpl, err = template.New("target-template").
+			Funcs(outputs.TemplateFuncs).
+			Parse(e.Cfg.TargetTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	e.setDefaults()
+	e.indexTpl, err = template.New("index").
+		Funcs(outputs.TemplateFuncs).
+		Option("missingkey=zero").
+		Parse(e.Cfg.Index)
+	if err != nil {
+		return fmt.Errorf("failed to parse index template: %v", err)
+	}
+	err = e.createHTTPClient()
+	if err != nil {
+		return err
+	}
+	e.itemsCh = make(chan *bulkItem, e.Cfg.BufferSize)
+	e.batchCh = make(chan []*bulkItem)
+
+	ctx, e.cancelFn = context.WithCancel(ctx)
+	e.wg.Add(e.Cfg.MaxInFlight + 1)
+	go e.batcher(ctx)
+	for i := 0; i < e.Cfg.MaxInFlight; i++ {
+		go e.writer(ctx, i)
+	}
+	formatters.StartEmitters(ctx, e.evps, func(ev *formatters.EventMsg) { e.WriteEvent(ctx, ev) })
+	e.logger.Printf("initialized elasticsearch output: %s", e.String())
+	go func() {
+		<-ctx.Done()
+		e.Close()
+	}()
+	return nil
+}
+
+func (e *elasticsearchOutput) setDefaults() {
+	if len(e.Cfg.Addresses) == 0 {
+		e.Cfg.Addresses = []string{defaultAddress}
+	}
+	for i, addr := range e.Cfg.Addresses {
+		e.Cfg.Addresses[i] = strings.TrimRight(addr, "/")
+	}
+	if e.Cfg.Index == "" {
+		e.Cfg.Index = defaultIndex
+	}
+	if e.Cfg.Timeout <= 0 {
+		e.Cfg.Timeout = defaultTimeout
+	}
+	if e.Cfg.FlushInterval <= 0 {
+		e.Cfg.FlushInterval = defaultFlushInterval
+	}
+	if e.Cfg.BufferSize <= 0 {
+		e.Cfg.BufferSize = defaultBufferSize
+	}
+	if e.Cfg.MaxDocumentsPerBulk <= 0 {
+		e.Cfg.MaxDocumentsPerBulk = defaultMaxDocumentsPerBulk
+	}
+	if e.Cfg.MaxBulkSize <= 0 {
+		e.Cfg.MaxBulkSize = defaultMaxBulkSize
+	}
+	if e.Cfg.MaxInFlight <= 0 {
+		e.Cfg.MaxInFlight = defaultMaxInFlight
+	}
+	if e.Cfg.MaxRetries < 0 {
+		e.Cfg.MaxRetries = 0
+	} else if e.Cfg.MaxRetries == 0 {
+		e.Cfg.MaxRetries = defaultMaxRetries
+	}
+	if e.Cfg.RetryBackoff <= 0 {
+		e.Cfg.RetryBackoff = defaultRetryBackoff
+	}
+}
+
+func (e *elasticsearchOutput) createHTTPClient() error {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if e.Cfg.TLS != nil {
+		tlsCfg, err := utils.NewTLSConfig(e.Cfg.TLS.CaFile, e.Cfg.TLS.CertFile, e.Cfg.TLS.KeyFile, e.Cfg.TLS.SkipVerify)
+		if err != nil {
+			return err
+		}
+		tr.TLSClientConfig = tlsCfg
+	}
+	e.httpClient = &http.Client{
+		Timeout:   e.Cfg.Timeout,
+		Transport: tr,
+	}
+	return nil
+}
+
+// Write implements the outputs.Output interface
+func (e *elasticsearchOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta) {
+	if rsp == nil {
+		return
+	}
+	switch rsp := rsp.(type) {
+	case *gnmi.SubscribeResponse:
+		measName := "default"
+		if subName, ok := meta["subscription-name"]; ok {
+			measName = subName
+		}
+		err := outputs.AddSubscriptionTarget(rsp, meta, e.Cfg.AddTarget, e.targetTpl)
+		if err != nil {
+			e.logger.Printf("failed to add target to the response: %v", err)
+		}
+		events, err := formatters.ResponseToEventMsgs(measName, rsp, meta, e.evps...)
+		if err != nil {
+			e.logger.Printf("failed to convert message to event: %v", err)
+			return
+		}
+		for _, ev := range events {
+			e.WriteEvent(ctx, ev)
+		}
+	}
+}
+
+func (e *elasticsearchOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	if ev == nil {
+		return
+	}
+	item, err := e.bulkItem(ev)
+	if err != nil {
+		e.logger.Printf("failed to build bulk item from event: %v", err)
+		if e.Cfg.EnableMetrics {
+			ElasticsearchNumberOfFailedDocuments.WithLabelValues(e.Cfg.Name, "marshal_error").Inc()
+		}
+		return
+	}
+	select {
+	case <-ctx.Done():
+	case e.itemsCh <- item:
+	}
+}
+
+func (e *elasticsearchOutput) Close() error {
+	formatters.CloseProcessors(e.evps)
+	if e.cancelFn != nil {
+		e.cancelFn()
+	}
+	e.wg.Wait()
+	return nil
+}
+
+func (e *elasticsearchOutput) RegisterMetrics(reg *prometheus.Registry) {
+	if !e.Cfg.EnableMetrics {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		e.logger.Printf("failed to register metric: %v", err)
+	}
+}
+
+func (e *elasticsearchOutput) SetName(name string) {
+	sb := strings.Builder{}
+	if name != "" {
+		sb.WriteString(name)
+		sb.WriteString("-")
+	}
+	sb.WriteString(e.Cfg.Name)
+	sb.WriteString("-elasticsearch")
+	e.Cfg.Name = sb.String()
+}
+
+func (e *elasticsearchOutput) SetClusterName(name string) {}
+
+func (e *elasticsearchOutput) SetTargetsConfig(map[string]*types.TargetConfig) {}
+
+// bulkItem builds the bulk index action of event ev
+func (e *elasticsearchOutput) bulkItem(ev *formatters.EventMsg) (*bulkItem, error) {
+	ts := time.Unix(0, ev.Timestamp).UTC()
+	if e.Cfg.OverrideTimestamps {
+		ts = time.Now().UTC()
+	}
+	sb := new(strings.Builder)
+	err := e.indexTpl.Execute(sb, &indexData{Name: ev.Name, Tags: ev.Tags, Time: ts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute index template: %v", err)
+	}
+	index := strings.ToLower(sb.String())
+	if index == "" {
+		return nil, errors.New("index template returned an empty index name")
+	}
+	action, err := json.Marshal(map[string]map[string]string{"index": {"_index": index}})
+	if err != nil {
+		return nil, err
+	}
+	doc, err := json.Marshal(&document{Timestamp: ts.Format(time.RFC3339Nano), EventMsg: ev})
+	if err != nil {
+		return nil, err
+	}
+	body := make([]byte, 0, len(action)+len(doc)+2)
+	body = append(body, action...)
+	body = append(body, '\n')
+	body = append(body, doc...)
+	body = append(body, '\n')
+	return &bulkItem{body: body}, nil
+}
+
+// batcher accumulates bulk items and hands them to the writers when max-documents-per-bulk
+// or max-bulk-size is reached or when the flush interval expires.
+func (e *elasticsearchOutput) batcher(ctx context.Context) {
+	defer e.wg.Done()
+	ticker := time.NewTicker(e.Cfg.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]*bulkItem, 0, e.Cfg.MaxDocumentsPerBulk)
+	size := 0
+	send := func() {
+		select {
+		case <-ctx.Done():
+		case e.batchCh <- batch:
+		}
+		batch = make([]*bulkItem, 0, e.Cfg.MaxDocumentsPerBulk)
+		size = 0
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case item := <-e.itemsCh:
+			if len(batch) > 0 && size+len(item.body) > e.Cfg.MaxBulkSize {
+				send()
+			}
+			batch = append(batch, item)
+			size += len(item.body)
+			if len(batch) >= e.Cfg.MaxDocumentsPerBulk || size >= e.Cfg.MaxBulkSize {
+				send()
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+			send()
+		}
+	}
+}
+
+func (e *elasticsearchOutput) writer(ctx context.Context, idx int) {
+	defer e.wg.Done()
+	workerLogPrefix := fmt.Sprintf("writer-%d", idx)
+	e.logger.Printf("%s starting", workerLogPrefix)
+	for {
+		select {
+		case <-ctx.Done():
+			e.logger.Printf("%s shutting down", workerLogPrefix)
+			return
+		case batch := <-e.batchCh:
+			e.write(ctx, workerLogPrefix, batch)
+		}
+	}
+}
+
+// write sends batch using the bulk API, the whole batch is retried if the request fails
+// and only the rejected items are retried if it partially succeeds.
+func (e *elasticsearchOutput) write(ctx context.Context, workerLogPrefix string, batch []*bulkItem) {
+	backoff := e.Cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := e.send(ctx, workerLogPrefix, batch)
+		if len(retry) == 0 {
+			if err != nil {
+				e.logger.Printf("%s failed to index %d documents: %v", workerLogPrefix, len(batch), err)
+				if e.Cfg.EnableMetrics {
+					ElasticsearchNumberOfFailedDocuments.WithLabelValues(e.Cfg.Name, "send_error").Add(float64(len(batch)))
+				}
+			}
+			return
+		}
+		if attempt >= e.Cfg.MaxRetries {
+			e.logger.Printf("%s failed to index %d documents after %d attempts, last error: %v", workerLogPrefix, len(retry), attempt+1, err)
+			if e.Cfg.EnableMetrics {
+				ElasticsearchNumberOfFailedDocuments.WithLabelValues(e.Cfg.Name, "max_retries").Add(float64(len(retry)))
+			}
+			return
+		}
+		if e.Cfg.Debug {
+			e.logger.Printf("%s bulk attempt %d: retrying %d documents in %s: %v", workerLogPrefix, attempt+1, len(retry), backoff, err)
+		}
+		batch = retry
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+type bulkResponse struct {
+	Errors bool                         `json:"errors,omitempty"`
+	Items  []map[string]*bulkItemResult `json:"items,omitempty"`
+}
+
+type bulkItemResult struct {
+	Status int `json:"status,omitempty"`
+	Error  *struct {
+		Type   string `json:"type,omitempty"`
+		Reason string `json:"reason,omitempty"`
+	} `json:"error,omitempty"`
+}
+
+// send sends a single bulk request.
+// It returns the items to retry: all of them if the request failed with a retryable error,
+// or the items rejected with a retryable status.
+func (e *elasticsearchOutput) send(ctx context.Context, workerLogPrefix string, batch []*bulkItem) ([]*bulkItem, error) {
+	var start time.Time
+	if e.Cfg.EnableMetrics {
+		start = time.Now()
+	}
+	size := 0
+	for _, item := range batch {
+		size += len(item.body)
+	}
+	body := make([]byte, 0, size)
+	for _, item := range batch {
+		body = append(body, item.body...)
+	}
+	addr := e.Cfg.Addresses[int(atomic.AddUint32(&e.next, 1)-1)%len(e.Cfg.Addresses)]
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, addr+"/_bulk", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-ndjson")
+	httpReq.Header.Set("User-Agent", userAgent)
+	for k, v := range e.Cfg.Headers {
+		httpReq.Header.Set(k, v)
+	}
+	if e.Cfg.Authentication != nil {
+		httpReq.SetBasicAuth(e.Cfg.Authentication.Username, e.Cfg.Authentication.Password)
+	}
+	if e.Cfg.APIKey != "" {
+		httpReq.Header.Set("Authorization", "ApiKey "+e.Cfg.APIKey)
+	}
+	rsp, err := e.httpClient.Do(httpReq)
+	if err != nil {
+		// network errors are retried
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return batch, err
+	}
+	defer rsp.Body.Close()
+	rb, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return batch, err
+	}
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		err = fmt.Errorf("server returned %s: %s", rsp.Status, strings.TrimSpace(string(rb)))
+		// only server side errors and throttling are retried
+		if retryableStatus(rsp.StatusCode) {
+			return batch, err
+		}
+		return nil, err
+	}
+	if e.Cfg.EnableMetrics {
+		ElasticsearchBulkDuration.WithLabelValues(e.Cfg.Name).Set(float64(time.Since(start).Nanoseconds()))
+		ElasticsearchNumberOfBulkRequests.WithLabelValues(e.Cfg.Name).Inc()
+	}
+	bulkRsp := new(bulkResponse)
+	err = json.Unmarshal(rb, bulkRsp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode bulk response: %v", err)
+	}
+	if !bulkRsp.Errors {
+		if e.Cfg.EnableMetrics {
+			ElasticsearchNumberOfIndexedDocuments.WithLabelValues(e.Cfg.Name).Add(float64(len(batch)))
+		}
+		return nil, nil
+	}
+	if len(bulkRsp.Items) != len(batch) {
+		return nil, fmt.Errorf("unexpected number of items in bulk response: got %d, sent %d", len(bulkRsp.Items), len(batch))
+	}
+	retry := make([]*bulkItem, 0)
+	indexed := 0
+	for i, item := range bulkRsp.Items {
+		for _, res := range item {
+			switch {
+			case res.Status >= 200 && res.Status < 300:
+				indexed++
+			case retryableStatus(res.Status):
+				retry = append(retry, batch[i])
+			default:
+				if e.Cfg.Debug && res.Error != nil {
+					e.logger.Printf("%s document rejected with status %d: %s: %s", workerLogPrefix, res.Status, res.Error.Type, res.Error.Reason)
+				}
+				if e.Cfg.EnableMetrics {
+					ElasticsearchNumberOfFailedDocuments.WithLabelValues(e.Cfg.Name, "rejected").Inc()
+				}
+			}
+		}
+	}
+	if e.Cfg.EnableMetrics {
+		ElasticsearchNumberOfIndexedDocuments.WithLabelValues(e.Cfg.Name).Add(float64(indexed))
+	}
+	if len(retry) > 0 {
+		return retry, fmt.Errorf("%d documents rejected with a retryable status", len(retry))
+	}
+	return nil, nil
+}
+
+func retryableStatus(code int) bool {
+	return code >= 500 || code == http.StatusTooManyRequests
+}
@@ -0,0 +1,255 @@
+package elasticsearch_output
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+)
+
+// bulkServer is an httptest stand-in for the bulk API,
+// it stores the indexed documents per index.
+type bulkServer struct {
+	m        sync.Mutex
+	requests int
+	indexed  map[string][]map[string]interface{}
+	// returns the status of the i-th item of the n-th request
+	itemStatus func(n, i int, doc map[string]interface{}) int
+	// checks the request headers
+	checkReq func(r *http.Request) error
+}
+
+func (s *bulkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/_bulk" || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if s.checkReq != nil {
+		if err := s.checkReq(r); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, err)
+			return
+		}
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	n := s.requests
+	s.requests++
+	rsp := &bulkResponse{Items: make([]map[string]*bulkItemResult, 0)}
+	sc := bufio.NewScanner(r.Body)
+	for i := 0; sc.Scan(); i++ {
+		action := make(map[string]map[string]string)
+		if err := json.Unmarshal(sc.Bytes(), &action); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !sc.Scan() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		doc := make(map[string]interface{})
+		if err := json.Unmarshal(sc.Bytes(), &doc); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		status := http.StatusCreated
+		if s.itemStatus != nil {
+			status = s.itemStatus(n, i, doc)
+		}
+		if status == http.StatusCreated {
+			index := action["index"]["_index"]
+			s.indexed[index] = append(s.indexed[index], doc)
+		} else {
+			rsp.Errors = true
+		}
+		rsp.Items = append(rsp.Items, map[string]*bulkItemResult{"index": {Status: status}})
+	}
+	json.NewEncoder(w).Encode(rsp)
+}
+
+func (s *bulkServer) numIndexed() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	n := 0
+	for _, docs := range s.indexed {
+		n += len(docs)
+	}
+	return n
+}
+
+func testEvent(ts time.Time, ifName string) *formatters.EventMsg {
+	return &formatters.EventMsg{
+		Name:      "sub1",
+		Timestamp: ts.UnixNano(),
+		Tags: map[string]string{
+			"source":            "router1",
+			"subscription-name": "sub1",
+			"interface_name":    ifName,
+		},
+		Values: map[string]interface{}{
+			"/interface/statistics/in-octets": 100,
+		},
+	}
+}
+
+func newTestOutput(t *testing.T, ctx context.Context, cfg map[string]interface{}) *elasticsearchOutput {
+	e := &elasticsearchOutput{
+		Cfg:    &config{},
+		wg:     new(sync.WaitGroup),
+		logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags),
+	}
+	err := e.Init(ctx, "elasticsearch_test", cfg)
+	if err != nil {
+		t.Fatalf("failed to init output: %v", err)
+	}
+	return e
+}
+
+func waitIndexed(s *bulkServer, n int) {
+	timeout := time.After(2 * time.Second)
+	for s.numIndexed() < n {
+		select {
+		case <-timeout:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestElasticsearchDailyIndex(t *testing.T) {
+	s := &bulkServer{
+		indexed: make(map[string][]map[string]interface{}),
+		checkReq: func(r *http.Request) error {
+			if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+				return fmt.Errorf("unexpected basic auth: %s:%s", u, p)
+			}
+			if r.Header.Get("Content-Type") != "application/x-ndjson" {
+				return fmt.Errorf("unexpected content type %q", r.Header.Get("Content-Type"))
+			}
+			return nil
+		},
+	}
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := newTestOutput(t, ctx, map[string]interface{}{
+		"addresses":      []string{hs.URL},
+		"index":          `telemetry-{{ index .Tags "subscription-name" }}-{{ .Time.Format "2006.01.02" }}`,
+		"flush-interval": "50ms",
+		"authentication": map[string]interface{}{"username": "user", "password": "pass"},
+	})
+	day1 := time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)
+	e.WriteEvent(ctx, testEvent(day1, "ethernet-1/1"))
+	e.WriteEvent(ctx, testEvent(day1.Add(time.Hour), "ethernet-1/2"))
+	e.WriteEvent(ctx, testEvent(day1.Add(24*time.Hour), "ethernet-1/1"))
+	waitIndexed(s, 3)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	if len(s.indexed["telemetry-sub1-2021.06.01"]) != 2 || len(s.indexed["telemetry-sub1-2021.06.02"]) != 1 {
+		t.Fatalf("unexpected indices: %v", s.indexed)
+	}
+	doc := s.indexed["telemetry-sub1-2021.06.02"][0]
+	if doc["@timestamp"] != "2021-06-02T10:00:00Z" {
+		t.Errorf("unexpected @timestamp %v", doc["@timestamp"])
+	}
+	if tags, ok := doc["tags"].(map[string]interface{}); !ok || tags["interface_name"] != "ethernet-1/1" {
+		t.Errorf("unexpected document tags: %v", doc["tags"])
+	}
+}
+
+func TestElasticsearchRetryFailedItems(t *testing.T) {
+	s := &bulkServer{
+		indexed: make(map[string][]map[string]interface{}),
+		itemStatus: func(n, i int, doc map[string]interface{}) int {
+			tags := doc["tags"].(map[string]interface{})
+			switch tags["interface_name"] {
+			case "ethernet-1/2":
+				// rejected once because of back pressure
+				if n == 0 {
+					return http.StatusTooManyRequests
+				}
+			case "ethernet-1/3":
+				// never indexed, not retried
+				return http.StatusBadRequest
+			}
+			return http.StatusCreated
+		},
+		checkReq: func(r *http.Request) error {
+			if r.Header.Get("Authorization") != "ApiKey a2V5OnNlY3JldA==" {
+				return fmt.Errorf("unexpected authorization header %q", r.Header.Get("Authorization"))
+			}
+			return nil
+		},
+	}
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := newTestOutput(t, ctx, map[string]interface{}{
+		"addresses":              []string{hs.URL},
+		"api-key":                "a2V5OnNlY3JldA==",
+		"max-documents-per-bulk": 3,
+		"retry-backoff":          "10ms",
+	})
+	now := time.Now()
+	e.WriteEvent(ctx, testEvent(now, "ethernet-1/1"))
+	e.WriteEvent(ctx, testEvent(now, "ethernet-1/2"))
+	e.WriteEvent(ctx, testEvent(now, "ethernet-1/3"))
+	waitIndexed(s, 2)
+	// let a possible unexpected retry happen
+	time.Sleep(100 * time.Millisecond)
+
+	if n := s.numIndexed(); n != 2 {
+		t.Errorf("expected 2 indexed documents, got %d", n)
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.requests != 2 {
+		t.Errorf("expected 2 bulk requests, got %d", s.requests)
+	}
+}
+
+func TestElasticsearchBatching(t *testing.T) {
+	s := &bulkServer{
+		indexed: make(map[string][]map[string]interface{}),
+	}
+	hs := httptest.NewServer(s)
+	defer hs.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := newTestOutput(t, ctx, map[string]interface{}{
+		"addresses":              []string{hs.URL + "/"},
+		"max-documents-per-bulk": 2,
+		"flush-interval":         "200ms",
+	})
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		e.WriteEvent(ctx, testEvent(now, fmt.Sprintf("ethernet-1/%d", i)))
+	}
+	// 2 full bulks are sent right away
+	waitIndexed(s, 4)
+	if n := s.numIndexed(); n != 4 {
+		t.Fatalf("expected 4 indexed documents before the flush interval, got %d", n)
+	}
+	// the last document is sent when the flush interval expires
+	waitIndexed(s, 5)
+	if n := s.numIndexed(); n != 5 {
+		t.Fatalf("expected 5 indexed documents, got %d", n)
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.requests != 3 {
+		t.Errorf("expected 3 bulk requests, got %d", s.requests)
+	}
+}
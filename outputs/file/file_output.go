@@ -82,7 +82,7 @@ func (f *File) SetEventProcessors(ps map[string]map[string]interface{}, logger *
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
 				if err != nil {
 					f.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
 					continue
@@ -222,6 +222,10 @@ func (f *File) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
 
 // Close //
 func (f *File) Close() error {
+	formatters.CloseProcessors(f.evps)
+	if f.file == nil {
+		return nil
+	}
 	f.logger.Printf("closing file '%s' output", f.file.Name())
 	return f.file.Close()
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"log"
 	"math"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
 	"github.com/karimra/gnmic/formatters"
 	"github.com/karimra/gnmic/outputs"
 	"github.com/karimra/gnmic/types"
@@ -55,6 +57,7 @@ type InfluxDBOutput struct {
 	dbVersion string
 
 	targetTpl *template.Template
+	syncCache outputs.SyncCache
 }
 type Config struct {
 	URL                string        `mapstructure:"url,omitempty"`
@@ -99,7 +102,7 @@ func (i *InfluxDBOutput) SetEventProcessors(ps map[string]map[string]interface{}
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithEmitting())
 				if err != nil {
 					i.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
 					continue
@@ -175,6 +178,7 @@ CRCLIENT:
 	for k := 0; k < numWorkers; k++ {
 		go i.worker(ctx, k)
 	}
+	formatters.StartEmitters(ctx, i.evps, func(ev *formatters.EventMsg) { i.WriteEvent(ctx, ev) })
 	go func() {
 		<-ctx.Done()
 		i.Close()
@@ -213,9 +217,78 @@ func (i *InfluxDBOutput) Write(ctx context.Context, rsp proto.Message, meta outp
 	}
 }
 
-func (i *InfluxDBOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
+// WriteSync implements outputs.SyncWriter,
+// the points are written using the blocking write API.
+func (i *InfluxDBOutput) WriteSync(ctx context.Context, rsp proto.Message, meta outputs.Meta) error {
+	if rsp == nil {
+		return nil
+	}
+	if i.client == nil || !i.wasUP {
+		return errors.New("influxdb output not initialized")
+	}
+	err := outputs.AddSubscriptionTarget(rsp, meta, i.Cfg.AddTarget, i.targetTpl)
+	if err != nil {
+		i.logger.Printf("failed to add target to the response: %v", err)
+	}
+	switch rsp := rsp.(type) {
+	case *gnmi.SubscribeResponse:
+		// the points are built once, a failed write is retried with the same points
+		v, err := i.syncCache.Get(rsp, func() (interface{}, error) {
+			measName := "default"
+			if subName, ok := meta["subscription-name"]; ok {
+				measName = subName
+			}
+			events, err := formatters.ResponseToEventMsgs(measName, rsp, meta, i.evps...)
+			if err != nil {
+				return nil, err
+			}
+			points := make([]*write.Point, 0, len(events))
+			for _, ev := range events {
+				points = append(points, i.eventToPoint(ev))
+			}
+			return points, nil
+		})
+		if err != nil {
+			// retrying a message that cannot be converted won't help
+			i.logger.Printf("failed to convert message to event: %v", err)
+			return nil
+		}
+		points := v.([]*write.Point)
+		if len(points) == 0 {
+			return nil
+		}
+		return i.client.WriteAPIBlocking(i.Cfg.Org, i.Cfg.Bucket).WritePoint(ctx, points...)
+	}
+	return nil
+}
+
+func (i *InfluxDBOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	if ev == nil {
+		return
+	}
+	select {
+	case <-ctx.Done():
+		return
+	case <-i.reset:
+		return
+	case i.eventChan <- ev:
+	}
+}
+
+// WriteEventSync implements outputs.EventSyncWriter,
+// the event is written using the blocking write API.
+func (i *InfluxDBOutput) WriteEventSync(ctx context.Context, ev *formatters.EventMsg) error {
+	if ev == nil {
+		return nil
+	}
+	if i.client == nil || !i.wasUP {
+		return errors.New("influxdb output not initialized")
+	}
+	return i.client.WriteAPIBlocking(i.Cfg.Org, i.Cfg.Bucket).WritePoint(ctx, i.eventToPoint(ev))
+}
 
 func (i *InfluxDBOutput) Close() error {
+	formatters.CloseProcessors(i.evps)
 	i.logger.Printf("closing client...")
 	i.cancelFn()
 	i.logger.Printf("closed.")
@@ -291,17 +364,7 @@ START:
 			i.logger.Printf("worker-%d terminating...", idx)
 			return
 		case ev := <-i.eventChan:
-			for n, v := range ev.Values {
-				switch v := v.(type) {
-				case *gnmi.Decimal64:
-					ev.Values[n] = float64(v.Digits) / math.Pow10(int(v.Precision))
-				}
-			}
-			if ev.Timestamp == 0 || i.Cfg.OverrideTimestamps {
-				ev.Timestamp = time.Now().UnixNano()
-			}
-			i.convertUints(ev)
-			writer.WritePoint(influxdb2.NewPoint(ev.Name, ev.Tags, ev.Values, time.Unix(0, ev.Timestamp)))
+			writer.WritePoint(i.eventToPoint(ev))
 		case <-i.reset:
 			firstStart = false
 			i.logger.Printf("resetting worker-%d...", idx)
@@ -312,6 +375,20 @@ START:
 	}
 }
 
+func (i *InfluxDBOutput) eventToPoint(ev *formatters.EventMsg) *write.Point {
+	for n, v := range ev.Values {
+		switch v := v.(type) {
+		case *gnmi.Decimal64:
+			ev.Values[n] = float64(v.Digits) / math.Pow10(int(v.Precision))
+		}
+	}
+	if ev.Timestamp == 0 || i.Cfg.OverrideTimestamps {
+		ev.Timestamp = time.Now().UnixNano()
+	}
+	i.convertUints(ev)
+	return influxdb2.NewPoint(ev.Name, ev.Tags, ev.Values, time.Unix(0, ev.Timestamp))
+}
+
 func (i *InfluxDBOutput) SetName(name string)                             {}
 func (i *InfluxDBOutput) SetClusterName(name string)                      {}
 func (i *InfluxDBOutput) SetTargetsConfig(map[string]*types.TargetConfig) {}
@@ -0,0 +1,67 @@
+package jetstream_output
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var JetStreamNumberOfSentMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "jetstream_output",
+	Name:      "number_of_jetstream_msgs_sent_success_total",
+	Help:      "Number of msgs successfully sent by gnmic jetstream output",
+}, []string{"publisher_id", "subject"})
+
+var JetStreamNumberOfSentBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "jetstream_output",
+	Name:      "number_of_written_jetstream_bytes_total",
+	Help:      "Number of bytes written by gnmic jetstream output",
+}, []string{"publisher_id", "subject"})
+
+var JetStreamNumberOfFailSendMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "jetstream_output",
+	Name:      "number_of_jetstream_msgs_sent_fail_total",
+	Help:      "Number of failed msgs sent by gnmic jetstream output",
+}, []string{"publisher_id", "reason"})
+
+var JetStreamNumberOfDuplicateMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "jetstream_output",
+	Name:      "number_of_jetstream_duplicate_msgs_total",
+	Help:      "Number of msgs sent by gnmic jetstream output and discarded by the stream as duplicates",
+}, []string{"publisher_id", "stream"})
+
+var JetStreamSendDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "jetstream_output",
+	Name:      "msg_send_duration_ns",
+	Help:      "gnmic jetstream output send duration in ns",
+}, []string{"publisher_id"})
+
+func initMetrics() {
+	JetStreamNumberOfSentMsgs.WithLabelValues("", "").Add(0)
+	JetStreamNumberOfSentBytes.WithLabelValues("", "").Add(0)
+	JetStreamNumberOfFailSendMsgs.WithLabelValues("", "").Add(0)
+	JetStreamNumberOfDuplicateMsgs.WithLabelValues("", "").Add(0)
+	JetStreamSendDuration.WithLabelValues("").Set(0)
+}
+
+func registerMetrics(reg *prometheus.Registry) error {
+	initMetrics()
+	var err error
+	if err = reg.Register(JetStreamNumberOfSentMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(JetStreamNumberOfSentBytes); err != nil {
+		return err
+	}
+	if err = reg.Register(JetStreamNumberOfFailSendMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(JetStreamNumberOfDuplicateMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(JetStreamSendDuration); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,574 @@
+package jetstream_output
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/types"
+	"github.com/karimra/gnmic/utils"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	natsConnectWait         = 2 * time.Second
+	natsReconnectBufferSize = 100 * 1024 * 1024
+	defaultStreamName       = "telemetry"
+	defaultSubjectName      = "telemetry"
+	defaultStorage          = "file"
+	defaultFormat           = "event"
+	defaultNumWorkers       = 1
+	defaultWriteTimeout     = 5 * time.Second
+	defaultAddress          = "localhost:4222"
+	loggingPrefix           = "[jetstream_output] "
+
+	// message headers, in their canonical MIME form
+	sourceHeader           = "Gnmic-Source"
+	subscriptionNameHeader = "Gnmic-Subscription-Name"
+)
+
+func init() {
+	outputs.Register("jetstream", func() outputs.Output {
+		return &JetStreamOutput{
+			Cfg:    &Config{},
+			wg:     new(sync.WaitGroup),
+			logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		}
+	})
+}
+
+type protoMsg struct {
+	m    proto.Message
+	meta outputs.Meta
+	// set by WriteSync to get the write result
+	errCh chan error
+}
+
+func (m *protoMsg) done(err error) {
+	if m.errCh != nil {
+		m.errCh <- err
+	}
+}
+
+// JetStreamOutput publishes messages to a NATS JetStream stream
+// and waits for the stream acknowledgement
+type JetStreamOutput struct {
+	Cfg      *Config
+	cancelFn context.CancelFunc
+	msgChan  chan *protoMsg
+	wg       *sync.WaitGroup
+	logger   *log.Logger
+	mo       *formatters.MarshalOptions
+	evps     []formatters.EventProcessor
+
+	targetTpl *template.Template
+	syncCache outputs.SyncCache
+}
+
+// Config //
+type Config struct {
+	Name               string        `mapstructure:"name,omitempty" json:"name,omitempty"`
+	Address            string        `mapstructure:"address,omitempty" json:"address,omitempty"`
+	Username           string        `mapstructure:"username,omitempty" json:"username,omitempty"`
+	Password           string        `mapstructure:"password,omitempty" json:"-"`
+	TLS                *tlsConfig    `mapstructure:"tls,omitempty" json:"tls,omitempty"`
+	ConnectTimeWait    time.Duration `mapstructure:"connect-time-wait,omitempty" json:"connect-time-wait,omitempty"`
+	Stream             string        `mapstructure:"stream,omitempty" json:"stream,omitempty"`
+	CreateStream       *streamConfig `mapstructure:"create-stream,omitempty" json:"create-stream,omitempty"`
+	SubjectPrefix      string        `mapstructure:"subject-prefix,omitempty" json:"subject-prefix,omitempty"`
+	Subject            string        `mapstructure:"subject,omitempty" json:"subject,omitempty"`
+	Format             string        `mapstructure:"format,omitempty" json:"format,omitempty"`
+	AddTarget          string        `mapstructure:"add-target,omitempty" json:"add-target,omitempty"`
+	TargetTemplate     string        `mapstructure:"target-template,omitempty" json:"target-template,omitempty"`
+	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty" json:"override-timestamps,omitempty"`
+	NumWorkers         int           `mapstructure:"num-workers,omitempty" json:"num-workers,omitempty"`
+	WriteTimeout       time.Duration `mapstructure:"write-timeout,omitempty" json:"write-timeout,omitempty"`
+	Debug              bool          `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+	EnableMetrics      bool          `mapstructure:"enable-metrics,omitempty" json:"enable-metrics,omitempty"`
+	EventProcessors    []string      `mapstructure:"event-processors,omitempty" json:"event-processors,omitempty"`
+}
+
+// streamConfig is used to create the stream if it does not exist
+type streamConfig struct {
+	Subjects        []string      `mapstructure:"subjects,omitempty" json:"subjects,omitempty"`
+	Storage         string        `mapstructure:"storage,omitempty" json:"storage,omitempty"`
+	MaxMsgs         int64         `mapstructure:"max-msgs,omitempty" json:"max-msgs,omitempty"`
+	MaxBytes        int64         `mapstructure:"max-bytes,omitempty" json:"max-bytes,omitempty"`
+	MaxAge          time.Duration `mapstructure:"max-age,omitempty" json:"max-age,omitempty"`
+	Replicas        int           `mapstructure:"replicas,omitempty" json:"replicas,omitempty"`
+	DuplicateWindow time.Duration `mapstructure:"duplicate-window,omitempty" json:"duplicate-window,omitempty"`
+}
+
+type tlsConfig struct {
+	CaFile     string `mapstructure:"ca-file,omitempty" json:"ca-file,omitempty"`
+	CertFile   string `mapstructure:"cert-file,omitempty" json:"cert-file,omitempty"`
+	KeyFile    string `mapstructure:"key-file,omitempty" json:"key-file,omitempty"`
+	SkipVerify bool   `mapstructure:"skip-verify,omitempty" json:"skip-verify,omitempty"`
+}
+
+func (n *JetStreamOutput) String() string {
+	b, err := json.Marshal(n.Cfg)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (n *JetStreamOutput) SetLogger(logger *log.Logger) {
+	if logger != nil && n.logger != nil {
+		n.logger.SetOutput(logger.Writer())
+		n.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (n *JetStreamOutput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]*types.TargetConfig) {
+	for _, epName := range n.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				if err != nil {
+					n.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
+					continue
+				}
+				n.evps = append(n.evps, ep)
+				n.logger.Printf("added event processor '%s' of type=%s to jetstream output", epName, epType)
+				continue
+			}
+			n.logger.Printf("%q event processor has an unknown type=%q", epName, epType)
+			continue
+		}
+		n.logger.Printf("%q event processor not found!", epName)
+	}
+}
+
+// Init //
+func (n *JetStreamOutput) Init(ctx context.Context, name string, cfg map[string]interface{}, opts ...outputs.Option) error {
+	err := outputs.DecodeConfig(cfg, n.Cfg)
+	if err != nil {
+		return err
+	}
+	if n.Cfg.Name == "" {
+		n.Cfg.Name = name
+	}
+	for _, opt := range opts {
+		opt(n)
+	}
+	err = n.setDefaults()
+	if err != nil {
+		return err
+	}
+	if n.Cfg.TargetTemplate == "" {
+		n.targetTpl = outputs.DefaultTargetTemplate
+	} else if n.Cfg.AddTarget != "" {
+		n.targetTpl, err = template.New("target-template").
+			Funcs(outputs.TemplateFuncs).
+			Parse(n.Cfg.TargetTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	n.msgChan = make(chan *protoMsg)
+	initMetrics()
+	n.mo = &formatters.MarshalOptions{
+		Format:     n.Cfg.Format,
+		OverrideTS: n.Cfg.OverrideTimestamps,
+	}
+	n.logger.Printf("initialized jetstream output: %s", n.String())
+	ctx, n.cancelFn = context.WithCancel(ctx)
+	n.wg.Add(n.Cfg.NumWorkers)
+	for i := 0; i < n.Cfg.NumWorkers; i++ {
+		cfg := *n.Cfg
+		cfg.Name = fmt.Sprintf("%s-%d", cfg.Name, i)
+		go n.worker(ctx, i, &cfg)
+	}
+
+	go func() {
+		<-ctx.Done()
+		n.Close()
+	}()
+	return nil
+}
+
+func (n *JetStreamOutput) setDefaults() error {
+	if n.Cfg.Format == "" {
+		n.Cfg.Format = defaultFormat
+	}
+	if !(n.Cfg.Format == "event" || n.Cfg.Format == "protojson" || n.Cfg.Format == "proto" || n.Cfg.Format == "json") {
+		return fmt.Errorf("unsupported output format '%s' for output type jetstream", n.Cfg.Format)
+	}
+	if n.Cfg.Address == "" {
+		n.Cfg.Address = defaultAddress
+	}
+	if n.Cfg.ConnectTimeWait <= 0 {
+		n.Cfg.ConnectTimeWait = natsConnectWait
+	}
+	if n.Cfg.Stream == "" {
+		n.Cfg.Stream = defaultStreamName
+	}
+	if strings.ContainsAny(n.Cfg.Stream, ".*> ") {
+		return fmt.Errorf("invalid stream name %q", n.Cfg.Stream)
+	}
+	if n.Cfg.Subject == "" && n.Cfg.SubjectPrefix == "" {
+		n.Cfg.Subject = defaultSubjectName
+	}
+	if n.Cfg.CreateStream != nil {
+		if len(n.Cfg.CreateStream.Subjects) == 0 {
+			if n.Cfg.SubjectPrefix != "" {
+				n.Cfg.CreateStream.Subjects = []string{n.Cfg.SubjectPrefix + ".>"}
+			} else {
+				n.Cfg.CreateStream.Subjects = []string{n.Cfg.Subject}
+			}
+		}
+		if n.Cfg.CreateStream.Storage == "" {
+			n.Cfg.CreateStream.Storage = defaultStorage
+		}
+		n.Cfg.CreateStream.Storage = strings.ToLower(n.Cfg.CreateStream.Storage)
+		if n.Cfg.CreateStream.Storage != "file" && n.Cfg.CreateStream.Storage != "memory" {
+			return fmt.Errorf("unsupported stream storage %q, must be 'file' or 'memory'", n.Cfg.CreateStream.Storage)
+		}
+	}
+	if n.Cfg.Name == "" {
+		n.Cfg.Name = "gnmic-" + uuid.New().String()
+	}
+	if n.Cfg.NumWorkers <= 0 {
+		n.Cfg.NumWorkers = defaultNumWorkers
+	}
+	if n.Cfg.WriteTimeout <= 0 {
+		n.Cfg.WriteTimeout = defaultWriteTimeout
+	}
+	return nil
+}
+
+// Write //
+func (n *JetStreamOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta) {
+	if rsp == nil || n.mo == nil {
+		return
+	}
+
+	wctx, cancel := context.WithTimeout(ctx, n.Cfg.WriteTimeout)
+	defer cancel()
+
+	select {
+	case <-ctx.Done():
+		return
+	case n.msgChan <- &protoMsg{m: rsp, meta: meta}:
+	case <-wctx.Done():
+		if n.Cfg.Debug {
+			n.logger.Printf("writing expired after %s, jetstream output might not be initialized", n.Cfg.WriteTimeout)
+		}
+		if n.Cfg.EnableMetrics {
+			JetStreamNumberOfFailSendMsgs.WithLabelValues(n.Cfg.Name, "timeout").Inc()
+		}
+		return
+	}
+}
+
+// WriteSync implements outputs.SyncWriter,
+// it returns once the message is stored in the stream.
+func (n *JetStreamOutput) WriteSync(ctx context.Context, rsp proto.Message, meta outputs.Meta) error {
+	if rsp == nil {
+		return nil
+	}
+	if n.mo == nil {
+		return errors.New("jetstream output not initialized")
+	}
+	wctx, cancel := context.WithTimeout(ctx, n.Cfg.WriteTimeout)
+	defer cancel()
+
+	m := &protoMsg{m: rsp, meta: meta, errCh: make(chan error, 1)}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case n.msgChan <- m:
+	case <-wctx.Done():
+		return fmt.Errorf("writing expired after %s, jetstream output might not be initialized", n.Cfg.WriteTimeout)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-m.errCh:
+		return err
+	}
+}
+
+func (n *JetStreamOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
+
+// Close //
+func (n *JetStreamOutput) Close() error {
+	formatters.CloseProcessors(n.evps)
+	if n.cancelFn != nil {
+		n.cancelFn()
+	}
+	n.wg.Wait()
+	return nil
+}
+
+// Metrics //
+func (n *JetStreamOutput) RegisterMetrics(reg *prometheus.Registry) {
+	if !n.Cfg.EnableMetrics {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		n.logger.Printf("failed to register metric: %+v", err)
+	}
+}
+
+func (n *JetStreamOutput) createNATSConn(c *Config) (*nats.Conn, error) {
+	opts := []nats.Option{
+		nats.Name(c.Name),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(n.Cfg.ConnectTimeWait),
+		nats.ReconnectBufSize(natsReconnectBufferSize),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			n.logger.Printf("NATS error: %v", err)
+		}),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			n.logger.Printf("Disconnected from NATS: %v", err)
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			n.logger.Printf("Reconnected to NATS server %s", c.ConnectedUrl())
+		}),
+		nats.ClosedHandler(func(c *nats.Conn) {
+			n.logger.Println("NATS connection is closed")
+		}),
+	}
+	if c.Username != "" && c.Password != "" {
+		opts = append(opts, nats.UserInfo(c.Username, c.Password))
+	}
+	if c.TLS != nil {
+		tlsCfg, err := utils.NewTLSConfig(c.TLS.CaFile, c.TLS.CertFile, c.TLS.KeyFile, c.TLS.SkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		if tlsCfg == nil {
+			tlsCfg = &tls.Config{}
+		}
+		opts = append(opts, nats.Secure(tlsCfg))
+	}
+	return nats.Connect(c.Address, opts...)
+}
+
+// bindStream checks that the configured stream exists,
+// creating it if a create-stream section is configured.
+func (n *JetStreamOutput) bindStream(js nats.JetStreamContext) error {
+	_, err := js.StreamInfo(n.Cfg.Stream)
+	if err == nil {
+		return nil
+	}
+	if n.Cfg.CreateStream == nil || !strings.Contains(err.Error(), "not found") {
+		return fmt.Errorf("failed to get stream %q info: %v", n.Cfg.Stream, err)
+	}
+	_, err = js.AddStream(n.streamConfig())
+	if err != nil {
+		return fmt.Errorf("failed to create stream %q: %v", n.Cfg.Stream, err)
+	}
+	n.logger.Printf("created stream %q with subjects %v", n.Cfg.Stream, n.Cfg.CreateStream.Subjects)
+	return nil
+}
+
+func (n *JetStreamOutput) streamConfig() *nats.StreamConfig {
+	sc := &nats.StreamConfig{
+		Name:       n.Cfg.Stream,
+		Subjects:   n.Cfg.CreateStream.Subjects,
+		Retention:  nats.LimitsPolicy,
+		MaxMsgs:    n.Cfg.CreateStream.MaxMsgs,
+		MaxBytes:   n.Cfg.CreateStream.MaxBytes,
+		MaxAge:     n.Cfg.CreateStream.MaxAge,
+		Storage:    nats.FileStorage,
+		Replicas:   n.Cfg.CreateStream.Replicas,
+		Duplicates: n.Cfg.CreateStream.DuplicateWindow,
+	}
+	if sc.MaxMsgs == 0 {
+		sc.MaxMsgs = -1
+	}
+	if sc.MaxBytes == 0 {
+		sc.MaxBytes = -1
+	}
+	if n.Cfg.CreateStream.Storage == "memory" {
+		sc.Storage = nats.MemoryStorage
+	}
+	return sc
+}
+
+func (n *JetStreamOutput) worker(ctx context.Context, i int, cfg *Config) {
+	defer n.wg.Done()
+	var natsConn *nats.Conn
+	var js nats.JetStreamContext
+	var err error
+	workerLogPrefix := fmt.Sprintf("worker-%d", i)
+	n.logger.Printf("%s starting", workerLogPrefix)
+CRCONN:
+	if ctx.Err() != nil {
+		return
+	}
+	natsConn, err = n.createNATSConn(cfg)
+	if err != nil {
+		n.logger.Printf("%s failed to create connection: %v", workerLogPrefix, err)
+		time.Sleep(n.Cfg.ConnectTimeWait)
+		goto CRCONN
+	}
+	js, err = natsConn.JetStream()
+	if err == nil {
+		err = n.bindStream(js)
+	}
+	if err != nil {
+		n.logger.Printf("%s %v", workerLogPrefix, err)
+		natsConn.Close()
+		time.Sleep(n.Cfg.ConnectTimeWait)
+		goto CRCONN
+	}
+	defer natsConn.Close()
+	n.logger.Printf("%s initialized jetstream producer: %+v", workerLogPrefix, cfg)
+	for {
+		select {
+		case <-ctx.Done():
+			n.logger.Printf("%s shutting down", workerLogPrefix)
+			return
+		case m := <-n.msgChan:
+			err = outputs.AddSubscriptionTarget(m.m, m.meta, n.Cfg.AddTarget, n.targetTpl)
+			if err != nil {
+				n.logger.Printf("failed to add target to the response: %v", err)
+			}
+			b, err := n.syncCache.Marshal(m.m, m.errCh != nil, func() ([]byte, error) {
+				return n.mo.Marshal(m.m, m.meta, n.evps...)
+			})
+			if err != nil {
+				if n.Cfg.Debug {
+					n.logger.Printf("%s failed marshaling proto msg: %v", workerLogPrefix, err)
+				}
+				if n.Cfg.EnableMetrics {
+					JetStreamNumberOfFailSendMsgs.WithLabelValues(cfg.Name, "marshal_error").Inc()
+				}
+				// retrying a message that cannot be marshaled won't help
+				m.done(nil)
+				continue
+			}
+			if len(b) == 0 {
+				m.done(nil)
+				continue
+			}
+			subject := n.subjectName(cfg, m.meta)
+			var start time.Time
+			if n.Cfg.EnableMetrics {
+				start = time.Now()
+			}
+			wctx, cancel := context.WithTimeout(ctx, n.Cfg.WriteTimeout)
+			ack, err := js.PublishMsg(newMsg(subject, b, m.meta),
+				nats.MsgId(msgID(subject, b, m.meta)),
+				nats.ExpectStream(n.Cfg.Stream),
+				nats.Context(wctx),
+			)
+			cancel()
+			if err != nil {
+				if n.Cfg.Debug {
+					n.logger.Printf("%s failed to publish to jetstream subject '%s': %v", workerLogPrefix, subject, err)
+				}
+				if n.Cfg.EnableMetrics {
+					JetStreamNumberOfFailSendMsgs.WithLabelValues(cfg.Name, "publish_error").Inc()
+				}
+				m.done(err)
+				continue
+			}
+			if ack.Duplicate {
+				if n.Cfg.Debug {
+					n.logger.Printf("%s message on subject '%s' is a duplicate of stream %s sequence %d", workerLogPrefix, subject, ack.Stream, ack.Sequence)
+				}
+				if n.Cfg.EnableMetrics {
+					JetStreamNumberOfDuplicateMsgs.WithLabelValues(cfg.Name, ack.Stream).Inc()
+				}
+				m.done(nil)
+				continue
+			}
+			if n.Cfg.EnableMetrics {
+				JetStreamSendDuration.WithLabelValues(cfg.Name).Set(float64(time.Since(start).Nanoseconds()))
+				JetStreamNumberOfSentMsgs.WithLabelValues(cfg.Name, subject).Inc()
+				JetStreamNumberOfSentBytes.WithLabelValues(cfg.Name, subject).Add(float64(len(b)))
+			}
+			m.done(nil)
+		}
+	}
+}
+
+// newMsg builds a message carrying the source and subscription name as headers,
+// so that they can be recovered regardless of the subject.
+func newMsg(subject string, b []byte, meta outputs.Meta) *nats.Msg {
+	msg := nats.NewMsg(subject)
+	msg.Data = b
+	if s, ok := meta["source"]; ok {
+		msg.Header.Set(sourceHeader, s)
+	}
+	if s, ok := meta["subscription-name"]; ok {
+		msg.Header.Set(subscriptionNameHeader, s)
+	}
+	return msg
+}
+
+// msgID returns the de-duplication ID of a message,
+// the same message written twice gets the same ID and is stored only once by the stream
+// as long as both writes happen within the stream duplicate window.
+func msgID(subject string, b []byte, meta outputs.Meta) string {
+	h := sha256.New()
+	h.Write([]byte(subject))
+	h.Write([]byte{0})
+	h.Write([]byte(meta["source"]))
+	h.Write([]byte{0})
+	h.Write([]byte(meta["subscription-name"]))
+	h.Write([]byte{0})
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (n *JetStreamOutput) subjectName(c *Config, meta outputs.Meta) string {
+	if c.SubjectPrefix != "" {
+		ssb := strings.Builder{}
+		ssb.WriteString(c.SubjectPrefix)
+		if s, ok := meta["source"]; ok {
+			source := strings.ReplaceAll(s, ".", "-")
+			source = strings.ReplaceAll(source, " ", "_")
+			ssb.WriteString(".")
+			ssb.WriteString(source)
+		}
+		if subname, ok := meta["subscription-name"]; ok {
+			ssb.WriteString(".")
+			ssb.WriteString(subname)
+		}
+		return strings.ReplaceAll(ssb.String(), " ", "_")
+	}
+	return strings.ReplaceAll(c.Subject, " ", "_")
+}
+
+func (n *JetStreamOutput) SetName(name string) {
+	sb := strings.Builder{}
+	if name != "" {
+		sb.WriteString(name)
+		sb.WriteString("-")
+	}
+	sb.WriteString(n.Cfg.Name)
+	sb.WriteString("-jetstream-pub")
+	n.Cfg.Name = sb.String()
+}
+
+func (n *JetStreamOutput) SetClusterName(name string) {}
+
+func (n *JetStreamOutput) SetTargetsConfig(map[string]*types.TargetConfig) {}
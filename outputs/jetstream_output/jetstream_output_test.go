@@ -0,0 +1,194 @@
+package jetstream_output
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/outputs"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+// startServer starts an in-process NATS server with JetStream enabled
+func startServer(t *testing.T, dir string) *server.Server {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		NoLog:     true,
+		NoSigs:    true,
+		JetStream: true,
+		StoreDir:  dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	return s
+}
+
+func TestWriteSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jetstream_output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := startServer(t, dir)
+	defer s.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := &JetStreamOutput{Cfg: &Config{}, wg: new(sync.WaitGroup), logger: log.New(ioutil.Discard, "", 0)}
+	err = o.Init(ctx, "js1", map[string]interface{}{
+		"address":           s.ClientURL(),
+		"format":            "proto",
+		"stream":            "gnmic",
+		"subject-prefix":    "telemetry",
+		"create-stream":     map[string]interface{}{"storage": "memory"},
+		"connect-time-wait": "100ms",
+		"write-timeout":     "200ms",
+	})
+	if err != nil {
+		t.Fatalf("failed to init output: %v", err)
+	}
+	defer o.Close()
+
+	rsp := func(ts int64) *gnmi.SubscribeResponse {
+		return &gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{
+				Update: &gnmi.Notification{Timestamp: ts},
+			},
+		}
+	}
+	meta := outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"}
+	// wait for the stream to be created
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err = o.WriteSync(ctx, rsp(1), meta); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("failed to write: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	// a retried message is stored once in the stream
+	for _, ts := range []int64{1, 2} {
+		if err = o.WriteSync(ctx, rsp(ts), meta); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	si, err := js.StreamInfo("gnmic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if si.State.Msgs != 2 {
+		t.Fatalf("got %d messages in the stream, want 2", si.State.Msgs)
+	}
+	sub, err := js.SubscribeSync("telemetry.>", nats.DeliverAll())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	for _, ts := range []int64{1, 2} {
+		msg, err := sub.NextMsg(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Subject != "telemetry.router1:57400.sub1" || msg.Header.Get(sourceHeader) != "router1:57400" {
+			t.Errorf("unexpected message subject %q, headers %v", msg.Subject, msg.Header)
+		}
+		got := new(gnmi.SubscribeResponse)
+		if err = proto.Unmarshal(msg.Data, got); err != nil {
+			t.Fatalf("failed to unmarshal message: %v", err)
+		}
+		if got.GetUpdate().GetTimestamp() != ts {
+			t.Errorf("got timestamp %d, want %d", got.GetUpdate().GetTimestamp(), ts)
+		}
+	}
+}
+
+func TestMsgID(t *testing.T) {
+	meta := outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"}
+	id := msgID("telemetry", []byte("msg1"), meta)
+	if id != msgID("telemetry", []byte("msg1"), outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"}) {
+		t.Errorf("same message got different IDs")
+	}
+	others := []struct {
+		name    string
+		subject string
+		data    string
+		meta    outputs.Meta
+	}{
+		{name: "other subject", subject: "telemetry2", data: "msg1", meta: meta},
+		{name: "other data", subject: "telemetry", data: "msg2", meta: meta},
+		{name: "other source", subject: "telemetry", data: "msg1", meta: outputs.Meta{"source": "router2:57400", "subscription-name": "sub1"}},
+		{name: "other subscription", subject: "telemetry", data: "msg1", meta: outputs.Meta{"source": "router1:57400", "subscription-name": "sub2"}},
+	}
+	for _, tt := range others {
+		t.Run(tt.name, func(t *testing.T) {
+			if msgID(tt.subject, []byte(tt.data), tt.meta) == id {
+				t.Errorf("different messages got the same ID")
+			}
+		})
+	}
+}
+
+func TestNewMsg(t *testing.T) {
+	msg := newMsg("telemetry", []byte("msg1"), outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"})
+	if msg.Subject != "telemetry" || string(msg.Data) != "msg1" {
+		t.Errorf("unexpected msg: %+v", msg)
+	}
+	if msg.Header.Get(sourceHeader) != "router1:57400" || msg.Header.Get(subscriptionNameHeader) != "sub1" {
+		t.Errorf("unexpected msg headers: %v", msg.Header)
+	}
+}
+
+func TestSubjectName(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+		meta outputs.Meta
+		want string
+	}{
+		{
+			name: "fixed subject",
+			cfg:  &Config{Subject: "gnmic telemetry"},
+			meta: outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"},
+			want: "gnmic_telemetry",
+		},
+		{
+			name: "subject prefix",
+			cfg:  &Config{SubjectPrefix: "telemetry"},
+			meta: outputs.Meta{"source": "10.1.1.1:57400", "subscription-name": "sub1"},
+			want: "telemetry.10-1-1-1:57400.sub1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := &JetStreamOutput{Cfg: tt.cfg}
+			if got := n.subjectName(tt.cfg, tt.meta); got != tt.want {
+				t.Errorf("got subject %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -38,6 +38,14 @@ const (
 type protoMsg struct {
 	m    proto.Message
 	meta outputs.Meta
+	// set by WriteSync to get the write result
+	errCh chan error
+}
+
+func (m *protoMsg) done(err error) {
+	if m.errCh != nil {
+		m.errCh <- err
+	}
 }
 
 func init() {
@@ -61,6 +69,7 @@ type KafkaOutput struct {
 	evps     []formatters.EventProcessor
 
 	targetTpl *template.Template
+	syncCache outputs.SyncCache
 }
 
 // Config //
@@ -122,7 +131,7 @@ func (k *KafkaOutput) SetEventProcessors(ps map[string]map[string]interface{}, l
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
 				if err != nil {
 					k.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
 					continue
@@ -256,10 +265,38 @@ func (k *KafkaOutput) Write(ctx context.Context, rsp proto.Message, meta outputs
 	}
 }
 
+// WriteSync implements outputs.SyncWriter
+func (k *KafkaOutput) WriteSync(ctx context.Context, rsp proto.Message, meta outputs.Meta) error {
+	if rsp == nil {
+		return nil
+	}
+	if k.mo == nil {
+		return errors.New("kafka output not initialized")
+	}
+	wctx, cancel := context.WithTimeout(ctx, k.Cfg.Timeout)
+	defer cancel()
+
+	m := &protoMsg{m: rsp, meta: meta, errCh: make(chan error, 1)}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case k.msgChan <- m:
+	case <-wctx.Done():
+		return fmt.Errorf("writing expired after %s, Kafka output might not be initialized", k.Cfg.Timeout)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-m.errCh:
+		return err
+	}
+}
+
 func (k *KafkaOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
 
 // Close //
 func (k *KafkaOutput) Close() error {
+	formatters.CloseProcessors(k.evps)
 	k.cancelFn()
 	k.wg.Wait()
 	return nil
@@ -300,7 +337,9 @@ CRPROD:
 			if err != nil {
 				k.logger.Printf("failed to add target to the response: %v", err)
 			}
-			b, err := k.mo.Marshal(m.m, m.meta, k.evps...)
+			b, err := k.syncCache.Marshal(m.m, m.errCh != nil, func() ([]byte, error) {
+				return k.mo.Marshal(m.m, m.meta, k.evps...)
+			})
 			if err != nil {
 				if k.Cfg.Debug {
 					k.logger.Printf("%s failed marshaling proto msg: %v", workerLogPrefix, err)
@@ -308,6 +347,8 @@ CRPROD:
 				if k.Cfg.EnableMetrics {
 					KafkaNumberOfFailSendMsgs.WithLabelValues(config.ClientID, "marshal_error").Inc()
 				}
+				// retrying a message that cannot be marshaled won't help
+				m.done(nil)
 				continue
 			}
 			msg := &sarama.ProducerMessage{
@@ -327,6 +368,7 @@ CRPROD:
 				if k.Cfg.EnableMetrics {
 					KafkaNumberOfFailSendMsgs.WithLabelValues(config.ClientID, "send_error").Inc()
 				}
+				m.done(err)
 				producer.Close()
 				time.Sleep(k.Cfg.RecoveryWaitTime)
 				goto CRPROD
@@ -336,6 +378,7 @@ CRPROD:
 				KafkaNumberOfSentMsgs.WithLabelValues(config.ClientID).Inc()
 				KafkaNumberOfSentBytes.WithLabelValues(config.ClientID).Add(float64(len(b)))
 			}
+			m.done(nil)
 		}
 	}
 }
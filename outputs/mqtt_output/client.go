@@ -0,0 +1,205 @@
+package mqtt_output
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/utils"
+)
+
+// client publishes messages using either MQTT 3.1.1 or MQTT 5,
+// both implementations reconnect to the broker on their own.
+type client interface {
+	publish(ctx context.Context, topic string, payload []byte, meta outputs.Meta) error
+	close()
+}
+
+func newClient(ctx context.Context, cfg *Config, logger *log.Logger) (client, error) {
+	var tlsCfg *tls.Config
+	var err error
+	if cfg.TLS != nil {
+		tlsCfg, err = utils.NewTLSConfig(cfg.TLS.CaFile, cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.SkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		if tlsCfg == nil {
+			tlsCfg = new(tls.Config)
+		}
+	}
+	brokerURL, err := parseBrokerURL(cfg.Address, tlsCfg != nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ProtocolVersion == 5 {
+		return newV5Client(ctx, cfg, brokerURL, tlsCfg, logger)
+	}
+	return newV3Client(cfg, brokerURL, tlsCfg, logger), nil
+}
+
+// parseBrokerURL parses the broker address, a scheme is added to addresses without one
+func parseBrokerURL(addr string, useTLS bool) (*url.URL, error) {
+	if !strings.Contains(addr, "://") {
+		if useTLS {
+			addr = "tls://" + addr
+		} else {
+			addr = "tcp://" + addr
+		}
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT broker address %q: %v", addr, err)
+	}
+	return u, nil
+}
+
+// MQTT 3.1.1 client
+
+type v3Client struct {
+	cfg    *Config
+	client mqtt.Client
+}
+
+func newV3Client(cfg *Config, brokerURL *url.URL, tlsCfg *tls.Config, logger *log.Logger) *v3Client {
+	opts := mqtt.NewClientOptions().
+		AddBroker(brokerURL.String()).
+		SetClientID(cfg.ClientID).
+		SetProtocolVersion(4).
+		SetKeepAlive(cfg.KeepAlive).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(cfg.ConnectTimeWait).
+		SetMaxReconnectInterval(cfg.ConnectTimeWait).
+		SetOrderMatters(false).
+		SetOnConnectHandler(func(mqtt.Client) {
+			logger.Printf("connected to MQTT broker %s", brokerURL.Host)
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logger.Printf("connection to MQTT broker %s lost: %v", brokerURL.Host, err)
+		})
+	if cfg.Username != "" {
+		opts.SetUsername(cfg.Username)
+		opts.SetPassword(cfg.Password)
+	}
+	if tlsCfg != nil {
+		opts.SetTLSConfig(tlsCfg)
+	}
+	c := &v3Client{
+		cfg:    cfg,
+		client: mqtt.NewClient(opts),
+	}
+	// with ConnectRetry set, the connection is retried in the background until it succeeds
+	c.client.Connect()
+	return c
+}
+
+func (c *v3Client) publish(ctx context.Context, topic string, payload []byte, _ outputs.Meta) error {
+	token := c.client.Publish(topic, c.cfg.QoS, c.cfg.Retain, payload)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-token.Done():
+		return token.Error()
+	}
+}
+
+func (c *v3Client) close() {
+	c.client.Disconnect(uint(time.Second / time.Millisecond))
+}
+
+// MQTT 5 client
+
+type v5Client struct {
+	cfg         *Config
+	cm          *autopaho.ConnectionManager
+	contentType string
+}
+
+func newV5Client(ctx context.Context, cfg *Config, brokerURL *url.URL, tlsCfg *tls.Config, logger *log.Logger) (*v5Client, error) {
+	cliCfg := autopaho.ClientConfig{
+		BrokerUrls:        []*url.URL{brokerURL},
+		TlsCfg:            tlsCfg,
+		KeepAlive:         uint16(cfg.KeepAlive.Seconds()),
+		ConnectRetryDelay: cfg.ConnectTimeWait,
+		OnConnectionUp: func(*autopaho.ConnectionManager, *paho.Connack) {
+			logger.Printf("connected to MQTT broker %s", brokerURL.Host)
+		},
+		OnConnectError: func(err error) {
+			logger.Printf("failed to connect to MQTT broker %s: %v", brokerURL.Host, err)
+		},
+		ClientConfig: paho.ClientConfig{
+			ClientID: cfg.ClientID,
+			OnClientError: func(err error) {
+				logger.Printf("MQTT client error: %v", err)
+			},
+			OnServerDisconnect: func(d *paho.Disconnect) {
+				logger.Printf("disconnected by MQTT broker %s: reason code %d", brokerURL.Host, d.ReasonCode)
+			},
+		},
+	}
+	if cfg.Username != "" {
+		cliCfg.SetUsernamePassword(cfg.Username, []byte(cfg.Password))
+	}
+	cm, err := autopaho.NewConnection(ctx, cliCfg)
+	if err != nil {
+		return nil, err
+	}
+	c := &v5Client{
+		cfg:         cfg,
+		cm:          cm,
+		contentType: "application/json",
+	}
+	if cfg.Format == "proto" {
+		c.contentType = "application/octet-stream"
+	}
+	return c, nil
+}
+
+// publish sends the message with its source and subscription name as user properties
+func (c *v5Client) publish(ctx context.Context, topic string, payload []byte, meta outputs.Meta) error {
+	err := c.cm.AwaitConnection(ctx)
+	if err != nil {
+		return err
+	}
+	props := &paho.PublishProperties{
+		ContentType: c.contentType,
+	}
+	for _, k := range []string{"source", "subscription-name"} {
+		if v, ok := meta[k]; ok {
+			props.User.Add(k, v)
+		}
+	}
+	rsp, err := c.cm.Publish(ctx, &paho.Publish{
+		QoS:        c.cfg.QoS,
+		Retain:     c.cfg.Retain,
+		Topic:      topic,
+		Properties: props,
+		Payload:    payload,
+	})
+	if err != nil {
+		return err
+	}
+	// a nil response is returned for QoS 0 messages
+	if rsp != nil && rsp.ReasonCode >= 0x80 {
+		if rsp.Properties != nil && rsp.Properties.ReasonString != "" {
+			return errors.New(rsp.Properties.ReasonString)
+		}
+		return fmt.Errorf("publish failed with reason code %d", rsp.ReasonCode)
+	}
+	return nil
+}
+
+func (c *v5Client) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.cm.Disconnect(ctx)
+}
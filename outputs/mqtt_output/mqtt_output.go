@@ -0,0 +1,420 @@
+package mqtt_output
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	loggingPrefix          = "[mqtt_output] "
+	defaultAddress         = "localhost:1883"
+	defaultTopic           = "telemetry"
+	defaultFormat          = "event"
+	defaultProtocolVersion = 4
+	defaultNumWorkers      = 1
+	defaultWriteTimeout    = 5 * time.Second
+	defaultKeepAlive       = 30 * time.Second
+	mqttConnectWait        = 2 * time.Second
+)
+
+func init() {
+	outputs.Register("mqtt", func() outputs.Output {
+		return &MqttOutput{
+			Cfg:    &Config{},
+			wg:     new(sync.WaitGroup),
+			logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		}
+	})
+}
+
+type protoMsg struct {
+	m    proto.Message
+	meta outputs.Meta
+	// set by WriteSync to get the write result
+	errCh chan error
+}
+
+func (m *protoMsg) done(err error) {
+	if m.errCh != nil {
+		m.errCh <- err
+	}
+}
+
+// MqttOutput //
+type MqttOutput struct {
+	Cfg      *Config
+	cancelFn context.CancelFunc
+	msgChan  chan *protoMsg
+	wg       *sync.WaitGroup
+	logger   *log.Logger
+	mo       *formatters.MarshalOptions
+	evps     []formatters.EventProcessor
+	client   client
+
+	targetTpl *template.Template
+	syncCache outputs.SyncCache
+}
+
+// Config //
+type Config struct {
+	Name               string        `mapstructure:"name,omitempty" json:"name,omitempty"`
+	Address            string        `mapstructure:"address,omitempty" json:"address,omitempty"`
+	ProtocolVersion    int           `mapstructure:"protocol-version,omitempty" json:"protocol-version,omitempty"`
+	ClientID           string        `mapstructure:"client-id,omitempty" json:"client-id,omitempty"`
+	Username           string        `mapstructure:"username,omitempty" json:"username,omitempty"`
+	Password           string        `mapstructure:"password,omitempty" json:"-"`
+	TLS                *tlsConfig    `mapstructure:"tls,omitempty" json:"tls,omitempty"`
+	TopicPrefix        string        `mapstructure:"topic-prefix,omitempty" json:"topic-prefix,omitempty"`
+	Topic              string        `mapstructure:"topic,omitempty" json:"topic,omitempty"`
+	QoS                byte          `mapstructure:"qos,omitempty" json:"qos,omitempty"`
+	Retain             bool          `mapstructure:"retain,omitempty" json:"retain,omitempty"`
+	KeepAlive          time.Duration `mapstructure:"keep-alive,omitempty" json:"keep-alive,omitempty"`
+	ConnectTimeWait    time.Duration `mapstructure:"connect-time-wait,omitempty" json:"connect-time-wait,omitempty"`
+	Format             string        `mapstructure:"format,omitempty" json:"format,omitempty"`
+	AddTarget          string        `mapstructure:"add-target,omitempty" json:"add-target,omitempty"`
+	TargetTemplate     string        `mapstructure:"target-template,omitempty" json:"target-template,omitempty"`
+	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty" json:"override-timestamps,omitempty"`
+	NumWorkers         int           `mapstructure:"num-workers,omitempty" json:"num-workers,omitempty"`
+	WriteTimeout       time.Duration `mapstructure:"write-timeout,omitempty" json:"write-timeout,omitempty"`
+	Debug              bool          `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+	EnableMetrics      bool          `mapstructure:"enable-metrics,omitempty" json:"enable-metrics,omitempty"`
+	EventProcessors    []string      `mapstructure:"event-processors,omitempty" json:"event-processors,omitempty"`
+}
+
+type tlsConfig struct {
+	CaFile     string `mapstructure:"ca-file,omitempty" json:"ca-file,omitempty"`
+	CertFile   string `mapstructure:"cert-file,omitempty" json:"cert-file,omitempty"`
+	KeyFile    string `mapstructure:"key-file,omitempty" json:"key-file,omitempty"`
+	SkipVerify bool   `mapstructure:"skip-verify,omitempty" json:"skip-verify,omitempty"`
+}
+
+func (m *MqttOutput) String() string {
+	b, err := json.Marshal(m.Cfg)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (m *MqttOutput) SetLogger(logger *log.Logger) {
+	if logger != nil && m.logger != nil {
+		m.logger.SetOutput(logger.Writer())
+		m.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (m *MqttOutput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]*types.TargetConfig) {
+	for _, epName := range m.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				if err != nil {
+					m.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
+					continue
+				}
+				m.evps = append(m.evps, ep)
+				m.logger.Printf("added event processor '%s' of type=%s to mqtt output", epName, epType)
+				continue
+			}
+			m.logger.Printf("%q event processor has an unknown type=%q", epName, epType)
+			continue
+		}
+		m.logger.Printf("%q event processor not found!", epName)
+	}
+}
+
+// Init //
+func (m *MqttOutput) Init(ctx context.Context, name string, cfg map[string]interface{}, opts ...outputs.Option) error {
+	err := outputs.DecodeConfig(cfg, m.Cfg)
+	if err != nil {
+		return err
+	}
+	if m.Cfg.Name == "" {
+		m.Cfg.Name = name
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	err = m.setDefaults()
+	if err != nil {
+		return err
+	}
+	m.msgChan = make(chan *protoMsg)
+	initMetrics()
+	m.mo = &formatters.MarshalOptions{
+		Format:     m.Cfg.Format,
+		OverrideTS: m.Cfg.OverrideTimestamps,
+	}
+	if m.Cfg.TargetTemplate == "" {
+		m.targetTpl = outputs.DefaultTargetTemplate
+	} else if m.Cfg.AddTarget != "" {
+		m.targetTpl, err = template.New("target-template").
+			Funcs(outputs.TemplateFuncs).
+			Parse(m.Cfg.TargetTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	ctx, m.cancelFn = context.WithCancel(ctx)
+	m.client, err = newClient(ctx, m.Cfg, m.logger)
+	if err != nil {
+		return err
+	}
+	m.logger.Printf("initialized mqtt output: %s", m.String())
+	m.wg.Add(m.Cfg.NumWorkers)
+	for i := 0; i < m.Cfg.NumWorkers; i++ {
+		go m.worker(ctx, i)
+	}
+
+	go func() {
+		<-ctx.Done()
+		m.Close()
+	}()
+	return nil
+}
+
+func (m *MqttOutput) setDefaults() error {
+	if m.Cfg.Format == "" {
+		m.Cfg.Format = defaultFormat
+	}
+	if !(m.Cfg.Format == "event" || m.Cfg.Format == "protojson" || m.Cfg.Format == "proto" || m.Cfg.Format == "json") {
+		return fmt.Errorf("unsupported output format '%s' for output type MQTT", m.Cfg.Format)
+	}
+	if m.Cfg.ProtocolVersion == 0 {
+		m.Cfg.ProtocolVersion = defaultProtocolVersion
+	}
+	if m.Cfg.ProtocolVersion != 4 && m.Cfg.ProtocolVersion != 5 {
+		return fmt.Errorf("unsupported MQTT protocol-version %d, must be 4 (MQTT 3.1.1) or 5", m.Cfg.ProtocolVersion)
+	}
+	if m.Cfg.QoS > 2 {
+		return fmt.Errorf("invalid MQTT qos %d, must be 0, 1 or 2", m.Cfg.QoS)
+	}
+	if m.Cfg.Address == "" {
+		m.Cfg.Address = defaultAddress
+	}
+	if m.Cfg.Topic == "" && m.Cfg.TopicPrefix == "" {
+		m.Cfg.Topic = defaultTopic
+	}
+	if strings.ContainsAny(m.Cfg.Topic+m.Cfg.TopicPrefix, "+#") {
+		return errors.New("MQTT topic and topic-prefix cannot contain wildcards '+' or '#'")
+	}
+	if m.Cfg.Name == "" {
+		m.Cfg.Name = "gnmic-" + uuid.New().String()
+	}
+	if m.Cfg.ClientID == "" {
+		m.Cfg.ClientID = m.Cfg.Name
+	}
+	if m.Cfg.KeepAlive <= 0 {
+		m.Cfg.KeepAlive = defaultKeepAlive
+	}
+	if m.Cfg.ConnectTimeWait <= 0 {
+		m.Cfg.ConnectTimeWait = mqttConnectWait
+	}
+	if m.Cfg.NumWorkers <= 0 {
+		m.Cfg.NumWorkers = defaultNumWorkers
+	}
+	if m.Cfg.WriteTimeout <= 0 {
+		m.Cfg.WriteTimeout = defaultWriteTimeout
+	}
+	return nil
+}
+
+// Write //
+func (m *MqttOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta) {
+	if rsp == nil || m.mo == nil {
+		return
+	}
+
+	wctx, cancel := context.WithTimeout(ctx, m.Cfg.WriteTimeout)
+	defer cancel()
+
+	select {
+	case <-ctx.Done():
+		return
+	case m.msgChan <- &protoMsg{m: rsp, meta: meta}:
+	case <-wctx.Done():
+		if m.Cfg.Debug {
+			m.logger.Printf("writing expired after %s, MQTT output might not be initialized", m.Cfg.WriteTimeout)
+		}
+		if m.Cfg.EnableMetrics {
+			MqttNumberOfFailSendMsgs.WithLabelValues(m.Cfg.Name, "timeout").Inc()
+		}
+		return
+	}
+}
+
+// WriteSync implements outputs.SyncWriter
+func (m *MqttOutput) WriteSync(ctx context.Context, rsp proto.Message, meta outputs.Meta) error {
+	if rsp == nil {
+		return nil
+	}
+	if m.mo == nil {
+		return errors.New("MQTT output not initialized")
+	}
+	wctx, cancel := context.WithTimeout(ctx, m.Cfg.WriteTimeout)
+	defer cancel()
+
+	msg := &protoMsg{m: rsp, meta: meta, errCh: make(chan error, 1)}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case m.msgChan <- msg:
+	case <-wctx.Done():
+		return fmt.Errorf("writing expired after %s, MQTT output might not be initialized", m.Cfg.WriteTimeout)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-msg.errCh:
+		return err
+	}
+}
+
+func (m *MqttOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
+
+// Close //
+func (m *MqttOutput) Close() error {
+	formatters.CloseProcessors(m.evps)
+	if m.cancelFn != nil {
+		m.cancelFn()
+	}
+	m.wg.Wait()
+	if m.client != nil {
+		m.client.close()
+	}
+	return nil
+}
+
+// Metrics //
+func (m *MqttOutput) RegisterMetrics(reg *prometheus.Registry) {
+	if !m.Cfg.EnableMetrics {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		m.logger.Printf("failed to register metric: %+v", err)
+	}
+}
+
+func (m *MqttOutput) worker(ctx context.Context, i int) {
+	defer m.wg.Done()
+	workerLogPrefix := fmt.Sprintf("worker-%d", i)
+	m.logger.Printf("%s starting", workerLogPrefix)
+	for {
+		select {
+		case <-ctx.Done():
+			m.logger.Printf("%s shutting down", workerLogPrefix)
+			return
+		case msg := <-m.msgChan:
+			err := outputs.AddSubscriptionTarget(msg.m, msg.meta, m.Cfg.AddTarget, m.targetTpl)
+			if err != nil {
+				m.logger.Printf("failed to add target to the response: %v", err)
+			}
+			b, err := m.syncCache.Marshal(msg.m, msg.errCh != nil, func() ([]byte, error) {
+				return m.mo.Marshal(msg.m, msg.meta, m.evps...)
+			})
+			if err != nil {
+				if m.Cfg.Debug {
+					m.logger.Printf("%s failed marshaling proto msg: %v", workerLogPrefix, err)
+				}
+				if m.Cfg.EnableMetrics {
+					MqttNumberOfFailSendMsgs.WithLabelValues(m.Cfg.Name, "marshal_error").Inc()
+				}
+				// retrying a message that cannot be marshaled won't help
+				msg.done(nil)
+				continue
+			}
+			if len(b) == 0 {
+				msg.done(nil)
+				continue
+			}
+			topic := m.topicName(msg.meta)
+			var start time.Time
+			if m.Cfg.EnableMetrics {
+				start = time.Now()
+			}
+			pctx, cancel := context.WithTimeout(ctx, m.Cfg.WriteTimeout)
+			err = m.client.publish(pctx, topic, b, msg.meta)
+			cancel()
+			if err != nil {
+				if m.Cfg.Debug {
+					m.logger.Printf("%s failed to publish to mqtt topic '%s': %v", workerLogPrefix, topic, err)
+				}
+				if m.Cfg.EnableMetrics {
+					MqttNumberOfFailSendMsgs.WithLabelValues(m.Cfg.Name, "publish_error").Inc()
+				}
+				msg.done(err)
+				continue
+			}
+			if m.Cfg.EnableMetrics {
+				MqttSendDuration.WithLabelValues(m.Cfg.Name).Set(float64(time.Since(start).Nanoseconds()))
+				MqttNumberOfSentMsgs.WithLabelValues(m.Cfg.Name, topic).Inc()
+				MqttNumberOfSentBytes.WithLabelValues(m.Cfg.Name, topic).Add(float64(len(b)))
+			}
+			msg.done(nil)
+		}
+	}
+}
+
+// topicName returns the topic a message is published to.
+// If a topic prefix is set, the topic is built from the prefix, the message source
+// and the subscription name: <topic-prefix>/<source>/<subscription-name>.
+func (m *MqttOutput) topicName(meta outputs.Meta) string {
+	if m.Cfg.TopicPrefix != "" {
+		sb := strings.Builder{}
+		sb.WriteString(strings.TrimSuffix(m.Cfg.TopicPrefix, "/"))
+		if s, ok := meta["source"]; ok {
+			sb.WriteString("/")
+			sb.WriteString(topicLevel(s))
+		}
+		if subname, ok := meta["subscription-name"]; ok {
+			sb.WriteString("/")
+			sb.WriteString(topicLevel(subname))
+		}
+		return strings.ReplaceAll(sb.String(), " ", "_")
+	}
+	return strings.ReplaceAll(m.Cfg.Topic, " ", "_")
+}
+
+var topicLevelReplacer = strings.NewReplacer("/", "_", "+", "_", "#", "_")
+
+// topicLevel makes s usable as a single MQTT topic level,
+// replacing the levels separator and the wildcards
+func topicLevel(s string) string {
+	return topicLevelReplacer.Replace(s)
+}
+
+func (m *MqttOutput) SetName(name string) {
+	sb := strings.Builder{}
+	if name != "" {
+		sb.WriteString(name)
+		sb.WriteString("-")
+	}
+	sb.WriteString(m.Cfg.Name)
+	sb.WriteString("-mqtt-pub")
+	m.Cfg.Name = sb.String()
+}
+
+func (m *MqttOutput) SetClusterName(name string) {}
+
+func (m *MqttOutput) SetTargetsConfig(map[string]*types.TargetConfig) {}
@@ -0,0 +1,144 @@
+package mqtt_output
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/outputs"
+	mqttserver "github.com/mochi-co/mqtt/server"
+	"github.com/mochi-co/mqtt/server/events"
+	"github.com/mochi-co/mqtt/server/listeners"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+// startBroker starts an in-process MQTT 3.1.1 broker listening on addr,
+// the messages it receives are sent to msgs.
+func startBroker(t *testing.T, addr string, msgs chan<- events.Packet) *mqttserver.Server {
+	s := mqttserver.NewServer(nil)
+	err := s.AddListener(listeners.NewTCP("t1", addr), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Events.OnMessage = func(_ events.Client, pk events.Packet) (events.Packet, error) {
+		msgs <- pk
+		return pk, nil
+	}
+	if err = s.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestWriteSync(t *testing.T) {
+	// reserve a port for the broker started later
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := &MqttOutput{Cfg: &Config{}, wg: new(sync.WaitGroup), logger: log.New(ioutil.Discard, "", 0)}
+	err = o.Init(ctx, "mqtt1", map[string]interface{}{
+		"address":           addr,
+		"format":            "proto",
+		"topic-prefix":      "telemetry",
+		"qos":               1,
+		"connect-time-wait": "100ms",
+		"write-timeout":     "200ms",
+	})
+	if err != nil {
+		t.Fatalf("failed to init output: %v", err)
+	}
+	defer o.Close()
+
+	rsp := &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{Timestamp: 42},
+		},
+	}
+	meta := outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"}
+	// the broker is not running yet
+	if err = o.WriteSync(ctx, rsp, meta); err == nil {
+		t.Fatal("expected an error writing without a broker")
+	}
+
+	msgs := make(chan events.Packet, 10)
+	s := startBroker(t, addr, msgs)
+	defer s.Close()
+	// the message is written once the client is reconnected
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err = o.WriteSync(ctx, rsp, meta); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("failed to write after the broker started: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	select {
+	case pk := <-msgs:
+		if pk.TopicName != "telemetry/router1:57400/sub1" || pk.FixedHeader.Qos != 1 {
+			t.Errorf("unexpected message topic %q, qos %d", pk.TopicName, pk.FixedHeader.Qos)
+		}
+		got := new(gnmi.SubscribeResponse)
+		if err = proto.Unmarshal(pk.Payload, got); err != nil {
+			t.Fatalf("failed to unmarshal message: %v", err)
+		}
+		if !proto.Equal(got, rsp) {
+			t.Errorf("got message %v, want %v", got, rsp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message not received by the broker")
+	}
+}
+
+func TestTopicName(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+		meta outputs.Meta
+		want string
+	}{
+		{
+			name: "fixed topic",
+			cfg:  &Config{Topic: "gnmic telemetry"},
+			meta: outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"},
+			want: "gnmic_telemetry",
+		},
+		{
+			name: "topic prefix",
+			cfg:  &Config{TopicPrefix: "telemetry/"},
+			meta: outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"},
+			want: "telemetry/router1:57400/sub1",
+		},
+		{
+			name: "topic prefix without meta",
+			cfg:  &Config{TopicPrefix: "telemetry"},
+			want: "telemetry",
+		},
+		{
+			name: "topic prefix with levels separator and wildcards",
+			cfg:  &Config{TopicPrefix: "telemetry"},
+			meta: outputs.Meta{"source": "10.1.1.1", "subscription-name": "ports/#+"},
+			want: "telemetry/10.1.1.1/ports___",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &MqttOutput{Cfg: tt.cfg}
+			if got := m.topicName(tt.meta); got != tt.want {
+				t.Errorf("got topic %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
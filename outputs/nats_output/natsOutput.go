@@ -3,6 +3,7 @@ package nats_output
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -45,6 +46,14 @@ func init() {
 type protoMsg struct {
 	m    proto.Message
 	meta outputs.Meta
+	// set by WriteSync to get the write result
+	errCh chan error
+}
+
+func (m *protoMsg) done(err error) {
+	if m.errCh != nil {
+		m.errCh <- err
+	}
 }
 
 // NatsOutput //
@@ -59,6 +68,7 @@ type NatsOutput struct {
 	evps     []formatters.EventProcessor
 
 	targetTpl *template.Template
+	syncCache outputs.SyncCache
 }
 
 // Config //
@@ -106,7 +116,7 @@ func (n *NatsOutput) SetEventProcessors(ps map[string]map[string]interface{}, lo
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
 				if err != nil {
 					n.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
 					continue
@@ -221,10 +231,38 @@ func (n *NatsOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.
 	}
 }
 
+// WriteSync implements outputs.SyncWriter
+func (n *NatsOutput) WriteSync(ctx context.Context, rsp proto.Message, meta outputs.Meta) error {
+	if rsp == nil {
+		return nil
+	}
+	if n.mo == nil {
+		return errors.New("NATS output not initialized")
+	}
+	wctx, cancel := context.WithTimeout(ctx, n.Cfg.WriteTimeout)
+	defer cancel()
+
+	m := &protoMsg{m: rsp, meta: meta, errCh: make(chan error, 1)}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case n.msgChan <- m:
+	case <-wctx.Done():
+		return fmt.Errorf("writing expired after %s, NATS output might not be initialized", n.Cfg.WriteTimeout)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-m.errCh:
+		return err
+	}
+}
+
 func (n *NatsOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
 
 // Close //
 func (n *NatsOutput) Close() error {
+	formatters.CloseProcessors(n.evps)
 	//	n.conn.Close()
 	n.cancelFn()
 	n.wg.Wait()
@@ -319,7 +357,9 @@ CRCONN:
 			if err != nil {
 				n.logger.Printf("failed to add target to the response: %v", err)
 			}
-			b, err := n.mo.Marshal(m.m, m.meta, n.evps...)
+			b, err := n.syncCache.Marshal(m.m, m.errCh != nil, func() ([]byte, error) {
+				return n.mo.Marshal(m.m, m.meta, n.evps...)
+			})
 			if err != nil {
 				if n.Cfg.Debug {
 					n.logger.Printf("%s failed marshaling proto msg: %v", workerLogPrefix, err)
@@ -327,6 +367,8 @@ CRCONN:
 				if n.Cfg.EnableMetrics {
 					NatsNumberOfFailSendMsgs.WithLabelValues(cfg.Name, "marshal_error").Inc()
 				}
+				// retrying a message that cannot be marshaled won't help
+				m.done(nil)
 				continue
 			}
 			subject := n.subjectName(cfg, m.meta)
@@ -335,6 +377,10 @@ CRCONN:
 				start = time.Now()
 			}
 			err = natsConn.Publish(subject, b)
+			if err == nil && m.errCh != nil {
+				// make sure the message reached the server before reporting it as written
+				err = natsConn.FlushTimeout(n.Cfg.WriteTimeout)
+			}
 			if err != nil {
 				if n.Cfg.Debug {
 					n.logger.Printf("%s failed to write to nats subject '%s': %v", workerLogPrefix, subject, err)
@@ -342,6 +388,7 @@ CRCONN:
 				if n.Cfg.EnableMetrics {
 					NatsNumberOfFailSendMsgs.WithLabelValues(cfg.Name, "publish_error").Inc()
 				}
+				m.done(err)
 				natsConn.Close()
 				time.Sleep(cfg.ConnectTimeWait)
 				goto CRCONN
@@ -351,6 +398,7 @@ CRCONN:
 				NatsNumberOfSentMsgs.WithLabelValues(cfg.Name, subject).Inc()
 				NatsNumberOfSentBytes.WithLabelValues(cfg.Name, subject).Add(float64(len(b)))
 			}
+			m.done(nil)
 		}
 	}
 }
@@ -0,0 +1,45 @@
+package otlp_output
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var OTLPNumberOfSentDataPoints = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "otlp_output",
+	Name:      "number_of_otlp_data_points_sent_success_total",
+	Help:      "Number of data points successfully exported by gnmic otlp output",
+}, []string{"exporter_id"})
+
+var OTLPNumberOfFailedExports = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "otlp_output",
+	Name:      "number_of_otlp_exports_fail_total",
+	Help:      "Number of failed exports by gnmic otlp output",
+}, []string{"exporter_id", "reason"})
+
+var OTLPExportDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "otlp_output",
+	Name:      "export_duration_ns",
+	Help:      "gnmic otlp output export duration in ns",
+}, []string{"exporter_id"})
+
+func initMetrics() {
+	OTLPNumberOfSentDataPoints.WithLabelValues("").Add(0)
+	OTLPNumberOfFailedExports.WithLabelValues("", "").Add(0)
+	OTLPExportDuration.WithLabelValues("").Set(0)
+}
+
+func registerMetrics(reg *prometheus.Registry) error {
+	initMetrics()
+	var err error
+	if err = reg.Register(OTLPNumberOfSentDataPoints); err != nil {
+		return err
+	}
+	if err = reg.Register(OTLPNumberOfFailedExports); err != nil {
+		return err
+	}
+	if err = reg.Register(OTLPExportDuration); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,744 @@
+package otlp_output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/types"
+	"github.com/karimra/gnmic/utils"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultGRPCEndpoint = "localhost:4317"
+	defaultHTTPEndpoint = "http://localhost:4318/v1/metrics"
+	defaultProtocol     = "grpc"
+	defaultTimeout      = 10 * time.Second
+	defaultBatchSize    = 1000
+	defaultFlushTimer   = 10 * time.Second
+	defaultNumWorkers   = 1
+	defaultHTTPPath     = "/v1/metrics"
+	metricNameRegex     = "[^a-zA-Z0-9_]+"
+	instrumentationName = "gnmic"
+	loggingPrefix       = "[otlp_output] "
+)
+
+func init() {
+	outputs.Register("otlp", func() outputs.Output {
+		return &otlpOutput{
+			Cfg:         &Config{},
+			wg:          new(sync.WaitGroup),
+			logger:      log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+			metricRegex: regexp.MustCompile(metricNameRegex),
+		}
+	})
+}
+
+type otlpOutput struct {
+	Cfg      *Config
+	logger   *log.Logger
+	cancelFn context.CancelFunc
+	wg       *sync.WaitGroup
+	evps     []formatters.EventProcessor
+
+	eventChan   chan *formatters.EventMsg
+	metricRegex *regexp.Regexp
+	counterRegs []*regexp.Regexp
+	resTagKeys  map[string]struct{}
+
+	conn       *grpc.ClientConn
+	grpcClient colmetricspb.MetricsServiceClient
+	httpClient *http.Client
+
+	targetTpl *template.Template
+}
+
+// Config //
+type Config struct {
+	Name                   string            `mapstructure:"name,omitempty" json:"name,omitempty"`
+	Endpoint               string            `mapstructure:"endpoint,omitempty" json:"endpoint,omitempty"`
+	Protocol               string            `mapstructure:"protocol,omitempty" json:"protocol,omitempty"`
+	Timeout                time.Duration     `mapstructure:"timeout,omitempty" json:"timeout,omitempty"`
+	Headers                map[string]string `mapstructure:"headers,omitempty" json:"headers,omitempty"`
+	TLS                    *tlsConfig        `mapstructure:"tls,omitempty" json:"tls,omitempty"`
+	BatchSize              int               `mapstructure:"batch-size,omitempty" json:"batch-size,omitempty"`
+	FlushTimer             time.Duration     `mapstructure:"flush-timer,omitempty" json:"flush-timer,omitempty"`
+	BufferSize             int               `mapstructure:"buffer-size,omitempty" json:"buffer-size,omitempty"`
+	NumWorkers             int               `mapstructure:"num-workers,omitempty" json:"num-workers,omitempty"`
+	MetricPrefix           string            `mapstructure:"metric-prefix,omitempty" json:"metric-prefix,omitempty"`
+	AppendSubscriptionName bool              `mapstructure:"append-subscription-name,omitempty" json:"append-subscription-name,omitempty"`
+	ResourceTagKeys        []string          `mapstructure:"resource-tag-keys,omitempty" json:"resource-tag-keys,omitempty"`
+	ResourceAttributes     map[string]string `mapstructure:"resource-attributes,omitempty" json:"resource-attributes,omitempty"`
+	CounterPatterns        []string          `mapstructure:"counter-patterns,omitempty" json:"counter-patterns,omitempty"`
+	AddTarget              string            `mapstructure:"add-target,omitempty" json:"add-target,omitempty"`
+	TargetTemplate         string            `mapstructure:"target-template,omitempty" json:"target-template,omitempty"`
+	OverrideTimestamps     bool              `mapstructure:"override-timestamps,omitempty" json:"override-timestamps,omitempty"`
+	EventProcessors        []string          `mapstructure:"event-processors,omitempty" json:"event-processors,omitempty"`
+	EnableMetrics          bool              `mapstructure:"enable-metrics,omitempty" json:"enable-metrics,omitempty"`
+	Debug                  bool              `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+}
+
+type tlsConfig struct {
+	CaFile     string `mapstructure:"ca-file,omitempty" json:"ca-file,omitempty"`
+	CertFile   string `mapstructure:"cert-file,omitempty" json:"cert-file,omitempty"`
+	KeyFile    string `mapstructure:"key-file,omitempty" json:"key-file,omitempty"`
+	SkipVerify bool   `mapstructure:"skip-verify,omitempty" json:"skip-verify,omitempty"`
+}
+
+func (o *otlpOutput) String() string {
+	b, err := json.Marshal(o.Cfg)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (o *otlpOutput) SetLogger(logger *log.Logger) {
+	if logger != nil && o.logger != nil {
+		o.logger.SetOutput(logger.Writer())
+		o.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (o *otlpOutput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]*types.TargetConfig) {
+	for _, epName := range o.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithEmitting())
+				if err != nil {
+					o.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
+					continue
+				}
+				o.evps = append(o.evps, ep)
+				o.logger.Printf("added event processor '%s' of type=%s to otlp output", epName, epType)
+				continue
+			}
+			o.logger.Printf("%q event processor has an unknown type=%q", epName, epType)
+			continue
+		}
+		o.logger.Printf("%q event processor not found!", epName)
+	}
+}
+
+// Init //
+func (o *otlpOutput) Init(ctx context.Context, name string, cfg map[string]interface{}, opts ...outputs.Option) error {
+	err := outputs.DecodeConfig(cfg, o.Cfg)
+	if err != nil {
+		return err
+	}
+	if o.Cfg.Name == "" {
+		o.Cfg.Name = name
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	err = o.setDefaults()
+	if err != nil {
+		return err
+	}
+	o.counterRegs = make([]*regexp.Regexp, 0, len(o.Cfg.CounterPatterns))
+	for _, p := range o.Cfg.CounterPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("failed to compile counter pattern %q: %v", p, err)
+		}
+		o.counterRegs = append(o.counterRegs, re)
+	}
+	o.resTagKeys = make(map[string]struct{}, len(o.Cfg.ResourceTagKeys))
+	for _, k := range o.Cfg.ResourceTagKeys {
+		o.resTagKeys[k] = struct{}{}
+	}
+
+	if o.Cfg.TargetTemplate == "" {
+		o.targetTpl = outputs.DefaultTargetTemplate
+	} else if o.Cfg.AddTarget != "" {
+		o.targetTpl, err = template.New("target-template").
+			Funcs(outputs.TemplateFuncs).
+			Parse(o.Cfg.TargetTemplate)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = o.createClient(ctx)
+	if err != nil {
+		return err
+	}
+	o.eventChan = make(chan *formatters.EventMsg, o.Cfg.BufferSize)
+	ctx, o.cancelFn = context.WithCancel(ctx)
+	o.wg.Add(o.Cfg.NumWorkers)
+	for i := 0; i < o.Cfg.NumWorkers; i++ {
+		go o.worker(ctx, i)
+	}
+	formatters.StartEmitters(ctx, o.evps, func(ev *formatters.EventMsg) { o.WriteEvent(ctx, ev) })
+	o.logger.Printf("initialized otlp output: %s", o.String())
+	go func() {
+		<-ctx.Done()
+		o.Close()
+	}()
+	return nil
+}
+
+func (o *otlpOutput) setDefaults() error {
+	o.Cfg.Protocol = strings.ToLower(o.Cfg.Protocol)
+	switch o.Cfg.Protocol {
+	case "":
+		o.Cfg.Protocol = defaultProtocol
+	case "grpc", "http":
+	default:
+		return fmt.Errorf("unsupported protocol %q for output type otlp", o.Cfg.Protocol)
+	}
+	if o.Cfg.Endpoint == "" {
+		o.Cfg.Endpoint = defaultGRPCEndpoint
+		if o.Cfg.Protocol == "http" {
+			o.Cfg.Endpoint = defaultHTTPEndpoint
+		}
+	}
+	if o.Cfg.Protocol == "http" {
+		u, err := o.httpURL()
+		if err != nil {
+			return err
+		}
+		o.Cfg.Endpoint = u
+	}
+	if o.Cfg.Timeout <= 0 {
+		o.Cfg.Timeout = defaultTimeout
+	}
+	if o.Cfg.BatchSize <= 0 {
+		o.Cfg.BatchSize = defaultBatchSize
+	}
+	if o.Cfg.FlushTimer <= 0 {
+		o.Cfg.FlushTimer = defaultFlushTimer
+	}
+	if o.Cfg.BufferSize < 0 {
+		o.Cfg.BufferSize = 0
+	}
+	if o.Cfg.NumWorkers <= 0 {
+		o.Cfg.NumWorkers = defaultNumWorkers
+	}
+	return nil
+}
+
+// httpURL builds the OTLP/HTTP URL from the configured endpoint,
+// adding a scheme and the default metrics path if they are missing.
+func (o *otlpOutput) httpURL() (string, error) {
+	ep := o.Cfg.Endpoint
+	if !strings.HasPrefix(ep, "http://") && !strings.HasPrefix(ep, "https://") {
+		if o.Cfg.TLS != nil {
+			ep = "https://" + ep
+		} else {
+			ep = "http://" + ep
+		}
+	}
+	u, err := url.Parse(ep)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse otlp endpoint %q: %v", o.Cfg.Endpoint, err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = defaultHTTPPath
+	}
+	return u.String(), nil
+}
+
+func (o *otlpOutput) createClient(ctx context.Context) error {
+	var err error
+	tlsCfg := new(tlsConfig)
+	if o.Cfg.TLS != nil {
+		tlsCfg = o.Cfg.TLS
+	}
+	tlscfg, err := utils.NewTLSConfig(tlsCfg.CaFile, tlsCfg.CertFile, tlsCfg.KeyFile, tlsCfg.SkipVerify)
+	if err != nil {
+		return err
+	}
+	switch o.Cfg.Protocol {
+	case "grpc":
+		opts := []grpc.DialOption{}
+		if tlscfg != nil {
+			opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlscfg)))
+		} else {
+			opts = append(opts, grpc.WithInsecure())
+		}
+		// the connection is established lazily, failed exports are retried on the next flush.
+		o.conn, err = grpc.DialContext(ctx, o.Cfg.Endpoint, opts...)
+		if err != nil {
+			return fmt.Errorf("failed to create otlp grpc client: %v", err)
+		}
+		o.grpcClient = colmetricspb.NewMetricsServiceClient(o.conn)
+	case "http":
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		if tlscfg != nil {
+			tr.TLSClientConfig = tlscfg
+		}
+		o.httpClient = &http.Client{
+			Timeout:   o.Cfg.Timeout,
+			Transport: tr,
+		}
+	}
+	return nil
+}
+
+// Write //
+func (o *otlpOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta) {
+	if rsp == nil {
+		return
+	}
+	err := outputs.AddSubscriptionTarget(rsp, meta, o.Cfg.AddTarget, o.targetTpl)
+	if err != nil {
+		o.logger.Printf("failed to add target to the response: %v", err)
+	}
+	switch rsp := rsp.(type) {
+	case *gnmi.SubscribeResponse:
+		measName := "default"
+		if subName, ok := meta["subscription-name"]; ok {
+			measName = subName
+		}
+		events, err := formatters.ResponseToEventMsgs(measName, rsp, meta, o.evps...)
+		if err != nil {
+			o.logger.Printf("failed to convert message to event: %v", err)
+			return
+		}
+		for _, ev := range events {
+			o.WriteEvent(ctx, ev)
+		}
+	}
+}
+
+func (o *otlpOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	if ev == nil {
+		return
+	}
+	if o.Cfg.OverrideTimestamps {
+		ev.Timestamp = time.Now().UnixNano()
+	}
+	select {
+	case <-ctx.Done():
+		return
+	case o.eventChan <- ev:
+	}
+}
+
+// Close //
+func (o *otlpOutput) Close() error {
+	formatters.CloseProcessors(o.evps)
+	if o.cancelFn != nil {
+		o.cancelFn()
+	}
+	o.wg.Wait()
+	if o.conn != nil {
+		return o.conn.Close()
+	}
+	return nil
+}
+
+// Metrics //
+func (o *otlpOutput) RegisterMetrics(reg *prometheus.Registry) {
+	if !o.Cfg.EnableMetrics {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		o.logger.Printf("failed to register metric: %v", err)
+	}
+}
+
+func (o *otlpOutput) SetName(name string) {
+	sb := strings.Builder{}
+	if name != "" {
+		sb.WriteString(name)
+		sb.WriteString("-")
+	}
+	sb.WriteString(o.Cfg.Name)
+	sb.WriteString("-otlp-exp")
+	o.Cfg.Name = sb.String()
+}
+
+func (o *otlpOutput) SetClusterName(name string) {}
+
+func (o *otlpOutput) SetTargetsConfig(map[string]*types.TargetConfig) {}
+
+func (o *otlpOutput) worker(ctx context.Context, idx int) {
+	defer o.wg.Done()
+	workerLogPrefix := fmt.Sprintf("worker-%d", idx)
+	o.logger.Printf("%s starting", workerLogPrefix)
+	ticker := time.NewTicker(o.Cfg.FlushTimer)
+	defer ticker.Stop()
+	batch := make([]*formatters.EventMsg, 0, o.Cfg.BatchSize)
+	for {
+		select {
+		case <-ctx.Done():
+			if len(batch) > 0 {
+				// best effort flush of the remaining events
+				fctx, cancel := context.WithTimeout(context.Background(), o.Cfg.Timeout)
+				o.flush(fctx, workerLogPrefix, batch)
+				cancel()
+			}
+			o.logger.Printf("%s shutting down", workerLogPrefix)
+			return
+		case ev := <-o.eventChan:
+			batch = append(batch, ev)
+			if len(batch) >= o.Cfg.BatchSize {
+				o.flush(ctx, workerLogPrefix, batch)
+				batch = make([]*formatters.EventMsg, 0, o.Cfg.BatchSize)
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+			o.flush(ctx, workerLogPrefix, batch)
+			batch = make([]*formatters.EventMsg, 0, o.Cfg.BatchSize)
+		}
+	}
+}
+
+func (o *otlpOutput) flush(ctx context.Context, workerLogPrefix string, evs []*formatters.EventMsg) {
+	req := o.exportRequest(evs)
+	numDataPoints := countDataPoints(req)
+	if numDataPoints == 0 {
+		return
+	}
+	var start time.Time
+	if o.Cfg.EnableMetrics {
+		start = time.Now()
+	}
+	err := o.export(ctx, req)
+	if err != nil {
+		o.logger.Printf("%s failed to export %d data points: %v", workerLogPrefix, numDataPoints, err)
+		if o.Cfg.EnableMetrics {
+			OTLPNumberOfFailedExports.WithLabelValues(o.Cfg.Name, "export_error").Inc()
+		}
+		return
+	}
+	if o.Cfg.Debug {
+		o.logger.Printf("%s exported %d data points", workerLogPrefix, numDataPoints)
+	}
+	if o.Cfg.EnableMetrics {
+		OTLPExportDuration.WithLabelValues(o.Cfg.Name).Set(float64(time.Since(start).Nanoseconds()))
+		OTLPNumberOfSentDataPoints.WithLabelValues(o.Cfg.Name).Add(float64(numDataPoints))
+	}
+}
+
+func (o *otlpOutput) export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, o.Cfg.Timeout)
+	defer cancel()
+	switch o.Cfg.Protocol {
+	case "grpc":
+		if len(o.Cfg.Headers) > 0 {
+			ctx = metadata.NewOutgoingContext(ctx, metadata.New(o.Cfg.Headers))
+		}
+		_, err := o.grpcClient.Export(ctx, req)
+		return err
+	case "http":
+		b, err := proto.Marshal(req)
+		if err != nil {
+			return err
+		}
+		hreq, err := http.NewRequestWithContext(ctx, http.MethodPost, o.Cfg.Endpoint, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		hreq.Header.Set("Content-Type", "application/x-protobuf")
+		for k, v := range o.Cfg.Headers {
+			hreq.Header.Set(k, v)
+		}
+		rsp, err := o.httpClient.Do(hreq)
+		if err != nil {
+			return err
+		}
+		defer rsp.Body.Close()
+		ioutil.ReadAll(rsp.Body)
+		if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+			return fmt.Errorf("unexpected response status: %s", rsp.Status)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown protocol %q", o.Cfg.Protocol)
+}
+
+// exportRequest converts a list of events into an OTLP ExportMetricsServiceRequest.
+// events are grouped into resources based on the configured resource-tag-keys,
+// data points with the same metric name under the same resource are grouped in a single metric.
+func (o *otlpOutput) exportRequest(evs []*formatters.EventMsg) *colmetricspb.ExportMetricsServiceRequest {
+	req := &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: make([]*metricspb.ResourceMetrics, 0),
+	}
+	resIndex := make(map[string]*metricspb.ResourceMetrics)
+	metricIndex := make(map[string]map[string]*metricspb.Metric)
+	for _, ev := range evs {
+		resAttrs, dpLabels := o.splitTags(ev)
+		resKey := attributesKey(resAttrs)
+		rm, ok := resIndex[resKey]
+		if !ok {
+			rm = &metricspb.ResourceMetrics{
+				Resource: &resourcepb.Resource{Attributes: resAttrs},
+				InstrumentationLibraryMetrics: []*metricspb.InstrumentationLibraryMetrics{
+					{
+						InstrumentationLibrary: &commonpb.InstrumentationLibrary{Name: instrumentationName},
+						Metrics:                make([]*metricspb.Metric, 0),
+					},
+				},
+			}
+			resIndex[resKey] = rm
+			metricIndex[resKey] = make(map[string]*metricspb.Metric)
+			req.ResourceMetrics = append(req.ResourceMetrics, rm)
+		}
+		ilm := rm.InstrumentationLibraryMetrics[0]
+		for vName, v := range ev.Values {
+			iv, fv, isInt, err := getNumber(v)
+			if err != nil {
+				if o.Cfg.Debug {
+					o.logger.Printf("skipping non numeric value %q: %v", vName, v)
+				}
+				continue
+			}
+			mName := o.metricName(ev.Name, vName)
+			m, ok := metricIndex[resKey][mName]
+			if !ok {
+				m = &metricspb.Metric{Name: mName}
+				metricIndex[resKey][mName] = m
+				ilm.Metrics = append(ilm.Metrics, m)
+			}
+			ts := uint64(ev.Timestamp)
+			if isInt {
+				o.addIntDataPoint(m, vName, &metricspb.IntDataPoint{Labels: dpLabels, TimeUnixNano: ts, Value: iv})
+				continue
+			}
+			o.addDoubleDataPoint(m, vName, &metricspb.DoubleDataPoint{Labels: dpLabels, TimeUnixNano: ts, Value: fv})
+		}
+	}
+	return req
+}
+
+// addIntDataPoint appends an integer data point to metric m.
+// the metric data is created as an IntSum if the value name matches
+// one of the counter-patterns, as an IntGauge otherwise.
+func (o *otlpOutput) addIntDataPoint(m *metricspb.Metric, vName string, dp *metricspb.IntDataPoint) {
+	switch data := m.Data.(type) {
+	case *metricspb.Metric_IntSum:
+		data.IntSum.DataPoints = append(data.IntSum.DataPoints, dp)
+	case *metricspb.Metric_IntGauge:
+		data.IntGauge.DataPoints = append(data.IntGauge.DataPoints, dp)
+	case *metricspb.Metric_DoubleSum:
+		data.DoubleSum.DataPoints = append(data.DoubleSum.DataPoints, intToDoubleDataPoint(dp))
+	case *metricspb.Metric_DoubleGauge:
+		data.DoubleGauge.DataPoints = append(data.DoubleGauge.DataPoints, intToDoubleDataPoint(dp))
+	default:
+		if o.isCounter(vName) {
+			m.Data = &metricspb.Metric_IntSum{IntSum: &metricspb.IntSum{
+				DataPoints:             []*metricspb.IntDataPoint{dp},
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			}}
+			return
+		}
+		m.Data = &metricspb.Metric_IntGauge{IntGauge: &metricspb.IntGauge{
+			DataPoints: []*metricspb.IntDataPoint{dp},
+		}}
+	}
+}
+
+// addDoubleDataPoint appends a float data point to metric m.
+// an existing integer metric is converted to a double metric.
+func (o *otlpOutput) addDoubleDataPoint(m *metricspb.Metric, vName string, dp *metricspb.DoubleDataPoint) {
+	switch data := m.Data.(type) {
+	case *metricspb.Metric_DoubleSum:
+		data.DoubleSum.DataPoints = append(data.DoubleSum.DataPoints, dp)
+	case *metricspb.Metric_DoubleGauge:
+		data.DoubleGauge.DataPoints = append(data.DoubleGauge.DataPoints, dp)
+	case *metricspb.Metric_IntSum:
+		dps := make([]*metricspb.DoubleDataPoint, 0, len(data.IntSum.DataPoints)+1)
+		for _, idp := range data.IntSum.DataPoints {
+			dps = append(dps, intToDoubleDataPoint(idp))
+		}
+		m.Data = &metricspb.Metric_DoubleSum{DoubleSum: &metricspb.DoubleSum{
+			DataPoints:             append(dps, dp),
+			AggregationTemporality: data.IntSum.AggregationTemporality,
+			IsMonotonic:            data.IntSum.IsMonotonic,
+		}}
+	case *metricspb.Metric_IntGauge:
+		dps := make([]*metricspb.DoubleDataPoint, 0, len(data.IntGauge.DataPoints)+1)
+		for _, idp := range data.IntGauge.DataPoints {
+			dps = append(dps, intToDoubleDataPoint(idp))
+		}
+		m.Data = &metricspb.Metric_DoubleGauge{DoubleGauge: &metricspb.DoubleGauge{
+			DataPoints: append(dps, dp),
+		}}
+	default:
+		if o.isCounter(vName) {
+			m.Data = &metricspb.Metric_DoubleSum{DoubleSum: &metricspb.DoubleSum{
+				DataPoints:             []*metricspb.DoubleDataPoint{dp},
+				AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+				IsMonotonic:            true,
+			}}
+			return
+		}
+		m.Data = &metricspb.Metric_DoubleGauge{DoubleGauge: &metricspb.DoubleGauge{
+			DataPoints: []*metricspb.DoubleDataPoint{dp},
+		}}
+	}
+}
+
+// splitTags splits the event tags into resource attributes and data point labels
+func (o *otlpOutput) splitTags(ev *formatters.EventMsg) ([]*commonpb.KeyValue, []*commonpb.StringKeyValue) {
+	resAttrs := make([]*commonpb.KeyValue, 0, len(o.resTagKeys)+len(o.Cfg.ResourceAttributes))
+	labels := make([]*commonpb.StringKeyValue, 0, len(ev.Tags))
+	for k, v := range o.Cfg.ResourceAttributes {
+		resAttrs = append(resAttrs, stringKeyValue(k, v))
+	}
+	for k, v := range ev.Tags {
+		if _, ok := o.resTagKeys[k]; ok {
+			resAttrs = append(resAttrs, stringKeyValue(k, v))
+			continue
+		}
+		labels = append(labels, &commonpb.StringKeyValue{Key: k, Value: v})
+	}
+	sort.Slice(resAttrs, func(i, j int) bool { return resAttrs[i].Key < resAttrs[j].Key })
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Key < labels[j].Key })
+	return resAttrs, labels
+}
+
+func (o *otlpOutput) metricName(measName, valueName string) string {
+	sb := strings.Builder{}
+	if o.Cfg.MetricPrefix != "" {
+		sb.WriteString(o.metricRegex.ReplaceAllString(o.Cfg.MetricPrefix, "_"))
+		sb.WriteString("_")
+	}
+	if o.Cfg.AppendSubscriptionName {
+		sb.WriteString(strings.TrimRight(o.metricRegex.ReplaceAllString(measName, "_"), "_"))
+		sb.WriteString("_")
+	}
+	sb.WriteString(strings.TrimLeft(o.metricRegex.ReplaceAllString(valueName, "_"), "_"))
+	return sb.String()
+}
+
+func (o *otlpOutput) isCounter(valueName string) bool {
+	for _, re := range o.counterRegs {
+		if re.MatchString(valueName) || re.MatchString(filepath.Base(valueName)) {
+			return true
+		}
+	}
+	return false
+}
+
+func stringKeyValue(k, v string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   k,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}},
+	}
+}
+
+func attributesKey(attrs []*commonpb.KeyValue) string {
+	sb := strings.Builder{}
+	for _, kv := range attrs {
+		sb.WriteString(kv.Key)
+		sb.WriteString("=")
+		sb.WriteString(kv.GetValue().GetStringValue())
+		sb.WriteString(",")
+	}
+	return sb.String()
+}
+
+func intToDoubleDataPoint(dp *metricspb.IntDataPoint) *metricspb.DoubleDataPoint {
+	return &metricspb.DoubleDataPoint{
+		Labels:            dp.Labels,
+		StartTimeUnixNano: dp.StartTimeUnixNano,
+		TimeUnixNano:      dp.TimeUnixNano,
+		Value:             float64(dp.Value),
+	}
+}
+
+func countDataPoints(req *colmetricspb.ExportMetricsServiceRequest) int {
+	count := 0
+	for _, rm := range req.GetResourceMetrics() {
+		for _, ilm := range rm.GetInstrumentationLibraryMetrics() {
+			for _, m := range ilm.GetMetrics() {
+				count += len(m.GetIntGauge().GetDataPoints())
+				count += len(m.GetIntSum().GetDataPoints())
+				count += len(m.GetDoubleGauge().GetDataPoints())
+				count += len(m.GetDoubleSum().GetDataPoints())
+			}
+		}
+	}
+	return count
+}
+
+// getNumber returns the value v as an int64 or a float64.
+// the returned bool is true if the value is an integer.
+func getNumber(v interface{}) (int64, float64, bool, error) {
+	switch i := v.(type) {
+	case float64:
+		return 0, i, false, nil
+	case float32:
+		return 0, float64(i), false, nil
+	case int64:
+		return i, 0, true, nil
+	case int32:
+		return int64(i), 0, true, nil
+	case int16:
+		return int64(i), 0, true, nil
+	case int8:
+		return int64(i), 0, true, nil
+	case int:
+		return int64(i), 0, true, nil
+	case uint64:
+		if i > math.MaxInt64 {
+			return 0, float64(i), false, nil
+		}
+		return int64(i), 0, true, nil
+	case uint32:
+		return int64(i), 0, true, nil
+	case uint16:
+		return int64(i), 0, true, nil
+	case uint8:
+		return int64(i), 0, true, nil
+	case uint:
+		if uint64(i) > math.MaxInt64 {
+			return 0, float64(i), false, nil
+		}
+		return int64(i), 0, true, nil
+	case string:
+		if iv, err := strconv.ParseInt(i, 10, 64); err == nil {
+			return iv, 0, true, nil
+		}
+		f, err := strconv.ParseFloat(i, 64)
+		if err != nil {
+			return 0, math.NaN(), false, err
+		}
+		return 0, f, false, nil
+	case *gnmi.Decimal64:
+		return 0, float64(i.Digits) / math.Pow10(int(i.Precision)), false, nil
+	default:
+		return 0, math.NaN(), false, errors.New("getNumber: unknown value is of incompatible type")
+	}
+}
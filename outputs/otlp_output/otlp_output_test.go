@@ -0,0 +1,270 @@
+package otlp_output
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/formatters"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+var testEvents = []*formatters.EventMsg{
+	{
+		Name:      "sub1",
+		Timestamp: 42,
+		Tags: map[string]string{
+			"source":                   "router1:57400",
+			"interface_name":           "ethernet-1/1",
+			"subscription-name":        "sub1",
+			"interface/subinterface/x": "0",
+		},
+		Values: map[string]interface{}{
+			"/interface/statistics/in-octets": uint64(100),
+			"/interface/oper-state":           "up",
+			"/interface/cpu":                  float64(0.5),
+		},
+	},
+	{
+		Name:      "sub1",
+		Timestamp: 43,
+		Tags: map[string]string{
+			"source":         "router1:57400",
+			"interface_name": "ethernet-1/2",
+		},
+		Values: map[string]interface{}{
+			"/interface/statistics/in-octets": "200",
+		},
+	},
+	{
+		Name:      "sub1",
+		Timestamp: 44,
+		Tags: map[string]string{
+			"source":         "router2:57400",
+			"interface_name": "ethernet-1/1",
+		},
+		Values: map[string]interface{}{
+			"/interface/statistics/in-octets": int64(300),
+		},
+	},
+}
+
+var exportRequestTestSet = map[string]struct {
+	cfg             *Config
+	numResources    int
+	numDataPoints   int
+	expectedMetrics map[string]string
+}{
+	"default": {
+		cfg:           &Config{},
+		numResources:  1,
+		numDataPoints: 4,
+		expectedMetrics: map[string]string{
+			"interface_statistics_in_octets": "int_gauge",
+			"interface_cpu":                  "double_gauge",
+		},
+	},
+	"counters_and_resources": {
+		cfg: &Config{
+			MetricPrefix:           "gnmic",
+			AppendSubscriptionName: true,
+			ResourceTagKeys:        []string{"source"},
+			CounterPatterns:        []string{"in-octets$"},
+		},
+		numResources:  2,
+		numDataPoints: 4,
+		expectedMetrics: map[string]string{
+			"gnmic_sub1_interface_statistics_in_octets": "int_sum",
+			"gnmic_sub1_interface_cpu":                  "double_gauge",
+		},
+	},
+}
+
+func TestExportRequest(t *testing.T) {
+	for name, tc := range exportRequestTestSet {
+		t.Run(name, func(t *testing.T) {
+			o := newTestOutput(t, tc.cfg)
+			req := o.exportRequest(testEvents)
+			if len(req.ResourceMetrics) != tc.numResources {
+				t.Errorf("expected %d resources, got %d", tc.numResources, len(req.ResourceMetrics))
+			}
+			if n := countDataPoints(req); n != tc.numDataPoints {
+				t.Errorf("expected %d data points, got %d", tc.numDataPoints, n)
+			}
+			for _, rm := range req.ResourceMetrics {
+				for _, ilm := range rm.InstrumentationLibraryMetrics {
+					for _, m := range ilm.Metrics {
+						expectedType, ok := tc.expectedMetrics[m.Name]
+						if !ok {
+							t.Errorf("unexpected metric name %q", m.Name)
+							continue
+						}
+						if mt := metricType(m); mt != expectedType {
+							t.Errorf("metric %q: expected type %s, got %s", m.Name, expectedType, mt)
+						}
+						if m.GetIntSum() != nil && !m.GetIntSum().IsMonotonic {
+							t.Errorf("metric %q: expected a monotonic sum", m.Name)
+						}
+					}
+				}
+				if len(tc.cfg.ResourceTagKeys) > 0 {
+					if len(rm.Resource.Attributes) != len(tc.cfg.ResourceTagKeys) {
+						t.Errorf("expected %d resource attributes, got %d", len(tc.cfg.ResourceTagKeys), len(rm.Resource.Attributes))
+					}
+				}
+			}
+		})
+	}
+}
+
+type testMetricsServer struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	reqs chan *colmetricspb.ExportMetricsServiceRequest
+	md   chan metadata.MD
+}
+
+func (s *testMetricsServer) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.md <- md
+	s.reqs <- req
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
+
+func TestGRPCExport(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &testMetricsServer{
+		reqs: make(chan *colmetricspb.ExportMetricsServiceRequest, 1),
+		md:   make(chan metadata.MD, 1),
+	}
+	gs := grpc.NewServer()
+	colmetricspb.RegisterMetricsServiceServer(gs, srv)
+	go gs.Serve(l)
+	defer gs.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := initTestOutput(ctx, t, map[string]interface{}{
+		"endpoint":   l.Addr().String(),
+		"protocol":   "grpc",
+		"batch-size": len(testEvents),
+		"headers":    map[string]string{"x-api-key": "secret"},
+	})
+	for _, ev := range testEvents {
+		o.WriteEvent(ctx, ev)
+	}
+	select {
+	case req := <-srv.reqs:
+		if n := countDataPoints(req); n != 4 {
+			t.Errorf("expected 4 data points, got %d", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the otlp export request")
+	}
+	md := <-srv.md
+	if v := md.Get("x-api-key"); len(v) != 1 || v[0] != "secret" {
+		t.Errorf("expected header x-api-key=secret, got %v", v)
+	}
+}
+
+func TestHTTPExport(t *testing.T) {
+	reqs := make(chan *colmetricspb.ExportMetricsServiceRequest, 1)
+	hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != defaultHTTPPath {
+			t.Errorf("unexpected path %q", r.URL.Path)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-protobuf" {
+			t.Errorf("unexpected content type %q", ct)
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		req := new(colmetricspb.ExportMetricsServiceRequest)
+		err = proto.Unmarshal(b, req)
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reqs <- req
+	}))
+	defer hs.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := initTestOutput(ctx, t, map[string]interface{}{
+		"endpoint":    hs.URL,
+		"protocol":    "http",
+		"flush-timer": "100ms",
+	})
+	for _, ev := range testEvents {
+		o.WriteEvent(ctx, ev)
+	}
+	select {
+	case req := <-reqs:
+		if n := countDataPoints(req); n != 4 {
+			t.Errorf("expected 4 data points, got %d", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the otlp export request")
+	}
+}
+
+func newTestOutput(t *testing.T, cfg *Config) *otlpOutput {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := initTestOutput(ctx, t, map[string]interface{}{
+		"metric-prefix":            cfg.MetricPrefix,
+		"append-subscription-name": cfg.AppendSubscriptionName,
+		"resource-tag-keys":        cfg.ResourceTagKeys,
+		"counter-patterns":         cfg.CounterPatterns,
+	})
+	return o
+}
+
+func initTestOutput(ctx context.Context, t *testing.T, cfg map[string]interface{}) *otlpOutput {
+	o := &otlpOutput{
+		Cfg:         &Config{},
+		wg:          new(sync.WaitGroup),
+		logger:      log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		metricRegex: regexp.MustCompile(metricNameRegex),
+	}
+	if testing.Verbose() {
+		o.SetLogger(log.New(os.Stderr, "", log.LstdFlags))
+	}
+	err := o.Init(ctx, "otlp_test", cfg)
+	if err != nil {
+		t.Fatalf("failed to init otlp output: %v", err)
+	}
+	return o
+}
+
+func metricType(m *metricspb.Metric) string {
+	switch m.Data.(type) {
+	case *metricspb.Metric_IntGauge:
+		return "int_gauge"
+	case *metricspb.Metric_IntSum:
+		return "int_sum"
+	case *metricspb.Metric_DoubleGauge:
+		return "double_gauge"
+	case *metricspb.Metric_DoubleSum:
+		return "double_sum"
+	}
+	return "unknown"
+}
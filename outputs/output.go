@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"strings"
+	"sync"
 	"text/template"
 
 	"github.com/karimra/gnmic/formatters"
@@ -31,6 +32,57 @@ type Output interface {
 	SetTargetsConfig(map[string]*types.TargetConfig)
 }
 
+// SyncWriter is implemented by outputs able to report whether a message
+// was written to their destination.
+// It is required to use an output with a disk queue, see package outputs/queue.
+type SyncWriter interface {
+	WriteSync(context.Context, proto.Message, Meta) error
+}
+
+// EventSyncWriter is implemented by outputs able to report whether an event
+// was written to their destination.
+// It is used by the inputs acknowledging the messages they read once they are written.
+type EventSyncWriter interface {
+	WriteEventSync(context.Context, *formatters.EventMsg) error
+}
+
+// SyncCache keeps the conversion result of the last message written with WriteSync.
+// A message is retried from the disk queue until it is written, caching its conversion
+// makes sure the event processors, which can be stateful, are applied to it only once.
+type SyncCache struct {
+	m   sync.Mutex
+	msg proto.Message
+	v   interface{}
+}
+
+// Get returns the cached conversion result of msg, or calls convert and caches its result.
+func (c *SyncCache) Get(msg proto.Message, convert func() (interface{}, error)) (interface{}, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if msg != nil && c.msg == msg {
+		return c.v, nil
+	}
+	v, err := convert()
+	if err != nil {
+		return nil, err
+	}
+	c.msg, c.v = msg, v
+	return v, nil
+}
+
+// Marshal returns the bytes produced by marshal for msg,
+// they are cached if msg is written with WriteSync, i.e if sync is true.
+func (c *SyncCache) Marshal(msg proto.Message, sync bool, marshal func() ([]byte, error)) ([]byte, error) {
+	if !sync {
+		return marshal()
+	}
+	v, err := c.Get(msg, func() (interface{}, error) { return marshal() })
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
 type Initializer func() Output
 
 var Outputs = map[string]Initializer{}
@@ -41,10 +93,17 @@ var OutputTypes = []string{
 	"kafka",
 	"nats",
 	"prometheus",
+	"prometheus_write",
 	"stan",
 	"tcp",
 	"udp",
 	"gnmi",
+	"otlp",
+	"elasticsearch",
+	"mqtt",
+	"redis",
+	"jetstream",
+	"amqp",
 }
 
 func Register(name string, initFn Initializer) {
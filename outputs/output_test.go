@@ -0,0 +1,42 @@
+package outputs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+func TestSyncCache(t *testing.T) {
+	c := new(SyncCache)
+	calls := 0
+	marshal := func() ([]byte, error) {
+		calls++
+		return []byte("msg"), nil
+	}
+	m1, m2 := new(gnmi.SubscribeResponse), new(gnmi.SubscribeResponse)
+	// a message retried from the queue is converted once
+	for i := 0; i < 3; i++ {
+		b, err := c.Marshal(m1, true, marshal)
+		if err != nil || string(b) != "msg" {
+			t.Fatalf("unexpected result %q, %v", b, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected 1 conversion, got %d", calls)
+	}
+	c.Marshal(m2, true, marshal)
+	c.Marshal(m2, false, marshal)
+	if calls != 3 {
+		t.Fatalf("expected 3 conversions, got %d", calls)
+	}
+	// errors are not cached
+	_, err := c.Marshal(m1, true, func() ([]byte, error) { return nil, errors.New("marshal error") })
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	c.Marshal(m1, true, marshal)
+	if calls != 4 {
+		t.Fatalf("expected 4 conversions, got %d", calls)
+	}
+}
@@ -0,0 +1,120 @@
+package prometheus_output
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/karimra/gnmic/formatters"
+	"github.com/openconfig/gnmi/proto/gnmi"
+)
+
+var metricRegex = regexp.MustCompile(metricNameRegex)
+
+// LabelPair is a prometheus label name and value
+type LabelPair struct {
+	Name  string
+	Value string
+}
+
+// MetricBuilder generates prometheus metric names and labels from events.
+// It is shared between the prometheus outputs so that the same event results in identical series.
+type MetricBuilder struct {
+	Prefix                 string
+	AppendSubscriptionName bool
+	StringsAsLabels        bool
+}
+
+// MetricName generates the prometheus metric name based on the configured prefix,
+// the measurement name and the value name.
+// it makes sure the name matches the regex "[^a-zA-Z0-9_]+"
+func (m *MetricBuilder) MetricName(measName, valueName string) string {
+	sb := strings.Builder{}
+	if m.Prefix != "" {
+		sb.WriteString(metricRegex.ReplaceAllString(m.Prefix, "_"))
+		sb.WriteString("_")
+	}
+	if m.AppendSubscriptionName {
+		sb.WriteString(strings.TrimRight(metricRegex.ReplaceAllString(measName, "_"), "_"))
+		sb.WriteString("_")
+	}
+	sb.WriteString(strings.TrimLeft(metricRegex.ReplaceAllString(valueName, "_"), "_"))
+	return sb.String()
+}
+
+// GetLabels builds the prometheus labels from the event tags,
+// and from the event string values if StringsAsLabels is true.
+func (m *MetricBuilder) GetLabels(ev *formatters.EventMsg) []*LabelPair {
+	labels := make([]*LabelPair, 0, len(ev.Tags))
+	addedLabels := make(map[string]struct{})
+	for k, v := range ev.Tags {
+		labelName := metricRegex.ReplaceAllString(filepath.Base(k), "_")
+		if _, ok := addedLabels[labelName]; ok {
+			continue
+		}
+		labels = append(labels, &LabelPair{Name: labelName, Value: v})
+		addedLabels[labelName] = struct{}{}
+	}
+	if !m.StringsAsLabels {
+		return labels
+	}
+
+	var err error
+	for k, v := range ev.Values {
+		_, err = GetFloat(v)
+		if err == nil {
+			continue
+		}
+		if vs, ok := v.(string); ok {
+			labelName := metricRegex.ReplaceAllString(filepath.Base(k), "_")
+			if _, ok := addedLabels[labelName]; ok {
+				continue
+			}
+			labels = append(labels, &LabelPair{Name: labelName, Value: vs})
+		}
+	}
+	return labels
+}
+
+// GetFloat converts an event value to a float64
+func GetFloat(v interface{}) (float64, error) {
+	switch i := v.(type) {
+	case float64:
+		return float64(i), nil
+	case float32:
+		return float64(i), nil
+	case int64:
+		return float64(i), nil
+	case int32:
+		return float64(i), nil
+	case int16:
+		return float64(i), nil
+	case int8:
+		return float64(i), nil
+	case uint64:
+		return float64(i), nil
+	case uint32:
+		return float64(i), nil
+	case uint16:
+		return float64(i), nil
+	case uint8:
+		return float64(i), nil
+	case int:
+		return float64(i), nil
+	case uint:
+		return float64(i), nil
+	case string:
+		f, err := strconv.ParseFloat(i, 64)
+		if err != nil {
+			return math.NaN(), err
+		}
+		return f, err
+	case *gnmi.Decimal64:
+		return float64(i.Digits) / math.Pow10(int(i.Precision)), nil
+	default:
+		return math.NaN(), errors.New("getFloat: unknown value is of incompatible type")
+	}
+}
@@ -3,16 +3,12 @@ package prometheus_output
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"hash/fnv"
 	"io/ioutil"
 	"log"
-	"math"
 	"net"
 	"net/http"
-	"path/filepath"
-	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -45,13 +41,9 @@ const (
 	defaultTimeout = 10 * time.Second
 )
 
-type labelPair struct {
-	Name  string
-	Value string
-}
 type promMetric struct {
 	name   string
-	labels []*labelPair
+	labels []*LabelPair
 	time   *time.Time
 	value  float64
 	// addedAt is used to expire metrics if the time field is not initialized
@@ -62,13 +54,12 @@ type promMetric struct {
 func init() {
 	outputs.Register("prometheus", func() outputs.Output {
 		return &prometheusOutput{
-			Cfg:         &config{},
-			eventChan:   make(chan *formatters.EventMsg),
-			wg:          new(sync.WaitGroup),
-			entries:     make(map[uint64]*promMetric),
-			metricRegex: regexp.MustCompile(metricNameRegex),
-			logger:      log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
-			caches:      make(map[string]*cache.Cache),
+			Cfg:       &config{},
+			eventChan: make(chan *formatters.EventMsg),
+			wg:        new(sync.WaitGroup),
+			entries:   make(map[uint64]*promMetric),
+			logger:    log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+			caches:    make(map[string]*cache.Cache),
 		}
 	})
 }
@@ -83,7 +74,6 @@ type prometheusOutput struct {
 	sync.Mutex
 	entries map[uint64]*promMetric
 
-	metricRegex  *regexp.Regexp
 	evps         []formatters.EventProcessor
 	consulClient *api.Client
 
@@ -140,7 +130,7 @@ func (p *prometheusOutput) SetEventProcessors(ps map[string]map[string]interface
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithEmitting())
 				if err != nil {
 					p.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
 					continue
@@ -218,6 +208,7 @@ func (p *prometheusOutput) Init(ctx context.Context, name string, cfg map[string
 		wcancel()
 	}()
 	go p.registerService(wctx)
+	formatters.StartEmitters(wctx, p.evps, func(ev *formatters.EventMsg) { p.WriteEvent(wctx, ev) })
 	p.logger.Printf("initialized prometheus output: %s", p.String())
 	go func() {
 		<-ctx.Done()
@@ -269,6 +260,7 @@ func (p *prometheusOutput) WriteEvent(ctx context.Context, ev *formatters.EventM
 }
 
 func (p *prometheusOutput) Close() error {
+	formatters.CloseProcessors(p.evps)
 	var err error
 	if p.consulClient != nil {
 		err = p.consulClient.Agent().ServiceDeregister(p.Cfg.ServiceRegistration.Name)
@@ -317,36 +309,8 @@ func (p *prometheusOutput) Collect(ch chan<- prometheus.Metric) {
 	}
 }
 
-func (p *prometheusOutput) getLabels(ev *formatters.EventMsg) []*labelPair {
-	labels := make([]*labelPair, 0, len(ev.Tags))
-	addedLabels := make(map[string]struct{})
-	for k, v := range ev.Tags {
-		labelName := p.metricRegex.ReplaceAllString(filepath.Base(k), "_")
-		if _, ok := addedLabels[labelName]; ok {
-			continue
-		}
-		labels = append(labels, &labelPair{Name: labelName, Value: v})
-		addedLabels[labelName] = struct{}{}
-	}
-	if !p.Cfg.StringsAsLabels {
-		return labels
-	}
-
-	var err error
-	for k, v := range ev.Values {
-		_, err = getFloat(v)
-		if err == nil {
-			continue
-		}
-		if vs, ok := v.(string); ok {
-			labelName := p.metricRegex.ReplaceAllString(filepath.Base(k), "_")
-			if _, ok := addedLabels[labelName]; ok {
-				continue
-			}
-			labels = append(labels, &labelPair{Name: labelName, Value: vs})
-		}
-	}
-	return labels
+func (p *prometheusOutput) getLabels(ev *formatters.EventMsg) []*LabelPair {
+	return p.metricBuilder().GetLabels(ev)
 }
 
 func (p *prometheusOutput) worker(ctx context.Context) {
@@ -526,60 +490,19 @@ func (p *promMetric) Write(out *dto.Metric) error {
 	return nil
 }
 
-func getFloat(v interface{}) (float64, error) {
-	switch i := v.(type) {
-	case float64:
-		return float64(i), nil
-	case float32:
-		return float64(i), nil
-	case int64:
-		return float64(i), nil
-	case int32:
-		return float64(i), nil
-	case int16:
-		return float64(i), nil
-	case int8:
-		return float64(i), nil
-	case uint64:
-		return float64(i), nil
-	case uint32:
-		return float64(i), nil
-	case uint16:
-		return float64(i), nil
-	case uint8:
-		return float64(i), nil
-	case int:
-		return float64(i), nil
-	case uint:
-		return float64(i), nil
-	case string:
-		f, err := strconv.ParseFloat(i, 64)
-		if err != nil {
-			return math.NaN(), err
-		}
-		return f, err
-	case *gnmi.Decimal64:
-		return float64(i.Digits) / math.Pow10(int(i.Precision)), nil
-	default:
-		return math.NaN(), errors.New("getFloat: unknown value is of incompatible type")
-	}
-}
-
 // metricName generates the prometheus metric name based on the output plugin,
 // the measurement name and the value name.
 // it makes sure the name matches the regex "[^a-zA-Z0-9_]+"
 func (p *prometheusOutput) metricName(measName, valueName string) string {
-	sb := strings.Builder{}
-	if p.Cfg.MetricPrefix != "" {
-		sb.WriteString(p.metricRegex.ReplaceAllString(p.Cfg.MetricPrefix, "_"))
-		sb.WriteString("_")
-	}
-	if p.Cfg.AppendSubscriptionName {
-		sb.WriteString(strings.TrimRight(p.metricRegex.ReplaceAllString(measName, "_"), "_"))
-		sb.WriteString("_")
+	return p.metricBuilder().MetricName(measName, valueName)
+}
+
+func (p *prometheusOutput) metricBuilder() *MetricBuilder {
+	return &MetricBuilder{
+		Prefix:                 p.Cfg.MetricPrefix,
+		AppendSubscriptionName: p.Cfg.AppendSubscriptionName,
+		StringsAsLabels:        p.Cfg.StringsAsLabels,
 	}
-	sb.WriteString(strings.TrimLeft(p.metricRegex.ReplaceAllString(valueName, "_"), "_"))
-	return sb.String()
 }
 
 func (p *prometheusOutput) SetName(name string) {
@@ -618,7 +541,7 @@ func (p *prometheusOutput) metricsFromEvent(ev *formatters.EventMsg, now time.Ti
 	pms := make([]*promMetric, 0, len(ev.Values))
 	labels := p.getLabels(ev)
 	for vName, val := range ev.Values {
-		v, err := getFloat(val)
+		v, err := GetFloat(val)
 		if err != nil {
 			if !p.Cfg.StringsAsLabels {
 				continue
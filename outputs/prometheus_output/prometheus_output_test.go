@@ -1,7 +1,6 @@
 package prometheus_output
 
 import (
-	"regexp"
 	"testing"
 )
 
@@ -13,8 +12,7 @@ var metricNameSet = map[string]struct {
 }{
 	"with_prefix_with_subscription_with_value_no-append-subsc": {
 		p: &prometheusOutput{
-			Cfg:         &config{MetricPrefix: "gnmic"},
-			metricRegex: regexp.MustCompile(metricNameRegex),
+			Cfg: &config{MetricPrefix: "gnmic"},
 		},
 		measName:  "sub",
 		valueName: "value",
@@ -25,7 +23,6 @@ var metricNameSet = map[string]struct {
 			Cfg: &config{MetricPrefix: "gnmic",
 				AppendSubscriptionName: true,
 			},
-			metricRegex: regexp.MustCompile(metricNameRegex),
 		},
 		measName:  "sub",
 		valueName: "value",
@@ -36,7 +33,6 @@ var metricNameSet = map[string]struct {
 			Cfg: &config{MetricPrefix: "gnmic-prefix",
 				AppendSubscriptionName: true,
 			},
-			metricRegex: regexp.MustCompile(metricNameRegex),
 		},
 		measName:  "sub",
 		valueName: "value",
@@ -44,8 +40,7 @@ var metricNameSet = map[string]struct {
 	},
 	"without_prefix_with_subscription_with_value_no-append-subsc": {
 		p: &prometheusOutput{
-			Cfg:         &config{},
-			metricRegex: regexp.MustCompile(metricNameRegex),
+			Cfg: &config{},
 		},
 		measName:  "sub",
 		valueName: "value",
@@ -53,8 +48,7 @@ var metricNameSet = map[string]struct {
 	},
 	"without_prefix_with_subscription_with_value_with_append-subsc": {
 		p: &prometheusOutput{
-			Cfg:         &config{AppendSubscriptionName: true},
-			metricRegex: regexp.MustCompile(metricNameRegex),
+			Cfg: &config{AppendSubscriptionName: true},
 		},
 		measName:  "sub",
 		valueName: "value",
@@ -62,8 +56,7 @@ var metricNameSet = map[string]struct {
 	},
 	"without_prefix_with_subscription-bad-chars_with_value-bad-chars_with_append-subsc": {
 		p: &prometheusOutput{
-			Cfg:         &config{AppendSubscriptionName: true},
-			metricRegex: regexp.MustCompile(metricNameRegex),
+			Cfg: &config{AppendSubscriptionName: true},
 		},
 		measName:  "sub-name",
 		valueName: "value-name2",
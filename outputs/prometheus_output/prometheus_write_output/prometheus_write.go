@@ -0,0 +1,475 @@
+package prometheus_write_output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	promcom "github.com/karimra/gnmic/outputs/prometheus_output"
+	"github.com/karimra/gnmic/types"
+	"github.com/karimra/gnmic/utils"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	outputType                   = "prometheus_write"
+	loggingPrefix                = "[prometheus_write_output] "
+	defaultURL                   = "http://localhost:9090/api/v1/write"
+	defaultTimeout               = 10 * time.Second
+	defaultWriteInterval         = 10 * time.Second
+	defaultBufferSize            = 1000
+	defaultMaxTimeSeriesPerWrite = 500
+	defaultMaxInFlight           = 1
+	defaultMaxRetries            = 3
+	defaultRetryBackoff          = 100 * time.Millisecond
+	userAgent                    = "gNMIc prometheus write"
+	remoteWriteVersion           = "0.1.0"
+)
+
+func init() {
+	outputs.Register(outputType, func() outputs.Output {
+		return &promWriteOutput{
+			Cfg:    &config{},
+			wg:     new(sync.WaitGroup),
+			logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		}
+	})
+}
+
+type promWriteOutput struct {
+	Cfg    *config
+	logger *log.Logger
+
+	cancelFn     context.CancelFunc
+	wg           *sync.WaitGroup
+	timeSeriesCh chan *timeSeries
+	batchCh      chan []*timeSeries
+	httpClient   *http.Client
+	mb           *promcom.MetricBuilder
+	evps         []formatters.EventProcessor
+
+	targetTpl *template.Template
+}
+
+type config struct {
+	Name                   string            `mapstructure:"name,omitempty" json:"name,omitempty"`
+	URL                    string            `mapstructure:"url,omitempty" json:"url,omitempty"`
+	Timeout                time.Duration     `mapstructure:"timeout,omitempty" json:"timeout,omitempty"`
+	Headers                map[string]string `mapstructure:"headers,omitempty" json:"headers,omitempty"`
+	Authentication         *auth             `mapstructure:"authentication,omitempty" json:"authentication,omitempty"`
+	Authorization          *authorization    `mapstructure:"authorization,omitempty" json:"authorization,omitempty"`
+	TLS                    *tlsConfig        `mapstructure:"tls,omitempty" json:"tls,omitempty"`
+	Interval               time.Duration     `mapstructure:"interval,omitempty" json:"interval,omitempty"`
+	BufferSize             int               `mapstructure:"buffer-size,omitempty" json:"buffer-size,omitempty"`
+	MaxTimeSeriesPerWrite  int               `mapstructure:"max-time-series-per-write,omitempty" json:"max-time-series-per-write,omitempty"`
+	MaxInFlight            int               `mapstructure:"max-in-flight,omitempty" json:"max-in-flight,omitempty"`
+	MaxRetries             int               `mapstructure:"max-retries,omitempty" json:"max-retries,omitempty"`
+	RetryBackoff           time.Duration     `mapstructure:"retry-backoff,omitempty" json:"retry-backoff,omitempty"`
+	MetricPrefix           string            `mapstructure:"metric-prefix,omitempty" json:"metric-prefix,omitempty"`
+	AppendSubscriptionName bool              `mapstructure:"append-subscription-name,omitempty" json:"append-subscription-name,omitempty"`
+	StringsAsLabels        bool              `mapstructure:"strings-as-labels,omitempty" json:"strings-as-labels,omitempty"`
+	OverrideTimestamps     bool              `mapstructure:"override-timestamps,omitempty" json:"override-timestamps,omitempty"`
+	AddTarget              string            `mapstructure:"add-target,omitempty" json:"add-target,omitempty"`
+	TargetTemplate         string            `mapstructure:"target-template,omitempty" json:"target-template,omitempty"`
+	EventProcessors        []string          `mapstructure:"event-processors,omitempty" json:"event-processors,omitempty"`
+	EnableMetrics          bool              `mapstructure:"enable-metrics,omitempty" json:"enable-metrics,omitempty"`
+	Debug                  bool              `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+}
+
+type auth struct {
+	Username string `mapstructure:"username,omitempty" json:"username,omitempty"`
+	Password string `mapstructure:"password,omitempty" json:"password,omitempty"`
+}
+
+type authorization struct {
+	Type        string `mapstructure:"type,omitempty" json:"type,omitempty"`
+	Credentials string `mapstructure:"credentials,omitempty" json:"credentials,omitempty"`
+}
+
+type tlsConfig struct {
+	CaFile     string `mapstructure:"ca-file,omitempty" json:"ca-file,omitempty"`
+	CertFile   string `mapstructure:"cert-file,omitempty" json:"cert-file,omitempty"`
+	KeyFile    string `mapstructure:"key-file,omitempty" json:"key-file,omitempty"`
+	SkipVerify bool   `mapstructure:"skip-verify,omitempty" json:"skip-verify,omitempty"`
+}
+
+func (p *promWriteOutput) String() string {
+	b, err := json.Marshal(p.Cfg)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (p *promWriteOutput) SetLogger(logger *log.Logger) {
+	if logger != nil && p.logger != nil {
+		p.logger.SetOutput(logger.Writer())
+		p.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (p *promWriteOutput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]*types.TargetConfig) {
+	for _, epName := range p.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs), formatters.WithEmitting())
+				if err != nil {
+					p.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
+					continue
+				}
+				p.evps = append(p.evps, ep)
+				p.logger.Printf("added event processor '%s' of type=%s to prometheus_write output", epName, epType)
+				continue
+			}
+			p.logger.Printf("%q event processor has an unknown type=%q", epName, epType)
+			continue
+		}
+		p.logger.Printf("%q event processor not found!", epName)
+	}
+}
+
+func (p *promWriteOutput) Init(ctx context.Context, name string, cfg map[string]interface{}, opts ...outputs.Option) error {
+	err := outputs.DecodeConfig(cfg, p.Cfg)
+	if err != nil {
+		return err
+	}
+	if p.Cfg.Name == "" {
+		p.Cfg.Name = name
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.Cfg.TargetTemplate == "" {
+		p.targetTpl = outputs.DefaultTargetTemplate
+	} else if p.Cfg.AddTarget != "" {
+		p.targetTpl, err = template.New("target-template").
+			Funcs(outputs.TemplateFuncs).
+			Parse(p.Cfg.TargetTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	p.setDefaults()
+	p.mb = &promcom.MetricBuilder{
+		Prefix:                 p.Cfg.MetricPrefix,
+		AppendSubscriptionName: p.Cfg.AppendSubscriptionName,
+		StringsAsLabels:        p.Cfg.StringsAsLabels,
+	}
+	err = p.createHTTPClient()
+	if err != nil {
+		return err
+	}
+	p.timeSeriesCh = make(chan *timeSeries, p.Cfg.BufferSize)
+	p.batchCh = make(chan []*timeSeries)
+
+	ctx, p.cancelFn = context.WithCancel(ctx)
+	p.wg.Add(p.Cfg.MaxInFlight + 1)
+	go p.batcher(ctx)
+	for i := 0; i < p.Cfg.MaxInFlight; i++ {
+		go p.writer(ctx, i)
+	}
+	formatters.StartEmitters(ctx, p.evps, func(ev *formatters.EventMsg) { p.WriteEvent(ctx, ev) })
+	p.logger.Printf("initialized prometheus write output: %s", p.String())
+	go func() {
+		<-ctx.Done()
+		p.Close()
+	}()
+	return nil
+}
+
+func (p *promWriteOutput) setDefaults() {
+	if p.Cfg.URL == "" {
+		p.Cfg.URL = defaultURL
+	}
+	if p.Cfg.Timeout <= 0 {
+		p.Cfg.Timeout = defaultTimeout
+	}
+	if p.Cfg.Interval <= 0 {
+		p.Cfg.Interval = defaultWriteInterval
+	}
+	if p.Cfg.BufferSize <= 0 {
+		p.Cfg.BufferSize = defaultBufferSize
+	}
+	if p.Cfg.MaxTimeSeriesPerWrite <= 0 {
+		p.Cfg.MaxTimeSeriesPerWrite = defaultMaxTimeSeriesPerWrite
+	}
+	if p.Cfg.MaxInFlight <= 0 {
+		p.Cfg.MaxInFlight = defaultMaxInFlight
+	}
+	if p.Cfg.MaxRetries < 0 {
+		p.Cfg.MaxRetries = 0
+	} else if p.Cfg.MaxRetries == 0 {
+		p.Cfg.MaxRetries = defaultMaxRetries
+	}
+	if p.Cfg.RetryBackoff <= 0 {
+		p.Cfg.RetryBackoff = defaultRetryBackoff
+	}
+	if p.Cfg.Authorization != nil && p.Cfg.Authorization.Type == "" {
+		p.Cfg.Authorization.Type = "Bearer"
+	}
+}
+
+func (p *promWriteOutput) createHTTPClient() error {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if p.Cfg.TLS != nil {
+		tlsCfg, err := utils.NewTLSConfig(p.Cfg.TLS.CaFile, p.Cfg.TLS.CertFile, p.Cfg.TLS.KeyFile, p.Cfg.TLS.SkipVerify)
+		if err != nil {
+			return err
+		}
+		tr.TLSClientConfig = tlsCfg
+	}
+	p.httpClient = &http.Client{
+		Timeout:   p.Cfg.Timeout,
+		Transport: tr,
+	}
+	return nil
+}
+
+// Write implements the outputs.Output interface
+func (p *promWriteOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta) {
+	if rsp == nil {
+		return
+	}
+	switch rsp := rsp.(type) {
+	case *gnmi.SubscribeResponse:
+		measName := "default"
+		if subName, ok := meta["subscription-name"]; ok {
+			measName = subName
+		}
+		err := outputs.AddSubscriptionTarget(rsp, meta, p.Cfg.AddTarget, p.targetTpl)
+		if err != nil {
+			p.logger.Printf("failed to add target to the response: %v", err)
+		}
+		events, err := formatters.ResponseToEventMsgs(measName, rsp, meta, p.evps...)
+		if err != nil {
+			p.logger.Printf("failed to convert message to event: %v", err)
+			return
+		}
+		for _, ev := range events {
+			p.WriteEvent(ctx, ev)
+		}
+	}
+}
+
+func (p *promWriteOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {
+	if ev == nil {
+		return
+	}
+	for _, ts := range p.timeSeriesFromEvent(ev) {
+		select {
+		case <-ctx.Done():
+			return
+		case p.timeSeriesCh <- ts:
+		}
+	}
+}
+
+func (p *promWriteOutput) Close() error {
+	formatters.CloseProcessors(p.evps)
+	if p.cancelFn != nil {
+		p.cancelFn()
+	}
+	p.wg.Wait()
+	return nil
+}
+
+func (p *promWriteOutput) RegisterMetrics(reg *prometheus.Registry) {
+	if !p.Cfg.EnableMetrics {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		p.logger.Printf("failed to register metric: %v", err)
+	}
+}
+
+func (p *promWriteOutput) SetName(name string) {
+	sb := strings.Builder{}
+	if name != "" {
+		sb.WriteString(name)
+		sb.WriteString("-")
+	}
+	sb.WriteString(p.Cfg.Name)
+	sb.WriteString("-prom-write")
+	p.Cfg.Name = sb.String()
+}
+
+func (p *promWriteOutput) SetClusterName(name string) {}
+
+func (p *promWriteOutput) SetTargetsConfig(map[string]*types.TargetConfig) {}
+
+// timeSeriesFromEvent builds a time series per event value,
+// using the same metric name and labels as the prometheus output.
+func (p *promWriteOutput) timeSeriesFromEvent(ev *formatters.EventMsg) []*timeSeries {
+	tss := make([]*timeSeries, 0, len(ev.Values))
+	pLabels := p.mb.GetLabels(ev)
+	tsMs := ev.Timestamp / int64(time.Millisecond)
+	if p.Cfg.OverrideTimestamps {
+		tsMs = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	for vName, val := range ev.Values {
+		v, err := promcom.GetFloat(val)
+		if err != nil {
+			if !p.Cfg.StringsAsLabels {
+				continue
+			}
+			v = 1.0
+		}
+		labels := make([]*label, 0, len(pLabels)+1)
+		labels = append(labels, &label{Name: "__name__", Value: p.mb.MetricName(ev.Name, vName)})
+		for _, lp := range pLabels {
+			labels = append(labels, &label{Name: lp.Name, Value: lp.Value})
+		}
+		// remote write requires the labels to be sorted by name
+		sort.Slice(labels, func(i, j int) bool {
+			return labels[i].Name < labels[j].Name
+		})
+		tss = append(tss, &timeSeries{
+			Labels:  labels,
+			Samples: []*sample{{Value: v, Timestamp: tsMs}},
+		})
+	}
+	return tss
+}
+
+// batcher accumulates time series and hands them to the writers
+// when max-time-series-per-write is reached or when the write interval expires.
+func (p *promWriteOutput) batcher(ctx context.Context) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.Cfg.Interval)
+	defer ticker.Stop()
+	batch := make([]*timeSeries, 0, p.Cfg.MaxTimeSeriesPerWrite)
+	send := func() {
+		select {
+		case <-ctx.Done():
+		case p.batchCh <- batch:
+		}
+		batch = make([]*timeSeries, 0, p.Cfg.MaxTimeSeriesPerWrite)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ts := <-p.timeSeriesCh:
+			batch = append(batch, ts)
+			if len(batch) >= p.Cfg.MaxTimeSeriesPerWrite {
+				send()
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+			send()
+		}
+	}
+}
+
+func (p *promWriteOutput) writer(ctx context.Context, idx int) {
+	defer p.wg.Done()
+	workerLogPrefix := fmt.Sprintf("writer-%d", idx)
+	p.logger.Printf("%s starting", workerLogPrefix)
+	for {
+		select {
+		case <-ctx.Done():
+			p.logger.Printf("%s shutting down", workerLogPrefix)
+			return
+		case batch := <-p.batchCh:
+			p.write(ctx, workerLogPrefix, batch)
+		}
+	}
+}
+
+func (p *promWriteOutput) write(ctx context.Context, workerLogPrefix string, batch []*timeSeries) {
+	req := &writeRequest{TimeSeries: batch}
+	body := snappy.Encode(nil, req.Marshal())
+	backoff := p.Cfg.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		var start time.Time
+		if p.Cfg.EnableMetrics {
+			start = time.Now()
+		}
+		retry, err := p.send(ctx, body)
+		if err == nil {
+			if p.Cfg.Debug {
+				p.logger.Printf("%s wrote %d time series", workerLogPrefix, len(batch))
+			}
+			if p.Cfg.EnableMetrics {
+				PromWriteSendDuration.WithLabelValues(p.Cfg.Name).Set(float64(time.Since(start).Nanoseconds()))
+				PromWriteNumberOfSentMsgs.WithLabelValues(p.Cfg.Name).Inc()
+				PromWriteNumberOfSentTimeSeries.WithLabelValues(p.Cfg.Name).Add(float64(len(batch)))
+			}
+			return
+		}
+		if !retry || attempt >= p.Cfg.MaxRetries {
+			p.logger.Printf("%s failed to write %d time series: %v", workerLogPrefix, len(batch), err)
+			if p.Cfg.EnableMetrics {
+				PromWriteNumberOfFailSendMsgs.WithLabelValues(p.Cfg.Name, "send_error").Inc()
+			}
+			return
+		}
+		if p.Cfg.Debug {
+			p.logger.Printf("%s write attempt %d failed, retrying in %s: %v", workerLogPrefix, attempt+1, backoff, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// send sends a single remote write request,
+// it returns true if the request failed and can be retried.
+func (p *promWriteOutput) send(ctx context.Context, body []byte) (bool, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.Cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", userAgent)
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", remoteWriteVersion)
+	for k, v := range p.Cfg.Headers {
+		httpReq.Header.Set(k, v)
+	}
+	if p.Cfg.Authentication != nil {
+		httpReq.SetBasicAuth(p.Cfg.Authentication.Username, p.Cfg.Authentication.Password)
+	}
+	if p.Cfg.Authorization != nil {
+		httpReq.Header.Set("Authorization", p.Cfg.Authorization.Type+" "+p.Cfg.Authorization.Credentials)
+	}
+	rsp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		// network errors are retried
+		return ctx.Err() == nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode >= 200 && rsp.StatusCode < 300 {
+		ioutil.ReadAll(rsp.Body)
+		return false, nil
+	}
+	msg, _ := ioutil.ReadAll(rsp.Body)
+	err = fmt.Errorf("server returned %s: %s", rsp.Status, strings.TrimSpace(string(msg)))
+	// only server side errors and throttling are retried
+	return rsp.StatusCode >= 500 || rsp.StatusCode == http.StatusTooManyRequests, err
+}
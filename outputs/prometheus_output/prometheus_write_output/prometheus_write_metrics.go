@@ -0,0 +1,56 @@
+package prometheus_write_output
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var PromWriteNumberOfSentMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "prometheus_write_output",
+	Name:      "number_of_prom_write_msgs_sent_success_total",
+	Help:      "Number of remote write requests successfully sent by gnmic prometheus_write output",
+}, []string{"name"})
+
+var PromWriteNumberOfSentTimeSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "prometheus_write_output",
+	Name:      "number_of_prom_write_time_series_sent_success_total",
+	Help:      "Number of time series successfully sent by gnmic prometheus_write output",
+}, []string{"name"})
+
+var PromWriteNumberOfFailSendMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "prometheus_write_output",
+	Name:      "number_of_prom_write_msgs_sent_fail_total",
+	Help:      "Number of failed remote write requests sent by gnmic prometheus_write output",
+}, []string{"name", "reason"})
+
+var PromWriteSendDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "prometheus_write_output",
+	Name:      "msg_send_duration_ns",
+	Help:      "gnmic prometheus_write output send duration in ns",
+}, []string{"name"})
+
+func initMetrics() {
+	PromWriteNumberOfSentMsgs.WithLabelValues("").Add(0)
+	PromWriteNumberOfSentTimeSeries.WithLabelValues("").Add(0)
+	PromWriteNumberOfFailSendMsgs.WithLabelValues("", "").Add(0)
+	PromWriteSendDuration.WithLabelValues("").Set(0)
+}
+
+func registerMetrics(reg *prometheus.Registry) error {
+	initMetrics()
+	var err error
+	if err = reg.Register(PromWriteNumberOfSentMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(PromWriteNumberOfSentTimeSeries); err != nil {
+		return err
+	}
+	if err = reg.Register(PromWriteNumberOfFailSendMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(PromWriteSendDuration); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package prometheus_write_output
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/karimra/gnmic/formatters"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var testEvent = &formatters.EventMsg{
+	Name:      "sub1",
+	Timestamp: 1622505600000000000,
+	Tags: map[string]string{
+		"source":                      "router1:57400",
+		"interface_name":              "ethernet-1/1",
+		"subscription-name":           "sub1",
+		"interface/subinterface/name": "0",
+	},
+	Values: map[string]interface{}{
+		"/interface/statistics/in-octets": uint64(100),
+		"/interface/oper-state":           "up",
+	},
+}
+
+var writeTestSet = map[string]struct {
+	cfg              map[string]interface{}
+	statusCodes      []int
+	expectedRequests int32
+	expectedWritten  int
+	expectedLabels   map[string]string
+}{
+	"simple": {
+		cfg: map[string]interface{}{
+			"metric-prefix":            "gnmic",
+			"append-subscription-name": true,
+		},
+		statusCodes:      []int{http.StatusNoContent},
+		expectedRequests: 1,
+		expectedWritten:  1,
+		expectedLabels: map[string]string{
+			"__name__":          "gnmic_sub1_interface_statistics_in_octets",
+			"source":            "router1:57400",
+			"name":              "0",
+			"subscription_name": "sub1",
+		},
+	},
+	"retry_on_5xx": {
+		cfg: map[string]interface{}{
+			"max-retries":   2,
+			"retry-backoff": "10ms",
+		},
+		statusCodes:      []int{http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusOK},
+		expectedRequests: 3,
+		expectedWritten:  1,
+	},
+	"no_retry_on_4xx": {
+		cfg: map[string]interface{}{
+			"max-retries":   2,
+			"retry-backoff": "10ms",
+		},
+		statusCodes:      []int{http.StatusBadRequest, http.StatusOK},
+		expectedRequests: 1,
+		expectedWritten:  0,
+	},
+	"strings_as_labels": {
+		cfg: map[string]interface{}{
+			"strings-as-labels": true,
+		},
+		statusCodes:      []int{http.StatusOK},
+		expectedRequests: 1,
+		expectedWritten:  2,
+	},
+}
+
+func TestPromWrite(t *testing.T) {
+	for name, tc := range writeTestSet {
+		t.Run(name, func(t *testing.T) {
+			var numReqs int32
+			written := make(chan [][]label, len(tc.statusCodes))
+			hs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				idx := atomic.AddInt32(&numReqs, 1) - 1
+				if r.Header.Get("Content-Encoding") != "snappy" {
+					t.Errorf("unexpected content encoding %q", r.Header.Get("Content-Encoding"))
+				}
+				if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+					t.Errorf("unexpected basic auth: %s:%s", u, p)
+				}
+				if r.Header.Get("X-Custom") != "value" {
+					t.Errorf("missing custom header")
+				}
+				code := tc.statusCodes[idx]
+				w.WriteHeader(code)
+				if code >= 300 {
+					return
+				}
+				b, _ := ioutil.ReadAll(r.Body)
+				b, err := snappy.Decode(nil, b)
+				if err != nil {
+					t.Errorf("failed to decode snappy body: %v", err)
+					return
+				}
+				series, err := decodeWriteRequest(b)
+				if err != nil {
+					t.Errorf("failed to decode write request: %v", err)
+					return
+				}
+				written <- series
+			}))
+			defer hs.Close()
+
+			cfg := map[string]interface{}{
+				"url":                       hs.URL,
+				"interval":                  "50ms",
+				"max-time-series-per-write": 10,
+				"headers":                   map[string]string{"X-Custom": "value"},
+				"authentication":            map[string]interface{}{"username": "user", "password": "pass"},
+			}
+			for k, v := range tc.cfg {
+				cfg[k] = v
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			p := &promWriteOutput{
+				Cfg:    &config{},
+				wg:     new(sync.WaitGroup),
+				logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags),
+			}
+			err := p.Init(ctx, "prom_write_test", cfg)
+			if err != nil {
+				t.Fatalf("failed to init output: %v", err)
+			}
+			p.WriteEvent(ctx, copyEvent(testEvent))
+
+			timeout := time.After(2 * time.Second)
+			numWritten := 0
+			var series [][]label
+		WAIT:
+			for {
+				select {
+				case s := <-written:
+					series = append(series, s...)
+					numWritten += len(s)
+				case <-timeout:
+					break WAIT
+				case <-time.After(300 * time.Millisecond):
+					if atomic.LoadInt32(&numReqs) >= tc.expectedRequests {
+						break WAIT
+					}
+				}
+			}
+			if n := atomic.LoadInt32(&numReqs); n != tc.expectedRequests {
+				t.Errorf("expected %d requests, got %d", tc.expectedRequests, n)
+			}
+			if numWritten != tc.expectedWritten {
+				t.Errorf("expected %d time series written, got %d", tc.expectedWritten, numWritten)
+			}
+			if tc.expectedLabels == nil || len(series) == 0 {
+				return
+			}
+			got := make(map[string]string)
+			for i, l := range series[0] {
+				if i > 0 && series[0][i-1].Name > l.Name {
+					t.Errorf("labels are not sorted: %v", series[0])
+				}
+				got[l.Name] = l.Value
+			}
+			for k, v := range tc.expectedLabels {
+				if got[k] != v {
+					t.Errorf("label %q: expected %q, got %q", k, v, got[k])
+				}
+			}
+		})
+	}
+}
+
+func copyEvent(ev *formatters.EventMsg) *formatters.EventMsg {
+	nev := &formatters.EventMsg{
+		Name:      ev.Name,
+		Timestamp: ev.Timestamp,
+		Tags:      make(map[string]string),
+		Values:    make(map[string]interface{}),
+	}
+	for k, v := range ev.Tags {
+		nev.Tags[k] = v
+	}
+	for k, v := range ev.Values {
+		nev.Values[k] = v
+	}
+	return nev
+}
+
+// decodeWriteRequest decodes a remote write request and returns the labels of each time series
+func decodeWriteRequest(b []byte) ([][]label, error) {
+	result := make([][]label, 0)
+	for len(b) > 0 {
+		_, _, n := protowire.ConsumeTag(b)
+		b = b[n:]
+		tsb, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		labels := make([]label, 0)
+		for len(tsb) > 0 {
+			num, _, n := protowire.ConsumeTag(tsb)
+			tsb = tsb[n:]
+			fb, n := protowire.ConsumeBytes(tsb)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			tsb = tsb[n:]
+			switch num {
+			case 1:
+				l := label{}
+				for len(fb) > 0 {
+					lnum, _, n := protowire.ConsumeTag(fb)
+					fb = fb[n:]
+					s, n := protowire.ConsumeString(fb)
+					fb = fb[n:]
+					if lnum == 1 {
+						l.Name = s
+					} else {
+						l.Value = s
+					}
+				}
+				labels = append(labels, l)
+			case 2:
+				_, _, n := protowire.ConsumeTag(fb)
+				v, _ := protowire.ConsumeFixed64(fb[n:])
+				if math.IsNaN(math.Float64frombits(v)) {
+					return nil, protowire.ParseError(-1)
+				}
+			}
+		}
+		result = append(result, labels)
+	}
+	return result, nil
+}
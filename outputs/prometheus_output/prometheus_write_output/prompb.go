@@ -0,0 +1,67 @@
+package prometheus_write_output
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// the types below are a minimal representation of the prometheus remote write
+// protobuf messages (prometheus/prompb), they are encoded by hand
+// to avoid pulling the prometheus server module as a dependency.
+//
+// message WriteRequest { repeated TimeSeries timeseries = 1; }
+// message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+// message Label { string name = 1; string value = 2; }
+// message Sample { double value = 1; int64 timestamp = 2; }
+
+type writeRequest struct {
+	TimeSeries []*timeSeries
+}
+
+type timeSeries struct {
+	Labels  []*label
+	Samples []*sample
+}
+
+type label struct {
+	Name  string
+	Value string
+}
+
+type sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+func (w *writeRequest) Marshal() []byte {
+	b := make([]byte, 0, 128*len(w.TimeSeries))
+	for _, ts := range w.TimeSeries {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, ts.marshal())
+	}
+	return b
+}
+
+func (t *timeSeries) marshal() []byte {
+	b := make([]byte, 0, 128)
+	for _, l := range t.Labels {
+		lb := make([]byte, 0, len(l.Name)+len(l.Value)+4)
+		lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.Name)
+		lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+		lb = protowire.AppendString(lb, l.Value)
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, lb)
+	}
+	for _, s := range t.Samples {
+		sb := make([]byte, 0, 20)
+		sb = protowire.AppendTag(sb, 1, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.Value))
+		sb = protowire.AppendTag(sb, 2, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(s.Timestamp))
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, sb)
+	}
+	return b
+}
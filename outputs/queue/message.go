@@ -0,0 +1,47 @@
+package queue
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"github.com/karimra/gnmic/outputs"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+// EncodeMessage encodes a subscribe response and its metadata into a queue message
+func EncodeMessage(rsp *gnmi.SubscribeResponse, meta outputs.Meta) ([]byte, error) {
+	mb, err := json.Marshal(meta)
+	if err != nil {
+		return nil, err
+	}
+	pb, err := proto.Marshal(rsp)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(mb)+len(pb))
+	n := binary.PutUvarint(b, uint64(len(mb)))
+	b = b[:n]
+	b = append(b, mb...)
+	return append(b, pb...), nil
+}
+
+// DecodeMessage decodes a queue message created with EncodeMessage
+func DecodeMessage(b []byte) (*gnmi.SubscribeResponse, outputs.Meta, error) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < l {
+		return nil, nil, errors.New("invalid queue message")
+	}
+	meta := make(outputs.Meta)
+	err := json.Unmarshal(b[n:n+int(l)], &meta)
+	if err != nil {
+		return nil, nil, err
+	}
+	rsp := new(gnmi.SubscribeResponse)
+	err = proto.Unmarshal(b[n+int(l):], rsp)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rsp, meta, nil
+}
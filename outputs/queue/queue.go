@@ -0,0 +1,562 @@
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSegmentSize   = 16 * 1024 * 1024
+	defaultMaxSize       = 1024 * 1024 * 1024
+	defaultRetryInterval = time.Second
+
+	segmentSuffix      = ".seg"
+	cursorFileName     = "cursor"
+	recordHeaderSize   = 8
+	cursorSaveInterval = time.Second
+	loggingPrefix      = "[output_queue] "
+)
+
+// ErrClosed is returned when using a closed queue
+var ErrClosed = errors.New("queue closed")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Config is the configuration of an output disk queue,
+// it is set under the `queue` field of an output.
+type Config struct {
+	// Directory under which the queue segment files are stored, a sub directory per output is created.
+	Directory string `mapstructure:"directory,omitempty" json:"directory,omitempty"`
+	// SegmentSize is the size in bytes after which a new segment file is created.
+	SegmentSize int64 `mapstructure:"segment-size,omitempty" json:"segment-size,omitempty"`
+	// MaxSize is the maximum size in bytes of the queue, the oldest segments are dropped when it is reached.
+	MaxSize int64 `mapstructure:"max-size,omitempty" json:"max-size,omitempty"`
+	// MaxAge is the maximum age of a segment, segments older than MaxAge are dropped.
+	MaxAge time.Duration `mapstructure:"max-age,omitempty" json:"max-age,omitempty"`
+	// RetryInterval is the wait time before retrying to write a message to an output after a failure.
+	RetryInterval time.Duration `mapstructure:"retry-interval,omitempty" json:"retry-interval,omitempty"`
+	// Debug enables extra logging.
+	Debug bool `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+}
+
+func (c *Config) setDefaults() {
+	if c.Directory == "" {
+		c.Directory = filepath.Join(os.TempDir(), "gnmic", "queue")
+	}
+	if c.SegmentSize <= 0 {
+		c.SegmentSize = defaultSegmentSize
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = defaultMaxSize
+	}
+	if c.MaxSize < c.SegmentSize {
+		c.SegmentSize = c.MaxSize
+	}
+	if c.RetryInterval <= 0 {
+		c.RetryInterval = defaultRetryInterval
+	}
+}
+
+// Queue is a FIFO queue of messages persisted in segment files on disk.
+// Messages are appended to the newest segment and read from the oldest one,
+// a segment file is deleted once all its messages are acknowledged.
+// The read position is periodically saved to disk,
+// messages might be delivered more than once after a restart.
+type Queue struct {
+	name   string
+	cfg    *Config
+	dir    string
+	logger *log.Logger
+
+	m        *sync.Mutex
+	notify   chan struct{}
+	closed   bool
+	segments []*segment
+	wf       *os.File
+	rf       *os.File
+	// read offset and read records count in segments[0]
+	rOffset int64
+	rCount  int64
+	// message returned by Peek and not acknowledged yet
+	pending     []byte
+	pendingSize int64
+
+	depth          int64
+	lastCursorSave time.Time
+	lastSegmentID  uint64
+}
+
+type segment struct {
+	id      uint64
+	path    string
+	size    int64
+	count   int64
+	modTime time.Time
+}
+
+// Open creates or loads the queue called name,
+// its segments are stored under cfg.Directory/name.
+func Open(name string, cfg *Config, logger *log.Logger) (*Queue, error) {
+	if cfg == nil {
+		cfg = new(Config)
+	}
+	cfg.setDefaults()
+	q := &Queue{
+		name:   name,
+		cfg:    cfg,
+		dir:    filepath.Join(cfg.Directory, name),
+		logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		m:      new(sync.Mutex),
+		notify: make(chan struct{}, 1),
+	}
+	if logger != nil {
+		q.logger.SetOutput(logger.Writer())
+		q.logger.SetFlags(logger.Flags())
+	}
+	err := os.MkdirAll(q.dir, 0750)
+	if err != nil {
+		return nil, err
+	}
+	err = q.load()
+	if err != nil {
+		return nil, err
+	}
+	// always start writing in a new segment
+	err = q.roll()
+	if err != nil {
+		return nil, err
+	}
+	err = q.openReader()
+	if err != nil {
+		return nil, err
+	}
+	q.updateMetrics()
+	q.logger.Printf("queue %q loaded from %s: %d messages, %d segments", q.name, q.dir, q.depth, len(q.segments))
+	return q, nil
+}
+
+// load reads the existing segments and the saved read position
+func (q *Queue) load() error {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(fi.Name(), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		seg := &segment{
+			id:      id,
+			path:    filepath.Join(q.dir, fi.Name()),
+			modTime: fi.ModTime(),
+		}
+		seg.count, seg.size, err = scanSegment(seg.path, -1)
+		if err != nil {
+			return err
+		}
+		if seg.size < fi.Size() {
+			// remove a partially written record at the end of the segment
+			q.logger.Printf("truncating segment %s from %d to %d bytes", seg.path, fi.Size(), seg.size)
+			if err = os.Truncate(seg.path, seg.size); err != nil {
+				return err
+			}
+		}
+		if seg.count == 0 {
+			os.Remove(seg.path)
+			continue
+		}
+		q.segments = append(q.segments, seg)
+	}
+	sort.Slice(q.segments, func(i, j int) bool {
+		return q.segments[i].id < q.segments[j].id
+	})
+	segID, offset, err := q.readCursor()
+	if err != nil {
+		q.logger.Printf("failed to read queue cursor: %v", err)
+	}
+	q.lastSegmentID = segID
+	if len(q.segments) > 0 && q.segments[len(q.segments)-1].id > segID {
+		q.lastSegmentID = q.segments[len(q.segments)-1].id
+	}
+	// delete the segments fully consumed before the last run ended
+	for len(q.segments) > 0 && q.segments[0].id < segID {
+		os.Remove(q.segments[0].path)
+		q.segments = q.segments[1:]
+	}
+	if len(q.segments) > 0 && q.segments[0].id == segID && offset > 0 {
+		q.rCount, q.rOffset, err = scanSegment(q.segments[0].path, offset)
+		if err != nil {
+			return err
+		}
+	}
+	for _, seg := range q.segments {
+		q.depth += seg.count
+	}
+	q.depth -= q.rCount
+	return nil
+}
+
+// scanSegment returns the number of valid records in the segment file
+// and the size they occupy, it stops at offset limit if limit is positive.
+func scanSegment(path string, limit int64) (int64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	var count, offset int64
+	header := make([]byte, recordHeaderSize)
+	for limit < 0 || offset < limit {
+		_, err = f.ReadAt(header, offset)
+		if err != nil {
+			break
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		if offset+recordHeaderSize+length > fi.Size() {
+			break
+		}
+		payload := make([]byte, length)
+		_, err = f.ReadAt(payload, offset+recordHeaderSize)
+		if err != nil {
+			break
+		}
+		if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+			break
+		}
+		count++
+		offset += recordHeaderSize + length
+	}
+	return count, offset, nil
+}
+
+func (q *Queue) readCursor() (uint64, int64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(q.dir, cursorFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+	var segID uint64
+	var offset int64
+	_, err = fmt.Sscanf(string(b), "%d %d", &segID, &offset)
+	return segID, offset, err
+}
+
+func (q *Queue) saveCursor() error {
+	if len(q.segments) == 0 {
+		return nil
+	}
+	tmp := filepath.Join(q.dir, cursorFileName+".tmp")
+	err := ioutil.WriteFile(tmp, []byte(fmt.Sprintf("%d %d\n", q.segments[0].id, q.rOffset)), 0640)
+	if err != nil {
+		return err
+	}
+	q.lastCursorSave = time.Now()
+	return os.Rename(tmp, filepath.Join(q.dir, cursorFileName))
+}
+
+// roll closes the current write segment and creates a new one
+func (q *Queue) roll() error {
+	q.lastSegmentID++
+	id := q.lastSegmentID
+	if q.wf != nil {
+		q.wf.Close()
+	}
+	seg := &segment{
+		id:      id,
+		path:    filepath.Join(q.dir, fmt.Sprintf("%020d%s", id, segmentSuffix)),
+		modTime: time.Now(),
+	}
+	var err error
+	q.wf, err = os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	q.segments = append(q.segments, seg)
+	return nil
+}
+
+// openReader opens the oldest segment for reading
+func (q *Queue) openReader() error {
+	if q.rf != nil {
+		q.rf.Close()
+		q.rf = nil
+	}
+	var err error
+	q.rf, err = os.Open(q.segments[0].path)
+	return err
+}
+
+// Enqueue appends a message to the queue
+func (q *Queue) Enqueue(b []byte) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	recSize := int64(recordHeaderSize + len(b))
+	ws := q.segments[len(q.segments)-1]
+	if ws.size > 0 && ws.size+recSize > q.cfg.SegmentSize {
+		if err := q.roll(); err != nil {
+			return err
+		}
+	}
+	q.expire()
+	for q.size()+recSize > q.cfg.MaxSize {
+		if len(q.segments) == 1 {
+			if q.segments[0].size == 0 {
+				// a single message bigger than the queue max size
+				break
+			}
+			if err := q.roll(); err != nil {
+				return err
+			}
+		}
+		if err := q.dropOldest("max_size"); err != nil {
+			return err
+		}
+	}
+	rec := make([]byte, recSize)
+	binary.BigEndian.PutUint32(rec[:4], uint32(len(b)))
+	binary.BigEndian.PutUint32(rec[4:recordHeaderSize], crc32.Checksum(b, crcTable))
+	copy(rec[recordHeaderSize:], b)
+	n, err := q.wf.Write(rec)
+	ws = q.segments[len(q.segments)-1]
+	if err != nil {
+		// make sure the next record starts after the partially written one
+		ws.size += int64(n)
+		q.roll()
+		QueueNumberOfDroppedMsgs.WithLabelValues(q.name, "write_error").Inc()
+		return err
+	}
+	ws.size += recSize
+	ws.count++
+	ws.modTime = time.Now()
+	q.depth++
+	QueueNumberOfEnqueuedMsgs.WithLabelValues(q.name).Inc()
+	q.updateMetrics()
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Peek blocks until a message is available and returns it without removing it from the queue.
+// The same message is returned until Ack is called.
+func (q *Queue) Peek(ctx context.Context) ([]byte, error) {
+	for {
+		q.m.Lock()
+		if q.closed {
+			q.m.Unlock()
+			return nil, ErrClosed
+		}
+		if q.pending != nil {
+			b := q.pending
+			q.m.Unlock()
+			return b, nil
+		}
+		q.expire()
+		b, err := q.readNext()
+		if err != nil {
+			q.m.Unlock()
+			return nil, err
+		}
+		if b != nil {
+			q.m.Unlock()
+			return b, nil
+		}
+		q.m.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-q.notify:
+		}
+	}
+}
+
+// Ack removes the message returned by the last Peek call from the queue
+func (q *Queue) Ack() {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.pending == nil || q.closed {
+		return
+	}
+	q.pending = nil
+	q.rOffset += q.pendingSize
+	q.rCount++
+	q.depth--
+	q.advance()
+	if time.Since(q.lastCursorSave) > cursorSaveInterval {
+		if err := q.saveCursor(); err != nil {
+			q.logger.Printf("queue %q: failed to save cursor: %v", q.name, err)
+		}
+	}
+	q.updateMetrics()
+}
+
+// Len returns the number of messages in the queue
+func (q *Queue) Len() int64 {
+	q.m.Lock()
+	defer q.m.Unlock()
+	return q.depth
+}
+
+// RetryInterval returns the configured wait time after a failed write
+func (q *Queue) RetryInterval() time.Duration {
+	return q.cfg.RetryInterval
+}
+
+// Close saves the queue read position and closes its files,
+// the messages not acknowledged are delivered after the queue is opened again.
+func (q *Queue) Close() error {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.notify)
+	err := q.saveCursor()
+	if q.wf != nil {
+		q.wf.Close()
+	}
+	if q.rf != nil {
+		q.rf.Close()
+	}
+	return err
+}
+
+// readNext reads the next message from the oldest segment,
+// it returns a nil slice if there is no message to read.
+func (q *Queue) readNext() ([]byte, error) {
+	for {
+		q.advance()
+		seg := q.segments[0]
+		if q.rOffset >= seg.size {
+			return nil, nil
+		}
+		header := make([]byte, recordHeaderSize)
+		_, err := q.rf.ReadAt(header, q.rOffset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		if err == nil && q.rOffset+recordHeaderSize+length <= seg.size {
+			payload := make([]byte, length)
+			_, err = q.rf.ReadAt(payload, q.rOffset+recordHeaderSize)
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			if err == nil && crc32.Checksum(payload, crcTable) == binary.BigEndian.Uint32(header[4:]) {
+				q.pending = payload
+				q.pendingSize = recordHeaderSize + length
+				return payload, nil
+			}
+		}
+		// skip the rest of a corrupted segment
+		dropped := seg.count - q.rCount
+		q.logger.Printf("queue %q: segment %s is corrupted at offset %d, dropping %d messages", q.name, seg.path, q.rOffset, dropped)
+		QueueNumberOfDroppedMsgs.WithLabelValues(q.name, "corrupted").Add(float64(dropped))
+		q.depth -= dropped
+		q.rOffset = seg.size
+		q.rCount = seg.count
+		if len(q.segments) == 1 {
+			// corrupted write segment, start a new one
+			if err = q.roll(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// advance deletes the oldest segment if it was fully read and it is not the write segment
+func (q *Queue) advance() {
+	for len(q.segments) > 1 && q.rOffset >= q.segments[0].size && q.pending == nil {
+		q.removeOldest()
+	}
+}
+
+func (q *Queue) removeOldest() {
+	seg := q.segments[0]
+	if q.rf != nil {
+		q.rf.Close()
+		q.rf = nil
+	}
+	if err := os.Remove(seg.path); err != nil {
+		q.logger.Printf("queue %q: failed to remove segment %s: %v", q.name, seg.path, err)
+	}
+	q.segments = q.segments[1:]
+	q.rOffset = 0
+	q.rCount = 0
+	if err := q.openReader(); err != nil {
+		q.logger.Printf("queue %q: failed to open segment %s: %v", q.name, q.segments[0].path, err)
+	}
+	if err := q.saveCursor(); err != nil {
+		q.logger.Printf("queue %q: failed to save cursor: %v", q.name, err)
+	}
+}
+
+// dropOldest deletes the oldest segment including its unread messages
+func (q *Queue) dropOldest(reason string) error {
+	if len(q.segments) < 2 {
+		return nil
+	}
+	seg := q.segments[0]
+	dropped := seg.count - q.rCount
+	q.pending = nil
+	q.depth -= dropped
+	if q.cfg.Debug {
+		q.logger.Printf("queue %q: dropping segment %s with %d messages, reason=%s", q.name, seg.path, dropped, reason)
+	}
+	QueueNumberOfDroppedMsgs.WithLabelValues(q.name, reason).Add(float64(dropped))
+	q.removeOldest()
+	return nil
+}
+
+// expire drops the segments older than max-age
+func (q *Queue) expire() {
+	if q.cfg.MaxAge <= 0 {
+		return
+	}
+	now := time.Now()
+	for len(q.segments) > 1 && now.Sub(q.segments[0].modTime) > q.cfg.MaxAge {
+		q.dropOldest("max_age")
+	}
+}
+
+// size returns the total size of the segments
+func (q *Queue) size() int64 {
+	var s int64
+	for _, seg := range q.segments {
+		s += seg.size
+	}
+	return s
+}
+
+func (q *Queue) updateMetrics() {
+	QueueDepth.WithLabelValues(q.name).Set(float64(q.depth))
+	QueueBytes.WithLabelValues(q.name).Set(float64(q.size() - q.rOffset))
+}
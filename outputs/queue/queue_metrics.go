@@ -0,0 +1,49 @@
+package queue
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var QueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "output_queue",
+	Name:      "depth",
+	Help:      "Number of messages waiting in the output disk queue",
+}, []string{"output"})
+
+var QueueBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "output_queue",
+	Name:      "bytes",
+	Help:      "Size in bytes of the messages waiting in the output disk queue",
+}, []string{"output"})
+
+var QueueNumberOfEnqueuedMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "output_queue",
+	Name:      "number_of_enqueued_msgs_total",
+	Help:      "Number of messages written to the output disk queue",
+}, []string{"output"})
+
+var QueueNumberOfDroppedMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "output_queue",
+	Name:      "number_of_dropped_msgs_total",
+	Help:      "Number of messages dropped from the output disk queue",
+}, []string{"output", "reason"})
+
+// RegisterMetrics registers the output queues metrics
+func RegisterMetrics(reg *prometheus.Registry) error {
+	var err error
+	if err = reg.Register(QueueDepth); err != nil {
+		return err
+	}
+	if err = reg.Register(QueueBytes); err != nil {
+		return err
+	}
+	if err = reg.Register(QueueNumberOfEnqueuedMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(QueueNumberOfDroppedMsgs); err != nil {
+		return err
+	}
+	return nil
+}
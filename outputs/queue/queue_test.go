@@ -0,0 +1,160 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/outputs"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gnmic-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func consume(t *testing.T, q *Queue, n int) []string {
+	result := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		b, err := q.Peek(ctx)
+		cancel()
+		if err != nil {
+			t.Fatalf("peek %d failed: %v", i, err)
+		}
+		result = append(result, string(b))
+		q.Ack()
+	}
+	return result
+}
+
+func TestQueueOrder(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q, err := Open("test", &Config{Directory: dir, SegmentSize: 64}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	for i := 0; i < 20; i++ {
+		if err = q.Enqueue([]byte(fmt.Sprintf("msg%02d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if q.Len() != 20 {
+		t.Fatalf("expected queue length 20, got %d", q.Len())
+	}
+	// peek without ack returns the same message
+	b1, _ := q.Peek(context.Background())
+	b2, _ := q.Peek(context.Background())
+	if string(b1) != string(b2) {
+		t.Fatalf("expected the same message, got %q and %q", b1, b2)
+	}
+	msgs := consume(t, q, 20)
+	for i, m := range msgs {
+		if m != fmt.Sprintf("msg%02d", i) {
+			t.Errorf("message %d: expected msg%02d, got %s", i, i, m)
+		}
+	}
+	if q.Len() != 0 {
+		t.Fatalf("expected empty queue, got %d", q.Len())
+	}
+	segs, _ := filepath.Glob(filepath.Join(dir, "test", "*"+segmentSuffix))
+	if len(segs) != 1 {
+		t.Errorf("expected the consumed segments to be removed, found %d segments", len(segs))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = q.Peek(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected peek on an empty queue to block until the context is done, got %v", err)
+	}
+}
+
+func TestQueueReopen(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q, err := Open("test", &Config{Directory: dir, SegmentSize: 64}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		q.Enqueue([]byte(fmt.Sprintf("msg%02d", i)))
+	}
+	consume(t, q, 4)
+	q.Close()
+
+	q, err = Open("test", &Config{Directory: dir, SegmentSize: 64}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if q.Len() != 6 {
+		t.Fatalf("expected 6 messages after reopening the queue, got %d", q.Len())
+	}
+	q.Enqueue([]byte("msg10"))
+	msgs := consume(t, q, 7)
+	for i, m := range msgs {
+		if m != fmt.Sprintf("msg%02d", i+4) {
+			t.Errorf("message %d: expected msg%02d, got %s", i, i+4, m)
+		}
+	}
+}
+
+func TestQueueMaxSize(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// each record is 13 bytes, 4 records per segment
+	q, err := Open("test", &Config{Directory: dir, SegmentSize: 52, MaxSize: 104}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	for i := 0; i < 20; i++ {
+		q.Enqueue([]byte(fmt.Sprintf("msg%02d", i)))
+	}
+	if q.Len() > 8 {
+		t.Fatalf("expected at most 8 messages in the queue, got %d", q.Len())
+	}
+	msgs := consume(t, q, int(q.Len()))
+	if msgs[len(msgs)-1] != "msg19" {
+		t.Errorf("expected the newest message to be kept, got %s", msgs[len(msgs)-1])
+	}
+}
+
+func TestMessageEncoding(t *testing.T) {
+	rsp := &gnmi.SubscribeResponse{
+		Response: &gnmi.SubscribeResponse_Update{
+			Update: &gnmi.Notification{
+				Timestamp: 42,
+				Prefix:    &gnmi.Path{Target: "router1"},
+			},
+		},
+	}
+	meta := outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"}
+	b, err := EncodeMessage(rsp, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drsp, dmeta, err := DecodeMessage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(rsp, drsp) {
+		t.Errorf("expected %v, got %v", rsp, drsp)
+	}
+	for k, v := range meta {
+		if dmeta[k] != v {
+			t.Errorf("meta %q: expected %q, got %q", k, v, dmeta[k])
+		}
+	}
+}
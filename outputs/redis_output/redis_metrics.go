@@ -0,0 +1,56 @@
+package redis_output
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var RedisNumberOfSentMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "redis_output",
+	Name:      "number_of_redis_msgs_sent_success_total",
+	Help:      "Number of msgs successfully sent by gnmic redis output",
+}, []string{"publisher_id", "stream"})
+
+var RedisNumberOfSentBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "redis_output",
+	Name:      "number_of_written_redis_bytes_total",
+	Help:      "Number of bytes written by gnmic redis output",
+}, []string{"publisher_id", "stream"})
+
+var RedisNumberOfFailSendMsgs = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "gnmic",
+	Subsystem: "redis_output",
+	Name:      "number_of_redis_msgs_sent_fail_total",
+	Help:      "Number of failed msgs sent by gnmic redis output",
+}, []string{"publisher_id", "reason"})
+
+var RedisSendDuration = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "gnmic",
+	Subsystem: "redis_output",
+	Name:      "msg_send_duration_ns",
+	Help:      "gnmic redis output send duration in ns",
+}, []string{"publisher_id"})
+
+func initMetrics() {
+	RedisNumberOfSentMsgs.WithLabelValues("", "").Add(0)
+	RedisNumberOfSentBytes.WithLabelValues("", "").Add(0)
+	RedisNumberOfFailSendMsgs.WithLabelValues("", "").Add(0)
+	RedisSendDuration.WithLabelValues("").Set(0)
+}
+
+func registerMetrics(reg *prometheus.Registry) error {
+	initMetrics()
+	var err error
+	if err = reg.Register(RedisNumberOfSentMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(RedisNumberOfSentBytes); err != nil {
+		return err
+	}
+	if err = reg.Register(RedisNumberOfFailSendMsgs); err != nil {
+		return err
+	}
+	if err = reg.Register(RedisSendDuration); err != nil {
+		return err
+	}
+	return nil
+}
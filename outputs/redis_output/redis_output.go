@@ -0,0 +1,441 @@
+package redis_output
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/karimra/gnmic/formatters"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/karimra/gnmic/types"
+	"github.com/karimra/gnmic/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	loggingPrefix       = "[redis_output] "
+	defaultAddress      = "localhost:6379"
+	defaultStream       = `telemetry:{{ index . "source" }}:{{ index . "subscription-name" }}`
+	defaultMaxLen       = 100000
+	defaultFormat       = "event"
+	defaultNumWorkers   = 1
+	defaultWriteTimeout = 5 * time.Second
+
+	// stream entries fields
+	dataField             = "data"
+	sourceField           = "source"
+	subscriptionNameField = "subscription-name"
+)
+
+func init() {
+	outputs.Register("redis", func() outputs.Output {
+		return &RedisOutput{
+			Cfg:    &Config{},
+			wg:     new(sync.WaitGroup),
+			logger: log.New(ioutil.Discard, loggingPrefix, log.LstdFlags|log.Lmicroseconds),
+		}
+	})
+}
+
+type protoMsg struct {
+	m    proto.Message
+	meta outputs.Meta
+	// set by WriteSync to get the write result
+	errCh chan error
+}
+
+func (m *protoMsg) done(err error) {
+	if m.errCh != nil {
+		m.errCh <- err
+	}
+}
+
+// RedisOutput adds the messages to Redis streams
+type RedisOutput struct {
+	Cfg      *Config
+	cancelFn context.CancelFunc
+	msgChan  chan *protoMsg
+	wg       *sync.WaitGroup
+	logger   *log.Logger
+	mo       *formatters.MarshalOptions
+	evps     []formatters.EventProcessor
+	client   *redis.Client
+
+	streamTpl *template.Template
+	targetTpl *template.Template
+	syncCache outputs.SyncCache
+}
+
+// Config //
+type Config struct {
+	Name               string        `mapstructure:"name,omitempty" json:"name,omitempty"`
+	Address            string        `mapstructure:"address,omitempty" json:"address,omitempty"`
+	Username           string        `mapstructure:"username,omitempty" json:"username,omitempty"`
+	Password           string        `mapstructure:"password,omitempty" json:"-"`
+	DB                 int           `mapstructure:"db,omitempty" json:"db,omitempty"`
+	TLS                *tlsConfig    `mapstructure:"tls,omitempty" json:"tls,omitempty"`
+	Stream             string        `mapstructure:"stream,omitempty" json:"stream,omitempty"`
+	MaxLen             int64         `mapstructure:"max-len,omitempty" json:"max-len,omitempty"`
+	ExactTrimming      bool          `mapstructure:"exact-trimming,omitempty" json:"exact-trimming,omitempty"`
+	Format             string        `mapstructure:"format,omitempty" json:"format,omitempty"`
+	AddTarget          string        `mapstructure:"add-target,omitempty" json:"add-target,omitempty"`
+	TargetTemplate     string        `mapstructure:"target-template,omitempty" json:"target-template,omitempty"`
+	OverrideTimestamps bool          `mapstructure:"override-timestamps,omitempty" json:"override-timestamps,omitempty"`
+	NumWorkers         int           `mapstructure:"num-workers,omitempty" json:"num-workers,omitempty"`
+	WriteTimeout       time.Duration `mapstructure:"write-timeout,omitempty" json:"write-timeout,omitempty"`
+	Debug              bool          `mapstructure:"debug,omitempty" json:"debug,omitempty"`
+	EnableMetrics      bool          `mapstructure:"enable-metrics,omitempty" json:"enable-metrics,omitempty"`
+	EventProcessors    []string      `mapstructure:"event-processors,omitempty" json:"event-processors,omitempty"`
+}
+
+type tlsConfig struct {
+	CaFile     string `mapstructure:"ca-file,omitempty" json:"ca-file,omitempty"`
+	CertFile   string `mapstructure:"cert-file,omitempty" json:"cert-file,omitempty"`
+	KeyFile    string `mapstructure:"key-file,omitempty" json:"key-file,omitempty"`
+	SkipVerify bool   `mapstructure:"skip-verify,omitempty" json:"skip-verify,omitempty"`
+}
+
+func (r *RedisOutput) String() string {
+	b, err := json.Marshal(r.Cfg)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (r *RedisOutput) SetLogger(logger *log.Logger) {
+	if logger != nil && r.logger != nil {
+		r.logger.SetOutput(logger.Writer())
+		r.logger.SetFlags(logger.Flags())
+	}
+}
+
+func (r *RedisOutput) SetEventProcessors(ps map[string]map[string]interface{}, logger *log.Logger, tcs map[string]*types.TargetConfig) {
+	for _, epName := range r.Cfg.EventProcessors {
+		if epCfg, ok := ps[epName]; ok {
+			epType := ""
+			for k := range epCfg {
+				epType = k
+				break
+			}
+			if in, ok := formatters.EventProcessors[epType]; ok {
+				ep := in()
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				if err != nil {
+					r.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
+					continue
+				}
+				r.evps = append(r.evps, ep)
+				r.logger.Printf("added event processor '%s' of type=%s to redis output", epName, epType)
+				continue
+			}
+			r.logger.Printf("%q event processor has an unknown type=%q", epName, epType)
+			continue
+		}
+		r.logger.Printf("%q event processor not found!", epName)
+	}
+}
+
+// Init //
+func (r *RedisOutput) Init(ctx context.Context, name string, cfg map[string]interface{}, opts ...outputs.Option) error {
+	err := outputs.DecodeConfig(cfg, r.Cfg)
+	if err != nil {
+		return err
+	}
+	if r.Cfg.Name == "" {
+		r.Cfg.Name = name
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	err = r.setDefaults()
+	if err != nil {
+		return err
+	}
+	r.streamTpl, err = template.New("stream").
+		Funcs(outputs.TemplateFuncs).
+		Parse(r.Cfg.Stream)
+	if err != nil {
+		return fmt.Errorf("failed to parse stream template: %v", err)
+	}
+	if r.Cfg.TargetTemplate == "" {
+		r.targetTpl = outputs.DefaultTargetTemplate
+	} else if r.Cfg.AddTarget != "" {
+		r.targetTpl, err = template.New("target-template").
+			Funcs(outputs.TemplateFuncs).
+			Parse(r.Cfg.TargetTemplate)
+		if err != nil {
+			return err
+		}
+	}
+	r.client, err = r.createClient()
+	if err != nil {
+		return err
+	}
+	r.msgChan = make(chan *protoMsg)
+	initMetrics()
+	r.mo = &formatters.MarshalOptions{
+		Format:     r.Cfg.Format,
+		OverrideTS: r.Cfg.OverrideTimestamps,
+	}
+	r.logger.Printf("initialized redis output: %s", r.String())
+	ctx, r.cancelFn = context.WithCancel(ctx)
+	r.wg.Add(r.Cfg.NumWorkers)
+	for i := 0; i < r.Cfg.NumWorkers; i++ {
+		go r.worker(ctx, i)
+	}
+
+	go func() {
+		<-ctx.Done()
+		r.Close()
+	}()
+	return nil
+}
+
+func (r *RedisOutput) setDefaults() error {
+	if r.Cfg.Format == "" {
+		r.Cfg.Format = defaultFormat
+	}
+	if !(r.Cfg.Format == "event" || r.Cfg.Format == "protojson" || r.Cfg.Format == "proto" || r.Cfg.Format == "json") {
+		return fmt.Errorf("unsupported output format '%s' for output type redis", r.Cfg.Format)
+	}
+	if r.Cfg.Address == "" {
+		r.Cfg.Address = defaultAddress
+	}
+	if r.Cfg.Stream == "" {
+		r.Cfg.Stream = defaultStream
+	}
+	if r.Cfg.MaxLen == 0 {
+		r.Cfg.MaxLen = defaultMaxLen
+	}
+	if r.Cfg.Name == "" {
+		r.Cfg.Name = "gnmic-" + uuid.New().String()
+	}
+	if r.Cfg.NumWorkers <= 0 {
+		r.Cfg.NumWorkers = defaultNumWorkers
+	}
+	if r.Cfg.WriteTimeout <= 0 {
+		r.Cfg.WriteTimeout = defaultWriteTimeout
+	}
+	return nil
+}
+
+func (r *RedisOutput) createClient() (*redis.Client, error) {
+	opts := &redis.Options{
+		Addr:     r.Cfg.Address,
+		Username: r.Cfg.Username,
+		Password: r.Cfg.Password,
+		DB:       r.Cfg.DB,
+	}
+	if r.Cfg.TLS != nil {
+		tlsCfg, err := utils.NewTLSConfig(r.Cfg.TLS.CaFile, r.Cfg.TLS.CertFile, r.Cfg.TLS.KeyFile, r.Cfg.TLS.SkipVerify)
+		if err != nil {
+			return nil, err
+		}
+		if tlsCfg == nil {
+			tlsCfg = &tls.Config{}
+		}
+		opts.TLSConfig = tlsCfg
+	}
+	return redis.NewClient(opts), nil
+}
+
+// Write //
+func (r *RedisOutput) Write(ctx context.Context, rsp proto.Message, meta outputs.Meta) {
+	if rsp == nil || r.mo == nil {
+		return
+	}
+
+	wctx, cancel := context.WithTimeout(ctx, r.Cfg.WriteTimeout)
+	defer cancel()
+
+	select {
+	case <-ctx.Done():
+		return
+	case r.msgChan <- &protoMsg{m: rsp, meta: meta}:
+	case <-wctx.Done():
+		if r.Cfg.Debug {
+			r.logger.Printf("writing expired after %s, redis output might not be initialized", r.Cfg.WriteTimeout)
+		}
+		if r.Cfg.EnableMetrics {
+			RedisNumberOfFailSendMsgs.WithLabelValues(r.Cfg.Name, "timeout").Inc()
+		}
+		return
+	}
+}
+
+// WriteSync implements outputs.SyncWriter
+func (r *RedisOutput) WriteSync(ctx context.Context, rsp proto.Message, meta outputs.Meta) error {
+	if rsp == nil {
+		return nil
+	}
+	if r.mo == nil {
+		return errors.New("redis output not initialized")
+	}
+	wctx, cancel := context.WithTimeout(ctx, r.Cfg.WriteTimeout)
+	defer cancel()
+
+	m := &protoMsg{m: rsp, meta: meta, errCh: make(chan error, 1)}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case r.msgChan <- m:
+	case <-wctx.Done():
+		return fmt.Errorf("writing expired after %s, redis output might not be initialized", r.Cfg.WriteTimeout)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-m.errCh:
+		return err
+	}
+}
+
+func (r *RedisOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
+
+// Close //
+func (r *RedisOutput) Close() error {
+	formatters.CloseProcessors(r.evps)
+	if r.cancelFn != nil {
+		r.cancelFn()
+	}
+	r.wg.Wait()
+	if r.client != nil {
+		return r.client.Close()
+	}
+	return nil
+}
+
+// Metrics //
+func (r *RedisOutput) RegisterMetrics(reg *prometheus.Registry) {
+	if !r.Cfg.EnableMetrics {
+		return
+	}
+	if err := registerMetrics(reg); err != nil {
+		r.logger.Printf("failed to register metric: %+v", err)
+	}
+}
+
+func (r *RedisOutput) worker(ctx context.Context, i int) {
+	defer r.wg.Done()
+	workerLogPrefix := fmt.Sprintf("worker-%d", i)
+	r.logger.Printf("%s starting", workerLogPrefix)
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Printf("%s shutting down", workerLogPrefix)
+			return
+		case m := <-r.msgChan:
+			err := outputs.AddSubscriptionTarget(m.m, m.meta, r.Cfg.AddTarget, r.targetTpl)
+			if err != nil {
+				r.logger.Printf("failed to add target to the response: %v", err)
+			}
+			b, err := r.syncCache.Marshal(m.m, m.errCh != nil, func() ([]byte, error) {
+				return r.mo.Marshal(m.m, m.meta, r.evps...)
+			})
+			if err != nil {
+				if r.Cfg.Debug {
+					r.logger.Printf("%s failed marshaling proto msg: %v", workerLogPrefix, err)
+				}
+				if r.Cfg.EnableMetrics {
+					RedisNumberOfFailSendMsgs.WithLabelValues(r.Cfg.Name, "marshal_error").Inc()
+				}
+				// retrying a message that cannot be marshaled won't help
+				m.done(nil)
+				continue
+			}
+			if len(b) == 0 {
+				m.done(nil)
+				continue
+			}
+			stream, err := r.streamName(m.meta)
+			if err != nil {
+				r.logger.Printf("%s failed to build stream name: %v", workerLogPrefix, err)
+				if r.Cfg.EnableMetrics {
+					RedisNumberOfFailSendMsgs.WithLabelValues(r.Cfg.Name, "stream_template_error").Inc()
+				}
+				m.done(nil)
+				continue
+			}
+			var start time.Time
+			if r.Cfg.EnableMetrics {
+				start = time.Now()
+			}
+			wctx, cancel := context.WithTimeout(ctx, r.Cfg.WriteTimeout)
+			err = r.client.XAdd(wctx, r.xaddArgs(stream, b, m.meta)).Err()
+			cancel()
+			if err != nil {
+				if r.Cfg.Debug {
+					r.logger.Printf("%s failed to add to redis stream '%s': %v", workerLogPrefix, stream, err)
+				}
+				if r.Cfg.EnableMetrics {
+					RedisNumberOfFailSendMsgs.WithLabelValues(r.Cfg.Name, "xadd_error").Inc()
+				}
+				m.done(err)
+				continue
+			}
+			if r.Cfg.EnableMetrics {
+				RedisSendDuration.WithLabelValues(r.Cfg.Name).Set(float64(time.Since(start).Nanoseconds()))
+				RedisNumberOfSentMsgs.WithLabelValues(r.Cfg.Name, stream).Inc()
+				RedisNumberOfSentBytes.WithLabelValues(r.Cfg.Name, stream).Add(float64(len(b)))
+			}
+			m.done(nil)
+		}
+	}
+}
+
+// xaddArgs builds the stream entry, the message source and subscription name
+// are added as fields next to the marshaled message
+func (r *RedisOutput) xaddArgs(stream string, b []byte, meta outputs.Meta) *redis.XAddArgs {
+	values := []interface{}{dataField, b}
+	if s, ok := meta["source"]; ok {
+		values = append(values, sourceField, s)
+	}
+	if s, ok := meta["subscription-name"]; ok {
+		values = append(values, subscriptionNameField, s)
+	}
+	args := &redis.XAddArgs{
+		Stream: stream,
+		Values: values,
+	}
+	if r.Cfg.MaxLen > 0 {
+		args.MaxLen = r.Cfg.MaxLen
+		args.Approx = !r.Cfg.ExactTrimming
+	}
+	return args
+}
+
+func (r *RedisOutput) streamName(meta outputs.Meta) (string, error) {
+	sb := new(strings.Builder)
+	err := r.streamTpl.Execute(sb, meta)
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(sb.String(), " ", "_"), nil
+}
+
+func (r *RedisOutput) SetName(name string) {
+	sb := strings.Builder{}
+	if name != "" {
+		sb.WriteString(name)
+		sb.WriteString("-")
+	}
+	sb.WriteString(r.Cfg.Name)
+	sb.WriteString("-redis-pub")
+	r.Cfg.Name = sb.String()
+}
+
+func (r *RedisOutput) SetClusterName(name string) {}
+
+func (r *RedisOutput) SetTargetsConfig(map[string]*types.TargetConfig) {}
@@ -0,0 +1,121 @@
+package redis_output
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"text/template"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/karimra/gnmic/outputs"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRedisOutputXAdd(t *testing.T) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o := &RedisOutput{Cfg: &Config{}, wg: new(sync.WaitGroup), logger: log.New(ioutil.Discard, "", 0)}
+	err = o.Init(ctx, "redis1", map[string]interface{}{
+		"address":        s.Addr(),
+		"format":         "proto",
+		"max-len":        2,
+		"exact-trimming": true,
+	})
+	if err != nil {
+		t.Fatalf("failed to init output: %v", err)
+	}
+	defer o.Close()
+
+	meta := outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"}
+	for i := 1; i <= 3; i++ {
+		rsp := &gnmi.SubscribeResponse{
+			Response: &gnmi.SubscribeResponse_Update{
+				Update: &gnmi.Notification{Timestamp: int64(i)},
+			},
+		}
+		if err := o.WriteSync(ctx, rsp, meta); err != nil {
+			t.Fatalf("failed to write: %v", err)
+		}
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer client.Close()
+	msgs, err := client.XRange(ctx, "telemetry:router1:57400:sub1", "-", "+").Result()
+	if err != nil {
+		t.Fatalf("failed to read stream: %v", err)
+	}
+	// the stream is trimmed to the last 2 entries
+	if len(msgs) != 2 {
+		t.Fatalf("got %d stream entries, want 2", len(msgs))
+	}
+	for i, msg := range msgs {
+		if msg.Values["source"] != "router1:57400" || msg.Values["subscription-name"] != "sub1" {
+			t.Errorf("unexpected entry fields: %v", msg.Values)
+		}
+		rsp := new(gnmi.SubscribeResponse)
+		err = proto.Unmarshal([]byte(msg.Values["data"].(string)), rsp)
+		if err != nil {
+			t.Fatalf("failed to unmarshal entry data: %v", err)
+		}
+		if rsp.GetUpdate().GetTimestamp() != int64(i+2) {
+			t.Errorf("got timestamp %d, want %d", rsp.GetUpdate().GetTimestamp(), i+2)
+		}
+	}
+}
+
+func TestStreamName(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream string
+		meta   outputs.Meta
+		want   string
+	}{
+		{
+			name: "default",
+			meta: outputs.Meta{"source": "router1:57400", "subscription-name": "sub1"},
+			want: "telemetry:router1:57400:sub1",
+		},
+		{
+			name:   "custom template",
+			stream: `gnmic:{{ index . "subscription-name" }}:{{ index . "source" | host }}`,
+			meta:   outputs.Meta{"source": "router1:57400", "subscription-name": "port stats"},
+			want:   "gnmic:port_stats:router1",
+		},
+		{
+			name:   "fixed",
+			stream: "telemetry",
+			meta:   outputs.Meta{"source": "router1:57400"},
+			want:   "telemetry",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := &RedisOutput{Cfg: &Config{Stream: tt.stream}}
+			if err := o.setDefaults(); err != nil {
+				t.Fatal(err)
+			}
+			var err error
+			o.streamTpl, err = template.New("stream").Funcs(outputs.TemplateFuncs).Parse(o.Cfg.Stream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := o.streamName(tt.meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got stream %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
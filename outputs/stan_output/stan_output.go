@@ -112,7 +112,7 @@ func (s *StanOutput) SetEventProcessors(ps map[string]map[string]interface{}, lo
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
 				if err != nil {
 					s.logger.Printf("failed initializing event processor %q of type=%q: %v", epName, epType, err)
 					continue
@@ -252,6 +252,7 @@ func (s *StanOutput) RegisterMetrics(reg *prometheus.Registry) {
 
 // Close //
 func (s *StanOutput) Close() error {
+	formatters.CloseProcessors(s.evps)
 	s.cancelFn()
 	s.wg.Wait()
 	return nil
@@ -3,6 +3,7 @@ package tcp_output
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -36,13 +37,26 @@ type TCPOutput struct {
 	Cfg *Config
 
 	cancelFn context.CancelFunc
-	buffer   chan []byte
+	buffer   chan *tcpMsg
 	limiter  *time.Ticker
 	logger   *log.Logger
 	mo       *formatters.MarshalOptions
 	evps     []formatters.EventProcessor
 
 	targetTpl *template.Template
+	syncCache outputs.SyncCache
+}
+
+type tcpMsg struct {
+	b []byte
+	// set by WriteSync to get the write result
+	errCh chan error
+}
+
+func (m *tcpMsg) done(err error) {
+	if m.errCh != nil {
+		m.errCh <- err
+	}
 }
 
 type Config struct {
@@ -77,7 +91,7 @@ func (t *TCPOutput) SetEventProcessors(ps map[string]map[string]interface{}, log
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
 				if err != nil {
 					t.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
 					continue
@@ -105,7 +119,7 @@ func (t *TCPOutput) Init(ctx context.Context, name string, cfg map[string]interf
 	if err != nil {
 		return fmt.Errorf("wrong address format: %v", err)
 	}
-	t.buffer = make(chan []byte, t.Cfg.BufferSize)
+	t.buffer = make(chan *tcpMsg, t.Cfg.BufferSize)
 	if t.Cfg.Rate > 0 {
 		t.limiter = time.NewTicker(t.Cfg.Rate)
 	}
@@ -160,13 +174,48 @@ func (t *TCPOutput) Write(ctx context.Context, m proto.Message, meta outputs.Met
 			t.logger.Printf("failed marshaling proto msg: %v", err)
 			return
 		}
-		t.buffer <- b
+		t.buffer <- &tcpMsg{b: b}
+	}
+}
+
+// WriteSync implements outputs.SyncWriter
+func (t *TCPOutput) WriteSync(ctx context.Context, m proto.Message, meta outputs.Meta) error {
+	if m == nil {
+		return nil
+	}
+	if t.mo == nil {
+		return errors.New("tcp output not initialized")
+	}
+	err := outputs.AddSubscriptionTarget(m, meta, t.Cfg.AddTarget, t.targetTpl)
+	if err != nil {
+		t.logger.Printf("failed to add target to the response: %v", err)
+	}
+	b, err := t.syncCache.Marshal(m, true, func() ([]byte, error) {
+		return t.mo.Marshal(m, meta, t.evps...)
+	})
+	if err != nil {
+		// retrying a message that cannot be marshaled won't help
+		t.logger.Printf("failed marshaling proto msg: %v", err)
+		return nil
+	}
+	msg := &tcpMsg{b: b, errCh: make(chan error, 1)}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case t.buffer <- msg:
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err = <-msg.errCh:
+		return err
 	}
 }
 
 func (t *TCPOutput) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
 
 func (t *TCPOutput) Close() error {
+	formatters.CloseProcessors(t.evps)
 	t.cancelFn()
 	if t.limiter != nil {
 		t.limiter.Stop()
@@ -208,11 +257,12 @@ START:
 		select {
 		case <-ctx.Done():
 			return
-		case b := <-t.buffer:
+		case m := <-t.buffer:
 			if t.limiter != nil {
 				<-t.limiter.C
 			}
-			_, err = conn.Write(b)
+			_, err = conn.Write(m.b)
+			m.done(err)
 			if err != nil {
 				t.logger.Printf("%s failed sending tcp bytes: %v", workerLogPrefix, err)
 				conn.Close()
@@ -75,7 +75,7 @@ func (u *UDPSock) SetEventProcessors(ps map[string]map[string]interface{}, logge
 			}
 			if in, ok := formatters.EventProcessors[epType]; ok {
 				ep := in()
-				err := ep.Init(epCfg[epType], formatters.WithLogger(logger), formatters.WithTargets(tcs))
+				err := ep.Init(epCfg[epType], formatters.WithName(epName), formatters.WithLogger(logger), formatters.WithTargets(tcs))
 				if err != nil {
 					u.logger.Printf("failed initializing event processor '%s' of type='%s': %v", epName, epType, err)
 					continue
@@ -159,6 +159,7 @@ func (u *UDPSock) Write(ctx context.Context, m proto.Message, meta outputs.Meta)
 func (u *UDPSock) WriteEvent(ctx context.Context, ev *formatters.EventMsg) {}
 
 func (u *UDPSock) Close() error {
+	formatters.CloseProcessors(u.evps)
 	u.cancelFn()
 	if u.limiter != nil {
 		u.limiter.Stop()
@@ -0,0 +1,152 @@
+package target
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// RetryState holds the reconnection state of a target gNMI client or subscription
+type RetryState struct {
+	Attempts  int       `json:"attempts,omitempty"`
+	NextRetry time.Time `json:"next-retry,omitempty"`
+	LastError string    `json:"last-error,omitempty"`
+}
+
+type targetRetryState struct {
+	Client        *RetryState            `json:"client,omitempty"`
+	Subscriptions map[string]*RetryState `json:"subscriptions,omitempty"`
+}
+
+// MarshalJSON encodes the target along with its status and reconnection state
+func (t *Target) MarshalJSON() ([]byte, error) {
+	type target Target
+	t.m.Lock()
+	var rs *targetRetryState
+	if t.clientRetry != nil || len(t.subscriptionsRetry) > 0 {
+		rs = new(targetRetryState)
+		if t.clientRetry != nil {
+			crs := *t.clientRetry
+			rs.Client = &crs
+		}
+		if len(t.subscriptionsRetry) > 0 {
+			rs.Subscriptions = make(map[string]*RetryState, len(t.subscriptionsRetry))
+			for name, s := range t.subscriptionsRetry {
+				srs := *s
+				rs.Subscriptions[name] = &srs
+			}
+		}
+	}
+	status := t.status.copy()
+	t.m.Unlock()
+	return json.Marshal(&struct {
+		*target
+		Status     *Status           `json:"status,omitempty"`
+		RetryState *targetRetryState `json:"retry-state,omitempty"`
+	}{
+		target:     (*target)(t),
+		Status:     status,
+		RetryState: rs,
+	})
+}
+
+// NextClientRetry records a failed gNMI client creation attempt and returns the delay before the next one.
+// It returns false if the maximum number of attempts is reached.
+func (t *Target) NextClientRetry(err error) (time.Duration, bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if t.clientRetry == nil {
+		t.clientRetry = new(RetryState)
+	}
+	d, ok := t.nextRetry(t.clientRetry, err)
+	if ok {
+		t.setState(StateRetrying, err)
+	} else {
+		t.setState(StateFailed, err)
+	}
+	return d, ok
+}
+
+// ResetClientRetry clears the gNMI client reconnection state
+func (t *Target) ResetClientRetry() {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.clientRetry = nil
+}
+
+func (t *Target) nextSubscriptionRetry(name string, err error) (time.Duration, bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	rs, ok := t.subscriptionsRetry[name]
+	if !ok {
+		rs = new(RetryState)
+		t.subscriptionsRetry[name] = rs
+	}
+	d, ok := t.nextRetry(rs, err)
+	if ok {
+		t.setSubscriptionState(name, StateRetrying, err)
+	} else {
+		t.setSubscriptionState(name, StateFailed, err)
+	}
+	return d, ok
+}
+
+func (t *Target) resetSubscriptionRetry(name string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	delete(t.subscriptionsRetry, name)
+}
+
+// nextRetry must be called with t.m locked
+func (t *Target) nextRetry(rs *RetryState, err error) (time.Duration, bool) {
+	rs.Attempts++
+	if err != nil {
+		rs.LastError = err.Error()
+	}
+	d, ok := t.Config.Backoff.Delay(rs.Attempts, t.Config.RetryTimer)
+	if !ok {
+		rs.NextRetry = time.Time{}
+		return 0, false
+	}
+	rs.NextRetry = time.Now().Add(d)
+	return d, true
+}
+
+// waitSubscriptionRetry reports err for subscription `name` and waits for the backoff delay.
+// It returns false if the subscription should not be retried,
+// either because ctx is done, i.e the subscription is deleted or the target stopped,
+// or because the maximum number of attempts is reached.
+func (t *Target) waitSubscriptionRetry(ctx context.Context, name string, err error) bool {
+	if ctx.Err() != nil {
+		// subscription deleted or target stopped
+		return false
+	}
+	d, ok := t.nextSubscriptionRetry(name, err)
+	if !ok {
+		t.sendError(ctx, &TargetError{
+			SubscriptionName: name,
+			Err:              fmt.Errorf("target '%s': %v, max reconnection attempts reached", t.Config.Name, err),
+		})
+		return false
+	}
+	t.sendError(ctx, &TargetError{
+		SubscriptionName: name,
+		Err:              fmt.Errorf("target '%s': %v, retrying in %s", t.Config.Name, err, d),
+	})
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (t *Target) sendError(ctx context.Context, err *TargetError) {
+	select {
+	case <-ctx.Done():
+	case t.errors <- err:
+	}
+}
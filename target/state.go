@@ -0,0 +1,156 @@
+package target
+
+import (
+	"time"
+)
+
+// target and subscription lifecycle states
+const (
+	StateConnecting  = "connecting"
+	StateConnected   = "connected"
+	StateSubscribing = "subscribing"
+	StateStreaming   = "streaming"
+	StateRetrying    = "retrying"
+	StateFailed      = "failed"
+)
+
+// States lists the target states, in increasing order of severity once the gNMI client is connected
+var States = []string{
+	StateConnecting,
+	StateConnected,
+	StateStreaming,
+	StateSubscribing,
+	StateRetrying,
+	StateFailed,
+}
+
+// SubscriptionStates lists the subscription states
+var SubscriptionStates = []string{
+	StateSubscribing,
+	StateStreaming,
+	StateRetrying,
+	StateFailed,
+}
+
+// Status is the lifecycle status of a target
+type Status struct {
+	State         string                         `json:"state,omitempty"`
+	Since         time.Time                      `json:"since,omitempty"`
+	LastError     string                         `json:"last-error,omitempty"`
+	LastErrorTime *time.Time                     `json:"last-error-time,omitempty"`
+	Subscriptions map[string]*SubscriptionStatus `json:"subscriptions,omitempty"`
+}
+
+// SubscriptionStatus is the lifecycle status of a target subscription
+type SubscriptionStatus struct {
+	State         string     `json:"state,omitempty"`
+	Since         time.Time  `json:"since,omitempty"`
+	LastError     string     `json:"last-error,omitempty"`
+	LastErrorTime *time.Time `json:"last-error-time,omitempty"`
+	LastResponse  *time.Time `json:"last-response,omitempty"`
+}
+
+// Status returns a copy of the target status
+func (t *Target) Status() *Status {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.status.copy()
+}
+
+// SetState sets the target gNMI client state, err is recorded as the target last error if not nil
+func (t *Target) SetState(state string, err error) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.setState(state, err)
+}
+
+// setState must be called with t.m locked
+func (t *Target) setState(state string, err error) {
+	t.clientState = state
+	now := time.Now()
+	if err != nil {
+		t.status.LastError = err.Error()
+		t.status.LastErrorTime = &now
+	}
+	t.updateState(now)
+}
+
+// setSubscriptionState must be called with t.m locked
+func (t *Target) setSubscriptionState(name, state string, err error) {
+	now := time.Now()
+	ss, ok := t.status.Subscriptions[name]
+	if !ok {
+		ss = new(SubscriptionStatus)
+		t.status.Subscriptions[name] = ss
+	}
+	if ss.State != state {
+		ss.State = state
+		ss.Since = now
+	}
+	if err != nil {
+		ss.LastError = err.Error()
+		ss.LastErrorTime = &now
+		t.status.LastError = ss.LastError
+		t.status.LastErrorTime = &now
+	}
+	t.updateState(now)
+}
+
+func (t *Target) subscriptionState(name, state string, err error) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.setSubscriptionState(name, state, err)
+}
+
+func (t *Target) subscriptionResponse(name string) {
+	now := time.Now()
+	t.m.Lock()
+	defer t.m.Unlock()
+	if ss, ok := t.status.Subscriptions[name]; ok {
+		ss.LastResponse = &now
+	}
+}
+
+// updateState derives the target state from the gNMI client state
+// and the subscriptions states, it must be called with t.m locked.
+func (t *Target) updateState(now time.Time) {
+	state := t.clientState
+	if state == StateConnected {
+		// once connected, the target state is the most severe subscription state
+		for _, ss := range t.status.Subscriptions {
+			if stateSeverity(ss.State) > stateSeverity(state) {
+				state = ss.State
+			}
+		}
+	}
+	if state != t.status.State {
+		t.status.State = state
+		t.status.Since = now
+	}
+}
+
+func stateSeverity(s string) int {
+	for i, st := range States {
+		if st == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Status) copy() *Status {
+	ns := &Status{
+		State:         s.State,
+		Since:         s.Since,
+		LastError:     s.LastError,
+		LastErrorTime: s.LastErrorTime,
+	}
+	if len(s.Subscriptions) > 0 {
+		ns.Subscriptions = make(map[string]*SubscriptionStatus, len(s.Subscriptions))
+		for n, ss := range s.Subscriptions {
+			nss := *ss
+			ns.Subscriptions[n] = &nss
+		}
+	}
+	return ns
+}
@@ -0,0 +1,70 @@
+package target
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/karimra/gnmic/types"
+)
+
+func TestTargetState(t *testing.T) {
+	tg := NewTarget(&types.TargetConfig{Name: "target1"})
+	tg.SetState(StateConnecting, nil)
+	if s := tg.Status(); s.State != StateConnecting {
+		t.Fatalf("expected state %q, got %q", StateConnecting, s.State)
+	}
+	if _, ok := tg.NextClientRetry(errors.New("connection refused")); !ok {
+		t.Fatal("unexpected max attempts reached")
+	}
+	s := tg.Status()
+	if s.State != StateRetrying || s.LastError != "connection refused" || s.LastErrorTime == nil {
+		t.Fatalf("unexpected status after a client error: %+v", s)
+	}
+	tg.SetState(StateConnected, nil)
+	tg.subscriptionState("sub1", StateSubscribing, nil)
+	tg.subscriptionState("sub2", StateSubscribing, nil)
+	if s := tg.Status(); s.State != StateSubscribing {
+		t.Fatalf("expected state %q, got %q", StateSubscribing, s.State)
+	}
+	tg.subscriptionState("sub1", StateStreaming, nil)
+	tg.subscriptionState("sub2", StateStreaming, nil)
+	if s := tg.Status(); s.State != StateStreaming {
+		t.Fatalf("expected state %q, got %q", StateStreaming, s.State)
+	}
+	// a single failing subscription degrades the target state
+	if _, ok := tg.nextSubscriptionRetry("sub2", errors.New("transport is closing")); !ok {
+		t.Fatal("unexpected max attempts reached")
+	}
+	s = tg.Status()
+	if s.State != StateRetrying || s.Subscriptions["sub1"].State != StateStreaming || s.Subscriptions["sub2"].State != StateRetrying {
+		t.Fatalf("unexpected status after a subscription error: %+v", s)
+	}
+	tg.DeleteSubscription("sub2")
+	if s := tg.Status(); s.State != StateStreaming || len(s.Subscriptions) != 1 {
+		t.Fatalf("unexpected status after deleting a subscription: %+v", s)
+	}
+}
+
+func TestTargetMarshalJSON(t *testing.T) {
+	tg := NewTarget(&types.TargetConfig{Name: "target1"})
+	tg.SetState(StateConnected, nil)
+	tg.subscriptionState("sub1", StateStreaming, nil)
+	b, err := json.Marshal(tg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := struct {
+		Config *types.TargetConfig `json:"config,omitempty"`
+		Status *Status             `json:"status,omitempty"`
+	}{}
+	if err = json.Unmarshal(b, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Config == nil || res.Config.Name != "target1" {
+		t.Fatalf("unexpected target config: %s", b)
+	}
+	if res.Status == nil || res.Status.State != StateStreaming || res.Status.Subscriptions["sub1"].State != StateStreaming {
+		t.Fatalf("unexpected target status: %s", b)
+	}
+}
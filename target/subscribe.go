@@ -15,36 +15,45 @@ import (
 
 // Subscribe sends a gnmi.SubscribeRequest to the target *t, responses and error are sent to the target channels
 func (t *Target) Subscribe(ctx context.Context, req *gnmi.SubscribeRequest, subscriptionName string) {
+	// the subscription context is canceled when the subscription is deleted or replaced,
+	// it stops both the running stream and the pending retries.
+	ctx, scancel := context.WithCancel(ctx)
+	defer scancel()
+	if !t.registerSubscription(subscriptionName, scancel) {
+		return
+	}
 SUBSC:
+	t.subscriptionState(subscriptionName, StateSubscribing, nil)
 	nctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	nctx = metadata.AppendToOutgoingContext(nctx, "username", *t.Config.Username, "password", *t.Config.Password)
 	subscribeClient, err := t.Client.Subscribe(nctx)
 	if err != nil {
-		t.errors <- &TargetError{
-			SubscriptionName: subscriptionName,
-			Err:              fmt.Errorf("failed to create a subscribe client, target='%s', retry in %d. err=%v", t.Config.Name, t.Config.RetryTimer, err),
-		}
 		cancel()
-		time.Sleep(t.Config.RetryTimer)
-		goto SUBSC
+		if t.waitSubscriptionRetry(ctx, subscriptionName, fmt.Errorf("failed to create a subscribe client: %v", err)) {
+			goto SUBSC
+		}
+		return
 	}
 	t.m.Lock()
 	t.SubscribeClients[subscriptionName] = subscribeClient
-	t.subscribeCancelFn[subscriptionName] = cancel
 	subConfig := t.Subscriptions[subscriptionName]
 	t.m.Unlock()
 	err = subscribeClient.Send(req)
 	if err != nil {
-		t.errors <- &TargetError{
-			SubscriptionName: subscriptionName,
-			Err:              fmt.Errorf("target '%s' send error, retry in %d. err=%v", t.Config.Name, t.Config.RetryTimer, err),
+		if nctx.Err() != nil {
+			// subscription deleted or target stopped
+			return
 		}
 		cancel()
-		time.Sleep(t.Config.RetryTimer)
-		goto SUBSC
+		if t.waitSubscriptionRetry(ctx, subscriptionName, fmt.Errorf("send error: %v", err)) {
+			goto SUBSC
+		}
+		return
 	}
 
+	// reconnection state is reset once the first response is received
+	received := false
 	switch req.GetSubscribe().Mode {
 	case gnmi.SubscriptionList_STREAM:
 		for {
@@ -53,18 +62,21 @@ SUBSC:
 			}
 			response, err := subscribeClient.Recv()
 			if err != nil {
-				t.errors <- &TargetError{
-					SubscriptionName: subscriptionName,
-					Err:              err,
-				}
-				t.errors <- &TargetError{
-					SubscriptionName: subscriptionName,
-					Err:              fmt.Errorf("retrying in %s", t.Config.RetryTimer),
+				if nctx.Err() != nil {
+					return
 				}
 				cancel()
-				time.Sleep(t.Config.RetryTimer)
-				goto SUBSC
+				if t.waitSubscriptionRetry(ctx, subscriptionName, err) {
+					goto SUBSC
+				}
+				return
 			}
+			if !received {
+				received = true
+				t.resetSubscriptionRetry(subscriptionName)
+				t.subscriptionState(subscriptionName, StateStreaming, nil)
+			}
+			t.subscriptionResponse(subscriptionName)
 			t.subscribeResponses <- &SubscribeResponse{
 				SubscriptionName:   subscriptionName,
 				SubscriptionConfig: subConfig,
@@ -79,17 +91,21 @@ SUBSC:
 					SubscriptionName: subscriptionName,
 					Err:              err,
 				}
-				if errors.Is(err, io.EOF) {
+				if errors.Is(err, io.EOF) || nctx.Err() != nil {
 					return
 				}
-				t.errors <- &TargetError{
-					SubscriptionName: subscriptionName,
-					Err:              fmt.Errorf("retrying in %d", t.Config.RetryTimer),
-				}
 				cancel()
-				time.Sleep(t.Config.RetryTimer)
-				goto SUBSC
+				if t.waitSubscriptionRetry(ctx, subscriptionName, err) {
+					goto SUBSC
+				}
+				return
+			}
+			if !received {
+				received = true
+				t.resetSubscriptionRetry(subscriptionName)
+				t.subscriptionState(subscriptionName, StateStreaming, nil)
 			}
+			t.subscriptionResponse(subscriptionName)
 			t.subscribeResponses <- &SubscribeResponse{
 				SubscriptionName:   subscriptionName,
 				SubscriptionConfig: subConfig,
@@ -206,11 +222,31 @@ func (t *Target) DecodeProtoBytes(resp *gnmi.SubscribeResponse) error {
 	return nil
 }
 
+// registerSubscription sets the cancel function of the subscription `name`, canceling a previous one if any.
+// It returns false if the subscription was deleted before it started.
+func (t *Target) registerSubscription(name string, cancel context.CancelFunc) bool {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if _, ok := t.Subscriptions[name]; !ok {
+		return false
+	}
+	if cfn, ok := t.subscribeCancelFn[name]; ok {
+		cfn()
+	}
+	t.subscribeCancelFn[name] = cancel
+	return true
+}
+
 func (t *Target) DeleteSubscription(name string) {
 	t.m.Lock()
 	defer t.m.Unlock()
-	t.subscribeCancelFn[name]()
+	if cfn, ok := t.subscribeCancelFn[name]; ok {
+		cfn()
+	}
 	delete(t.subscribeCancelFn, name)
 	delete(t.SubscribeClients, name)
 	delete(t.Subscriptions, name)
+	delete(t.subscriptionsRetry, name)
+	delete(t.status.Subscriptions, name)
+	t.updateState(time.Now())
 }
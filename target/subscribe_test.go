@@ -0,0 +1,110 @@
+package target
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karimra/gnmic/types"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc"
+)
+
+// failingClient is a gNMI client failing all the Subscribe RPCs
+type failingClient struct {
+	gnmi.GNMIClient
+
+	m     sync.Mutex
+	calls int
+}
+
+func (c *failingClient) Subscribe(ctx context.Context, opts ...grpc.CallOption) (gnmi.GNMI_SubscribeClient, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.calls++
+	return nil, errors.New("connection refused")
+}
+
+func (c *failingClient) numCalls() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.calls
+}
+
+func TestDeleteSubscriptionDuringBackoff(t *testing.T) {
+	username, password := "admin", "admin"
+	tg := NewTarget(&types.TargetConfig{
+		Name:       "target1",
+		Username:   &username,
+		Password:   &password,
+		RetryTimer: time.Minute,
+	})
+	client := new(failingClient)
+	tg.Client = client
+	tg.AddSubscription(&types.SubscriptionConfig{Name: "sub1"})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tg.Subscribe(context.Background(), &gnmi.SubscribeRequest{}, "sub1")
+	}()
+	_, errCh := tg.ReadSubscriptions()
+	select {
+	case <-errCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the subscription error")
+	}
+	// the subscription is waiting for the retry timer
+	tg.DeleteSubscription("sub1")
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription still running after being deleted")
+	}
+	if n := client.numCalls(); n != 1 {
+		t.Fatalf("expected 1 subscribe attempt, got %d", n)
+	}
+	if s := tg.Status(); len(s.Subscriptions) != 0 {
+		t.Fatalf("unexpected subscriptions status after delete: %+v", s.Subscriptions)
+	}
+}
+
+func TestSubscribeReplacesRunningSubscription(t *testing.T) {
+	username, password := "admin", "admin"
+	tg := NewTarget(&types.TargetConfig{
+		Name:       "target1",
+		Username:   &username,
+		Password:   &password,
+		RetryTimer: time.Minute,
+	})
+	tg.Client = new(failingClient)
+	tg.AddSubscription(&types.SubscriptionConfig{Name: "sub1"})
+	_, errCh := tg.ReadSubscriptions()
+
+	first := make(chan struct{})
+	go func() {
+		defer close(first)
+		tg.Subscribe(context.Background(), &gnmi.SubscribeRequest{}, "sub1")
+	}()
+	<-errCh
+	second := make(chan struct{})
+	go func() {
+		defer close(second)
+		tg.Subscribe(context.Background(), &gnmi.SubscribeRequest{}, "sub1")
+	}()
+	// starting the same subscription again stops the first one
+	select {
+	case <-first:
+	case <-time.After(5 * time.Second):
+		t.Fatal("first subscription still running")
+	}
+	<-errCh
+	tg.DeleteSubscription("sub1")
+	select {
+	case <-second:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second subscription still running after being deleted")
+	}
+}
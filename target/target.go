@@ -45,6 +45,12 @@ type Target struct {
 	stopped            bool
 	StopChan           chan struct{}      `json:"-"`
 	Cfn                context.CancelFunc `json:"-"`
+	// context of the target subscriptions, canceled by Cfn
+	Ctx                context.Context `json:"-"`
+	clientState        string
+	status             *Status
+	clientRetry        *RetryState
+	subscriptionsRetry map[string]*RetryState // subscription name to reconnection state
 
 	RootDesc desc.Descriptor
 }
@@ -61,12 +67,15 @@ func NewTarget(c *types.TargetConfig) *Target {
 		subscribeResponses: make(chan *SubscribeResponse, c.BufferSize),
 		errors:             make(chan *TargetError),
 		StopChan:           make(chan struct{}),
+		status:             &Status{Subscriptions: make(map[string]*SubscriptionStatus)},
+		subscriptionsRetry: make(map[string]*RetryState),
 	}
 	return t
 }
 
 // CreateGNMIClient //
 func (t *Target) CreateGNMIClient(ctx context.Context, opts ...grpc.DialOption) error {
+	t.SetState(StateConnecting, nil)
 	tOpts := make([]grpc.DialOption, 0, len(opts)+1)
 	tOpts = append(tOpts, opts...)
 
@@ -121,6 +130,7 @@ func (t *Target) CreateGNMIClient(ctx context.Context, opts ...grpc.DialOption)
 		case conn := <-connC:
 			close(done)
 			t.Client = gnmi.NewGNMIClient(conn)
+			t.SetState(StateConnected, nil)
 			return nil
 		case err := <-errC:
 			errs = append(errs, err.Error())
@@ -161,6 +171,13 @@ func (t *Target) Set(ctx context.Context, req *gnmi.SetRequest) (*gnmi.SetRespon
 	return response, nil
 }
 
+// AddSubscription adds or replaces the subscription config sc in the target subscriptions
+func (t *Target) AddSubscription(sc *types.SubscriptionConfig) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	t.Subscriptions[sc.Name] = sc
+}
+
 func (t *Target) Stop() {
 	t.m.Lock()
 	defer t.m.Unlock()
@@ -0,0 +1,95 @@
+package types
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBackoffMaxDelay = 5 * time.Minute
+)
+
+var (
+	jitterRandM = new(sync.Mutex)
+	jitterRand  = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// BackoffConfig defines the delay between consecutive reconnection attempts to a target.
+type BackoffConfig struct {
+	// delay before the first retry, defaults to the target retry timer
+	InitialDelay time.Duration `mapstructure:"initial-delay,omitempty" json:"initial-delay,omitempty" yaml:"initial-delay,omitempty"`
+	// upper bound of the delay, before jitter is applied
+	MaxDelay time.Duration `mapstructure:"max-delay,omitempty" json:"max-delay,omitempty" yaml:"max-delay,omitempty"`
+	// factor by which the delay is multiplied after each failed attempt
+	Multiplier float64 `mapstructure:"multiplier,omitempty" json:"multiplier,omitempty" yaml:"multiplier,omitempty"`
+	// fraction of the delay, between 0 and 1, added or subtracted randomly
+	Jitter float64 `mapstructure:"jitter,omitempty" json:"jitter,omitempty" yaml:"jitter,omitempty"`
+	// maximum number of consecutive attempts, 0 means no limit
+	MaxAttempts int `mapstructure:"max-attempts,omitempty" json:"max-attempts,omitempty" yaml:"max-attempts,omitempty"`
+}
+
+// Delay returns the wait time before the reconnection attempt number `attempt` (starting at 1).
+// A nil BackoffConfig results in a fixed delay equal to defaultDelay.
+// The returned boolean is false if the maximum number of attempts is exceeded.
+func (b *BackoffConfig) Delay(attempt int, defaultDelay time.Duration) (time.Duration, bool) {
+	if b == nil {
+		return defaultDelay, true
+	}
+	if b.MaxAttempts > 0 && attempt > b.MaxAttempts {
+		return 0, false
+	}
+	initial := b.InitialDelay
+	if initial <= 0 {
+		initial = defaultDelay
+	}
+	maxDelay := b.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultBackoffMaxDelay
+	}
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	if attempt < 1 {
+		attempt = 1
+	}
+	d := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	if d > float64(maxDelay) || math.IsInf(d, 0) {
+		d = float64(maxDelay)
+	}
+	jitter := b.Jitter
+	if jitter > 1 {
+		jitter = 1
+	}
+	if jitter > 0 {
+		jitterRandM.Lock()
+		r := jitterRand.Float64()
+		jitterRandM.Unlock()
+		d += (2*r - 1) * jitter * d
+	}
+	return time.Duration(d), true
+}
+
+// SetDefaults sets the fields of b left unset to their value in def.
+func (b *BackoffConfig) SetDefaults(def *BackoffConfig) {
+	if b == nil || def == nil {
+		return
+	}
+	if b.InitialDelay == 0 {
+		b.InitialDelay = def.InitialDelay
+	}
+	if b.MaxDelay == 0 {
+		b.MaxDelay = def.MaxDelay
+	}
+	if b.Multiplier == 0 {
+		b.Multiplier = def.Multiplier
+	}
+	if b.Jitter == 0 {
+		b.Jitter = def.Jitter
+	}
+	if b.MaxAttempts == 0 {
+		b.MaxAttempts = def.MaxAttempts
+	}
+}
@@ -0,0 +1,56 @@
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := &BackoffConfig{
+		InitialDelay: time.Second,
+		MaxDelay:     10 * time.Second,
+		Multiplier:   2,
+		MaxAttempts:  6,
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, exp := range expected {
+		d, ok := b.Delay(i+1, 0)
+		if !ok {
+			t.Fatalf("attempt %d: unexpected max attempts reached", i+1)
+		}
+		if d != exp {
+			t.Errorf("attempt %d: expected %s, got %s", i+1, exp, d)
+		}
+	}
+	if _, ok := b.Delay(len(expected)+1, 0); ok {
+		t.Errorf("expected max attempts to be reached")
+	}
+}
+
+func TestBackoffDelayDefaults(t *testing.T) {
+	var b *BackoffConfig
+	for i := 1; i < 5; i++ {
+		d, ok := b.Delay(i, 10*time.Second)
+		if !ok || d != 10*time.Second {
+			t.Errorf("attempt %d: expected a fixed 10s delay, got %s", i, d)
+		}
+	}
+	b = &BackoffConfig{Multiplier: 3}
+	d, _ := b.Delay(3, time.Second)
+	if d != 9*time.Second {
+		t.Errorf("expected the initial delay to default to the retry timer, got %s", d)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := &BackoffConfig{
+		InitialDelay: 10 * time.Second,
+		Jitter:       0.1,
+	}
+	for i := 0; i < 100; i++ {
+		d, _ := b.Delay(1, 0)
+		if d < 9*time.Second || d > 11*time.Second {
+			t.Fatalf("delay %s out of the jitter range", d)
+		}
+	}
+}
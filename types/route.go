@@ -0,0 +1,19 @@
+package types
+
+// OutputRoute selects the outputs the subscribe responses are sent to.
+// A route matches a response if all its criteria match, an empty criteria list matches any value.
+type OutputRoute struct {
+	Name string `mapstructure:"name,omitempty" json:"name,omitempty" yaml:"name,omitempty"`
+	// subscription names, the route matches if the response subscription is one of them
+	Subscriptions []string `mapstructure:"subscriptions,omitempty" json:"subscriptions,omitempty" yaml:"subscriptions,omitempty"`
+	// target tags, the route matches if the response target has at least one of them
+	TargetTags []string `mapstructure:"target-tags,omitempty" json:"target-tags,omitempty" yaml:"target-tags,omitempty"`
+	// jq condition, the route matches if it is true for at least one of the response events
+	Condition string `mapstructure:"condition,omitempty" json:"condition,omitempty" yaml:"condition,omitempty"`
+	// outputs names the matched responses are sent to
+	Outputs []string `mapstructure:"outputs,omitempty" json:"outputs,omitempty" yaml:"outputs,omitempty"`
+	// if true, the following routes are evaluated after this one matched
+	Fallthrough bool `mapstructure:"fallthrough,omitempty" json:"fallthrough,omitempty" yaml:"fallthrough,omitempty"`
+	// if true, the route is used for the responses not matching any other route
+	Default bool `mapstructure:"default,omitempty" json:"default,omitempty" yaml:"default,omitempty"`
+}